@@ -0,0 +1,153 @@
+// Package chaos injects latency and failures into the calls to the server's dependencies (the
+// model servers over HTTP, Postgres over its connections), so retries, timeouts and error
+// reporting can be exercised by integration tests. It is only wired in when the server runs
+// with -chaos.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of injected failures
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Fault is the trouble injected into the calls to one dependency
+type Fault struct {
+	// Latency is the maximum delay added to each call, drawn uniformly in [0, Latency)
+	Latency time.Duration
+	// FailureRate is the probability in [0, 1] that a call fails
+	FailureRate float64
+}
+
+// Settings are the faults of every dependency
+type Settings struct {
+	// Models applies to HTTP calls to Ollama and the transcription server
+	Models Fault
+	// DB applies to Postgres connections: dialing and sending each statement
+	DB Fault
+}
+
+// DialFunc opens a network connection, like net.Dialer.DialContext
+type DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Injector applies the current Settings; they can be changed while the server runs
+type Injector struct {
+	mu       sync.RWMutex
+	settings Settings
+}
+
+// New returns an injector starting with s
+func New(s Settings) *Injector {
+	return &Injector{settings: s}
+}
+
+// Settings returns the current faults
+func (i *Injector) Settings() Settings {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.settings
+}
+
+// Set replaces the faults; calls already delayed keep their delay
+func (i *Injector) Set(s Settings) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.settings = s
+}
+
+// Transport wraps base so requests are delayed and fail per the Models fault. Half of the failures
+// are transport errors and half 503 responses, since clients handle the two differently.
+func (i *Injector) Transport(base http.RoundTripper) http.RoundTripper {
+	return roundTripper{base: base, injector: i}
+}
+
+type roundTripper struct {
+	base     http.RoundTripper
+	injector *Injector
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := t.injector.Settings().Models
+	if err := delay(req.Context(), f.Latency); err != nil {
+		return nil, err
+	}
+	if fail(f.FailureRate) {
+		if rand.IntN(2) == 0 {
+			return nil, ErrInjected
+		}
+		return &http.Response{
+			Status:     "503 Service Unavailable",
+			StatusCode: http.StatusServiceUnavailable,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader(ErrInjected.Error())),
+			Request:    req,
+		}, nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// WrapDial wraps dial so connections are slow to open and to send, and break, per the DB fault.
+// A broken connection fails its statement and is discarded by the pool, like after a network drop.
+func (i *Injector) WrapDial(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		f := i.Settings().DB
+		if err := delay(ctx, f.Latency); err != nil {
+			return nil, err
+		}
+		if fail(f.FailureRate) {
+			return nil, ErrInjected
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &faultyConn{Conn: conn, injector: i}, nil
+	}
+}
+
+type faultyConn struct {
+	net.Conn
+	injector *Injector
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+	f := c.injector.Settings().DB
+	if f.Latency > 0 {
+		time.Sleep(rand.N(f.Latency))
+	}
+	if fail(f.FailureRate) {
+		c.Conn.Close()
+		return 0, ErrInjected
+	}
+	return c.Conn.Write(b)
+}
+
+// delay sleeps up to upTo, returning early with the context's error if it is done
+func delay(ctx context.Context, upTo time.Duration) error {
+	if upTo <= 0 {
+		return nil
+	}
+	t := time.NewTimer(rand.N(upTo))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func fail(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
@@ -0,0 +1,200 @@
+// Command canary compares a candidate embedding model against the one a collection uses,
+// before committing to a full re-embedding. It re-embeds a random sample of stored chunks
+// with the candidate, replays logged questions against both versions of the sample and
+// reports how much the retrieved chunks change.
+//
+//	canary -candidate mxbai-embed-large [-collection legal] [-- server flags such as -db-url]
+//
+// Server settings (database, Ollama, collections) are read like the server reads them:
+// RAG_* environment variables, overridden by the flags after "--".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/config"
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+func main() {
+	fs := flag.NewFlagSet("canary", flag.ContinueOnError)
+	candidate := fs.String("candidate", "", "embedding model to evaluate (required)")
+	collection := fs.String("collection", repo.DefaultCollection, "collection to sample")
+	sampleSize := fs.Int("sample", 500, "number of stored chunks to re-embed")
+	maxQueries := fs.Int("queries", 100, "number of logged questions to replay")
+	k := fs.Int("k", 10, "results compared per question")
+	show := fs.Int("show", 5, "most divergent questions to print in detail")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if *candidate == "" {
+		log.Fatal("-candidate is required")
+	}
+
+	cfg, err := config.Load(fs.Args())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	dbRepo, err := repo.NewPostgresRepository(ctx, cfg.DatabaseURL, repo.PostgresOptions{QueryTimeout: cfg.DBQueryTimeout})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dbRepo.Close(ctx)
+	svc := service.NewRAGService(dbRepo, &http.Client{Timeout: cfg.HTTPTimeout}, nil, cfg.Service)
+
+	col, err := svc.Collection(ctx, *collection)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sample, err := dbRepo.SampleChunks(ctx, col.Name, *sampleSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queries, err := dbRepo.LoggedQueries(ctx, col.Name, *maxQueries)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(sample) == 0 || len(queries) == 0 {
+		log.Fatalf("collection %q has %d chunks and %d logged questions; both are needed", col.Name, len(sample), len(queries))
+	}
+	log.Printf("re-embedding %d chunks of %q with %s (current model %s)", len(sample), col.Name, *candidate, col.Model)
+
+	baseline := make([][]float32, len(sample))
+	candidates := make([][]float32, len(sample))
+	for i, d := range sample {
+		baseline[i] = d.Vector.Slice()
+		if candidates[i], err = svc.EmbedWith(*candidate, d.Content); err != nil {
+			log.Fatalf("embedding chunk %d: %v", d.ID, err)
+		}
+		if (i+1)%100 == 0 {
+			log.Printf("%d/%d chunks", i+1, len(sample))
+		}
+	}
+
+	var results []replay
+	for _, q := range queries {
+		qb, err := svc.EmbedWith(col.Model, q)
+		if err != nil {
+			log.Fatalf("embedding question with %s: %v", col.Model, err)
+		}
+		qc, err := svc.EmbedWith(*candidate, q)
+		if err != nil {
+			log.Fatalf("embedding question with %s: %v", *candidate, err)
+		}
+		results = append(results, compare(q, rank(qb, baseline, *k), rank(qc, candidates, *k)))
+	}
+	report(os.Stdout, col, *candidate, sample, results, *k, *show)
+}
+
+// replay is the outcome of one question against both versions of the sample
+type replay struct {
+	query     string
+	baseline  []int
+	candidate []int
+	// overlap is the share of the baseline top k also in the candidate top k
+	overlap float64
+	// topRank is the 1-based candidate rank of the baseline's best chunk, 0 if outside the top k
+	topRank int
+}
+
+func compare(query string, baseline, candidate []int) replay {
+	r := replay{query: query, baseline: baseline, candidate: candidate}
+	in := map[int]int{}
+	for i, idx := range candidate {
+		in[idx] = i + 1
+	}
+	shared := 0
+	for _, idx := range baseline {
+		if in[idx] > 0 {
+			shared++
+		}
+	}
+	if len(baseline) > 0 {
+		r.overlap = float64(shared) / float64(len(baseline))
+		r.topRank = in[baseline[0]]
+	}
+	return r
+}
+
+// rank returns the indexes of the k vectors most similar to q, best first
+func rank(q []float32, vecs [][]float32, k int) []int {
+	idx := make([]int, len(vecs))
+	sims := make([]float64, len(vecs))
+	for i, v := range vecs {
+		idx[i], sims[i] = i, cosine(q, v)
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return sims[idx[a]] > sims[idx[b]] })
+	return idx[:min(k, len(idx))]
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return math.Inf(-1)
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func report(w io.Writer, col repo.Collection, candidate string, sample []repo.Document, results []replay, k, show int) {
+	var overlap, rr float64
+	kept := 0
+	for _, r := range results {
+		overlap += r.overlap
+		if r.topRank > 0 {
+			rr += 1 / float64(r.topRank)
+		}
+		if r.topRank == 1 {
+			kept++
+		}
+	}
+	n := float64(len(results))
+	fmt.Fprintf(w, "collection %q: %s -> %s, %d chunks, %d questions, k=%d\n\n", col.Name, col.Model, candidate, len(sample), len(results), k)
+	fmt.Fprintf(w, "%-32s %.1f%%\n", fmt.Sprintf("mean overlap@%d:", k), 100*overlap/n)
+	fmt.Fprintf(w, "%-32s %.1f%%\n", "same top result:", 100*float64(kept)/n)
+	fmt.Fprintf(w, "%-32s %.3f\n", "MRR of the current top result:", rr/n)
+
+	sort.SliceStable(results, func(a, b int) bool { return results[a].overlap < results[b].overlap })
+	for _, r := range results[:min(show, len(results))] {
+		fmt.Fprintf(w, "\n%q  overlap %.0f%%\n", r.query, 100*r.overlap)
+		for i := 0; i < min(3, len(r.baseline)); i++ {
+			fmt.Fprintf(w, "  %d. current:   %s\n", i+1, snippet(sample[r.baseline[i]]))
+			if i < len(r.candidate) {
+				fmt.Fprintf(w, "     candidate: %s\n", snippet(sample[r.candidate[i]]))
+			}
+		}
+	}
+}
+
+func snippet(d repo.Document) string {
+	text := strings.Join(strings.Fields(d.Content), " ")
+	if r := []rune(text); len(r) > 80 {
+		text = string(r[:80]) + "…"
+	}
+	return fmt.Sprintf("[%s#%d] %s", d.Source, d.ID, text)
+}
@@ -0,0 +1,56 @@
+// Command mcp serves the local index to Model Context Protocol hosts (Claude Desktop and other
+// MCP clients) over stdio, as the tools search, list_documents and fetch_document.
+// Hosts start it as a subprocess, e.g. in claude_desktop_config.json:
+//
+//	"mcpServers": {"local-rag": {"command": "/path/to/mcp", "args": ["-db-url", "postgres://..."]}}
+//
+// Server settings (database, Ollama, collections) are read like the server reads them:
+// RAG_* environment variables, overridden by the flags given. Stdout carries the protocol,
+// so logs go to stderr.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Thaizir/go-local-RAG/config"
+	"github.com/Thaizir/go-local-RAG/mcp"
+	"github.com/Thaizir/go-local-RAG/rag"
+)
+
+func main() {
+	log.SetOutput(os.Stderr)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r, err := rag.New(ctx, rag.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close(context.Background())
+
+	store := r.Repository()
+	srv := &mcp.Server{
+		Name:           "go-local-rag",
+		Version:        "1.0.0",
+		Search:         r.Service().SearchPassages,
+		ListDocuments:  store.ListDocuments,
+		GetDocument:    store.GetDocument,
+		DocumentChunks: store.DocumentChunks,
+	}
+	if err := srv.Serve(ctx, os.Stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,77 @@
+// Command reindex re-embeds the chunks of a collection with its configured embedding model,
+// after the model or its dimension changed. Without -collection it lists the collections that
+// need it. The server can keep running: searches use the old vectors until every chunk is
+// re-embedded, and POST /api/reindex does the same from the API.
+//
+//	reindex [-collection legal] [-- server flags such as -db-url]
+//
+// Server settings (database, Ollama, collections) are read like the server reads them:
+// RAG_* environment variables, overridden by the flags after "--".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Thaizir/go-local-RAG/config"
+	"github.com/Thaizir/go-local-RAG/rag"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+func main() {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	collection := fs.String("collection", "", "collection to re-embed (\"default\" for the default one); empty lists the stale ones")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	cfg, err := config.Load(fs.Args())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r, err := rag.New(ctx, rag.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	svc := r.Service()
+
+	if *collection == "" {
+		statuses, err := svc.ReindexStatuses(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(statuses) == 0 {
+			log.Print("every collection is embedded with its configured model")
+		}
+		for _, st := range statuses {
+			log.Printf("%s: %d chunks to re-embed with %s (%d dimensions), serving with %s",
+				st.Collection, st.StaleChunks, st.Model, st.Dimension, st.ServingModel)
+		}
+		return
+	}
+	ctx = service.WithProgress(ctx, func(done, total int) {
+		if done%1000 == 0 || done == total {
+			log.Printf("%d/%d chunks re-embedded", done, total)
+		}
+	})
+	report, err := svc.Reindex(ctx, *collection)
+	if err != nil {
+		log.Fatalf("re-embedding stopped after %d chunks: %v", report.Chunks, err)
+	}
+	log.Printf("collection %q now embedded with %s (%d dimensions), %d chunks re-embedded",
+		report.Collection, report.Model, report.Dimension, report.Chunks)
+}
@@ -0,0 +1,90 @@
+// Command triplets exports the feedback on answers (ratings and opened passages, see
+// service.TrainingExamples) as a JSON Lines dataset for fine-tuning an embedding model on the
+// corpus, in one of two formats:
+//
+//	sentence-transformers  {"anchor": question, "positive": chunk, "negative": chunk}, one per pair
+//	bge                    {"query": question, "pos": [chunks], "neg": [chunks]}, one per question
+//
+//	triplets [-format bge] [-out train.jsonl] [-tenant acme] [-- server flags such as -db-url]
+//
+// Server settings are read like the server reads them: RAG_* environment variables, overridden
+// by the flags after "--".
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/Thaizir/go-local-RAG/config"
+	"github.com/Thaizir/go-local-RAG/rag"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+func main() {
+	fs := flag.NewFlagSet("triplets", flag.ContinueOnError)
+	format := fs.String("format", "sentence-transformers", "dataset format: sentence-transformers or bge")
+	out := fs.String("out", "", "file to write; empty writes to stdout")
+	tenant := fs.String("tenant", "", "tenant whose history is exported; empty for the unscoped one")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	if *format != "sentence-transformers" && *format != "bge" {
+		log.Fatalf("unknown format %q: use sentence-transformers or bge", *format)
+	}
+	cfg, err := config.Load(fs.Args())
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := repo.WithTenant(context.Background(), *tenant)
+
+	r, err := rag.New(ctx, rag.Options{Config: cfg})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close(context.Background())
+	examples, err := r.Service().TrainingExamples(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dst := os.Stdout
+	if *out != "" {
+		if dst, err = os.Create(*out); err != nil {
+			log.Fatal(err)
+		}
+	}
+	w := bufio.NewWriter(dst)
+	enc := json.NewEncoder(w)
+	rows := 0
+	for _, ex := range examples {
+		if *format == "bge" {
+			_ = enc.Encode(map[string]any{"query": ex.Query, "pos": ex.Positives, "neg": ex.Negatives})
+			rows++
+			continue
+		}
+		for _, pos := range ex.Positives {
+			for _, neg := range ex.Negatives {
+				_ = enc.Encode(map[string]string{"anchor": ex.Query, "positive": pos, "negative": neg})
+				rows++
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if err := dst.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d rows from %d questions", rows, len(examples))
+}
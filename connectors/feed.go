@@ -0,0 +1,224 @@
+package connectors
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// maxFeedEntries bounds the pages a single sync fetches; the rest are picked up by later syncs
+const maxFeedEntries = 500
+
+// FeedSource is a feed or sitemap URL and the collection its pages go to
+type FeedSource struct {
+	URL        string
+	Collection string
+}
+
+// Feed syncs the pages listed by RSS/Atom feeds and sitemaps (sitemap indexes included). Every
+// page is indexed with its URL as source and the entry date as version, so only new or updated
+// entries are fetched again.
+type Feed struct {
+	// Client fetches the feeds and the pages they list; both come from outside, so it should be
+	// the NewClient one refusing private network addresses
+	Client *http.Client
+	// IndexedVersion and Sync are the service.RAGService methods of the same name
+	IndexedVersion func(ctx context.Context, collection, source string) (string, error)
+	Sync           func(ctx context.Context, doc service.Document, version string) (service.IndexReport, bool, error)
+}
+
+// feedEntry is a page listed by a feed, with the date it was last updated ("" if unknown)
+type feedEntry struct {
+	URL     string
+	Updated string
+}
+
+// feedXML matches the elements of RSS 2.0, Atom, sitemaps and sitemap indexes
+type feedXML struct {
+	XMLName xml.Name
+	Items   []struct {
+		Link    string `xml:"link"`
+		PubDate string `xml:"pubDate"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Updated   string `xml:"updated"`
+		Published string `xml:"published"`
+	} `xml:"entry"`
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// SyncFeed indexes into collection the pages listed by the feed or sitemap at feedURL.
+// Entries whose date did not change since the last sync are not fetched; entries without a date
+// are fetched once. Failures of single pages are reported and do not stop the sync.
+func (f *Feed) SyncFeed(ctx context.Context, feedURL, collection string) (SyncReport, error) {
+	var rep SyncReport
+	entries, err := f.entries(ctx, feedURL, 0)
+	if err != nil {
+		return rep, err
+	}
+	for _, e := range entries {
+		if rep.Indexed+rep.Failed == maxFeedEntries {
+			break
+		}
+		rep.Listed++
+		f.syncEntry(ctx, e, collection, &rep)
+		if ctx.Err() != nil {
+			return rep, ctx.Err()
+		}
+	}
+	return rep, nil
+}
+
+// Run syncs every source now and then every interval, logging the reports, until ctx is done
+func (f *Feed) Run(ctx context.Context, sources []FeedSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, src := range sources {
+			rep, err := f.SyncFeed(ctx, src.URL, src.Collection)
+			if err != nil {
+				log.Printf("feed %s: %v", src.URL, err)
+				continue
+			}
+			if rep.Indexed > 0 || rep.Failed > 0 {
+				log.Printf("feed %s: %d indexed, %d unchanged, %d failed", src.URL, rep.Indexed, rep.Unchanged, rep.Failed)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Feed) syncEntry(ctx context.Context, e feedEntry, collection string, rep *SyncReport) {
+	fail := func(err error) {
+		rep.Failed++
+		rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", e.URL, err))
+	}
+	pageURL, err := url.Parse(e.URL)
+	if err != nil || (pageURL.Scheme != "http" && pageURL.Scheme != "https") || pageURL.Host == "" {
+		rep.Skipped++
+		return
+	}
+	version := e.Updated
+	if version == "" {
+		version = "listed"
+	}
+	current, err := f.IndexedVersion(ctx, collection, pageURL.String())
+	if err != nil {
+		fail(err)
+		return
+	}
+	if current == version {
+		rep.Unchanged++
+		return
+	}
+	doc, _, _, err := FetchPage(ctx, f.Client, pageURL)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if strings.TrimSpace(doc.Content) == "" {
+		rep.Skipped++
+		return
+	}
+	doc.Collection, doc.Origin = collection, service.OriginFeed
+	if doc.Date.IsZero() {
+		doc.Date = parseFeedDate(e.Updated)
+	}
+	report, indexed, err := f.Sync(ctx, doc, version)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if indexed {
+		rep.Indexed++
+		log.Printf("feed: indexed %s (%d chunks)", doc.Source, report.Chunks)
+	} else {
+		rep.Unchanged++
+	}
+}
+
+// entries fetches and parses a feed, following sitemap indexes one level down
+func (f *Feed) entries(ctx context.Context, feedURL string, depth int) ([]feedEntry, error) {
+	data, _, _, err := Fetch(ctx, f.Client, feedURL, maxPageBytes)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", feedURL, err)
+	}
+	var doc feedXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", feedURL, err)
+	}
+
+	var entries []feedEntry
+	switch doc.XMLName.Local {
+	case "rss":
+		for _, it := range doc.Items {
+			entries = append(entries, feedEntry{URL: strings.TrimSpace(it.Link), Updated: strings.TrimSpace(it.PubDate)})
+		}
+	case "feed":
+		for _, en := range doc.Entries {
+			e := feedEntry{Updated: strings.TrimSpace(en.Updated)}
+			if e.Updated == "" {
+				e.Updated = strings.TrimSpace(en.Published)
+			}
+			for _, l := range en.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					e.URL = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			entries = append(entries, e)
+		}
+	case "urlset":
+		for _, u := range doc.URLs {
+			entries = append(entries, feedEntry{URL: strings.TrimSpace(u.Loc), Updated: strings.TrimSpace(u.LastMod)})
+		}
+	case "sitemapindex":
+		if depth > 0 {
+			return nil, errors.New("nested sitemap indexes are not supported")
+		}
+		for _, sm := range doc.Sitemaps {
+			children, err := f.entries(ctx, strings.TrimSpace(sm.Loc), depth+1)
+			if err != nil {
+				log.Printf("feed %s: %v", feedURL, err)
+				continue
+			}
+			entries = append(entries, children...)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not an RSS/Atom feed or a sitemap", feedURL)
+	}
+	return entries, nil
+}
+
+// parseFeedDate parses the date formats of RSS (RFC 822), Atom and sitemaps (W3C datetime),
+// truncated to the UTC day; zero if unparseable
+func parseFeedDate(v string) time.Time {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t.UTC().Truncate(24 * time.Hour)
+		}
+	}
+	return time.Time{}
+}
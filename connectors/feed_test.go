@@ -0,0 +1,22 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSyncFeedRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the guarded client reached a loopback server")
+	}))
+	defer srv.Close()
+	f := &Feed{Client: NewClient(5 * time.Second)}
+
+	if _, err := f.SyncFeed(context.Background(), srv.URL+"/feed.xml", ""); !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("SyncFeed err = %v, want ErrForbiddenAddress", err)
+	}
+}
@@ -0,0 +1,236 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// maxGitFileBytes skips large tracked files (generated code, data dumps)
+const maxGitFileBytes = 1 << 20
+
+// skippedGitDirs hold dependencies or generated files rather than the project's own content
+var skippedGitDirs = []string{"node_modules", "vendor", "dist", "build", "third_party"}
+
+// Git syncs repositories into the index. Repositories are cloned under Dir and pulled on later
+// syncs; only the files changed since the last synced commit are re-indexed.
+type Git struct {
+	// Dir holds the clones
+	Dir      string
+	Registry *loaders.Registry
+	// IndexedVersion, Sync, Remove and RecordVersion are the service.RAGService methods of the same name
+	IndexedVersion func(ctx context.Context, collection, source string) (string, error)
+	Sync           func(ctx context.Context, doc service.Document, version string) (service.IndexReport, bool, error)
+	Remove         func(ctx context.Context, collection, source string) (int64, error)
+	RecordVersion  func(ctx context.Context, collection, source, version string) error
+}
+
+// GitReport tells what a repository sync did
+type GitReport struct {
+	Commit string `json:"commit"`
+	// Previous is the commit synced last time, empty on the first sync
+	Previous string `json:"previous,omitempty"`
+	Indexed  int    `json:"indexed"`
+	Removed  int    `json:"removed"`
+	// Unchanged files were already indexed with the same content
+	Unchanged int      `json:"unchanged"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// SyncRepo clones or pulls repoURL (branch, or the default branch when empty) and indexes its
+// README, docs and source files into collection. Each file has "<repo>/<path>" as source and its
+// path and the commit as "path"/"commit" metadata. After a successful sync only the files that
+// changed since the synced commit are processed.
+func (g *Git) SyncRepo(ctx context.Context, repoURL, branch, collection string) (GitReport, error) {
+	var rep GitReport
+	dir := filepath.Join(g.Dir, cloneName(repoURL))
+	if err := g.fetch(ctx, dir, repoURL, branch); err != nil {
+		return rep, err
+	}
+	head, err := git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return rep, err
+	}
+	rep.Commit = head
+
+	marker := "git:" + repoURL
+	if branch != "" {
+		marker += "@" + branch
+	}
+	previous, err := g.IndexedVersion(ctx, collection, marker)
+	if err != nil {
+		return rep, err
+	}
+	rep.Previous = previous
+
+	incremental := previous != ""
+	if incremental {
+		// a force-push may have dropped the synced commit
+		_, err := git(ctx, dir, "cat-file", "-e", previous+"^{commit}")
+		incremental = err == nil
+	}
+	var changed, removed []string
+	if incremental {
+		out, err := git(ctx, dir, "diff", "--name-status", "--no-renames", "-z", previous, head)
+		if err != nil {
+			return rep, err
+		}
+		fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == "D" {
+				removed = append(removed, fields[i+1])
+			} else {
+				changed = append(changed, fields[i+1])
+			}
+		}
+	} else {
+		// first sync, or history rewritten: look at every tracked file
+		out, err := git(ctx, dir, "ls-files", "-z")
+		if err != nil {
+			return rep, err
+		}
+		changed = strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+	for _, p := range removed {
+		if !g.wanted(p) {
+			continue
+		}
+		if _, err := g.Remove(ctx, collection, base+"/"+p); err != nil {
+			rep.Failed++
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", p, err))
+			continue
+		}
+		rep.Removed++
+	}
+	for _, p := range changed {
+		if p == "" || !g.wanted(p) {
+			continue
+		}
+		if err := g.syncFile(ctx, dir, base, p, head, collection, &rep); err != nil {
+			rep.Failed++
+			rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", p, err))
+		}
+		if ctx.Err() != nil {
+			return rep, ctx.Err()
+		}
+	}
+	if rep.Failed > 0 {
+		// keep the previous commit so the next sync retries the failed files
+		return rep, nil
+	}
+	return rep, g.RecordVersion(ctx, collection, marker, head)
+}
+
+// syncFile indexes one file of the checkout, with its content hash as version
+func (g *Git) syncFile(ctx context.Context, dir, base, p, commit, collection string, rep *GitReport) error {
+	loader, _ := g.Registry.Lookup(p, "")
+	full := filepath.Join(dir, filepath.FromSlash(p))
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxGitFileBytes {
+		// gone in the working tree, a symlink or submodule, or too large
+		return nil
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return err
+	}
+	sections, err := loader.Load(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	doc := loaders.ToDocument(sections)
+	doc.Source = base + "/" + p
+	doc.Collection = collection
+	doc.Metadata = map[string]string{"path": p, "commit": commit}
+	if loaders.IsCode(p) {
+		doc.Type = service.DocTypeCode
+	}
+	sum := sha256.Sum256(data)
+	report, indexed, err := g.Sync(ctx, doc, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return err
+	}
+	if indexed {
+		rep.Indexed++
+		log.Printf("git: indexed %s (%d chunks)", doc.Source, report.Chunks)
+	} else {
+		rep.Unchanged++
+	}
+	return nil
+}
+
+// wanted reports whether a repository path is indexed: a file some loader reads, outside
+// hidden and dependency directories
+func (g *Git) wanted(p string) bool {
+	for _, part := range strings.Split(path.Dir(p), "/") {
+		if strings.HasPrefix(part, ".") && part != "." {
+			return false
+		}
+		for _, skip := range skippedGitDirs {
+			if part == skip {
+				return false
+			}
+		}
+	}
+	_, ok := g.Registry.Lookup(p, "")
+	return ok
+}
+
+// fetch clones repoURL into dir, or updates an existing clone to the tip of branch
+func (g *Git) fetch(ctx context.Context, dir, repoURL, branch string) error {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(g.Dir, 0o755); err != nil {
+			return err
+		}
+		args := []string{"clone", "--quiet"}
+		if branch != "" {
+			args = append(args, "--branch", branch)
+		}
+		_, err := git(ctx, "", append(args, "--", repoURL, dir)...)
+		return err
+	}
+	ref := branch
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := git(ctx, dir, "fetch", "--quiet", "origin", ref); err != nil {
+		return err
+	}
+	_, err := git(ctx, dir, "reset", "--quiet", "--hard", "FETCH_HEAD")
+	return err
+}
+
+// git runs a git command in dir and returns its trimmed output. Only network transports are
+// allowed, so a crafted URL cannot run commands (ext::) or read local files.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL=http:https:ssh:git")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// cloneName is a stable directory name for a repository URL
+func cloneName(repoURL string) string {
+	sum := sha256.Sum256([]byte(repoURL))
+	name := path.Base(strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git"))
+	return name + "-" + hex.EncodeToString(sum[:6])
+}
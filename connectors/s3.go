@@ -0,0 +1,277 @@
+// Package connectors ingests content from external systems into the index, tracking the version
+// of every item so repeated syncs only re-index what changed.
+package connectors
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// S3 syncs objects of S3-compatible buckets (AWS, MinIO, Ceph...). Requests use path-style
+// addressing and are signed with AWS Signature Version 4.
+type S3 struct {
+	// Endpoint is the server base URL, e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+	Registry  *loaders.Registry
+	// IndexedVersion returns the version recorded for a source (service.RAGService.IndexedVersion)
+	IndexedVersion func(ctx context.Context, collection, source string) (string, error)
+	// Sync indexes a document unless its version is already indexed (service.RAGService.SyncSource)
+	Sync func(ctx context.Context, doc service.Document, version string) (service.IndexReport, bool, error)
+}
+
+// maxObjectBytes skips objects too large to load in memory
+const maxObjectBytes = 100 << 20
+
+// SyncReport tells what a sync did
+type SyncReport struct {
+	Listed    int `json:"listed"`
+	Indexed   int `json:"indexed"`
+	Unchanged int `json:"unchanged"`
+	// Skipped objects have no loader for their type or are too large
+	Skipped int      `json:"skipped"`
+	Failed  int      `json:"failed"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// s3Object is an entry of a ListObjectsV2 response
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// SyncBucket indexes the objects of bucket under prefix into collection, with "s3://bucket/key"
+// as source and the ETag as version: objects whose ETag did not change are not downloaded.
+// Failures of single objects are reported and do not stop the sync.
+func (c *S3) SyncBucket(ctx context.Context, bucket, prefix, collection string) (SyncReport, error) {
+	var rep SyncReport
+	token := ""
+	for {
+		objects, next, err := c.list(ctx, bucket, prefix, token)
+		if err != nil {
+			return rep, err
+		}
+		for _, obj := range objects {
+			rep.Listed++
+			c.syncObject(ctx, bucket, collection, obj, &rep)
+			if ctx.Err() != nil {
+				return rep, ctx.Err()
+			}
+		}
+		if next == "" {
+			return rep, nil
+		}
+		token = next
+	}
+}
+
+func (c *S3) syncObject(ctx context.Context, bucket, collection string, obj s3Object, rep *SyncReport) {
+	fail := func(err error) {
+		rep.Failed++
+		rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", obj.Key, err))
+	}
+	loader, ok := c.Registry.Lookup(obj.Key, "")
+	if !ok || strings.HasSuffix(obj.Key, "/") || obj.Size > maxObjectBytes {
+		rep.Skipped++
+		return
+	}
+	source := "s3://" + bucket + "/" + obj.Key
+	etag := strings.Trim(obj.ETag, `"`)
+	current, err := c.IndexedVersion(ctx, collection, source)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if current == etag {
+		rep.Unchanged++
+		return
+	}
+	body, err := c.get(ctx, bucket, obj.Key)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer body.Close()
+	sections, err := loader.Load(io.LimitReader(body, maxObjectBytes))
+	if err != nil {
+		fail(err)
+		return
+	}
+	doc := loaders.ToDocument(sections)
+	doc.Source = source
+	doc.Collection = collection
+	doc.Date = obj.LastModified
+	report, indexed, err := c.Sync(ctx, doc, etag)
+	if err != nil {
+		fail(err)
+		return
+	}
+	if indexed {
+		rep.Indexed++
+		log.Printf("s3: indexed %s (%d chunks)", source, report.Chunks)
+	} else {
+		rep.Unchanged++
+	}
+}
+
+// list returns a page of objects and the continuation token of the next page ("" at the end)
+func (c *S3) list(ctx context.Context, bucket, prefix, token string) ([]s3Object, string, error) {
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	resp, err := c.do(ctx, "/"+bucket, q)
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing bucket %s: %w", bucket, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Contents              []s3Object `xml:"Contents"`
+		IsTruncated           bool       `xml:"IsTruncated"`
+		NextContinuationToken string     `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, "", fmt.Errorf("error parsing bucket listing: %w", err)
+	}
+	if !result.IsTruncated {
+		return result.Contents, "", nil
+	}
+	return result.Contents, result.NextContinuationToken, nil
+}
+
+// get downloads an object; the caller closes the body
+func (c *S3) get(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, "/"+bucket+"/"+key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading: %w", err)
+	}
+	return resp.Body, nil
+}
+
+// do sends a signed GET request, turning non-200 answers into errors
+func (c *S3) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	base, err := url.Parse(strings.TrimRight(c.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	canonicalURI := base.EscapedPath() + escapePath(path)
+	u := *base
+	u.RawPath = canonicalURI
+	u.Path, _ = url.PathUnescape(canonicalURI)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.sign(req, canonicalURI, time.Now().UTC())
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	return resp, nil
+}
+
+// emptyPayloadHash is the SHA-256 of the empty body of GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign adds AWS Signature Version 4 headers to req
+func (c *S3) sign(req *http.Request, canonicalURI string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + emptyPayloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := day + "/" + c.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + c.SecretKey)
+	for _, part := range []string{day, c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, as SigV4 requires
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escapePath encodes every segment of an object path, keeping the slashes
+func escapePath(p string) string {
+	return uriEncode(p, false)
+}
+
+// uriEncode percent-encodes everything but RFC 3986 unreserved characters (and '/' unless
+// encodeSlash), the encoding SigV4 signs
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// maxPageBytes caps the size of fetched pages and feeds
+const maxPageBytes = 10 << 20
+
+// ErrForbiddenAddress is returned when a URL resolves to an address of the host or its private network
+var ErrForbiddenAddress = errors.New("address not allowed")
+
+// NewClient returns the HTTP client fetching user-supplied URLs. It refuses to connect to loopback,
+// private, link-local and unspecified addresses: the check runs on the resolved IP of every
+// connection, so it holds for each redirect hop and against DNS rebinding. Proxies from the
+// environment are not used, since they would resolve the host themselves.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// publicOnly is a net.Dialer Control hook rejecting connections to non-public addresses
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+// FetchPage downloads pageURL and converts it into a document, returning the page title and
+// the image references of its main content (HTML boilerplate such as navigation and footers is dropped)
+func FetchPage(ctx context.Context, client *http.Client, pageURL *url.URL) (service.Document, string, []string, error) {
+	data, contentType, header, err := Fetch(ctx, client, pageURL.String(), maxPageBytes)
+	if err != nil {
+		return service.Document{}, "", nil, err
+	}
+	doc := service.Document{Source: pageURL.String()}
+	if lm, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		doc.Date = lm.UTC().Truncate(24 * time.Hour)
+	}
+
+	switch contentType {
+	case "text/html", "application/xhtml+xml":
+		page, err := loaders.ParseHTML(data)
+		if err != nil {
+			return service.Document{}, "", nil, err
+		}
+		doc.Content, doc.Format = page.Text, service.FormatMarkdown
+		return doc, page.Title, page.Images, nil
+	case "text/markdown":
+		doc.Content, doc.Format = string(data), service.FormatMarkdown
+	case "text/plain":
+		doc.Content = string(data)
+	default:
+		return service.Document{}, "", nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return doc, "", nil, nil
+}
+
+// Fetch GETs rawURL, returning at most limit bytes of body and its media type
+func Fetch(ctx context.Context, client *http.Client, rawURL string, limit int64) ([]byte, string, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("User-Agent", "github.com/Thaizir/go-local-RAG/1.0 (+local knowledge base)")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", nil, fmt.Errorf("response larger than %d bytes", limit)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+	return data, mediaType, resp.Header, nil
+}
@@ -0,0 +1,50 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the guarded client reached a loopback server")
+	}))
+	defer srv.Close()
+	client := NewClient(5 * time.Second)
+
+	pageURL, _ := url.Parse(srv.URL)
+	if _, _, _, err := FetchPage(context.Background(), client, pageURL); !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("FetchPage(%s) err = %v, want ErrForbiddenAddress", srv.URL, err)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"127.0.0.1:80", false},
+		{"[::1]:443", false},
+		{"10.0.0.5:80", false},
+		{"172.16.3.4:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"[fd00::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"93.184.216.34:443", true},
+		{"[2606:4700::1111]:443", true},
+	}
+	for _, tt := range tests {
+		err := publicOnly("tcp", tt.address, nil)
+		if got := err == nil; got != tt.allowed {
+			t.Errorf("publicOnly(%s) err = %v, want allowed=%v", tt.address, err, tt.allowed)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// hotChunkItem is the JSON view of a frequently retrieved chunk
+type hotChunkItem struct {
+	ID              int       `json:"id"`
+	Source          string    `json:"source"`
+	DocumentID      int64     `json:"document_id,omitempty"`
+	Position        int       `json:"position,omitempty"`
+	Excerpt         string    `json:"excerpt"`
+	Retrievals      int64     `json:"retrievals"`
+	LastRetrievedAt time.Time `json:"last_retrieved_at"`
+}
+
+// coldSourceItem is the JSON view of a source never retrieved
+type coldSourceItem struct {
+	Source    string     `json:"source"`
+	Chunks    int64      `json:"chunks"`
+	IndexedAt *time.Time `json:"indexed_at,omitempty"`
+}
+
+// NewAccessStatsHandler returns a handler for /api/stats/access (GET) reporting how retrieval
+// uses the collection named by 'collection' (the default one when absent): the chunks retrieved
+// most often and the sources never retrieved, largest first, up to 'limit' of each (default 20):
+//
+//	{"chunks": 1200, "retrieved_chunks": 310, "hot": [...], "cold": [...]}
+//
+// Cold sources are documents retrieval cannot reach, or does not need; recently indexed ones
+// may just not have been asked about yet.
+func NewAccessStatsHandler(reportFn func(ctx context.Context, collection string, limit int) (repo.AccessReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		q := r.URL.Query()
+		collection := v.collection("collection", q.Get("collection"))
+		limit := v.intIn("limit", q.Get("limit"), 20, 1, 500)
+		if v.respond(w, r) {
+			return
+		}
+		report, err := reportFn(r.Context(), collection, limit)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reporting access statistics: %v", err))
+			return
+		}
+		hot := make([]hotChunkItem, 0, len(report.Hot))
+		for _, c := range report.Hot {
+			hot = append(hot, hotChunkItem(c))
+		}
+		cold := make([]coldSourceItem, 0, len(report.Cold))
+		for _, s := range report.Cold {
+			item := coldSourceItem{Source: s.Source, Chunks: s.Chunks}
+			if !s.IndexedAt.IsZero() {
+				item.IndexedAt = &s.IndexedAt
+			}
+			cold = append(cold, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"chunks":           report.Chunks,
+			"retrieved_chunks": report.RetrievedChunks,
+			"hot":              hot,
+			"cold":             cold,
+		})
+	}
+}
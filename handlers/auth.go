@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// WithAPIKeys requires every API request to carry one of keys, as "Authorization: Bearer <key>"
+// or an X-API-Key header, and scopes it to the tenant the key maps to (see repo.WithTenant), ""
+// for the unscoped corpus. Requests to /api/admin/ need one of adminKeys instead, which are
+// scoped the same way and accepted by every other endpoint too; a valid key that is not an admin
+// one is answered 403 there. The health check and the static files stay open; empty keys and
+// adminKeys disable the checks. The history of the users a request names is the one of its key
+// (see historyUser).
+func WithAPIKeys(keys, adminKeys map[string]string, next http.Handler) http.Handler {
+	if len(keys) == 0 && len(adminKeys) == 0 {
+		return next
+	}
+	tenants, admins := hashKeys(keys), hashKeys(adminKeys)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/api/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := r.Header.Get("X-API-Key")
+		if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = strings.TrimSpace(auth)
+		}
+		adminTenant, isAdmin := lookupKey(admins, key)
+		tenant, found := lookupKey(tenants, key)
+		if isAdmin {
+			tenant, found = adminTenant, true
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/admin/") && !isAdmin:
+			if found {
+				writeError(w, r, http.StatusForbidden, "this endpoint needs an admin API key")
+				return
+			}
+		case len(keys) == 0 && !found:
+			// the API is open, only the admin endpoints are not
+			next.ServeHTTP(w, r)
+			return
+		}
+		if key == "" || !found {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, r, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		ctx := context.WithValue(repo.WithTenant(r.Context(), tenant), callerKey{}, keyID(key))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type callerKey struct{}
+
+// keyID identifies an API key without revealing it
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// historyUser is the id the history of a user is kept under: user ids are declared by clients,
+// so with API keys they are scoped to the key of the request, which only reaches the history of
+// the users it named itself. Rotating a key starts new histories. Without keys (the API is open)
+// user is taken as is.
+func historyUser(ctx context.Context, user string) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	if caller == "" || user == "" {
+		return user
+	}
+	return caller + "." + user
+}
+
+// hashKeys maps the hash of every key to its tenant; keys are compared by hash in constant time
+// so response times do not reveal them
+func hashKeys(keys map[string]string) map[[sha256.Size]byte]string {
+	hashed := make(map[[sha256.Size]byte]string, len(keys))
+	for key, tenant := range keys {
+		hashed[sha256.Sum256([]byte(key))] = tenant
+	}
+	return hashed
+}
+
+// lookupKey returns the tenant of key among hashed keys, false if it is not one of them
+func lookupKey(hashed map[[sha256.Size]byte]string, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(key))
+	tenant, found := "", false
+	for h, t := range hashed {
+		if subtle.ConstantTimeCompare(h[:], sum[:]) == 1 {
+			tenant, found = t, true
+		}
+	}
+	return tenant, found
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+func TestWithAPIKeys(t *testing.T) {
+	var gotTenant string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { gotTenant = repo.TenantFrom(r.Context()) })
+
+	tests := []struct {
+		name      string
+		keys      map[string]string
+		adminKeys map[string]string
+		path      string
+		key       string
+		want      int
+		tenant    string
+	}{
+		{"open API", nil, nil, "/api/admin/chaos", "", http.StatusOK, ""},
+		{"tenant key", map[string]string{"k1": "acme"}, nil, "/api/query", "k1", http.StatusOK, "acme"},
+		{"missing key", map[string]string{"k1": "acme"}, nil, "/api/query", "", http.StatusUnauthorized, ""},
+		{"health stays open", map[string]string{"k1": "acme"}, nil, "/api/health", "", http.StatusOK, ""},
+		{"admin without admin keys", map[string]string{"k1": "acme"}, nil, "/api/admin/documents/delete", "k1", http.StatusForbidden, ""},
+		{"admin with a tenant key", map[string]string{"k1": "acme"}, map[string]string{"root": ""}, "/api/admin/chaos", "k1", http.StatusForbidden, ""},
+		{"admin key", map[string]string{"k1": "acme"}, map[string]string{"root": ""}, "/api/admin/chaos", "root", http.StatusOK, ""},
+		{"scoped admin key", map[string]string{"k1": "acme"}, map[string]string{"boss": "acme"}, "/api/admin/documents/delete", "boss", http.StatusOK, "acme"},
+		{"admin key elsewhere", map[string]string{"k1": "acme"}, map[string]string{"boss": "acme"}, "/api/query", "boss", http.StatusOK, "acme"},
+		{"open API, closed admin", nil, map[string]string{"root": ""}, "/api/admin/chaos", "", http.StatusUnauthorized, ""},
+		{"open API, other endpoint", nil, map[string]string{"root": ""}, "/api/query", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		gotTenant = ""
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.key != "" {
+			req.Header.Set("X-API-Key", tt.key)
+		}
+		rec := httptest.NewRecorder()
+		WithAPIKeys(tt.keys, tt.adminKeys, next).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if gotTenant != tt.tenant {
+			t.Errorf("%s: tenant %q, want %q", tt.name, gotTenant, tt.tenant)
+		}
+	}
+}
+
+func TestHistoryUser(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = historyUser(r.Context(), "alice") })
+	keys := map[string]string{"k1": "acme", "k2": "acme"}
+
+	users := make(map[string]string)
+	for _, key := range []string{"k1", "k2"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/history", nil)
+		req.Header.Set("X-API-Key", key)
+		WithAPIKeys(keys, nil, next).ServeHTTP(httptest.NewRecorder(), req)
+		users[key] = got
+	}
+	if users["k1"] == "alice" || users["k1"] == users["k2"] {
+		t.Errorf("history users of two keys: %q, %q; want distinct ids scoped to each key", users["k1"], users["k2"])
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		user string
+		want string
+	}{
+		{"open API", context.Background(), "alice", "alice"},
+		{"no user", context.WithValue(context.Background(), callerKey{}, "abc"), "", ""},
+		{"authenticated", context.WithValue(context.Background(), callerKey{}, "abc"), "alice", "abc.alice"},
+	}
+	for _, tt := range tests {
+		if got := historyUser(tt.ctx, tt.user); got != tt.want {
+			t.Errorf("%s: historyUser(%q) = %q, want %q", tt.name, tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+const (
+	// maxBatchQuestions bounds the questions of a batch query
+	maxBatchQuestions = 100
+	// maxBatchConcurrency bounds the questions of a batch answered at once
+	maxBatchConcurrency = 4
+)
+
+// batchResult is the NDJSON line of a question of a batch query, with its answer or its error
+type batchResult struct {
+	Index     int                `json:"index"`
+	Question  string             `json:"question"`
+	Answer    string             `json:"answer,omitempty"`
+	Sources   []sourceItem       `json:"sources,omitempty"`
+	Citations map[int]sourceItem `json:"citations,omitempty"`
+	Error     *APIError          `json:"error,omitempty"`
+}
+
+// NewBatchQueryHandler returns a handler answering many questions in one request (POST), for
+// reports over a corpus. It accepts
+//
+//	{"questions": ["...", "..."], "collection": "...", "sources": [...], "tags": [...], "k": 20,
+//	 "style": "concise", "template": "...", "max_tokens": 512, "concurrency": 2}
+//
+// (all but the questions optional, see NewQueryHandler for their meaning), retrieves context for
+// every question with searchFn, builds its prompt with promptFn and answers it with answerFn,
+// without streaming, through processFn's post-processor when non-nil. Questions are answered one
+// at a time, or 'concurrency' (up to 4) at once. Results are streamed as NDJSON as they complete,
+// one line per question:
+//
+//	{"index": 0, "question": "...", "answer": "...", "sources": [...], "citations": {"1": {...}}}
+//
+// or with an "error" (see APIError) in place of the answer when it failed; index is the place of
+// the question in the request. Nothing is recorded in the history.
+func NewBatchQueryHandler(
+	searchFn func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error),
+	promptFn func(template, question string, passages []service.Passage, imageDesc, style string) (string, error),
+	answerFn func(ctx context.Context, prompt string, maxTokens int) (string, error),
+	processFn func(passages []service.Passage) service.AnswerProcessor,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			Questions   []string `json:"questions"`
+			Collection  string   `json:"collection"`
+			Sources     []string `json:"sources"`
+			Tags        []string `json:"tags"`
+			K           int      `json:"k"`
+			Style       string   `json:"style"`
+			Template    string   `json:"template"`
+			MaxTokens   int      `json:"max_tokens"`
+			Concurrency int      `json:"concurrency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		if len(body.Questions) == 0 || len(body.Questions) > maxBatchQuestions {
+			v.fail("questions", "must list 1 to %d questions", maxBatchQuestions)
+		}
+		for i, q := range body.Questions {
+			if body.Questions[i] = strings.TrimSpace(q); body.Questions[i] == "" {
+				v.fail("questions", "question %d is empty", i)
+			}
+			v.maxRunes("questions", body.Questions[i], maxQuestionRunes)
+		}
+		filter := service.SearchOptions{
+			SearchFilter: repo.SearchFilter{
+				Collection: v.collection("collection", body.Collection),
+				Sources:    v.list("sources", body.Sources),
+				Tags:       v.tags("tags", body.Tags),
+			},
+			Compress: true,
+		}
+		switch {
+		case body.K == 0:
+			body.K = defaultQueryK
+		case body.K < 1 || body.K > maxQueryK:
+			v.fail("k", "must be between 1 and %d", maxQueryK)
+		}
+		body.Style = strings.TrimSpace(body.Style)
+		if !service.ValidStyle(body.Style) {
+			v.fail("style", "must be %s, %s or %s", service.StyleConcise, service.StyleDetailed, service.StyleBullet)
+		}
+		body.Template = strings.TrimSpace(body.Template)
+		v.maxRunes("template", body.Template, maxTemplateRunes)
+		if _, err := promptFn(body.Template, "", nil, "", body.Style); err != nil {
+			var unknown *service.UnknownPromptTemplateError
+			if errors.As(err, &unknown) {
+				v.fail("template", "unknown prompt template %q", unknown.Name)
+			}
+		}
+		if body.MaxTokens < 0 || body.MaxTokens > 32768 {
+			v.fail("max_tokens", "must be at most 32768, 0 for the default of the model")
+		}
+		switch {
+		case body.Concurrency == 0:
+			body.Concurrency = 1
+		case body.Concurrency < 1 || body.Concurrency > maxBatchConcurrency:
+			v.fail("concurrency", "must be between 1 and %d", maxBatchConcurrency)
+		}
+		if v.respond(w, r) {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, r, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+
+		answer := func(i int, question string) batchResult {
+			res := batchResult{Index: i, Question: question}
+			passages, err := searchFn(r.Context(), question, body.K, filter)
+			if err != nil {
+				res.Error = batchError(r, err, "error looking for context")
+				return res
+			}
+			prompt, err := promptFn(body.Template, question, passages, "", body.Style)
+			if err != nil {
+				res.Error = batchError(r, err, "error building prompt")
+				return res
+			}
+			text, err := answerFn(r.Context(), prompt, body.MaxTokens)
+			if err != nil {
+				res.Error = batchError(r, err, "error generating answer")
+				return res
+			}
+			if processFn != nil {
+				text = service.ProcessAnswer(processFn(passages), text)
+			}
+			res.Answer, res.Sources, res.Citations = text, sourceItems(passages), map[int]sourceItem{}
+			for _, n := range service.Citations(text, len(passages)) {
+				res.Citations[n] = res.Sources[n-1]
+			}
+			return res
+		}
+
+		// lines are written whole, one question at a time
+		var mu sync.Mutex
+		enc := json.NewEncoder(w)
+		sem := make(chan struct{}, body.Concurrency)
+		var wg sync.WaitGroup
+		for i, question := range body.Questions {
+			sem <- struct{}{}
+			if r.Context().Err() != nil {
+				// the client is gone: the answers left would be for nobody
+				<-sem
+				break
+			}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				res := answer(i, question)
+				mu.Lock()
+				defer mu.Unlock()
+				_ = enc.Encode(res)
+				flusher.Flush()
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// batchError records the failure of a question of a batch query and returns its APIError
+func batchError(r *http.Request, err error, msg string) *APIError {
+	class := recordFailure(r, err)
+	code := CodeInternal
+	var uc *repo.UnknownCollectionError
+	var oe *service.OllamaError
+	switch {
+	case errors.As(err, &uc):
+		code = CodeUnknownCollection
+	case errors.As(err, &oe):
+		code = CodeUpstream
+	}
+	return &APIError{
+		Code:      code,
+		Message:   fmt.Sprintf("%s: %v", msg, err),
+		Details:   map[string]string{"class": class},
+		RequestID: RequestID(r.Context()),
+	}
+}
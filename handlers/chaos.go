@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/chaos"
+)
+
+// faultJSON is the JSON form of a chaos.Fault
+type faultJSON struct {
+	LatencyMS   int64   `json:"latency_ms"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+func toFaultJSON(f chaos.Fault) faultJSON {
+	return faultJSON{LatencyMS: f.Latency.Milliseconds(), FailureRate: f.FailureRate}
+}
+
+func (f faultJSON) fault() (chaos.Fault, error) {
+	if f.LatencyMS < 0 || f.FailureRate < 0 || f.FailureRate > 1 {
+		return chaos.Fault{}, fmt.Errorf("'latency_ms' must be >= 0 and 'failure_rate' in [0, 1]")
+	}
+	return chaos.Fault{Latency: time.Duration(f.LatencyMS) * time.Millisecond, FailureRate: f.FailureRate}, nil
+}
+
+// NewChaosHandler returns the admin handler of the fault injector: GET returns the current
+// faults and PUT {"models": {"latency_ms": 500, "failure_rate": 0.2}, "db": {...}} replaces them
+// (an omitted dependency gets no faults)
+func NewChaosHandler(injector *chaos.Injector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var body struct {
+				Models faultJSON `json:"models"`
+				DB     faultJSON `json:"db"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+				return
+			}
+			var s chaos.Settings
+			var err error
+			if s.Models, err = body.Models.fault(); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("models: %v", err))
+				return
+			}
+			if s.DB, err = body.DB.fault(); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("db: %v", err))
+				return
+			}
+			injector.Set(s)
+		default:
+			methodNotAllowed(w, r)
+			return
+		}
+		s := injector.Settings()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"models": toFaultJSON(s.Models), "db": toFaultJSON(s.DB)})
+	}
+}
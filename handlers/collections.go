@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewCollectionsHandler returns a handler for /api/collections. GET lists the collections with
+// their model and size; POST with a JSON body {"name": "work"} creates an empty collection
+// embedded with the default model, to upload into and query with the 'collection' parameter.
+func NewCollectionsHandler(listFn func(ctx context.Context) ([]service.CollectionInfo, error),
+	createFn func(ctx context.Context, name string) (repo.Collection, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			items, err := listFn(r.Context())
+			if err != nil {
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error listing collections: %v", err))
+				return
+			}
+			if items == nil {
+				items = []service.CollectionInfo{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+		case http.MethodPost:
+			var body struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+				return
+			}
+			body.Name = strings.TrimSpace(body.Name)
+			var v validation
+			if v.required("name", body.Name) {
+				body.Name = v.collection("name", body.Name)
+			}
+			if v.respond(w, r) {
+				return
+			}
+			c, err := createFn(r.Context(), body.Name)
+			if err != nil {
+				if errors.Is(err, service.ErrCollectionExists) {
+					writeError(w, r, http.StatusConflict, fmt.Sprintf("collection %q already exists", body.Name))
+					return
+				}
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error creating collection: %v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "name": c.Name, "model": c.Model, "dimension": c.Dimension})
+		default:
+			methodNotAllowed(w, r)
+		}
+	}
+}
+
+// NewCollectionHandler returns a handler for /api/collections/{name}: DELETE deletes a
+// collection created through the API with all its documents. Configured collections are
+// refused with 409.
+func NewCollectionHandler(deleteFn func(ctx context.Context, name string) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, r)
+			return
+		}
+		name := r.PathValue("name")
+		deleted, err := deleteFn(r.Context(), name)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			if errors.Is(err, service.ErrCollectionConfigured) || errors.Is(err, service.ErrReindexRunning) {
+				writeError(w, r, http.StatusConflict, err.Error())
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error deleting collection: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "deleted_chunks": deleted})
+	}
+}
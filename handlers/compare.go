@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewCompareHandler returns an SSE handler for /api/query/compare that retrieves context for a
+// question like the query endpoint does, with the same parameters (see NewQueryHandler), and
+// runs the same prompt through two of models concurrently, named by two 'model' query params
+// (the first two models by default). Their answers are streamed interleaved, every token as
+//
+//	event: token
+//	data: {"model": "llama3.2", "response": "..."}
+//
+// then "event: end" with {"model": ...} when a model is done, an "error" event with the model in
+// its details when it fails, and "event: done" once both are finished. Nothing is recorded in
+// the history. Running two models at once needs an Ollama server allowed to keep both loaded
+// (OLLAMA_MAX_LOADED_MODELS). Each answer goes through its own processFn post-processor, when
+// non-nil; modelOptions are among the Ollama options of both answers.
+func NewCompareHandler(
+	searchFn func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error),
+	describeFn func(ctx context.Context, img []byte) (string, error),
+	promptFn func(template, question string, passages []service.Passage, imageDesc, style string) (string, error),
+	models []string,
+	keepAlive any,
+	modelOptions map[string]any,
+	ollamaURL string,
+	httpClient *http.Client,
+	sanitize bool,
+	processFn func(passages []service.Passage) service.AnswerProcessor,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var v validation
+		pair := v.list("model", r.URL.Query()["model"])
+		switch {
+		case len(pair) == 0:
+			pair = models[:2]
+		case len(pair) != 2 || pair[0] == pair[1]:
+			v.fail("model", "must be given twice, with two different models")
+		default:
+			for _, m := range pair {
+				if !slices.Contains(models, m) {
+					v.fail("model", "must be one of %s", strings.Join(models, ", "))
+					break
+				}
+			}
+		}
+		if v.respond(w, r) {
+			return
+		}
+		q, ok := retrieveForQuery(w, r, searchFn, describeFn, nil, nil)
+		if !ok {
+			return
+		}
+		prompt, ok := buildPrompt(w, r, promptFn, q)
+		if !ok {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, r, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		// both answers share the stream, one event at a time
+		var mu sync.Mutex
+		send := func(event string, data any) {
+			payload, _ := json.Marshal(data)
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+			flusher.Flush()
+		}
+		var wg sync.WaitGroup
+		for _, model := range pair {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reqBody := map[string]any{
+					"model":  model,
+					"prompt": prompt,
+					"stream": true,
+				}
+				if keepAlive != nil {
+					reqBody["keep_alive"] = keepAlive
+				}
+				options := maps.Clone(modelOptions)
+				if options == nil {
+					options = map[string]any{}
+				}
+				if q.maxTokens > 0 {
+					options["num_predict"] = q.maxTokens
+				}
+				if len(options) > 0 {
+					reqBody["options"] = options
+				}
+				var proc service.AnswerProcessor
+				if processFn != nil {
+					proc = processFn(q.passages)
+				}
+				var san *mdSanitizer
+				if sanitize {
+					san = newMDSanitizer()
+				}
+				show := func(text string) {
+					if san != nil {
+						text = san.Write(text)
+					}
+					if text != "" {
+						send("token", map[string]string{"model": model, "response": text})
+					}
+				}
+				emit := func(text string) {
+					if proc != nil {
+						text = proc.Write(text)
+					}
+					show(text)
+				}
+				if err := streamGeneration(r.Context(), httpClient, ollamaURL, reqBody, emit, nil); err != nil {
+					if r.Context().Err() == nil {
+						recordFailure(r, err)
+						send("error", APIError{
+							Code:      CodeStreamFailed,
+							Message:   strings.ReplaceAll(err.Error(), "\n", " "),
+							Details:   map[string]string{"model": model},
+							RequestID: RequestID(r.Context()),
+						})
+					}
+					return
+				}
+				if proc != nil {
+					show(proc.Flush())
+				}
+				if san != nil {
+					if text := san.Flush(); text != "" {
+						send("token", map[string]string{"model": model, "response": text})
+					}
+				}
+				send("end", map[string]string{"model": model})
+			}()
+		}
+		wg.Wait()
+		fmt.Fprintf(w, "event: done\ndata: done\n\n")
+		flusher.Flush()
+	}
+}
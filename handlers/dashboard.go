@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/metrics"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// recentQueryItem is the JSON view of a logged question
+type recentQueryItem struct {
+	Collection string    `json:"collection"`
+	Query      string    `json:"query"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// dependencyItem is the JSON view of a dependency check
+type dependencyItem struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// latencyItem is the JSON view of the latency percentiles of a route
+type latencyItem struct {
+	Count int64   `json:"count"`
+	P50MS float64 `json:"p50_ms"`
+	P90MS float64 `json:"p90_ms"`
+	P99MS float64 `json:"p99_ms"`
+}
+
+// milliseconds renders d in milliseconds, to the microsecond
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// NewDashboardHandler returns a handler for /api/dashboard (GET) gathering what the admin home
+// screen of the web UI shows in one call: the collections with their size, the 'limit' latest
+// questions and uploads (default 10), the state of the database and Ollama, and the latency
+// percentiles of every API route over its latest requests (see WithLatency):
+//
+//	{"collections": [...], "uploads": 42, "recent_uploads": [...], "recent_queries": [...],
+//	 "dependencies": [{"name": "ollama", "status": "ok", "latency_ms": 3.2}],
+//	 "latency": {"/api/query": {"count": 120, "p50_ms": 850, "p90_ms": 2100, "p99_ms": 4000}}}
+//
+// Questions are listed only when they are logged (-query-log). Latencies cover every tenant.
+// The response is 200 even when a dependency is down: its status says so.
+func NewDashboardHandler(dashboardFn func(ctx context.Context, limit int) (service.Dashboard, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		limit := v.intIn("limit", r.URL.Query().Get("limit"), 10, 1, 100)
+		if v.respond(w, r) {
+			return
+		}
+		d, err := dashboardFn(r.Context(), limit)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error loading dashboard: %v", err))
+			return
+		}
+		collections := d.Collections
+		if collections == nil {
+			collections = []service.CollectionInfo{}
+		}
+		queries := make([]recentQueryItem, 0, len(d.RecentQueries))
+		for _, q := range d.RecentQueries {
+			queries = append(queries, recentQueryItem(q))
+		}
+		uploads := make([]documentItem, 0, len(d.RecentUploads))
+		for _, doc := range d.RecentUploads {
+			uploads = append(uploads, newDocumentItem(doc))
+		}
+		deps := make([]dependencyItem, 0, len(d.Dependencies))
+		for _, dep := range d.Dependencies {
+			deps = append(deps, dependencyItem{Name: dep.Name, Status: dep.Status, LatencyMS: milliseconds(dep.Latency), Detail: dep.Detail})
+		}
+		latency := make(map[string]latencyItem)
+		for route, s := range metrics.Latencies.Summaries() {
+			latency[route] = latencyItem{Count: s.Count, P50MS: milliseconds(s.P50), P90MS: milliseconds(s.P90), P99MS: milliseconds(s.P99)}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"collections":    collections,
+			"uploads":        d.Uploads,
+			"recent_uploads": uploads,
+			"recent_queries": queries,
+			"dependencies":   deps,
+			"latency":        latency,
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// documentItem is the JSON view of an indexed document
+type documentItem struct {
+	ID          int64     `json:"id"`
+	Collection  string    `json:"collection"`
+	Source      string    `json:"source"`
+	Session     string    `json:"session,omitempty"`
+	Type        string    `json:"doc_type,omitempty"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	Chunks      int       `json:"chunks"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Version numbers the documents of a source; State is current, staged or retired
+	Version   int        `json:"version"`
+	State     string     `json:"state"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// documentChunkItem is the JSON view of a chunk of a document
+type documentChunkItem struct {
+	ID      int    `json:"id"`
+	Page    int    `json:"page,omitempty"`
+	Section string `json:"section,omitempty"`
+	Content string `json:"content"`
+}
+
+func newDocumentItem(d repo.IndexedDocument) documentItem {
+	return documentItem{
+		ID:          d.ID,
+		Collection:  d.Collection,
+		Source:      d.Source,
+		Session:     d.Session,
+		Type:        d.DocType,
+		ContentHash: d.ContentHash,
+		Chunks:      d.Chunks,
+		CreatedAt:   d.CreatedAt,
+		Version:     d.Version,
+		State:       d.State,
+		RetiredAt:   d.RetiredAt,
+		ExpiresAt:   d.ExpiresAt,
+	}
+}
+
+// NewDocumentsHandler returns a handler listing the indexed documents, newest first (GET).
+// 'collection' restricts the list to a collection; 'limit' (default 50) and 'offset' page it,
+// and the response carries the total number of documents.
+func NewDocumentsHandler(listFn func(ctx context.Context, opts repo.ListDocumentsOptions) ([]repo.IndexedDocument, int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		opts := repo.ListDocumentsOptions{
+			Collection: v.collection("collection", r.FormValue("collection")),
+			Limit:      v.intIn("limit", r.FormValue("limit"), 50, 1, 500),
+			Offset:     v.intIn("offset", r.FormValue("offset"), 0, 0, 1<<31-1),
+		}
+		if v.respond(w, r) {
+			return
+		}
+		docs, total, err := listFn(r.Context(), opts)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error listing documents: %v", err))
+			return
+		}
+		items := make([]documentItem, 0, len(docs))
+		for _, d := range docs {
+			items = append(items, newDocumentItem(d))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"items": items, "total": total, "limit": opts.Limit, "offset": opts.Offset})
+	}
+}
+
+// NewDocumentHandler returns a handler for /api/documents/{id}: GET returns the document with
+// its chunks, DELETE removes it with every chunk, stored or quarantined
+func NewDocumentHandler(
+	getFn func(ctx context.Context, id int64) (repo.IndexedDocument, bool, error),
+	chunksFn func(ctx context.Context, id int64) ([]repo.Document, error),
+	deleteFn func(ctx context.Context, id int64) (int64, bool, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			methodNotAllowed(w, r)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, r, http.StatusNotFound, "no such document")
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			deleted, found, err := deleteFn(r.Context(), id)
+			if err != nil {
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error deleting document: %v", err))
+				return
+			}
+			if !found {
+				writeError(w, r, http.StatusNotFound, "no such document")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "deleted_chunks": deleted})
+			return
+		}
+
+		doc, found, err := getFn(r.Context(), id)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading document: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "no such document")
+			return
+		}
+		chunks, err := chunksFn(r.Context(), id)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading document: %v", err))
+			return
+		}
+		items := make([]documentChunkItem, 0, len(chunks))
+		for _, c := range chunks {
+			items = append(items, documentChunkItem{ID: c.ID, Page: c.Page, Section: c.Section, Content: c.Content})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			documentItem
+			Items []documentChunkItem `json:"items"`
+		}{newDocumentItem(doc), items})
+	}
+}
+
+// NewDocumentWeightHandler returns a handler that sets the ranking weight of stored chunks.
+// It accepts a JSON body {"id": 12, "weight": 0.5} for a single chunk or
+// {"source": "policy-2019.pdf", "weight": 0.5} for every chunk of a source, in the default
+// collection unless "collection" names another.
+// Retrieval scores are multiplied by the weight: 1 is neutral, 0 hides the content.
+func NewDocumentWeightHandler(
+	setByIDFn func(ctx context.Context, id int, weight float64) (int64, error),
+	setBySourceFn func(ctx context.Context, collection, source string, weight float64) (int64, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			methodNotAllowed(w, r)
+			return
+		}
+
+		var body struct {
+			ID         int      `json:"id"`
+			Collection string   `json:"collection"`
+			Source     string   `json:"source"`
+			Weight     *float64 `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		body.Source = strings.TrimSpace(body.Source)
+		var v validation
+		collection := v.collection("collection", body.Collection)
+		if body.Weight == nil || *body.Weight < 0 {
+			v.fail("weight", "must be a number >= 0")
+		}
+		if (body.ID == 0) == (body.Source == "") {
+			v.fail("id", "or 'source' is required, not both")
+		}
+		if v.respond(w, r) {
+			return
+		}
+
+		var updated int64
+		var err error
+		if body.ID != 0 {
+			updated, err = setByIDFn(r.Context(), body.ID, *body.Weight)
+		} else {
+			updated, err = setBySourceFn(r.Context(), collection, body.Source, *body.Weight)
+		}
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error updating weight: %v", err))
+			return
+		}
+		if updated == 0 {
+			writeError(w, r, http.StatusNotFound, "no matching chunks")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "updated": updated})
+	}
+}
+
+// relatedDocumentItem is a document with its similarity to the one related documents are looked for
+type relatedDocumentItem struct {
+	documentItem
+	Score float64 `json:"score"`
+}
+
+// NewRelatedDocumentsHandler returns a handler for /api/documents/{id}/related (GET), listing the
+// documents most similar to a document by their document-level embeddings; 'k' (default 5) is
+// the number returned.
+func NewRelatedDocumentsHandler(relatedFn func(ctx context.Context, id int64, topK int) ([]repo.ScoredDocument, bool, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			writeError(w, r, http.StatusNotFound, "no such document")
+			return
+		}
+		var v validation
+		k := v.intIn("k", r.FormValue("k"), 5, 1, 50)
+		if v.respond(w, r) {
+			return
+		}
+		related, found, err := relatedFn(r.Context(), id, k)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error searching related documents: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "no such document")
+			return
+		}
+		items := make([]relatedDocumentItem, 0, len(related))
+		for _, d := range related {
+			items = append(items, relatedDocumentItem{newDocumentItem(d.IndexedDocument), d.Score})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+	}
+}
+
+// documentChangeItem is the JSON view of an entry of the change feed
+type documentChangeItem struct {
+	Cursor     string    `json:"cursor"`
+	Op         string    `json:"op"`
+	DocumentID int64     `json:"document_id"`
+	Collection string    `json:"collection"`
+	Source     string    `json:"source"`
+	At         time.Time `json:"at"`
+}
+
+// NewChangesHandler returns the change feed of the documents (GET), for external systems
+// mirroring the corpus: the documents inserted, updated or deleted after the 'since' cursor (the
+// start of the feed without it), in 'collection' when given, 'limit' (default 100) at a time:
+//
+//	{"changes": [{"cursor": "...", "op": "insert", "document_id": 12, "collection": "default", "source": "a.pdf", "at": "..."}], "cursor": "...", "more": true}
+//
+// A mirror keeps the returned cursor for 'since' of its next request, made right away while
+// "more" is true. An update stands for any change of the document or its chunks, read back from
+// /api/documents/{id}.
+func NewChangesHandler(changesFn func(ctx context.Context, since repo.ChangeCursor, collection string, limit int) ([]repo.DocumentChange, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		since, err := repo.ParseChangeCursor(r.FormValue("since"))
+		if err != nil {
+			v.fail("since", "must be a cursor returned by this endpoint")
+		}
+		collection := v.collection("collection", r.FormValue("collection"))
+		limit := v.intIn("limit", r.FormValue("limit"), 100, 1, 1000)
+		if v.respond(w, r) {
+			return
+		}
+		changes, err := changesFn(r.Context(), since, collection, limit)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading document changes: %v", err))
+			return
+		}
+		items := make([]documentChangeItem, 0, len(changes))
+		for _, ch := range changes {
+			items = append(items, documentChangeItem{
+				Cursor:     ch.Cursor.String(),
+				Op:         ch.Op,
+				DocumentID: ch.DocumentID,
+				Collection: ch.Collection,
+				Source:     ch.Source,
+				At:         ch.At,
+			})
+			since = ch.Cursor
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"changes": items, "cursor": since.String(), "more": len(changes) == limit})
+	}
+}
+
+// NewBulkDeleteHandler returns the admin handler deleting every document matching filters, with
+// their chunks (POST): 'source_prefix' starting its source, every 'tag' on one of its chunks,
+// uploaded before 'before' (YYYY-MM-DD), in 'collection' when given. At least one of the first
+// three is required. The request is a dry run counting the matches unless 'dry_run=false':
+//
+//	{"dry_run": true, "documents": 1200, "chunks": 48000}
+//
+// Documents are deleted in batches, so a failure leaves the batches already deleted deleted,
+// as the counts of the error details say.
+func NewBulkDeleteHandler(deleteFn func(ctx context.Context, pr repo.DocumentPredicate, dryRun bool) (service.BulkDeleteReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		_ = r.ParseForm()
+		var v validation
+		pr := repo.DocumentPredicate{
+			Collection:     v.collection("collection", r.FormValue("collection")),
+			Tags:           v.tags("tag", r.Form["tag"]),
+			SourcePrefix:   strings.TrimSpace(r.FormValue("source_prefix")),
+			UploadedBefore: v.date("before", r.FormValue("before")),
+		}
+		dryRun := v.boolean("dry_run", r.FormValue("dry_run"), true)
+		if len(pr.Tags) == 0 && pr.SourcePrefix == "" && pr.UploadedBefore.IsZero() {
+			v.fail("source_prefix", "a 'source_prefix', 'tag' or 'before' filter is required")
+		}
+		if v.respond(w, r) {
+			return
+		}
+		report, err := deleteFn(r.Context(), pr, dryRun)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			class := recordFailure(r, err)
+			writeAPIError(w, r, http.StatusInternalServerError, APIError{
+				Code:    CodeInternal,
+				Message: fmt.Sprintf("error deleting documents: %v", err),
+				Details: map[string]any{"class": class, "documents": report.Documents, "chunks": report.Chunks},
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"dry_run": dryRun, "documents": report.Documents, "chunks": report.Chunks})
+	}
+}
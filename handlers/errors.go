@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/metrics"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// Error codes of APIError, stable so clients can branch on them instead of parsing messages
+const (
+	CodeBadRequest        = "bad_request"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeMethodNotAllowed  = "method_not_allowed"
+	CodeNotFound          = "not_found"
+	CodeConflict          = "conflict"
+	CodePayloadTooLarge   = "payload_too_large"
+	CodeUnprocessable     = "unprocessable"
+	CodeValidation        = "validation_failed"
+	CodeInternal          = "internal"
+	CodeUpstream          = "upstream_error"
+	CodeUnavailable       = "unavailable"
+	CodeDimensionMismatch = "dimension_mismatch"
+	CodeUnknownCollection = "unknown_collection"
+	CodeQueueFull         = "queue_full"
+	CodeOverloaded        = "overloaded"
+	CodeJobExpired        = "job_expired"
+	CodeStreamFailed      = "stream_failed"
+)
+
+// APIError is the error of every failed request, sent as {"error": APIError} with the HTTP
+// status, or as the data of an "error" event once an SSE stream has started
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details are structured data about the error (the dimensions of a mismatch...)
+	Details any `json:"details,omitempty"`
+	// RequestID matches the X-Request-ID header, to find the request in the server logs
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// statusCodes is the default error code of each status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusBadGateway:            CodeUpstream,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// writeError answers status with msg and the default code of the status
+func writeError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	writeAPIError(w, r, status, APIError{Code: code, Message: msg})
+}
+
+// writeAPIError answers status with e, filling its request ID
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, e APIError) {
+	e.RequestID = RequestID(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]APIError{"error": e})
+}
+
+// writeFailure answers status with msg after recording err under its failure class (see
+// metrics.RecordFailure), which the details carry too
+func writeFailure(w http.ResponseWriter, r *http.Request, status int, err error, msg string) {
+	class := recordFailure(r, err)
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	writeAPIError(w, r, status, APIError{Code: code, Message: msg, Details: map[string]string{"class": class}})
+}
+
+// recordFailure records err under its failure class, the operation being the route of r
+func recordFailure(r *http.Request, err error) string {
+	op := r.Pattern
+	if op == "" {
+		op = r.URL.Path
+	}
+	return metrics.RecordFailure(op, RequestID(r.Context()), err)
+}
+
+// methodNotAllowed answers 405
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+}
+
+// writeSSEError sends an "error" event carrying an APIError, for failures after the stream started
+func writeSSEError(w io.Writer, r *http.Request, code, msg string) {
+	data, _ := json.Marshal(APIError{Code: code, Message: strings.ReplaceAll(msg, "\n", " "), RequestID: RequestID(r.Context())})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+}
+
+// writeDimensionMismatch answers 409 with the mismatch details if err is an embedding
+// dimension mismatch, reporting whether it did
+func writeDimensionMismatch(w http.ResponseWriter, r *http.Request, err error) bool {
+	var dm *repo.DimensionMismatchError
+	if !errors.As(err, &dm) {
+		return false
+	}
+	recordFailure(r, err)
+	writeAPIError(w, r, http.StatusConflict, APIError{
+		Code:    CodeDimensionMismatch,
+		Message: dm.Error(),
+		Details: map[string]any{
+			"model":              dm.Model,
+			"collection":         dm.Collection,
+			"dimension":          dm.Got,
+			"expected_dimension": dm.Expected,
+		},
+	})
+	return true
+}
+
+// writeUnknownCollection answers 404 if err is about a collection that is not configured,
+// reporting whether it did
+func writeUnknownCollection(w http.ResponseWriter, r *http.Request, err error) bool {
+	var uc *repo.UnknownCollectionError
+	if !errors.As(err, &uc) {
+		return false
+	}
+	writeAPIError(w, r, http.StatusNotFound, APIError{
+		Code:    CodeUnknownCollection,
+		Message: uc.Error(),
+		Details: map[string]string{"collection": uc.Name},
+	})
+	return true
+}
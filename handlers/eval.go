@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewEvalTrendsHandler returns a handler for GET /api/eval/trends?limit=30 listing the latest runs
+// of the golden-question suite, oldest first, each with its change since the previous run and
+// whether it is a regression
+func NewEvalTrendsHandler(trendsFn func(ctx context.Context, limit int) ([]service.EvalTrend, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		limit := v.intIn("limit", r.FormValue("limit"), 30, 1, 1000)
+		if v.respond(w, r) {
+			return
+		}
+		trends, err := trendsFn(r.Context(), limit)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading evaluation runs: %v", err))
+			return
+		}
+		if trends == nil {
+			trends = []service.EvalTrend{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"runs": trends})
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// historyItem is the JSON view of a history entry
+type historyItem struct {
+	ID        int64     `json:"id"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	CreatedAt time.Time `json:"created_at"`
+	Rating    int       `json:"rating"`
+	// Score is the similarity to the search query (1 - cosine distance), absent when listing
+	Score *float64 `json:"score,omitempty"`
+}
+
+// NewHistoryHandler returns a handler over a user's past questions and answers:
+// GET /api/history?user=...&q=backups&k=10 searches them by meaning, and without 'q' lists the
+// latest ones ('limit', default 20). With API keys only the users of the caller's key are
+// reached (see historyUser).
+func NewHistoryHandler(
+	listFn func(ctx context.Context, user string, limit int) ([]repo.HistoryEntry, error),
+	searchFn func(ctx context.Context, user, query string, topK int) ([]repo.HistoryEntry, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		user := historyUser(r.Context(), v.userID("user", r.FormValue("user"), true))
+		limit := v.intIn("limit", r.FormValue("limit"), 20, 1, 200)
+		limit = v.intIn("k", r.FormValue("k"), limit, 1, 200)
+		query := strings.TrimSpace(r.FormValue("q"))
+		v.maxRunes("q", query, maxQuestionRunes)
+		if v.respond(w, r) {
+			return
+		}
+
+		var entries []repo.HistoryEntry
+		var err error
+		if query != "" {
+			entries, err = searchFn(r.Context(), user, query, limit)
+		} else {
+			entries, err = listFn(r.Context(), user, limit)
+		}
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading history: %v", err))
+			return
+		}
+		items := make([]historyItem, 0, len(entries))
+		for _, e := range entries {
+			item := historyItem{ID: e.ID, Question: e.Question, Answer: e.Answer, CreatedAt: e.CreatedAt, Rating: e.Rating}
+			if query != "" {
+				score := 1 - e.Distance
+				item.Score = &score
+			}
+			items = append(items, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+	}
+}
+
+// NewAnswerFeedbackHandler returns a handler that rates an answer of a user's history.
+// It accepts POST {"user": "...", "id": 42, "rating": 1}: 1 is a thumbs-up, -1 a thumbs-down
+// and 0 clears the rating.
+func NewAnswerFeedbackHandler(rateFn func(ctx context.Context, user string, id int64, rating int) (bool, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			User   string `json:"user"`
+			ID     int64  `json:"id"`
+			Rating *int   `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		body.User = historyUser(r.Context(), v.userID("user", body.User, true))
+		if body.ID <= 0 {
+			v.fail("id", "must be the id of a history entry")
+		}
+		if body.Rating == nil || *body.Rating < -1 || *body.Rating > 1 {
+			v.fail("rating", "must be 1, 0 or -1")
+		}
+		if v.respond(w, r) {
+			return
+		}
+		found, err := rateFn(r.Context(), body.User, body.ID, *body.Rating)
+		if err != nil {
+			if writeDimensionMismatch(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error rating answer: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "no such answer in the user's history")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	}
+}
+
+// NewAnswerClickHandler returns a handler that records the passages of an answer a user opened,
+// telling relevant passages from the ones merely retrieved. It accepts
+// POST {"user": "...", "id": 42, "passages": [1, 3]}, passages numbered from 1 as in the prompt.
+func NewAnswerClickHandler(clickFn func(ctx context.Context, user string, id int64, passages []int) (bool, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			User     string `json:"user"`
+			ID       int64  `json:"id"`
+			Passages []int  `json:"passages"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		body.User = historyUser(r.Context(), v.userID("user", body.User, true))
+		if body.ID <= 0 {
+			v.fail("id", "must be the id of a history entry")
+		}
+		if len(body.Passages) == 0 || len(body.Passages) > maxQueryK || slices.Min(body.Passages) < 1 {
+			v.fail("passages", "must list 1 to %d passage numbers, from 1", maxQueryK)
+		}
+		if v.respond(w, r) {
+			return
+		}
+		found, err := clickFn(r.Context(), body.User, body.ID, body.Passages)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error recording clicks: %v", err))
+			return
+		}
+		if !found {
+			writeError(w, r, http.StatusNotFound, "no such answer in the user's history")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	}
+}
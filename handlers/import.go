@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/jobs"
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewImportHandler returns a handler (POST, multipart) importing the chunks exported from a
+// LangChain or LlamaIndex prototype, one or more 'file' parts of any format loaders.ParseExport
+// reads. The chunks are grouped into one document per source and stored as they were split,
+// each with its metadata; chunks exported with an embedding of the collection's dimension keep
+// it instead of being embedded again, so the export must come from the same embedding model.
+// The optional 'collection', 'doc_type', 'replace', 'ttl', 'tag' and 'meta' fields apply to every
+// document as for uploads (see NewUploadHandler), and the response lists the outcome of every
+// document like a multi-file upload, each with the format of its file as type. With a
+// submitFn the documents are indexed in a background job.
+func NewImportHandler(
+	indexFn func(ctx context.Context, doc service.Document) (service.IndexReport, error),
+	submitFn func(kind, owner string, fn jobs.Func) (jobs.Job, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error parsing form: %v", err))
+			return
+		}
+
+		var v validation
+		base := service.Document{
+			Collection: v.collection("collection", r.FormValue("collection")),
+			Replace:    v.boolean("replace", r.FormValue("replace"), true),
+			Metadata:   v.metadata("meta", r.MultipartForm.Value["meta"]),
+			TTL:        v.duration("ttl", r.FormValue("ttl")),
+			Origin:     service.OriginUpload,
+		}
+		if tags := v.tags("tag", r.MultipartForm.Value["tag"]); len(tags) > 0 {
+			if base.Metadata == nil {
+				base.Metadata = make(map[string]string)
+			}
+			base.Metadata[repo.MetaTags] = strings.Join(tags, ",")
+		}
+		if dt := r.FormValue("doc_type"); dt != "" {
+			var err error
+			if base.Type, err = service.ParseDocType(dt); err != nil {
+				v.fail("doc_type", "%v", err)
+			}
+		}
+		files := r.MultipartForm.File["file"]
+		if len(files) == 0 {
+			v.fail("file", "is required")
+		}
+		if v.respond(w, r) {
+			return
+		}
+
+		var results []fileResult
+		for _, fh := range files {
+			f, err := fh.Open()
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error reading %s: %v", fh.Filename, err))
+				return
+			}
+			docs, format, err := loaders.ParseExport(f)
+			f.Close()
+			if err != nil {
+				writeError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("error reading %s: %v", fh.Filename, err))
+				return
+			}
+			for _, doc := range docs {
+				withSettings(&doc, base)
+				results = append(results, fileResult{Source: doc.Source, Type: format, doc: &doc})
+			}
+		}
+
+		if submitFn != nil {
+			submitJob(w, r, submitFn, "import", func(ctx context.Context, progress func(done, total int)) (any, error) {
+				return filesResponse(indexFiles(ctx, results, indexFn, progress)), nil
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(filesResponse(indexFiles(r.Context(), results, indexFn, nil)))
+	}
+}
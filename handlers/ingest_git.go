@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/connectors"
+)
+
+// scpLikeRe matches SSH remotes written as user@host:path
+var scpLikeRe = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^-]`)
+
+// NewGitIngestHandler returns a handler that indexes a git repository. It accepts a JSON body
+// {"url": "https://github.com/org/repo.git", "branch": "main", "collection": "..."} (branch and
+// collection optional) and answers with the sync report. Repeated calls only re-index the files
+// changed since the last synced commit.
+func NewGitIngestHandler(syncFn func(ctx context.Context, repoURL, branch, collection string) (connectors.GitReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			URL        string `json:"url"`
+			Branch     string `json:"branch"`
+			Collection string `json:"collection"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		repoURL := strings.TrimSpace(body.URL)
+		if v.required("url", repoURL) && !validRepoURL(repoURL) {
+			v.fail("url", "must be an http(s), ssh or git URL, or user@host:path")
+		}
+		branch := strings.TrimSpace(body.Branch)
+		if strings.HasPrefix(branch, "-") || strings.ContainsAny(branch, " \t\n~^:?*[\\") {
+			v.fail("branch", "must be a branch name")
+		}
+		collection := v.collection("collection", body.Collection)
+		if v.respond(w, r) {
+			return
+		}
+
+		report, err := syncFn(r.Context(), repoURL, branch, collection)
+		if err != nil {
+			if writeDimensionMismatch(w, r, err) || writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error syncing repository: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": report.Failed == 0, "report": report})
+	}
+}
+
+// validRepoURL accepts remote repositories only: no local paths or helper transports
+func validRepoURL(v string) bool {
+	if scpLikeRe.MatchString(v) {
+		return true
+	}
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "ssh", "git":
+		return true
+	}
+	return false
+}
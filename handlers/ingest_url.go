@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/connectors"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+const (
+	maxFigureBytes = 5 << 20
+	maxPageFigures = 10
+)
+
+// NewURLIngestHandler returns a handler that accepts a JSON body
+// {"url": "https://...", "collection": "...", "doc_type": "...", "ttl": "72h"} (all but the URL optional), fetches the page,
+// extracts its main content (HTML boilerplate such as navigation and footers is dropped) and indexes it
+// with the URL as source. When withFigures is set, images of the main content are downloaded and passed
+// along for captioning.
+func NewURLIngestHandler(
+	indexFn func(ctx context.Context, doc service.Document) (service.IndexReport, error),
+	httpClient *http.Client,
+	withFigures bool,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+
+		var body struct {
+			URL        string `json:"url"`
+			Collection string `json:"collection"`
+			DocType    string `json:"doc_type"`
+			TTL        string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		pageURL := v.httpURL("url", body.URL)
+		body.Collection = v.collection("collection", body.Collection)
+		ttl := v.duration("ttl", body.TTL)
+		docType, err := service.ParseDocType(body.DocType)
+		if err != nil {
+			v.fail("doc_type", "%v", err)
+		}
+		if v.respond(w, r) {
+			return
+		}
+
+		doc, title, images, err := connectors.FetchPage(r.Context(), httpClient, pageURL)
+		if errors.Is(err, connectors.ErrForbiddenAddress) {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("cannot fetch %s: %v", pageURL, err))
+			return
+		}
+		if err != nil {
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error fetching %s: %v", pageURL, err))
+			return
+		}
+		if strings.TrimSpace(doc.Content) == "" {
+			writeError(w, r, http.StatusUnprocessableEntity, "the page has no readable content")
+			return
+		}
+		doc.Collection = strings.TrimSpace(body.Collection)
+		doc.Type = docType
+		doc.TTL, doc.Origin = ttl, service.OriginURL
+		// fetching a page again indexes its new version in place of the old one
+		doc.Replace = true
+		if withFigures {
+			doc.Figures = fetchFigures(r.Context(), httpClient, pageURL, images)
+		}
+
+		log.Printf("Indexing %s (len=%d, figures=%d)", doc.Source, len(doc.Content), len(doc.Figures))
+		report, err := indexFn(r.Context(), doc)
+		if err != nil {
+			if writeDimensionMismatch(w, r, err) || writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error indexing document: %v", err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "source": doc.Source, "title": title, "report": report})
+	}
+}
+
+// fetchFigures downloads up to maxPageFigures images referenced by the page, skipping failures
+func fetchFigures(ctx context.Context, client *http.Client, base *url.URL, refs []string) []service.Figure {
+	var figures []service.Figure
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if len(figures) == maxPageFigures {
+			break
+		}
+		u, err := base.Parse(ref)
+		if err != nil || seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+		data, contentType, _, err := connectors.Fetch(ctx, client, u.String(), maxFigureBytes)
+		if err != nil || !strings.HasPrefix(contentType, "image/") {
+			continue
+		}
+		figures = append(figures, service.Figure{Name: path.Base(u.Path), Data: data})
+	}
+	return figures
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/jobs"
+	"github.com/Thaizir/go-local-RAG/metrics"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// submitJob queues fn and answers 202 with the job and its status URL, or 503 when the queue is full
+func submitJob(w http.ResponseWriter, r *http.Request, submitFn func(kind, owner string, fn jobs.Func) (jobs.Job, error), kind string,
+	fn func(ctx context.Context, progress func(done, total int)) (any, error)) {
+	requestID, tenant := RequestID(r.Context()), repo.TenantFrom(r.Context())
+	job, err := submitFn(kind, tenant, func(ctx context.Context, progress func(done, total int)) (any, error) {
+		result, err := fn(repo.WithTenant(ctx, tenant), progress)
+		if err != nil {
+			metrics.RecordFailure("job:"+kind, requestID, err)
+		}
+		return result, err
+	})
+	if errors.Is(err, jobs.ErrQueueFull) {
+		w.Header().Set("Retry-After", "30")
+		writeAPIError(w, r, http.StatusServiceUnavailable, APIError{Code: CodeQueueFull, Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error queuing job: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "job": job})
+}
+
+// NewJobHandler returns a handler for GET /api/jobs/{id} reporting the status of a background
+// job: queued, running (with units done/total, chunks for uploads), done (with its result) or
+// failed (with its error). Finished jobs are kept for a limited time. Jobs of other tenants are
+// answered 404 like unknown ones.
+func NewJobHandler(getFn func(id string) (jobs.Job, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		job, ok := getFn(r.PathValue("id"))
+		if !ok || job.Owner != repo.TenantFrom(r.Context()) {
+			writeError(w, r, http.StatusNotFound, "unknown job")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(job)
+	}
+}
+
+// NewJobEventsHandler returns an SSE handler for GET /api/jobs/{id}/events that streams the job
+// as JSON every time it changes: "event: progress" while queued or running (done/total chunks
+// for uploads), then one "event: done" with the finished job, whether it succeeded or failed.
+// A job forgotten meanwhile ends the stream with an "event: error" (see APIError).
+func NewJobEventsHandler(watchFn func(id string) (jobs.Job, <-chan struct{}, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		id := r.PathValue("id")
+		job, changed, ok := watchFn(id)
+		if !ok || job.Owner != repo.TenantFrom(r.Context()) {
+			writeError(w, r, http.StatusNotFound, "unknown job")
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, r, http.StatusInternalServerError, "streaming not supported")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+
+		for {
+			data, err := json.Marshal(job)
+			if err != nil {
+				writeSSEError(w, r, CodeInternal, err.Error())
+				flusher.Flush()
+				return
+			}
+			if job.Status == jobs.StatusDone || job.Status == jobs.StatusFailed {
+				fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+				flusher.Flush()
+				return
+			}
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+			flusher.Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-changed:
+			}
+			if job, changed, ok = watchFn(id); !ok {
+				writeSSEError(w, r, CodeJobExpired, "job expired")
+				flusher.Flush()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/jobs"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+func TestJobHandlerTenants(t *testing.T) {
+	job := jobs.Job{ID: "abc", Kind: "upload", Owner: "acme", Status: jobs.StatusDone}
+	get := func(id string) (jobs.Job, bool) { return job, id == job.ID }
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs/{id}", NewJobHandler(get))
+
+	tests := []struct {
+		tenant string
+		want   int
+	}{
+		{"acme", http.StatusOK},
+		{"globex", http.StatusNotFound},
+		{"", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/jobs/abc", nil)
+		req = req.WithContext(repo.WithTenant(req.Context(), tt.tenant))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("tenant %q: status %d, want %d", tt.tenant, rec.Code, tt.want)
+		}
+	}
+}
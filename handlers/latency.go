@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/metrics"
+)
+
+// WithLatency records the duration of every API request in metrics.Latencies under its route
+// pattern ("/api/documents/{id}"), streamed answers until their last event. It must wrap the
+// mux itself: the route is only known once the mux served the request.
+func WithLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		if strings.HasPrefix(r.Pattern, "/api/") {
+			metrics.Latencies.Observe(r.Pattern, time.Since(start))
+		}
+	})
+}
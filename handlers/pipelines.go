@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewPipelinesHandler returns a handler listing the ingestion pipelines uploads can select with
+// their 'pipeline' field, by name (GET)
+func NewPipelinesHandler(listFn func() map[string]service.Pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		pipelines := listFn()
+		if pipelines == nil {
+			pipelines = map[string]service.Pipeline{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"pipelines": pipelines})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewPromptHandler returns a handler for /api/prompt that retrieves context for a question like
+// the query endpoint does, with the same parameters (see NewQueryHandler), and answers with the
+// assembled prompt instead of running it:
+//
+//	{"prompt": "...", "model": "llama3", "options": {"num_predict": 256}, "passages": [...]}
+//
+// model and options are what the query endpoint would send to Ollama's /api/generate,
+// modelOptions included, so the prompt can be fed to another model runner or inspected; passages
+// are numbered in the prompt in their order. Nothing is recorded in the history.
+func NewPromptHandler(
+	searchFn func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error),
+	describeFn func(ctx context.Context, img []byte) (string, error),
+	condenseFn func(ctx context.Context, user, session, question string) string,
+	settingsFn func(ctx context.Context, session string) (repo.SessionSettings, error),
+	promptFn func(template, question string, passages []service.Passage, imageDesc, style string) (string, error),
+	llmModel string,
+	modelOptions map[string]any,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, ok := retrieveForQuery(w, r, searchFn, describeFn, condenseFn, settingsFn)
+		if !ok {
+			return
+		}
+		prompt, ok := buildPrompt(w, r, promptFn, q)
+		if !ok {
+			return
+		}
+		passages := q.passages
+		if passages == nil {
+			passages = []service.Passage{}
+		}
+		resp := map[string]any{
+			"prompt":   prompt,
+			"model":    cmp.Or(q.model, llmModel),
+			"passages": passages,
+		}
+		if options := q.options(modelOptions); len(options) > 0 {
+			resp["options"] = options
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
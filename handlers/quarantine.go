@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// quarantinedItem is the JSON view of a quarantined chunk
+type quarantinedItem struct {
+	ID            int64     `json:"id"`
+	Collection    string    `json:"collection"`
+	Source        string    `json:"source"`
+	Page          int       `json:"page,omitempty"`
+	Section       string    `json:"section,omitempty"`
+	Content       string    `json:"content"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	CreatedAt     time.Time `json:"created_at"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+}
+
+// NewQuarantineHandler returns a handler that lists the chunks that failed to index (GET)
+func NewQuarantineHandler(listFn func(ctx context.Context) ([]repo.QuarantinedChunk, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, r)
+			return
+		}
+		chunks, err := listFn(r.Context())
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error listing quarantine: %v", err))
+			return
+		}
+		items := make([]quarantinedItem, 0, len(chunks))
+		for _, q := range chunks {
+			items = append(items, quarantinedItem{
+				ID:            q.ID,
+				Collection:    q.Chunk.Collection,
+				Source:        q.Chunk.Source,
+				Page:          q.Chunk.Page,
+				Section:       q.Chunk.Section,
+				Content:       q.Chunk.Content,
+				Error:         q.Error,
+				Attempts:      q.Attempts,
+				CreatedAt:     q.CreatedAt,
+				LastAttemptAt: q.LastAttemptAt,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"items": items})
+	}
+}
+
+// NewQuarantineRetryHandler returns a handler that retries quarantined chunks (POST).
+// The JSON body {"ids": [3, 7]} picks chunks; an empty body or list retries them all.
+func NewQuarantineRetryHandler(retryFn func(ctx context.Context, ids []int64) (service.RetryReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			IDs []int64 `json:"ids"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+				return
+			}
+		}
+		report, err := retryFn(r.Context(), body.IDs)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error retrying quarantine: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": report.Failed == 0, "report": report})
+	}
+}
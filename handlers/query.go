@@ -2,99 +2,515 @@ package handlers
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"maps"
+	"mime/multipart"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
 )
 
 // NewQueryHandler builds an SSE handler that:
-// - uses searchFn to fetch relevant chunk contents for a question (topK configurable via query param 'k', default applied)
+// - uses searchFn to fetch relevant chunk contents for a question (topK configurable via query param 'k', default 100), which may keep fewer to fit the context of the model (see service.RAGService.BudgetedSearch)
+// - restricts the search to chunks mentioning every 'entity' query param, if any
+// - restricts the search to any 'source' param, to chunks tagged with every 'tag' and holding every 'meta' key:value pair
+// - restricts the search by document date with 'before'/'after' (YYYY-MM-DD)
+// - searches the collection named by 'collection', the default one when absent
+// - adds the documents uploaded for the conversation 'session', if any, answering with its model and temperature and filtering with its collection, sources, tags and metadata unless the request sets them (see NewSessionSettingsHandler), and with a 'user' rewrites a follow-up question into a standalone one with condenseFn, from the earlier turns of the session
+// - with 'all_versions=true', also searches the replaced versions of documents still kept
+// - with 'lambda' in (0, 1], diversifies the chunks with maximal marginal relevance, lower values favoring diversity
+// - with 'min_score' in (0, 1], drops the chunks whose cosine similarity to the question is lower (keyword-only matches are kept)
+// - with 'neighbors' (0-5), widens every chunk with that many adjacent chunks of its document on each side instead of the server's -neighbor-chunks, 0 disabling it
+// - with 'mode=hybrid', fuses the vector search with a full-text one; 'mode=vector' skips keywords even for short questions
+// - with 'mode=multi-query', also searches reformulations of the question written by the model (-query-variants)
+// - cuts the passages down to their part relevant to the question when the server compresses them (-compression), unless 'compress=false'
+// - with 'hyde=true', searches with the embedding of a hypothetical answer written by the model (-hyde-model) instead of the question's
+// - on POST (multipart), accepts an 'image' that describeFn turns into text used for retrieval and the prompt
+// - adds type-specific instructions when most retrieved chunks share a document type (see service.PromptData)
+// - shapes the answer with 'style' (concise, detailed or bullet) and caps it at 'max_tokens' tokens
+// - builds the prompt with promptFn from the prompt template named by 'template', the default one when absent (see service.RAGService.Prompt)
+// - sends the retrieved chunks, numbered as in the prompt, as "event: sources" before the answer: [{"chunk_id":...,"document_id":...,"source":"manual.pdf","page":12,"section":...,"score":...}]
 // - calls Ollama with stream=true and forwards tokens as Server-Sent Events
+// - with 'think=true', lets reasoning models think first and streams their reasoning as "event: thinking", kept out of the saved answer
+// - bounds the generation with 'deadline' (e.g. 20s): past four fifths of it the model is asked to wrap up, the answer cut if it cannot
+// - ends with "event: done" carrying {"partial":...,"scores":[...],"citations":{...}}: partial when the deadline cut the answer short, the similarity of every retrieved chunk, null for keyword-only matches, and the chunk of every [n] citation of the answer by n, as in the sources event
+// - when a 'user' id is given, saves the question and answer in the 'session' with recordFn and sends the entry id as "event: history"
+//
+// Invalid parameters are answered 422 with one error per field (see validation).
+// describeFn may be nil, in which case image queries are rejected; condenseFn may be nil, in
+// which case questions are searched as asked, and settingsFn, in which case sessions have no
+// settings. keepAlive, when non-nil,
+// is sent as Ollama's keep_alive, and modelOptions among the Ollama options of the answer. With
+// sanitize, raw HTML is stripped from the streamed answer and unbalanced code fences are closed
+// (see mdSanitizer). processFn, when non-nil, returns the
+// post-processor the answer goes through before being streamed and saved (see
+// service.AnswerProcessor).
 func NewQueryHandler(
-	searchFn func(ctx context.Context, question string, topK int) ([]string, error),
+	searchFn func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error),
+	describeFn func(ctx context.Context, img []byte) (string, error),
+	condenseFn func(ctx context.Context, user, session, question string) string,
+	settingsFn func(ctx context.Context, session string) (repo.SessionSettings, error),
+	promptFn func(template, question string, passages []service.Passage, imageDesc, style string) (string, error),
 	llmModel string,
+	keepAlive any,
+	modelOptions map[string]any,
 	ollamaURL string,
 	httpClient *http.Client,
+	sanitize bool,
+	processFn func(passages []service.Passage) service.AnswerProcessor,
+	recordFn func(ctx context.Context, user, session, question, answer string, passages []service.Passage) (int64, error),
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-
-		question := strings.TrimSpace(r.URL.Query().Get("q"))
-		if question == "" {
-			http.Error(w, "missing parameter 'q'", http.StatusBadRequest)
+		q, ok := retrieveForQuery(w, r, searchFn, describeFn, condenseFn, settingsFn)
+		if !ok {
 			return
 		}
+		question, user := q.question, q.user
 
-		topK := 100
-
-		docs, err := searchFn(r.Context(), question, topK)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error looking for context: %v", err), http.StatusInternalServerError)
+		prompt, ok := buildPrompt(w, r, promptFn, q)
+		if !ok {
 			return
 		}
 
-		var contextStr strings.Builder
-		contextStr.WriteString("Relevant context:\n\n")
-		for i, content := range docs {
-			contextStr.WriteString(fmt.Sprintf("[%d] %s\n\n", i+1, content))
-		}
-		prompt := fmt.Sprintf("%s\nPregunta: %s\nInstrucciones: Responde la pregunta basándote ÚNICAMENTE en el contexto proporcionado. Si la información no está en el contexto, indica que no tienes suficiente información.\nRespuesta:", contextStr.String(), question)
-
 		w.Header().Set("Content-Type", "text/event-stream")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 
 		flusher, ok := w.(http.Flusher)
 		if !ok {
-			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, "streaming not supported")
 			return
 		}
 
 		reqBody := map[string]interface{}{
-			"model":  llmModel,
+			"model":  cmp.Or(q.model, llmModel),
 			"prompt": prompt,
 			"stream": true,
 		}
-		jsonData, _ := json.Marshal(reqBody)
-		ollamaResp, err := httpClient.Post(ollamaURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
-		if err != nil {
-			http.Error(w, fmt.Sprintf("error calling ollama: %v", err), http.StatusBadGateway)
-			return
+		if keepAlive != nil {
+			reqBody["keep_alive"] = keepAlive
+		}
+		if options := q.options(modelOptions); len(options) > 0 {
+			reqBody["options"] = options
+		}
+		if q.think {
+			reqBody["think"] = true
 		}
-		defer ollamaResp.Body.Close()
 
-		dec := json.NewDecoder(ollamaResp.Body)
-		for {
-			var chunk struct {
-				Response string `json:"response"`
-				Done     bool   `json:"done"`
+		sources, _ := json.Marshal(sourceItems(q.passages))
+		fmt.Fprintf(w, "event: sources\n")
+		fmt.Fprintf(w, "data: %s\n\n", sources)
+		flusher.Flush()
+
+		var san *mdSanitizer
+		if sanitize {
+			san = newMDSanitizer()
+		}
+		var proc service.AnswerProcessor
+		if processFn != nil {
+			proc = processFn(q.passages)
+		}
+		// answer is the generated text, shown what is left of it once post-processed
+		var answer, shown strings.Builder
+		show := func(text string) {
+			shown.WriteString(text)
+			if san != nil {
+				text = san.Write(text)
 			}
-			if err := dec.Decode(&chunk); err != nil {
-				if err == io.EOF {
-					break
-				}
-				fmt.Fprintf(w, "event: error\n")
-				fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+			if text != "" {
+				fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(text, "\n", "\\n"))
 				flusher.Flush()
-				break
 			}
+		}
+		emit := func(text string) {
+			answer.WriteString(text)
+			if proc != nil {
+				text = proc.Write(text)
+			}
+			show(text)
+		}
+		thought := func(text string) {
+			fmt.Fprintf(w, "event: thinking\n")
+			fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(text, "\n", "\\n"))
+			flusher.Flush()
+		}
 
-			if chunk.Response != "" {
-				fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(chunk.Response, "\n", "\\n"))
-				flusher.Flush()
+		// the last part of the deadline is kept to wrap the answer up
+		genCtx, cancel := r.Context(), context.CancelFunc(func() {})
+		if q.deadline > 0 {
+			genCtx, cancel = context.WithTimeout(r.Context(), q.deadline-q.deadline/wrapUpShare)
+		}
+		defer cancel()
+		err := streamGeneration(genCtx, httpClient, ollamaURL, reqBody, emit, thought)
+		partial := false
+		if err != nil && errors.Is(genCtx.Err(), context.DeadlineExceeded) && r.Context().Err() == nil {
+			partial, err = true, nil
+			if answer.Len() > 0 {
+				wrapCtx, cancelWrap := context.WithTimeout(r.Context(), q.deadline/wrapUpShare)
+				defer cancelWrap()
+				wrapBody := maps.Clone(reqBody)
+				wrapBody["prompt"] = service.WrapUpPrompt(prompt, answer.String())
+				wrapOptions := q.options(modelOptions)
+				wrapOptions["num_predict"] = wrapUpTokens
+				wrapBody["options"] = wrapOptions
+				// no time is left to think
+				delete(wrapBody, "think")
+				// best effort: the answer stays truncated when the model cannot finish it in time
+				if err := streamGeneration(wrapCtx, httpClient, ollamaURL, wrapBody, emit, nil); err != nil {
+					log.Printf("warning: wrapping up a timed-out answer: %v", err)
+				}
 			}
-			if chunk.Done {
-				fmt.Fprintf(w, "event: done\n")
-				fmt.Fprintf(w, "data: done\n\n")
-				flusher.Flush()
-				break
+		}
+		if err != nil {
+			// the stream is open since the sources event, the error can only go in it
+			recordFailure(r, err)
+			code := CodeStreamFailed
+			var oe *service.OllamaError
+			if errors.As(err, &oe) {
+				code = CodeUpstream
 			}
+			writeSSEError(w, r, code, err.Error())
+			flusher.Flush()
+			return
+		}
+		if proc != nil {
+			show(proc.Flush())
+		}
+		if san != nil {
+			if text := san.Flush(); text != "" {
+				fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(text, "\n", "\\n"))
+			}
+		}
+
+		if user != "" && recordFn != nil {
+			// the history id lets the client rate the answer
+			if id, err := recordFn(r.Context(), user, q.session, question, shown.String(), q.passages); err != nil {
+				log.Printf("warning: saving answer to history: %v", err)
+			} else {
+				fmt.Fprintf(w, "event: history\n")
+				fmt.Fprintf(w, "data: %d\n\n", id)
+			}
+		}
+		scores := make([]*float64, len(q.passages))
+		for i, p := range q.passages {
+			scores[i] = p.Score
+		}
+		citations := map[int]sourceItem{}
+		items := sourceItems(q.passages)
+		for _, n := range service.Citations(shown.String(), len(q.passages)) {
+			citations[n] = items[n-1]
+		}
+		done, _ := json.Marshal(map[string]any{"partial": partial, "scores": scores, "citations": citations})
+		fmt.Fprintf(w, "event: done\n")
+		fmt.Fprintf(w, "data: %s\n\n", done)
+		flusher.Flush()
+	}
+}
+
+const (
+	// wrapUpShare is the share of a generation deadline kept to wrap the answer up (a fifth)
+	wrapUpShare = 5
+	// wrapUpTokens caps the tokens the model may spend wrapping an answer up
+	wrapUpTokens = 80
+)
+
+// streamGeneration streams the generation of Ollama for reqBody, passing every token to emit
+// and every token of the reasoning of thinking models to thought, unless nil, until the model is
+// done or ctx ends. A failed call is an *service.OllamaError.
+func streamGeneration(ctx context.Context, httpClient *http.Client, ollamaURL string, reqBody map[string]any, emit, thought func(string)) error {
+	jsonData, _ := json.Marshal(reqBody)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return &service.OllamaError{Op: "generate", Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &service.OllamaError{Op: "generate", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &service.OllamaError{Op: "generate", Status: resp.StatusCode, Message: string(bytes.TrimSpace(body))}
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var chunk struct {
+			Response string `json:"response"`
+			Thinking string `json:"thinking"`
+			Done     bool   `json:"done"`
+		}
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if chunk.Thinking != "" && thought != nil {
+			thought(chunk.Thinking)
+		}
+		if chunk.Response != "" {
+			emit(chunk.Response)
+		}
+		if chunk.Done {
+			return nil
+		}
+	}
+}
+
+// queryRequest is a question with its validated parameters and retrieved passages
+type queryRequest struct {
+	question string
+	// standalone is the question rewritten to stand without the conversation, the question
+	// itself when it is not a follow-up
+	standalone string
+	session    string
+	imageDesc  string
+	style      string
+	// template names the prompt template, empty for the default one
+	template  string
+	maxTokens int
+	user      string
+	deadline  time.Duration
+	// think asks reasoning models to think before answering
+	think bool
+	// model and temperature are the settings of the session, empty when unset
+	model       string
+	temperature *float64
+	passages    []service.Passage
+}
+
+// sourceItem is the JSON view of a retrieved chunk in the sources event of the query endpoint
+type sourceItem struct {
+	ChunkID    int      `json:"chunk_id,omitempty"`
+	DocumentID int64    `json:"document_id,omitempty"`
+	Source     string   `json:"source"`
+	Page       int      `json:"page,omitempty"`
+	Section    string   `json:"section,omitempty"`
+	Score      *float64 `json:"score"`
+}
+
+// sourceItems returns the sources event of passages, in their order
+func sourceItems(passages []service.Passage) []sourceItem {
+	items := make([]sourceItem, 0, len(passages))
+	for _, p := range passages {
+		items = append(items, sourceItem{
+			ChunkID:    p.ChunkID(),
+			DocumentID: p.DocumentID,
+			Source:     p.Source,
+			Page:       p.Page,
+			Section:    p.Section,
+			Score:      p.Score,
+		})
+	}
+	return items
+}
+
+// maxTemplateRunes bounds the name of a prompt template
+const maxTemplateRunes = 64
+
+// buildPrompt builds the prompt of q with promptFn. It answers the request itself, 422 for an
+// unknown template, and reports false on error.
+func buildPrompt(w http.ResponseWriter, r *http.Request,
+	promptFn func(template, question string, passages []service.Passage, imageDesc, style string) (string, error),
+	q queryRequest) (string, bool) {
+	prompt, err := promptFn(q.template, q.standalone, q.passages, q.imageDesc, q.style)
+	var unknown *service.UnknownPromptTemplateError
+	switch {
+	case errors.As(err, &unknown):
+		var v validation
+		v.fail("template", "unknown prompt template %q", unknown.Name)
+		v.respond(w, r)
+		return "", false
+	case err != nil:
+		writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error building prompt: %v", err))
+		return "", false
+	}
+	return prompt, true
+}
+
+// options returns the Ollama options of the answer, base ones (which are left as they are)
+// included
+func (q queryRequest) options(base map[string]any) map[string]any {
+	options := maps.Clone(base)
+	if options == nil {
+		options = map[string]any{}
+	}
+	if q.maxTokens > 0 {
+		options["num_predict"] = q.maxTokens
+	}
+	if q.temperature != nil {
+		options["temperature"] = *q.temperature
+	}
+	return options
+}
+
+// retrieveForQuery parses and validates the parameters of a query request (see NewQueryHandler),
+// describes its image if any and retrieves the passages for it. It answers the request itself
+// and reports false on error.
+func retrieveForQuery(w http.ResponseWriter, r *http.Request,
+	searchFn func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error),
+	describeFn func(ctx context.Context, img []byte) (string, error),
+	condenseFn func(ctx context.Context, user, session, question string) string,
+	settingsFn func(ctx context.Context, session string) (repo.SessionSettings, error)) (queryRequest, bool) {
+	switch r.Method {
+	case http.MethodGet:
+		_ = r.ParseForm()
+	case http.MethodPost:
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error parsing form: %v", err))
+			return queryRequest{}, false
+		}
+	default:
+		methodNotAllowed(w, r)
+		return queryRequest{}, false
+	}
+
+	var v validation
+	q := queryRequest{question: strings.TrimSpace(r.FormValue("q"))}
+	if v.required("q", q.question) {
+		v.maxRunes("q", q.question, maxQuestionRunes)
+	}
+	topK := v.intIn("k", r.FormValue("k"), defaultQueryK, 1, maxQueryK)
+	q.style = strings.TrimSpace(r.FormValue("style"))
+	if !service.ValidStyle(q.style) {
+		v.fail("style", "must be %s, %s or %s", service.StyleConcise, service.StyleDetailed, service.StyleBullet)
+	}
+	q.template = strings.TrimSpace(r.FormValue("template"))
+	v.maxRunes("template", q.template, maxTemplateRunes)
+	q.maxTokens = v.intIn("max_tokens", r.FormValue("max_tokens"), 0, 1, 32768)
+	q.user = historyUser(r.Context(), v.userID("user", r.FormValue("user"), false))
+	q.deadline = v.duration("deadline", r.FormValue("deadline"))
+	q.think = v.boolean("think", r.FormValue("think"), false)
+	filter := service.SearchOptions{
+		SearchFilter: repo.SearchFilter{
+			Collection:  v.collection("collection", r.FormValue("collection")),
+			Session:     v.sessionID("session", r.FormValue("session")),
+			After:       v.date("after", r.FormValue("after")),
+			Before:      v.date("before", r.FormValue("before")),
+			Sources:     v.list("source", r.Form["source"]),
+			Tags:        v.tags("tag", r.Form["tag"]),
+			Metadata:    v.metadata("meta", r.Form["meta"]),
+			AllVersions: v.boolean("all_versions", r.FormValue("all_versions"), false),
+		},
+		Mode:      strings.TrimSpace(r.FormValue("mode")),
+		MMRLambda: v.fraction("lambda", r.FormValue("lambda")),
+		MinScore:  v.fraction("min_score", r.FormValue("min_score")),
+		HyDE:      v.boolean("hyde", r.FormValue("hyde"), false),
+		Compress:  v.boolean("compress", r.FormValue("compress"), true),
+	}
+	if raw := strings.TrimSpace(r.FormValue("neighbors")); raw != "" {
+		// 0 turns off the server setting
+		filter.NeighborChunks = v.intIn("neighbors", raw, 0, 0, maxNeighborChunks)
+		if filter.NeighborChunks == 0 {
+			filter.NeighborChunks = -1
 		}
 	}
+	if filter.Mode != "" && !slices.Contains(service.SearchModes, filter.Mode) {
+		v.fail("mode", "must be one of %s", strings.Join(service.SearchModes, ", "))
+	}
+	if !filter.After.IsZero() && !filter.Before.IsZero() && !filter.After.Before(filter.Before) {
+		v.fail("before", "must be later than 'after'")
+	}
+	for _, e := range r.Form["entity"] {
+		if e = strings.TrimSpace(e); e != "" {
+			v.maxRunes("entity", e, maxEntityRunes)
+			filter.Entities = append(filter.Entities, e)
+		}
+	}
+	if len(filter.Entities) > maxEntityFilters {
+		v.fail("entity", "must be given at most %d times", maxEntityFilters)
+	}
+	if v.respond(w, r) {
+		return queryRequest{}, false
+	}
+
+	if filter.Session != "" && settingsFn != nil {
+		settings, err := settingsFn(r.Context(), filter.Session)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading session settings: %v", err))
+			return queryRequest{}, false
+		}
+		// the parameters of the request win over the settings of the conversation
+		q.model, q.temperature = settings.Model, settings.Temperature
+		filter.Collection = cmp.Or(filter.Collection, settings.Collection)
+		if len(filter.Sources) == 0 {
+			filter.Sources = settings.Sources
+		}
+		if len(filter.Tags) == 0 {
+			filter.Tags = settings.Tags
+		}
+		if len(filter.Metadata) == 0 {
+			filter.Metadata = settings.Metadata
+		}
+	}
+
+	if r.MultipartForm != nil && len(r.MultipartForm.File["image"]) > 0 {
+		if describeFn == nil {
+			writeError(w, r, http.StatusBadRequest, "image queries are not enabled")
+			return queryRequest{}, false
+		}
+		img, err := readFormFile(r.MultipartForm.File["image"][0])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error reading image: %v", err))
+			return queryRequest{}, false
+		}
+		q.imageDesc, err = describeFn(r.Context(), img)
+		if err != nil {
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error describing image: %v", err))
+			return queryRequest{}, false
+		}
+	}
+
+	q.session, q.standalone = filter.Session, q.question
+	if condenseFn != nil {
+		q.standalone = condenseFn(r.Context(), q.user, q.session, q.question)
+	}
+	searchText := q.standalone
+	if q.imageDesc != "" {
+		searchText = q.standalone + "\n" + q.imageDesc
+	}
+	var err error
+	q.passages, err = searchFn(r.Context(), searchText, topK, filter)
+	if err != nil {
+		if writeDimensionMismatch(w, r, err) || writeUnknownCollection(w, r, err) {
+			return queryRequest{}, false
+		}
+		writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error looking for context: %v", err))
+		return queryRequest{}, false
+	}
+	return q, true
+}
+
+// parseDate accepts YYYY-MM-DD or RFC 3339 timestamps, truncated to the UTC day
+func parseDate(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD")
+	}
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/ollamatest"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+func TestStreamGeneration(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	srv.SetResponder(func(model, prompt string) string { return "the answer to " + prompt })
+
+	var parts []string
+	body := map[string]any{"model": "test-llm", "prompt": "everything", "stream": true}
+	if err := streamGeneration(context.Background(), srv.Client(), srv.URL, body, func(s string) { parts = append(parts, s) }, nil); err != nil {
+		t.Fatalf("streamGeneration: %v", err)
+	}
+	if len(parts) < 2 {
+		t.Errorf("got %d parts, want the answer streamed in several", len(parts))
+	}
+	if got, want := strings.Join(parts, ""), "the answer to everything"; got != want {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+}
+
+func TestStreamGenerationError(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	srv.FailWith("/api/generate", http.StatusInternalServerError)
+
+	body := map[string]any{"model": "test-llm", "prompt": "everything", "stream": true}
+	err := streamGeneration(context.Background(), srv.Client(), srv.URL, body, func(string) { t.Error("emitted a token of a failed call") }, nil)
+	var oe *service.OllamaError
+	if !errors.As(err, &oe) || oe.Status != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want an OllamaError with status 500", err)
+	}
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/jobs"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// NewReindexHandler returns a handler for /api/reindex. GET lists the collections with chunks
+// embedded with another model or dimension than configured; POST with a JSON body
+// {"collection": "..."} (optional, the default one when empty) re-embeds them with the
+// configured model. Searches keep using the old vectors until every chunk is re-embedded. With
+// index workers the re-embedding runs as a background job (202, see /api/jobs/{id}); otherwise
+// the request waits for its report.
+func NewReindexHandler(statusFn func(ctx context.Context) ([]service.ReindexStatus, error),
+	reindexFn func(ctx context.Context, collection string) (service.ReindexReport, error),
+	submitFn func(kind, owner string, fn jobs.Func) (jobs.Job, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			statuses, err := statusFn(r.Context())
+			if err != nil {
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error counting stale chunks: %v", err))
+				return
+			}
+			if statuses == nil {
+				statuses = []service.ReindexStatus{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"items": statuses})
+		case http.MethodPost:
+			var body struct {
+				Collection string `json:"collection"`
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+					return
+				}
+			}
+			var v validation
+			collection := v.collection("collection", body.Collection)
+			if v.respond(w, r) {
+				return
+			}
+			if submitFn != nil {
+				submitJob(w, r, submitFn, "reindex", func(ctx context.Context, progress func(done, total int)) (any, error) {
+					return reindexFn(service.WithProgress(ctx, progress), collection)
+				})
+				return
+			}
+			report, err := reindexFn(r.Context(), collection)
+			if err != nil {
+				if writeDimensionMismatch(w, r, err) || writeUnknownCollection(w, r, err) {
+					return
+				}
+				if errors.Is(err, service.ErrReindexRunning) {
+					writeError(w, r, http.StatusConflict, err.Error())
+					return
+				}
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error re-embedding collection: %v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "report": report})
+		default:
+			methodNotAllowed(w, r)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+)
+
+// htmlTagRe matches a complete raw HTML tag or comment
+var htmlTagRe = regexp.MustCompile(`^(<!--[\s\S]*-->|</?[A-Za-z][A-Za-z0-9-]*(\s[^<>]*)?/?>)$`)
+
+// maxHeldTag bounds how much text is held back waiting for the '>' of a possible tag
+const maxHeldTag = 256
+
+// mdSanitizer normalizes markdown streamed token by token: raw HTML tags outside code are
+// dropped, and a code fence left open is closed at the end. Text is held back only while it
+// is ambiguous (a possible tag, the start of a line that may be a fence), so the stream keeps
+// its pace.
+type mdSanitizer struct {
+	// held is text whose fate is not decided yet
+	held strings.Builder
+	// holding is what held may turn out to be
+	holding heldKind
+	// fence is the marker of the open code fence ("```", "~~~~"...), empty outside fences
+	fence      string
+	lineStart  bool
+	inlineCode bool
+}
+
+type heldKind int
+
+const (
+	holdNone heldKind = iota
+	holdTag
+	holdFence
+)
+
+func newMDSanitizer() *mdSanitizer {
+	return &mdSanitizer{lineStart: true}
+}
+
+// Write consumes a token and returns the text that can be forwarded
+func (s *mdSanitizer) Write(token string) string {
+	var out strings.Builder
+	for _, r := range token {
+		s.write(r, &out)
+	}
+	return out.String()
+}
+
+// Flush returns the text still held back, closing an unterminated code fence
+func (s *mdSanitizer) Flush() string {
+	out := s.release()
+	if s.holding == holdFence {
+		s.fenceLine(out)
+	}
+	s.holding = holdNone
+	if s.fence != "" {
+		out += "\n" + s.fence
+		s.fence = ""
+	}
+	return out
+}
+
+func (s *mdSanitizer) write(r rune, out *strings.Builder) {
+	switch s.holding {
+	case holdFence:
+		if r == ' ' || r == '`' || r == '~' {
+			s.held.WriteRune(r)
+			return
+		}
+		line := s.release()
+		s.fenceLine(line)
+		out.WriteString(line)
+	case holdTag:
+		s.held.WriteRune(r)
+		text := s.held.String()
+		switch {
+		case r == '>' && htmlTagRe.MatchString(text):
+			// complete tag (or comment): drop it
+			s.held.Reset()
+			s.holding = holdNone
+		case r == '>' && !strings.HasPrefix(text, "<!--"), r == '\n' || s.held.Len() > maxHeldTag,
+			s.held.Len() == 2 && !isTagStart(r):
+			out.WriteString(s.release())
+			s.lineStart = r == '\n'
+		}
+		return
+	}
+
+	if s.lineStart && (r == ' ' || r == '`' || r == '~') {
+		s.holding = holdFence
+		s.held.WriteRune(r)
+		return
+	}
+	s.lineStart = r == '\n'
+	switch {
+	case r == '\n':
+		s.inlineCode = false
+	case r == '`' && s.fence == "":
+		s.inlineCode = !s.inlineCode
+	case r == '<' && s.fence == "" && !s.inlineCode:
+		s.holding = holdTag
+		s.held.WriteRune(r)
+		return
+	}
+	out.WriteRune(r)
+}
+
+// release returns and clears the held text
+func (s *mdSanitizer) release() string {
+	text := s.held.String()
+	s.held.Reset()
+	s.holding = holdNone
+	return text
+}
+
+// fenceLine updates the fence state with the start of a line (spaces and fence characters)
+func (s *mdSanitizer) fenceLine(prefix string) {
+	s.lineStart = false
+	marker := strings.TrimLeft(prefix, " ")
+	if len(prefix)-len(marker) > 3 || len(marker) < 3 || strings.Trim(marker, marker[:1]) != "" {
+		// not a fence: a backtick run outside fences toggles inline code
+		if s.fence == "" && strings.Count(marker, "`")%2 == 1 {
+			s.inlineCode = !s.inlineCode
+		}
+		return
+	}
+	switch {
+	case s.fence == "":
+		s.fence = marker
+	case marker[0] == s.fence[0] && len(marker) >= len(s.fence):
+		s.fence = ""
+	}
+}
+
+// isTagStart reports whether r can follow '<' in an HTML tag or comment
+func isTagStart(r rune) bool {
+	return r == '/' || r == '!' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
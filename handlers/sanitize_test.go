@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMDSanitizer(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", "hello world", "hello world"},
+		{"tags dropped", "a <b>bold</b> c", "a bold c"},
+		{"self-closing tag dropped", "line<br/>next", "linenext"},
+		{"comment dropped", "x<!-- hi -->y", "xy"},
+		{"comparisons kept", "1 < 2 and 3<4", "1 < 2 and 3<4"},
+		{"unfinished tag kept", "a <b", "a <b"},
+		{"tag in inline code kept", "use `<div>` here", "use `<div>` here"},
+		{"tag in a fence kept", "```\n<div>\n```\n", "```\n<div>\n```\n"},
+		{"open fence closed", "```go\nx := 1", "```go\nx := 1\n```"},
+		{"tilde fence closed", "~~~\ncode\n", "~~~\ncode\n\n~~~"},
+		{"indented code is not a fence", "    ```\ntext", "    ```\ntext"},
+		{"tag after a fence", "```\na\n```\n<i>b</i>", "```\na\n```\nb"},
+	}
+	for _, tt := range tests {
+		// whole tokens and one rune per token must give the same output
+		for _, tokens := range [][]string{{tt.in}, strings.Split(tt.in, "")} {
+			s := newMDSanitizer()
+			var out strings.Builder
+			for _, tok := range tokens {
+				out.WriteString(s.Write(tok))
+			}
+			out.WriteString(s.Flush())
+			if got := out.String(); got != tt.want {
+				t.Errorf("%s (%d tokens): got %q, want %q", tt.name, len(tokens), got, tt.want)
+			}
+		}
+	}
+}
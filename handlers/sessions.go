@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// NewSessionEndHandler returns a handler that ends a conversation (DELETE /api/session?id=...),
+// deleting the documents uploaded for it
+func NewSessionEndHandler(endFn func(ctx context.Context, id string) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		id := strings.TrimSpace(r.FormValue("id"))
+		if v.required("id", id) {
+			id = v.sessionID("id", id)
+		}
+		if v.respond(w, r) {
+			return
+		}
+		deleted, err := endFn(r.Context(), id)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error ending session: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "deleted": deleted})
+	}
+}
+
+// sessionSettingsBody is the JSON form of repo.SessionSettings, with metadata as "key:value"
+// pairs like the 'meta' query parameter
+type sessionSettingsBody struct {
+	Model       string   `json:"model,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Collection  string   `json:"collection,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Meta        []string `json:"meta,omitempty"`
+}
+
+func toSessionSettingsBody(s repo.SessionSettings) sessionSettingsBody {
+	b := sessionSettingsBody{Model: s.Model, Temperature: s.Temperature, Collection: s.Collection, Sources: s.Sources, Tags: s.Tags}
+	for _, k := range slices.Sorted(maps.Keys(s.Metadata)) {
+		b.Meta = append(b.Meta, k+":"+s.Metadata[k])
+	}
+	return b
+}
+
+// NewSessionSettingsHandler returns a handler for /api/session/settings?id=... keeping the
+// options every turn of a conversation is answered with, so clients need not repeat them: GET
+// returns them and PUT replaces them with
+//
+//	{"model": "llama3", "temperature": 0.2, "collection": "docs", "sources": [...], "tags": [...], "meta": ["author:ana"]}
+//
+// every field being optional. The query and prompt endpoints use them for the parameters a
+// request leaves out. model must be one of models.
+func NewSessionSettingsHandler(
+	getFn func(ctx context.Context, id string) (repo.SessionSettings, error),
+	setFn func(ctx context.Context, id string, s repo.SessionSettings) error,
+	models []string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPut {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		id := strings.TrimSpace(r.URL.Query().Get("id"))
+		if v.required("id", id) {
+			id = v.sessionID("id", id)
+		}
+		if r.Method == http.MethodPut {
+			var body sessionSettingsBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+				return
+			}
+			settings := repo.SessionSettings{
+				Model:       strings.TrimSpace(body.Model),
+				Temperature: body.Temperature,
+				Collection:  v.collection("collection", body.Collection),
+				Sources:     v.list("sources", body.Sources),
+				Tags:        v.tags("tags", body.Tags),
+				Metadata:    v.metadata("meta", body.Meta),
+			}
+			if settings.Model != "" && !slices.Contains(models, settings.Model) {
+				v.fail("model", "must be one of %s", strings.Join(models, ", "))
+			}
+			if t := settings.Temperature; t != nil && (*t < 0 || *t > 2) {
+				v.fail("temperature", "must be between 0 and 2")
+			}
+			if v.respond(w, r) {
+				return
+			}
+			if err := setFn(r.Context(), id, settings); err != nil {
+				if writeUnknownCollection(w, r, err) {
+					return
+				}
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error saving session settings: %v", err))
+				return
+			}
+		} else if v.respond(w, r) {
+			return
+		}
+		settings, err := getFn(r.Context(), id)
+		if err != nil {
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading session settings: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": id, "settings": toSessionSettingsBody(settings)})
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Thaizir/go-local-RAG/metrics"
+)
+
+// shedRetryAfter is the Retry-After, in seconds, of requests rejected to shed load
+const shedRetryAfter = "15"
+
+// WithLoadShedding wraps a low-priority handler (ingestion, reindexing, syncs) so it answers 503
+// with the 'overloaded' code while overloaded reports a reason (see metrics.LoadLimits),
+// keeping Ollama and the database for the interactive queries. Rejections are counted in
+// metrics.Shed.
+func WithLoadShedding(overloaded func() string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if reason := overloaded(); reason != "" {
+			metrics.Shed.Inc(r.Pattern, reason)
+			w.Header().Set("Retry-After", shedRetryAfter)
+			writeAPIError(w, r, http.StatusServiceUnavailable, APIError{
+				Code:    CodeOverloaded,
+				Message: "server overloaded, retry later",
+				Details: map[string]string{"reason": reason},
+			})
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/connectors"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// NewS3SyncHandler returns a handler that syncs a bucket into the index. It accepts a JSON body
+// {"bucket": "docs", "prefix": "manuals/", "collection": "..."} (prefix and collection optional)
+// and answers with the sync report; only new or changed objects are re-indexed. Buckets that
+// allowedFn refuses to the tenant of the request are answered 403.
+func NewS3SyncHandler(
+	syncFn func(ctx context.Context, bucket, prefix, collection string) (connectors.SyncReport, error),
+	allowedFn func(tenant, bucket string) bool,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			Bucket     string `json:"bucket"`
+			Prefix     string `json:"prefix"`
+			Collection string `json:"collection"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		body.Bucket = strings.TrimSpace(body.Bucket)
+		if v.required("bucket", body.Bucket) && strings.Contains(body.Bucket, "/") {
+			v.fail("bucket", "must be a bucket name")
+		}
+		collection := v.collection("collection", body.Collection)
+		if v.respond(w, r) {
+			return
+		}
+		if !allowedFn(repo.TenantFrom(r.Context()), body.Bucket) {
+			writeError(w, r, http.StatusForbidden, fmt.Sprintf("bucket %q is not allowed for this API key", body.Bucket))
+			return
+		}
+
+		report, err := syncFn(r.Context(), body.Bucket, body.Prefix, collection)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error syncing bucket: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": report.Failed == 0, "report": report})
+	}
+}
+
+// NewFeedSyncHandler returns a handler that syncs an RSS/Atom feed or a sitemap into the index now,
+// without waiting for the schedule. It accepts a JSON body {"url": "https://blog.example/feed.xml",
+// "collection": "..."} (collection optional) and answers with the sync report.
+func NewFeedSyncHandler(syncFn func(ctx context.Context, feedURL, collection string) (connectors.SyncReport, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+		var body struct {
+			URL        string `json:"url"`
+			Collection string `json:"collection"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		var v validation
+		feedURL := v.httpURL("url", body.URL)
+		collection := v.collection("collection", body.Collection)
+		if v.respond(w, r) {
+			return
+		}
+
+		report, err := syncFn(r.Context(), feedURL.String(), collection)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			if errors.Is(err, connectors.ErrForbiddenAddress) {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("cannot sync feed: %v", err))
+				return
+			}
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error syncing feed: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": report.Failed == 0, "report": report})
+	}
+}
+
+// NewSourceDeleteHandler returns a handler that removes every chunk of a source
+// (DELETE /api/sources?name=manual.txt[&collection=...]), e.g. to purge an outdated version of a
+// file before uploading the new one. A source without chunks deletes nothing and is not an error.
+func NewSourceDeleteHandler(removeFn func(ctx context.Context, collection, source string) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, r)
+			return
+		}
+		var v validation
+		name := strings.TrimSpace(r.FormValue("name"))
+		v.required("name", name)
+		collection := v.collection("collection", r.FormValue("collection"))
+		if v.respond(w, r) {
+			return
+		}
+		deleted, err := removeFn(r.Context(), collection, name)
+		if err != nil {
+			if writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error deleting source: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "deleted": deleted})
+	}
+}
@@ -1,65 +1,559 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/jobs"
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Limits of ZIP archives, which can expand far beyond the upload size. maxArchiveBytes bounds
+// what all the archives of an upload expand to, every loaded file being held until indexed.
+const (
+	maxArchiveFiles     = 1000
+	maxArchiveFileBytes = 50 << 20
+	maxArchiveBytes     = 200 << 20
 )
 
-// NewUploadHandler returns a handler that accepts multipart form with optional text and/or .txt file
-// indexFn should persist content and its source into the vector DB.
-func NewUploadHandler(indexFn func(ctx context.Context, content, source string) error) http.HandlerFunc {
+// NewUploadHandler returns a handler that accepts multipart form with optional text and/or a file of
+// any type the registry has a loader for (built in: .txt, .md, .html, .pdf, .docx, .odt, .csv, .json,
+// .jsonl; PDFs are indexed page by page, CSV rows and JSON records as one chunk each),
+// an optional 'date' field (the file's date, YYYY-MM-DD or RFC 3339), an optional 'collection'
+// to store it in, an optional 'doc_type' tag (code, legal, meeting-notes...), an optional 'session'
+// id that keeps the document private to that conversation until it ends, and any number
+// of 'figure' image files belonging to the document. A file whose name is already indexed
+// replaces it as a new version (see service.Document.Replace) unless 'replace' is false.
+// Any number of 'tag' and 'meta' (key:value, e.g. author:ana) fields are stored as metadata of
+// every chunk, for the query filters of the same names. An optional 'pipeline' names the ingestion
+// pipeline (see service.Pipeline) the documents go through, pipelineFn looking it up; its
+// extractor reads every file of the upload. An optional 'ttl' (e.g. 72h) deletes the documents
+// once that old, instead of the default TTL of uploads. 'text' and 'file' are exclusive; invalid
+// fields or combinations are answered 422 with one error per field (see validation).
+// indexFn should persist content and its source into the vector DB; its report is returned as JSON
+// together with the detected file type.
+//
+// Several 'file' parts and .zip archives are indexed file by file, each with its name (its path
+// inside the archive) as source; the response then lists the outcome of every file, and
+// unsupported files of an archive are skipped. Figures only apply to single-file uploads.
+//
+// Images (PNG, JPEG, WebP, GIF, TIFF) are transcribed with ocrFn and indexed as text;
+// ocrFn may be nil, in which case they are rejected like any unsupported file.
+// Recordings (MP3, WAV, M4A, OGG, FLAC, WebM) are turned into a timestamped transcript by
+// transcriptFn, which may be nil as well.
+//
+// With a submitFn, the request only reads the uploaded bytes: loading them (transcription and OCR
+// included), embedding and storing happen in a background job. The handler answers 202 with the
+// job, whose result is the response described above once done (see NewJobHandler). A nil
+// submitFn indexes before answering.
+func NewUploadHandler(
+	indexFn func(ctx context.Context, doc service.Document) (service.IndexReport, error),
+	registry *loaders.Registry,
+	pipelineFn func(name string) (service.Pipeline, bool),
+	ocrFn func(ctx context.Context, img []byte) (string, error),
+	transcriptFn func(ctx context.Context, audio []byte, filename string) (service.Document, error),
+	submitFn func(kind, owner string, fn jobs.Func) (jobs.Job, error),
+) http.HandlerFunc {
+	loader := fileLoader{registry: registry, ocrFn: ocrFn, transcriptFn: transcriptFn}
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			methodNotAllowed(w, r)
 			return
 		}
 
 		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
-			http.Error(w, fmt.Sprintf("error parsing form: %v", err), http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error parsing form: %v", err))
 			return
 		}
 
+		// settings shared by every document of the upload
+		var v validation
+		base := service.Document{
+			Date:       v.date("date", r.FormValue("date")),
+			Collection: v.collection("collection", r.FormValue("collection")),
+			Session:    v.sessionID("session", r.FormValue("session")),
+			Replace:    v.boolean("replace", r.FormValue("replace"), true),
+			Metadata:   v.metadata("meta", r.MultipartForm.Value["meta"]),
+			TTL:        v.duration("ttl", r.FormValue("ttl")),
+			Origin:     service.OriginUpload,
+		}
+		if tags := v.tags("tag", r.MultipartForm.Value["tag"]); len(tags) > 0 {
+			if base.Metadata == nil {
+				base.Metadata = make(map[string]string)
+			}
+			base.Metadata[repo.MetaTags] = strings.Join(tags, ",")
+		}
+		fl := loader
+		if name := strings.TrimSpace(r.FormValue("pipeline")); name != "" {
+			if p, ok := pipelineFn(name); ok {
+				base.Pipeline, fl.extractor = name, p.Extractor
+			} else {
+				v.fail("pipeline", "unknown pipeline %q", name)
+			}
+		}
+		if dt := r.FormValue("doc_type"); dt != "" {
+			var err error
+			if base.Type, err = service.ParseDocType(dt); err != nil {
+				v.fail("doc_type", "%v", err)
+			}
+		}
+		uploads := r.MultipartForm.File["file"]
 		text := r.FormValue("text")
-		var source string
-		var content string
-
-		file, header, err := r.FormFile("file")
-		if err == nil {
-			defer file.Close()
-			if !strings.HasSuffix(strings.ToLower(header.Filename), ".txt") {
-				http.Error(w, "solo se aceptan archivos .txt", http.StatusBadRequest)
+		figures := r.MultipartForm.File["figure"]
+		switch {
+		case len(uploads) == 0 && strings.TrimSpace(text) == "":
+			v.fail("file", "or 'text' is required")
+		case len(uploads) > 0 && strings.TrimSpace(text) != "":
+			v.fail("text", "cannot be combined with 'file'; upload them separately")
+		}
+		if len(figures) > 0 && (len(uploads) > 1 || (len(uploads) == 1 && isZip(uploads[0]))) {
+			v.fail("figure", "only applies to single-file uploads")
+		}
+		for _, fh := range figures {
+			if !strings.HasPrefix(fh.Header.Get("Content-Type"), "image/") {
+				v.fail("figure", "%s is not an image", fh.Filename)
+			}
+		}
+		if v.respond(w, r) {
+			return
+		}
+
+		if len(uploads) > 1 || (len(uploads) == 1 && isZip(uploads[0])) {
+			results := fl.readAll(uploads)
+			if submitFn != nil {
+				submitJob(w, r, submitFn, "upload", func(ctx context.Context, progress func(done, total int)) (any, error) {
+					return filesResponse(indexFiles(ctx, fl.loadAll(ctx, results, base), indexFn, progress)), nil
+				})
+				return
+			}
+			results = fl.loadAll(r.Context(), results, base)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(filesResponse(indexFiles(r.Context(), results, indexFn, nil)))
+			return
+		}
+
+		// the uploaded bytes are read now, since the form files are gone once the request ends,
+		// and loaded by prepare, in the job when there is one
+		var raw rawFile
+		source, fileType := "user_text", "text"
+		if len(uploads) == 1 {
+			header := uploads[0]
+			source, fileType = header.Filename, fileTypeOf(header.Filename)
+			raw.mediaType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+			if !fl.supports(source, raw.mediaType) {
+				writeError(w, r, http.StatusBadRequest, fl.unsupportedMessage())
 				return
 			}
-			b, err := io.ReadAll(file)
+			var err error
+			if raw.data, err = readFormFile(header); err != nil {
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error reading file: %v", err))
+				return
+			}
+		}
+		var docFigures []service.Figure
+		for _, fh := range figures {
+			data, err := readFormFile(fh)
 			if err != nil {
-				http.Error(w, fmt.Sprintf("error leyendo archivo: %v", err), http.StatusBadRequest)
+				writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error reading figure: %v", err))
 				return
 			}
-			content = string(b)
-			source = header.Filename
+			docFigures = append(docFigures, service.Figure{Name: fh.Filename, Data: data})
 		}
-
-		if content == "" {
-			content = text
-			source = "user_text"
+		prepare := func(ctx context.Context) (service.Document, error) {
+			doc := service.Document{Content: text}
+			if len(uploads) == 1 {
+				var err error
+				if doc, err = fl.load(ctx, source, raw.mediaType, bytes.NewReader(raw.data)); err != nil {
+					return doc, err
+				}
+			}
+			if strings.TrimSpace(doc.Content) == "" {
+				return doc, &fileError{http.StatusUnprocessableEntity, "the file contains no text"}
+			}
+			doc.Source, doc.Figures = source, docFigures
+			withSettings(&doc, base)
+			// pasted texts share one source name, so they are never versions of each other
+			doc.Replace = doc.Replace && len(uploads) > 0
+			log.Printf("Indexing new content from %s (len=%d, records=%d, figures=%d)", doc.Source, len(doc.Content), len(doc.Records), len(doc.Figures))
+			return doc, nil
 		}
 
-		if strings.TrimSpace(content) == "" {
-			http.Error(w, "no se proporcionó texto ni archivo", http.StatusBadRequest)
+		if submitFn != nil {
+			submitJob(w, r, submitFn, "upload", func(ctx context.Context, progress func(done, total int)) (any, error) {
+				doc, err := prepare(ctx)
+				if err != nil {
+					return nil, err
+				}
+				report, err := indexFn(service.WithProgress(ctx, progress), doc)
+				if err != nil {
+					return nil, fmt.Errorf("error indexing document: %w", err)
+				}
+				return map[string]any{"ok": true, "source": doc.Source, "type": fileType, "report": report}, nil
+			})
 			return
 		}
-
-		log.Printf("Indexing new content from %s (len=%d)", source, len(content))
-		if err := indexFn(r.Context(), content, source); err != nil {
-			http.Error(w, fmt.Sprintf("error indexando documento: %v", err), http.StatusInternalServerError)
+		doc, err := prepare(r.Context())
+		if err != nil {
+			var fe *fileError
+			if errors.As(err, &fe) {
+				writeError(w, r, fe.status, fe.msg)
+			} else {
+				writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error reading file: %v", err))
+			}
+			return
+		}
+		report, err := indexFn(r.Context(), doc)
+		if err != nil {
+			if writeDimensionMismatch(w, r, err) || writeUnknownCollection(w, r, err) {
+				return
+			}
+			writeFailure(w, r, http.StatusInternalServerError, err, fmt.Sprintf("error indexing document: %v", err))
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		_, _ = w.Write([]byte(`{"ok":true}`))
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "source": doc.Source, "type": fileType, "report": report})
+	}
+}
+
+// fileResult is the outcome of one file of a multi-file upload
+type fileResult struct {
+	Source string               `json:"source"`
+	Type   string               `json:"type"`
+	Report *service.IndexReport `json:"report,omitempty"`
+	// Skipped explains why an archived file was not indexed
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// raw is the file read from the upload, waiting for loadAll
+	raw *rawFile
+	// doc is the loaded file, waiting for indexFiles
+	doc *service.Document
+}
+
+// rawFile is the content of an uploaded file, held until it is loaded
+type rawFile struct {
+	mediaType string
+	// date is the modification time of an archived file, zero otherwise
+	date time.Time
+	data []byte
+}
+
+// filesResponse is the JSON response of a multi-file upload
+func filesResponse(results []fileResult) map[string]any {
+	failed := 0
+	for _, res := range results {
+		if res.Error != "" {
+			failed++
+		}
+	}
+	return map[string]any{"ok": failed == 0, "files": results, "failed": failed}
+}
+
+// fileError is a file that cannot be loaded, with the HTTP status it deserves on its own
+type fileError struct {
+	status int
+	msg    string
+}
+
+func (e *fileError) Error() string { return e.msg }
+
+// fileLoader turns uploaded files into documents
+type fileLoader struct {
+	registry     *loaders.Registry
+	ocrFn        func(ctx context.Context, img []byte) (string, error)
+	transcriptFn func(ctx context.Context, audio []byte, filename string) (service.Document, error)
+	// extractor is the file type every file is read as, empty to go by name (see service.Pipeline)
+	extractor string
+}
+
+// supports reports whether the file can be loaded at all
+func (fl fileLoader) supports(filename, mediaType string) bool {
+	if _, ok := fl.registry.Lookup(filename, mediaType); ok || fl.extractor != "" {
+		return true
+	}
+	return (fl.transcriptFn != nil && isAudio(filename, mediaType)) || (fl.ocrFn != nil && isImage(filename, mediaType))
+}
+
+// load reads a file into a document; errors are *fileError
+func (fl fileLoader) load(ctx context.Context, filename, mediaType string, file io.Reader) (service.Document, error) {
+	if fl.extractor != "" {
+		filename, mediaType = "file."+fl.extractor, ""
+	}
+	switch {
+	case fl.transcriptFn != nil && isAudio(filename, mediaType):
+		audio, err := io.ReadAll(file)
+		if err != nil {
+			return service.Document{}, &fileError{http.StatusBadRequest, fmt.Sprintf("error reading file: %v", err)}
+		}
+		doc, err := fl.transcriptFn(ctx, audio, filename)
+		if err != nil {
+			return doc, &fileError{http.StatusBadGateway, fmt.Sprintf("error transcribing %s: %v", filename, err)}
+		}
+		if strings.TrimSpace(doc.Content) == "" {
+			return doc, &fileError{http.StatusUnprocessableEntity, fmt.Sprintf("no speech recognized in %s", filename)}
+		}
+		return doc, nil
+	case fl.ocrFn != nil && isImage(filename, mediaType):
+		img, err := io.ReadAll(file)
+		if err != nil {
+			return service.Document{}, &fileError{http.StatusBadRequest, fmt.Sprintf("error reading file: %v", err)}
+		}
+		transcript, err := fl.ocrFn(ctx, img)
+		if err != nil {
+			return service.Document{}, &fileError{http.StatusBadGateway, fmt.Sprintf("error transcribing %s: %v", filename, err)}
+		}
+		if transcript == "" {
+			return service.Document{}, &fileError{http.StatusUnprocessableEntity, fmt.Sprintf("no text found in %s", filename)}
+		}
+		return service.Document{Content: transcript}, nil
+	}
+	loader, ok := fl.registry.Lookup(filename, mediaType)
+	if !ok {
+		return service.Document{}, &fileError{http.StatusBadRequest, fl.unsupportedMessage()}
+	}
+	sections, err := loader.Load(file)
+	if err != nil {
+		return service.Document{}, &fileError{http.StatusBadRequest, fmt.Sprintf("error reading %s: %v", filename, err)}
+	}
+	return loaders.ToDocument(sections), nil
+}
+
+// unsupportedMessage lists the file types that can be uploaded
+func (fl fileLoader) unsupportedMessage() string {
+	exts := fl.registry.SupportedExtensions()
+	if fl.ocrFn != nil {
+		exts = append(exts, imageExtensions...)
+	}
+	if fl.transcriptFn != nil {
+		exts = append(exts, audioExtensions...)
+	}
+	exts = append(exts, ".zip")
+	return fmt.Sprintf("unsupported file type; accepted: %s", strings.Join(exts, ", "))
+}
+
+// loadAll loads the files read by readAll; the results of the files to index then hold their
+// document
+func (fl fileLoader) loadAll(ctx context.Context, results []fileResult, base service.Document) []fileResult {
+	for i := range results {
+		res := &results[i]
+		if res.raw == nil {
+			continue
+		}
+		raw := res.raw
+		res.raw = nil
+		doc, err := fl.load(ctx, res.Source, raw.mediaType, bytes.NewReader(raw.data))
+		if err != nil {
+			res.Error = err.Error()
+			continue
+		}
+		doc.Source = res.Source
+		withSettings(&doc, base)
+		if doc.Date.IsZero() {
+			doc.Date = raw.date
+		}
+		if strings.TrimSpace(doc.Content) == "" {
+			res.Skipped = "no text"
+			continue
+		}
+		res.doc = &doc
+	}
+	return results
+}
+
+// readAll reads every uploaded file, unpacking ZIP archives; the results of the files to load
+// hold their content
+func (fl fileLoader) readAll(uploads []*multipart.FileHeader) []fileResult {
+	var results []fileResult
+	read := func(name, mediaType string, date time.Time, file io.Reader) {
+		res := fileResult{Source: name, Type: fileTypeOf(name)}
+		data, err := io.ReadAll(file)
+		if err != nil {
+			res.Error = fmt.Sprintf("error reading file: %v", err)
+		} else {
+			res.raw = &rawFile{mediaType: mediaType, date: date, data: data}
+		}
+		results = append(results, res)
 	}
+
+	// expanded counts the bytes read from every archive entry
+	var expanded int64
+	for _, fh := range uploads {
+		file, err := fh.Open()
+		if err != nil {
+			results = append(results, fileResult{Source: fh.Filename, Type: fileTypeOf(fh.Filename), Error: err.Error()})
+			continue
+		}
+		if !isZip(fh) {
+			mediaType, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+			read(fh.Filename, mediaType, time.Time{}, file)
+			file.Close()
+			continue
+		}
+		archive, err := zip.NewReader(file, fh.Size)
+		if err != nil {
+			results = append(results, fileResult{Source: fh.Filename, Type: "zip", Error: fmt.Sprintf("invalid ZIP archive: %v", err)})
+			file.Close()
+			continue
+		}
+		count := 0
+		for _, f := range archive.File {
+			name := f.Name
+			if f.FileInfo().IsDir() || hiddenPath(name) {
+				continue
+			}
+			res := fileResult{Source: name, Type: fileTypeOf(name)}
+			switch {
+			case !fl.supports(name, ""):
+				res.Skipped = "unsupported file type"
+			case f.UncompressedSize64 > maxArchiveFileBytes:
+				res.Skipped = fmt.Sprintf("larger than %d MB", maxArchiveFileBytes>>20)
+			case count >= maxArchiveFiles:
+				res.Skipped = fmt.Sprintf("archive has more than %d files", maxArchiveFiles)
+			case expanded+int64(f.UncompressedSize64) > maxArchiveBytes:
+				res.Skipped = fmt.Sprintf("archives expand beyond %d MB", maxArchiveBytes>>20)
+			}
+			if res.Skipped != "" {
+				results = append(results, res)
+				continue
+			}
+			count++
+			rc, err := f.Open()
+			if err != nil {
+				res.Error = err.Error()
+				results = append(results, res)
+				continue
+			}
+			// the header sizes can lie, what is read is what counts
+			cr := &countingReader{r: io.LimitReader(rc, min(maxArchiveFileBytes, maxArchiveBytes-expanded))}
+			read(name, "", f.Modified, cr)
+			rc.Close()
+			expanded += cr.n
+		}
+		file.Close()
+	}
+	return results
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// indexFiles indexes the loaded files of results, recording each report or error. progress, when
+// set, gets the chunks done over every file, the total growing as files are chunked.
+func indexFiles(
+	ctx context.Context,
+	results []fileResult,
+	indexFn func(ctx context.Context, doc service.Document) (service.IndexReport, error),
+	progress func(done, total int),
+) []fileResult {
+	doneBefore, fileTotal := 0, 0
+	for i := range results {
+		res := &results[i]
+		if res.doc == nil {
+			continue
+		}
+		doc := *res.doc
+		res.doc = nil
+		fileCtx := ctx
+		if progress != nil {
+			fileTotal = 0
+			fileCtx = service.WithProgress(ctx, func(done, total int) {
+				fileTotal = total
+				progress(doneBefore+done, doneBefore+total)
+			})
+		}
+		log.Printf("Indexing new content from %s (len=%d, records=%d)", doc.Source, len(doc.Content), len(doc.Records))
+		report, err := indexFn(fileCtx, doc)
+		doneBefore += fileTotal
+		if err != nil {
+			res.Error = fmt.Sprintf("error indexing document: %v", err)
+			continue
+		}
+		res.Report = &report
+	}
+	return results
+}
+
+// withSettings applies the upload-wide settings of base to doc
+func withSettings(doc *service.Document, base service.Document) {
+	if doc.Date.IsZero() {
+		doc.Date = base.Date
+	}
+	doc.Collection = base.Collection
+	doc.Session = base.Session
+	doc.Replace = base.Replace
+	doc.Pipeline = base.Pipeline
+	doc.TTL, doc.Origin = base.TTL, base.Origin
+	// the fields given with the upload win over the metadata found by the loader
+	if len(base.Metadata) > 0 {
+		if doc.Metadata == nil {
+			doc.Metadata = make(map[string]string, len(base.Metadata))
+		}
+		maps.Copy(doc.Metadata, base.Metadata)
+	}
+	if base.Type != "" {
+		doc.Type = base.Type
+	}
+}
+
+// fileTypeOf is the lowercase extension of filename, without the dot
+func fileTypeOf(filename string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), ".")
+}
+
+// isZip reports whether an uploaded file is a ZIP archive
+func isZip(fh *multipart.FileHeader) bool {
+	mediaType, _, _ := mime.ParseMediaType(fh.Header.Get("Content-Type"))
+	return mediaType == "application/zip" || mediaType == "application/x-zip-compressed" || fileTypeOf(fh.Filename) == "zip"
+}
+
+// hiddenPath reports archive entries that are not user files: dotfiles and macOS resource forks
+func hiddenPath(name string) bool {
+	for _, part := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// imageExtensions are the image files accepted for OCR
+var imageExtensions = []string{".gif", ".jpeg", ".jpg", ".png", ".tif", ".tiff", ".webp"}
+
+// isImage reports whether an uploaded file is an image, by media type or extension
+func isImage(filename, mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/") || slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(filename)))
+}
+
+// audioExtensions are the recordings accepted for transcription
+var audioExtensions = []string{".flac", ".m4a", ".mp3", ".ogg", ".wav", ".webm"}
+
+// isAudio reports whether an uploaded file is a recording, by media type or extension
+func isAudio(filename, mediaType string) bool {
+	return strings.HasPrefix(mediaType, "audio/") || slices.Contains(audioExtensions, strings.ToLower(filepath.Ext(filename)))
 }
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// Limits of query parameters
+const (
+	// maxQuestionRunes bounds questions; longer texts are documents and belong in /api/upload
+	maxQuestionRunes = 2000
+	// defaultQueryK and maxQueryK are the passages retrieved per question by default and at most
+	defaultQueryK = 100
+	maxQueryK     = 200
+	// maxEntityFilters bounds the 'entity' filters of a query, each a name of up to maxEntityRunes
+	maxEntityFilters = 10
+	maxEntityRunes   = 100
+	// maxMetadataFilters bounds each of the 'source', 'tag' and 'meta' parameters, each value
+	// being up to maxEntityRunes long
+	maxMetadataFilters = 10
+	// maxNeighborChunks bounds the chunks added on each side of a retrieved one
+	maxNeighborChunks = 5
+)
+
+// FieldError is a problem with one field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validation collects the field errors of a request, so clients learn about all of them at once.
+// Its methods read and check one field each, returning the zero value when the field is invalid;
+// respond then answers 422 if any was.
+type validation struct {
+	errs []FieldError
+}
+
+func (v *validation) fail(field, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// respond answers 422 with the field errors, if any, reporting whether it did
+func (v *validation) respond(w http.ResponseWriter, r *http.Request) bool {
+	if len(v.errs) == 0 {
+		return false
+	}
+	msgs := make([]string, len(v.errs))
+	for i, e := range v.errs {
+		msgs[i] = fmt.Sprintf("'%s' %s", e.Field, e.Message)
+	}
+	writeAPIError(w, r, http.StatusUnprocessableEntity, APIError{
+		Code:    CodeValidation,
+		Message: "invalid request: " + strings.Join(msgs, "; "),
+		Details: map[string]any{"fields": v.errs},
+	})
+	return true
+}
+
+// required reports whether value is set, failing field otherwise
+func (v *validation) required(field, value string) bool {
+	if value == "" {
+		v.fail(field, "is required")
+		return false
+	}
+	return true
+}
+
+// maxRunes fails field if value is longer than n characters
+func (v *validation) maxRunes(field, value string, n int) {
+	if utf8.RuneCountInString(value) > n {
+		v.fail(field, "must be at most %d characters", n)
+	}
+}
+
+// intIn parses an integer in [lo, hi], def when raw is empty
+func (v *validation) intIn(field, raw string, def, lo, hi int) int {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < lo || n > hi {
+		v.fail(field, "must be an integer in [%d, %d]", lo, hi)
+		return 0
+	}
+	return n
+}
+
+// fraction parses an optional number in (0, 1], 0 when raw is empty
+func (v *validation) fraction(field, raw string) float64 {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return 0
+	}
+	x, err := strconv.ParseFloat(raw, 64)
+	if err != nil || x <= 0 || x > 1 {
+		v.fail(field, "must be a number above 0 and at most 1")
+		return 0
+	}
+	return x
+}
+
+// boolean parses an optional boolean (true/false, 1/0), def when raw is empty
+func (v *validation) boolean(field, raw string, def bool) bool {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		v.fail(field, "must be true or false")
+	}
+	return b
+}
+
+// date parses an optional date (YYYY-MM-DD or RFC 3339)
+func (v *validation) date(field, raw string) time.Time {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return time.Time{}
+	}
+	d, err := parseDate(raw)
+	if err != nil {
+		v.fail(field, "must be a date: %v", err)
+	}
+	return d
+}
+
+// duration parses an optional positive duration ("36h", "90m"), 0 when raw is empty
+func (v *validation) duration(field, raw string) time.Duration {
+	if raw = strings.TrimSpace(raw); raw == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		v.fail(field, "must be a positive duration such as 36h or 90m")
+		return 0
+	}
+	return d
+}
+
+// sessionID checks an optional session id
+func (v *validation) sessionID(field, raw string) string {
+	id := strings.TrimSpace(raw)
+	if id != "" && !repo.ValidSessionID(id) {
+		v.fail(field, "must be up to 64 letters, digits, '-' or '_'")
+		return ""
+	}
+	return id
+}
+
+// userID checks a user id, which must be set when required
+func (v *validation) userID(field, raw string, required bool) string {
+	id := strings.TrimSpace(raw)
+	if id == "" {
+		if required {
+			v.fail(field, "is required")
+		}
+		return ""
+	}
+	if !repo.ValidUserID(id) {
+		v.fail(field, "must be up to 64 letters, digits, '-' or '_'")
+		return ""
+	}
+	return id
+}
+
+// list trims the non-empty values of a repeatable field, checking their number and length
+func (v *validation) list(field string, raw []string) []string {
+	var out []string
+	for _, s := range raw {
+		if s = strings.TrimSpace(s); s != "" {
+			v.maxRunes(field, s, maxEntityRunes)
+			out = append(out, s)
+		}
+	}
+	if len(out) > maxMetadataFilters {
+		v.fail(field, "must be given at most %d times", maxMetadataFilters)
+	}
+	return out
+}
+
+// tags normalizes repeatable tags to lowercase; they are stored comma-separated (see repo.MetaTags)
+func (v *validation) tags(field string, raw []string) []string {
+	tags := v.list(field, raw)
+	for i, t := range tags {
+		if strings.Contains(t, ",") {
+			v.fail(field, "must not contain commas")
+		}
+		tags[i] = strings.ToLower(t)
+	}
+	return tags
+}
+
+// metadata parses repeatable key:value pairs
+func (v *validation) metadata(field string, raw []string) map[string]string {
+	var out map[string]string
+	for _, pair := range v.list(field, raw) {
+		key, value, ok := strings.Cut(pair, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			v.fail(field, "must be key:value")
+			continue
+		}
+		if key == repo.MetaTags {
+			v.fail(field, "cannot set %q, use 'tag'", repo.MetaTags)
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[key] = value
+	}
+	return out
+}
+
+// collection checks an optional collection name; whether it exists is left to the service
+func (v *validation) collection(field, raw string) string {
+	name := strings.TrimSpace(raw)
+	if name != "" && !repo.ValidCollectionName(name) {
+		v.fail(field, "must be a collection name: lowercase letters, digits and single underscores")
+		return ""
+	}
+	return name
+}
+
+// httpURL parses a required absolute http(s) URL
+func (v *validation) httpURL(field, raw string) *url.URL {
+	raw = strings.TrimSpace(raw)
+	if !v.required(field, raw) {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.fail(field, "must be an absolute http(s) URL")
+		return nil
+	}
+	return u
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// NewVoiceQueryHandler returns a handler that accepts a multipart 'audio' clip, transcribes it with
+// transcribeFn and answers the transcript through queryHandler, forwarding any other form field
+// (filters, etc.). The transcript is returned URL-encoded in the X-Transcript header.
+func NewVoiceQueryHandler(
+	transcribeFn func(ctx context.Context, audio []byte, filename string) (string, error),
+	queryHandler http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r)
+			return
+		}
+
+		if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error parsing form: %v", err))
+			return
+		}
+		files := r.MultipartForm.File["audio"]
+		if len(files) == 0 {
+			writeError(w, r, http.StatusBadRequest, "missing 'audio' file")
+			return
+		}
+		audio, err := readFormFile(files[0])
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("error reading audio: %v", err))
+			return
+		}
+
+		transcript, err := transcribeFn(r.Context(), audio, files[0].Filename)
+		if err != nil {
+			writeFailure(w, r, http.StatusBadGateway, err, fmt.Sprintf("error transcribing audio: %v", err))
+			return
+		}
+		if transcript == "" {
+			writeError(w, r, http.StatusUnprocessableEntity, "no speech recognized")
+			return
+		}
+		log.Printf("Voice query transcribed (len=%d)", len(transcript))
+
+		w.Header().Set("X-Transcript", url.QueryEscape(transcript))
+		r.Form.Set("q", transcript)
+		queryHandler(w, r)
+	}
+}
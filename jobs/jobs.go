@@ -0,0 +1,195 @@
+// Package jobs runs long tasks (indexing uploads...) in the background, keeping their progress
+// and outcome in memory so clients can poll or follow them.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Status is the state of a job
+type Status string
+
+const (
+	StatusQueued  Status = "queued"
+	StatusRunning Status = "running"
+	StatusDone    Status = "done"
+	StatusFailed  Status = "failed"
+)
+
+// ErrQueueFull is returned by Submit when every queue slot is taken
+var ErrQueueFull = errors.New("job queue is full")
+
+// Func is the work of a job. It calls progress with the units done so far and the total known
+// so far (chunks for indexing jobs); its result is kept as the job result.
+type Func func(ctx context.Context, progress func(done, total int)) (any, error)
+
+// Job is a snapshot of a submitted task
+type Job struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Owner is the tenant that submitted the job; only it may read the job
+	Owner  string `json:"-"`
+	Status Status `json:"status"`
+	Done   int    `json:"done"`
+	Total  int    `json:"total"`
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type task struct {
+	id string
+	fn Func
+}
+
+// entry is a job and the channel closed at its next change
+type entry struct {
+	job     Job
+	changed chan struct{}
+}
+
+// Queue runs submitted jobs on a fixed number of workers. Finished jobs are forgotten after
+// the retention period.
+type Queue struct {
+	workers   int
+	retention time.Duration
+	tasks     chan task
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+}
+
+// NewQueue builds a queue of size pending jobs run by workers goroutines once Start is called
+func NewQueue(workers, size int, retention time.Duration) *Queue {
+	return &Queue{
+		workers:   workers,
+		retention: retention,
+		tasks:     make(chan task, size),
+		jobs:      make(map[string]*entry),
+	}
+}
+
+// Start launches the workers; they stop when ctx is done, failing the jobs they were running
+func (q *Queue) Start(ctx context.Context) {
+	for range q.workers {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case t := <-q.tasks:
+					q.run(ctx, t)
+				}
+			}
+		}()
+	}
+}
+
+// Submit queues fn on behalf of owner and returns the new job
+func (q *Queue) Submit(kind, owner string, fn Func) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{ID: id, Kind: kind, Owner: owner, Status: StatusQueued, CreatedAt: time.Now().UTC()}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.purgeLocked()
+	select {
+	case q.tasks <- task{id: id, fn: fn}:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[id] = &entry{job: job, changed: make(chan struct{})}
+	return job, nil
+}
+
+// Get returns the job with that id, false if it is unknown or was forgotten
+func (q *Queue) Get(id string) (Job, bool) {
+	job, _, ok := q.Watch(id)
+	return job, ok
+}
+
+// Watch returns the job with that id and a channel closed when it next changes (progress,
+// status), false if it is unknown or was forgotten
+func (q *Queue) Watch(id string) (Job, <-chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	return e.job, e.changed, true
+}
+
+func (q *Queue) run(ctx context.Context, t task) {
+	q.update(t.id, func(j *Job) {
+		now := time.Now().UTC()
+		j.Status, j.StartedAt = StatusRunning, &now
+	})
+	progress := func(done, total int) {
+		q.update(t.id, func(j *Job) { j.Done, j.Total = done, total })
+	}
+	result, err := safeRun(ctx, t.fn, progress)
+	q.update(t.id, func(j *Job) {
+		now := time.Now().UTC()
+		j.FinishedAt, j.Result = &now, result
+		if err != nil {
+			j.Status, j.Error = StatusFailed, err.Error()
+			return
+		}
+		j.Status = StatusDone
+	})
+	if err != nil {
+		log.Printf("job %s failed: %v", t.id, err)
+	}
+}
+
+// safeRun runs fn, turning a panic into an error so one bad job does not kill its worker
+func safeRun(ctx context.Context, fn Func, progress func(done, total int)) (result any, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return fn(ctx, progress)
+}
+
+func (q *Queue) update(id string, fn func(*Job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if e, ok := q.jobs[id]; ok {
+		fn(&e.job)
+		close(e.changed)
+		e.changed = make(chan struct{})
+	}
+}
+
+// purgeLocked forgets the jobs finished more than the retention period ago
+func (q *Queue) purgeLocked() {
+	cutoff := time.Now().Add(-q.retention)
+	for id, e := range q.jobs {
+		if e.job.FinishedAt != nil && e.job.FinishedAt.Before(cutoff) {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
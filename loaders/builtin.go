@@ -0,0 +1,66 @@
+package loaders
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/service"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// builtin are the loaders of Default
+var builtin = []Loader{
+	FileLoader{Exts: []string{".txt"}, MIMEs: []string{"text/plain"}, Parse: extractPlainText},
+	FileLoader{Exts: []string{".md", ".markdown"}, MIMEs: []string{"text/markdown"}, Parse: extractMarkdown},
+	FileLoader{Exts: []string{".html", ".htm"}, MIMEs: []string{"text/html", "application/xhtml+xml"}, Parse: extractHTML},
+	FileLoader{Exts: []string{".pdf"}, MIMEs: []string{"application/pdf"}, Parse: extractPDF},
+	FileLoader{
+		Exts:  []string{".docx"},
+		MIMEs: []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		Parse: extractDOCX,
+	},
+	FileLoader{Exts: []string{".odt"}, MIMEs: []string{"application/vnd.oasis.opendocument.text"}, Parse: extractODT},
+	FileLoader{Exts: []string{".csv", ".tsv"}, MIMEs: []string{"text/csv", "text/tab-separated-values"}, Parse: extractCSV},
+	FileLoader{Exts: []string{".json"}, MIMEs: []string{"application/json"}, Parse: extractJSON},
+	FileLoader{Exts: []string{".jsonl", ".ndjson"}, MIMEs: []string{"application/jsonl", "application/x-ndjson"}, Parse: extractJSONL},
+	FileLoader{Exts: codeExtensions, Parse: extractPlainText},
+}
+
+func extractPlainText(data []byte) ([]Section, error) {
+	return []Section{{Text: string(data)}}, nil
+}
+
+func extractMarkdown(data []byte) ([]Section, error) {
+	return []Section{{Text: string(data), Format: service.FormatMarkdown}}, nil
+}
+
+// extractPDF returns the plain text of every page of a PDF, in order, one section per page.
+// Pages without a text layer (scans) come back empty.
+func extractPDF(data []byte) (pages []Section, err error) {
+	// the parser panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening PDF: %w", err)
+	}
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			pages = append(pages, Section{Page: i})
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading page %d: %w", i, err)
+		}
+		pages = append(pages, Section{Text: strings.TrimSpace(text), Page: i})
+	}
+	return pages, nil
+}
@@ -0,0 +1,19 @@
+package loaders
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// codeExtensions are source files indexed as plain text
+var codeExtensions = []string{
+	".c", ".cc", ".cpp", ".cs", ".css", ".go", ".h", ".hpp", ".java", ".js", ".jsx", ".kt", ".lua",
+	".php", ".proto", ".py", ".rb", ".rs", ".scala", ".sh", ".sql", ".swift", ".toml", ".ts", ".tsx",
+	".yaml", ".yml",
+}
+
+// IsCode reports whether filename is a source file handled by the code loader
+func IsCode(filename string) bool {
+	return slices.Contains(codeExtensions, strings.ToLower(filepath.Ext(filename)))
+}
@@ -0,0 +1,219 @@
+package loaders
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Export formats read by ParseExport
+const (
+	ExportLangChain  = "langchain"
+	ExportLlamaIndex = "llamaindex"
+)
+
+// exportNode is a chunk of an export with its optional embedding
+type exportNode struct {
+	Text      string
+	Metadata  map[string]any
+	Embedding []float32
+	// Ref is the document the chunk comes from, when the export links it
+	Ref string
+}
+
+// ParseExport reads chunks exported from a Python RAG prototype and groups them into one
+// document per source, each chunk a record keeping its metadata and embedding, in file order.
+// It reads LangChain documents ({"page_content", "metadata"} objects, plain or serialized with
+// dumpd, as JSON Lines or an array) and LlamaIndex nodes (a persisted docstore.json, or node
+// objects as JSON Lines or an array). It returns the format it found.
+func ParseExport(r io.Reader) ([]service.Document, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("\xef\xbb\xbf"))
+	var format string
+	var nodes []exportNode
+	if values, ok := docstoreNodes(data); ok {
+		format = ExportLlamaIndex
+		for _, v := range values {
+			n, _, err := parseNode(v)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, n)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if bytes.HasPrefix(data, []byte("[")) {
+			if _, err := dec.Token(); err != nil {
+				return nil, "", err
+			}
+		}
+		for i := 1; dec.More(); i++ {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, "", fmt.Errorf("error reading item %d: %w", i, err)
+			}
+			n, f, err := parseNode(raw)
+			if err != nil {
+				return nil, "", fmt.Errorf("item %d: %w", i, err)
+			}
+			if format == "" {
+				format = f
+			}
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, "", errors.New("no LangChain documents or LlamaIndex nodes found")
+	}
+
+	var docs []service.Document
+	bySource := make(map[string]int)
+	for _, n := range nodes {
+		if strings.TrimSpace(n.Text) == "" {
+			continue
+		}
+		source := exportSource(n)
+		i, ok := bySource[source]
+		if !ok {
+			i = len(docs)
+			bySource[source] = i
+			docs = append(docs, service.Document{Source: source})
+		}
+		docs[i].Records = append(docs[i].Records, service.Record{Text: n.Text, Metadata: flattenMetadata(n.Metadata), Embedding: n.Embedding})
+	}
+	for i := range docs {
+		texts := make([]string, len(docs[i].Records))
+		for j, rec := range docs[i].Records {
+			texts[j] = rec.Text
+		}
+		docs[i].Content = strings.Join(texts, "\n\n")
+	}
+	return docs, format, nil
+}
+
+// docstoreNodes returns the nodes of a persisted LlamaIndex docstore, in file order, reporting
+// whether data is one. Whole documents stored next to their nodes are left out.
+func docstoreNodes(data []byte) ([]json.RawMessage, bool) {
+	var store map[string]json.RawMessage
+	if !bytes.HasPrefix(data, []byte("{")) || json.Unmarshal(data, &store) != nil || store["docstore/data"] == nil {
+		return nil, false
+	}
+	// a map would lose the order of the nodes
+	dec := json.NewDecoder(bytes.NewReader(store["docstore/data"]))
+	if _, err := dec.Token(); err != nil {
+		return nil, false
+	}
+	var nodes, documents []json.RawMessage
+	for dec.More() {
+		if _, err := dec.Token(); err != nil {
+			return nil, false
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, false
+		}
+		var typed struct {
+			Type string `json:"__type__"`
+		}
+		_ = json.Unmarshal(raw, &typed)
+		if typed.Type == "4" || typed.Type == "Document" {
+			documents = append(documents, raw)
+		} else {
+			nodes = append(nodes, raw)
+		}
+	}
+	if len(nodes) == 0 {
+		return documents, true
+	}
+	return nodes, true
+}
+
+// parseNode reads a LangChain document or a LlamaIndex node, returning its format
+func parseNode(raw json.RawMessage) (exportNode, string, error) {
+	var obj struct {
+		// LangChain
+		PageContent *string `json:"page_content"`
+		Kwargs      *struct {
+			PageContent string         `json:"page_content"`
+			Metadata    map[string]any `json:"metadata"`
+		} `json:"kwargs"`
+		// LlamaIndex, the node fields possibly wrapped in __data__
+		Data         json.RawMessage `json:"__data__"`
+		Text         *string         `json:"text"`
+		TextResource *struct {
+			Text string `json:"text"`
+		} `json:"text_resource"`
+		Relationships map[string]json.RawMessage `json:"relationships"`
+		// both
+		Metadata  map[string]any `json:"metadata"`
+		Embedding []float32      `json:"embedding"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return exportNode{}, "", fmt.Errorf("not a LangChain document or a LlamaIndex node: %w", err)
+	}
+	switch {
+	case obj.PageContent != nil:
+		return exportNode{Text: *obj.PageContent, Metadata: obj.Metadata, Embedding: obj.Embedding}, ExportLangChain, nil
+	case obj.Kwargs != nil:
+		return exportNode{Text: obj.Kwargs.PageContent, Metadata: obj.Kwargs.Metadata}, ExportLangChain, nil
+	case obj.Data != nil:
+		return parseNode(obj.Data)
+	case obj.Text != nil || obj.TextResource != nil:
+		n := exportNode{Metadata: obj.Metadata, Embedding: obj.Embedding}
+		if obj.Text != nil {
+			n.Text = *obj.Text
+		} else {
+			n.Text = obj.TextResource.Text
+		}
+		// relationship "1" is the source document of the node
+		var source struct {
+			NodeID string `json:"node_id"`
+		}
+		if json.Unmarshal(obj.Relationships["1"], &source) == nil {
+			n.Ref = source.NodeID
+		}
+		return n, ExportLlamaIndex, nil
+	}
+	return exportNode{}, "", errors.New("not a LangChain document (page_content) or a LlamaIndex node (text)")
+}
+
+// exportSource names the document of a node after the file it was read from, else the
+// document it links to
+func exportSource(n exportNode) string {
+	for _, key := range []string{"source", "file_path", "file_name", "url"} {
+		if s, ok := n.Metadata[key].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	if n.Ref != "" {
+		return n.Ref
+	}
+	return "import"
+}
+
+// flattenMetadata turns the metadata of a node into strings, nested values as JSON
+func flattenMetadata(meta map[string]any) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			out[k] = v
+		default:
+			b, _ := json.Marshal(v)
+			out[k] = string(b)
+		}
+	}
+	return out
+}
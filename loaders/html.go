@@ -0,0 +1,310 @@
+package loaders
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/service"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// boilerplateRe matches class/id values of page chrome that never holds main content
+var boilerplateRe = regexp.MustCompile(`(?i)(^|[\s_-])(nav|navbar|menu|sidebar|footer|header|breadcrumbs?|cookie|banner|share|social|comments?|related|promo|advert|ads?|subscribe|newsletter|popup|modal)([\s_-]|$)`)
+
+// skippedTags never contribute text
+var skippedTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Iframe: true, atom.Svg: true, atom.Button: true, atom.Select: true,
+}
+
+// HTMLPage is the readable content of a web page
+type HTMLPage struct {
+	Title string
+	// Text is the markdown rendering of the main content: headings, paragraphs, lists, code and tables
+	Text string
+	// Images are the src attributes of the images inside the main content, as written
+	Images []string
+}
+
+func extractHTML(data []byte) ([]Section, error) {
+	page, err := ParseHTML(data)
+	if err != nil {
+		return nil, err
+	}
+	return []Section{{Text: page.Text, Format: service.FormatMarkdown}}, nil
+}
+
+// ParseHTML strips boilerplate readability-style: page chrome is dropped, then the
+// <main>/<article> element (or the block with the most non-link text) is kept.
+func ParseHTML(data []byte) (HTMLPage, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return HTMLPage{}, fmt.Errorf("error parsing HTML: %w", err)
+	}
+	var page HTMLPage
+	if t := findFirst(doc, atom.Title); t != nil {
+		page.Title = strings.TrimSpace(textOf(t))
+	}
+
+	root := mainContent(doc)
+	r := &htmlRenderer{}
+	r.render(root)
+	page.Text = strings.TrimSpace(r.out.String())
+	page.Images = r.images
+	if page.Title != "" && !strings.HasPrefix(page.Text, "# ") {
+		page.Text = "# " + page.Title + "\n\n" + page.Text
+	}
+	return page, nil
+}
+
+// mainContent picks the node holding the page's main content
+func mainContent(doc *html.Node) *html.Node {
+	for _, a := range []atom.Atom{atom.Main, atom.Article} {
+		if n := largest(doc, func(n *html.Node) bool { return n.DataAtom == a }); n != nil {
+			return n
+		}
+	}
+	if n := findByRole(doc, "main"); n != nil {
+		return n
+	}
+	best, bestScore := findFirst(doc, atom.Body), 0.0
+	walk(doc, func(n *html.Node) bool {
+		if isBoilerplate(n) {
+			return false
+		}
+		if n.DataAtom == atom.Div || n.DataAtom == atom.Section {
+			if score := contentScore(n); score > bestScore {
+				best, bestScore = n, score
+			}
+		}
+		return true
+	})
+	if best == nil {
+		return doc
+	}
+	return best
+}
+
+// contentScore favors blocks with many paragraphs of non-link text
+func contentScore(n *html.Node) float64 {
+	total := float64(len(textOf(n)))
+	if total == 0 {
+		return 0
+	}
+	linkText := 0.0
+	paragraphs := 0.0
+	walk(n, func(c *html.Node) bool {
+		switch c.DataAtom {
+		case atom.A:
+			linkText += float64(len(textOf(c)))
+			return false
+		case atom.P:
+			paragraphs++
+		}
+		return true
+	})
+	return (total - linkText) * (1 + paragraphs/10) * (1 - linkText/total)
+}
+
+func largest(doc *html.Node, match func(*html.Node) bool) *html.Node {
+	var best *html.Node
+	bestLen := 0
+	walk(doc, func(n *html.Node) bool {
+		if match(n) {
+			if l := len(textOf(n)); l > bestLen {
+				best, bestLen = n, l
+			}
+		}
+		return true
+	})
+	return best
+}
+
+// findFirst returns the first element with the given tag
+func findFirst(doc *html.Node, a atom.Atom) *html.Node {
+	return findNode(doc, func(n *html.Node) bool { return n.DataAtom == a })
+}
+
+// findByRole returns the first element with the given ARIA role
+func findByRole(doc *html.Node, role string) *html.Node {
+	return findNode(doc, func(n *html.Node) bool { return getAttr(n, "role") == role })
+}
+
+func findNode(doc *html.Node, match func(*html.Node) bool) *html.Node {
+	var found *html.Node
+	walk(doc, func(n *html.Node) bool {
+		if found != nil {
+			return false
+		}
+		if n.Type == html.ElementNode && match(n) {
+			found = n
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// walk visits n and its descendants depth-first; fn returns false to skip a node's children
+func walk(n *html.Node, fn func(*html.Node) bool) {
+	if !fn(n) {
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c, fn)
+	}
+}
+
+func isBoilerplate(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if skippedTags[n.DataAtom] || getAttr(n, "aria-hidden") == "true" {
+		return true
+	}
+	switch getAttr(n, "role") {
+	case "navigation", "banner", "contentinfo", "complementary":
+		return true
+	}
+	return boilerplateRe.MatchString(getAttr(n, "class")) || boilerplateRe.MatchString(getAttr(n, "id"))
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// textOf returns the collapsed visible text below n
+func textOf(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.ElementNode && skippedTags[c.DataAtom] {
+			return false
+		}
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+			b.WriteString(" ")
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// htmlRenderer turns the main content into markdown-ish text
+type htmlRenderer struct {
+	out    strings.Builder
+	images []string
+}
+
+func (r *htmlRenderer) block(s string) {
+	if s = strings.TrimSpace(s); s != "" {
+		r.out.WriteString(s)
+		r.out.WriteString("\n\n")
+	}
+}
+
+func (r *htmlRenderer) render(n *html.Node) {
+	if isBoilerplate(n) {
+		return
+	}
+	if n.Type == html.ElementNode {
+		switch n.DataAtom {
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			level := int(n.Data[1] - '0')
+			r.block(strings.Repeat("#", level) + " " + textOf(n))
+			return
+		case atom.P, atom.Blockquote, atom.Dt, atom.Dd, atom.Figcaption:
+			r.collectImages(n)
+			r.block(textOf(n))
+			return
+		case atom.Li:
+			r.collectImages(n)
+			if t := textOf(n); t != "" {
+				r.out.WriteString("- " + t + "\n")
+			}
+			return
+		case atom.Ul, atom.Ol:
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				r.render(c)
+			}
+			r.out.WriteString("\n")
+			return
+		case atom.Pre:
+			r.block("```\n" + strings.Trim(rawText(n), "\n") + "\n```")
+			return
+		case atom.Table:
+			r.renderTable(n)
+			return
+		case atom.Img:
+			r.collectImages(n)
+			return
+		}
+	}
+	if n.Type == html.TextNode {
+		// loose text directly inside containers
+		r.block(strings.Join(strings.Fields(n.Data), " "))
+		return
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.render(c)
+	}
+}
+
+func (r *htmlRenderer) collectImages(n *html.Node) {
+	walk(n, func(c *html.Node) bool {
+		if c.DataAtom == atom.Img {
+			if src := getAttr(c, "src"); src != "" && !strings.HasPrefix(src, "data:") {
+				r.images = append(r.images, src)
+			}
+		}
+		return true
+	})
+}
+
+// renderTable writes a table as markdown, taking the first row as the header
+func (r *htmlRenderer) renderTable(n *html.Node) {
+	var rows [][]string
+	walk(n, func(c *html.Node) bool {
+		if c != n && c.DataAtom == atom.Table {
+			return false // nested tables are flattened into their cell
+		}
+		if c.DataAtom == atom.Tr {
+			var row []string
+			for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.DataAtom == atom.Td || cell.DataAtom == atom.Th {
+					row = append(row, textOf(cell))
+				}
+			}
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+			return false
+		}
+		return true
+	})
+	if len(rows) == 0 {
+		return
+	}
+	r.block(service.MarkdownTable(rows[0], rows[1:]))
+}
+
+// rawText keeps the whitespace of preformatted text
+func rawText(n *html.Node) string {
+	var b strings.Builder
+	walk(n, func(c *html.Node) bool {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		}
+		return true
+	})
+	return b.String()
+}
@@ -0,0 +1,140 @@
+// Package loaders turns uploaded files into text. Each format is handled by a Loader;
+// a Registry picks the loader for a file so new formats can be added without touching
+// the HTTP handlers.
+package loaders
+
+import (
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Section is a piece of a loaded document
+type Section struct {
+	Text string
+	// Page is the 1-based page of paginated formats (PDF), 0 otherwise
+	Page int
+	// Format is service.FormatMarkdown when Text keeps markdown structure, empty for plain text
+	Format string
+	// Metadata is set on records of structured formats (CSV rows, JSON objects): each record is
+	// indexed as its own chunk and its fields are stored alongside it
+	Metadata map[string]string
+}
+
+// Loader parses one family of file formats
+type Loader interface {
+	// CanHandle reports whether the loader reads files with this name and media type;
+	// either may be empty when unknown
+	CanHandle(filename, mime string) bool
+	Load(r io.Reader) ([]Section, error)
+}
+
+// Extensions is implemented by loaders that can list the file extensions they handle,
+// used to tell users what they may upload
+type Extensions interface {
+	Extensions() []string
+}
+
+// Registry holds the available loaders. Loaders registered later take precedence, so
+// downstream code can override a built-in format.
+type Registry struct {
+	mu      sync.RWMutex
+	loaders []Loader
+}
+
+// NewRegistry returns a registry with the given loaders
+func NewRegistry(loaders ...Loader) *Registry {
+	return &Registry{loaders: loaders}
+}
+
+// Default returns a registry with the built-in loaders: plain text, markdown, HTML, PDF, DOCX, ODT,
+// CSV/TSV, JSON/JSON Lines and source code
+func Default() *Registry {
+	return NewRegistry(builtin...)
+}
+
+// Register adds l, taking precedence over the loaders already registered
+func (r *Registry) Register(l Loader) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaders = append(r.loaders, l)
+}
+
+// Lookup returns the loader for a file, preferring the most recently registered one
+func (r *Registry) Lookup(filename, mime string) (Loader, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.loaders) - 1; i >= 0; i-- {
+		if r.loaders[i].CanHandle(filename, mime) {
+			return r.loaders[i], true
+		}
+	}
+	return nil, false
+}
+
+// SupportedExtensions lists the extensions of the loaders that declare them, sorted
+func (r *Registry) SupportedExtensions() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var exts []string
+	for _, l := range r.loaders {
+		if e, ok := l.(Extensions); ok {
+			exts = append(exts, e.Extensions()...)
+		}
+	}
+	slices.Sort(exts)
+	return slices.Compact(exts)
+}
+
+// FileLoader is a Loader matching files by extension or media type, for formats that
+// are parsed from their whole content
+type FileLoader struct {
+	// Exts are lowercase extensions including the dot (".txt")
+	Exts []string
+	// MIMEs are media types without parameters ("text/plain")
+	MIMEs []string
+	Parse func(data []byte) ([]Section, error)
+}
+
+func (l FileLoader) CanHandle(filename, mime string) bool {
+	if filename != "" && slices.Contains(l.Exts, strings.ToLower(filepath.Ext(filename))) {
+		return true
+	}
+	return mime != "" && slices.Contains(l.MIMEs, mime)
+}
+
+func (l FileLoader) Load(r io.Reader) ([]Section, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return l.Parse(data)
+}
+
+func (l FileLoader) Extensions() []string { return l.Exts }
+
+// ToDocument builds the document of loaded sections. Records become service records;
+// other sections are joined into the content, and paginated sections are also kept
+// as pages. The format is that of the first section.
+func ToDocument(sections []Section) service.Document {
+	var doc service.Document
+	texts := make([]string, len(sections))
+	for i, s := range sections {
+		texts[i] = s.Text
+		if s.Metadata != nil {
+			doc.Records = append(doc.Records, service.Record{Text: s.Text, Metadata: s.Metadata})
+		}
+		if s.Page > 0 {
+			doc.Pages = texts
+		}
+	}
+	if len(sections) > 0 {
+		doc.Format = sections[0].Format
+	}
+	doc.Content = strings.Join(texts, "\n\n")
+	return doc
+}
@@ -0,0 +1,279 @@
+package loaders
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// extractDOCX reads word/document.xml from a Word document. Headings become markdown
+// headings and tables markdown tables, so the chunker keeps their structure.
+func extractDOCX(data []byte) ([]Section, error) {
+	doc, err := readZipEntry(data, "word/document.xml")
+	if err != nil {
+		return nil, err
+	}
+	w := newOfficeWriter()
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	inRun, inText := 0, false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing document.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				w.startParagraph(0)
+			case "pStyle":
+				w.setHeading(docxHeadingLevel(attr(t, "val")))
+			case "r":
+				inRun++
+			case "t":
+				inText = true
+			case "tab":
+				if inRun > 0 {
+					w.write("\t")
+				}
+			case "br", "cr":
+				if inRun > 0 {
+					w.write("\n")
+				}
+			case "tbl":
+				w.startTable()
+			case "tr":
+				w.startRow()
+			case "tc":
+				w.startCell()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p":
+				w.endParagraph()
+			case "r":
+				inRun--
+			case "t":
+				inText = false
+			case "tc":
+				w.endCell()
+			case "tr":
+				w.endRow()
+			case "tbl":
+				w.endTable()
+			}
+		case xml.CharData:
+			if inText {
+				w.write(string(t))
+			}
+		}
+	}
+	return []Section{{Text: w.String(), Format: service.FormatMarkdown}}, nil
+}
+
+// docxHeadingLevel maps paragraph styles such as "Heading2" or "Title" to a heading level (0 if none)
+func docxHeadingLevel(style string) int {
+	s := strings.ToLower(style)
+	if s == "title" {
+		return 1
+	}
+	if rest, ok := strings.CutPrefix(s, "heading"); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(rest)); err == nil && n > 0 {
+			return n
+		}
+		return 1
+	}
+	return 0
+}
+
+// extractODT reads content.xml from an OpenDocument text file
+func extractODT(data []byte) ([]Section, error) {
+	content, err := readZipEntry(data, "content.xml")
+	if err != nil {
+		return nil, err
+	}
+	w := newOfficeWriter()
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing content.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				w.startParagraph(0)
+			case "h":
+				level, _ := strconv.Atoi(attr(t, "outline-level"))
+				w.startParagraph(max(level, 1))
+			case "s":
+				n, _ := strconv.Atoi(attr(t, "c"))
+				w.write(strings.Repeat(" ", max(n, 1)))
+			case "tab":
+				w.write("\t")
+			case "line-break":
+				w.write("\n")
+			case "table":
+				w.startTable()
+			case "table-row":
+				w.startRow()
+			case "table-cell":
+				w.startCell()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p", "h":
+				w.endParagraph()
+			case "table-cell":
+				w.endCell()
+			case "table-row":
+				w.endRow()
+			case "table":
+				w.endTable()
+			}
+		case xml.CharData:
+			w.write(string(t))
+		}
+	}
+	return []Section{{Text: w.String(), Format: service.FormatMarkdown}}, nil
+}
+
+func readZipEntry(data []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive: %w", err)
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("missing %s: %w", name, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// attr returns the value of the attribute with the given local name
+func attr(el xml.StartElement, local string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// officeWriter accumulates paragraphs and tables of a word-processing document as markdown-ish text.
+// Paragraphs may nest (footnotes, text boxes); nested text is merged into the outer paragraph.
+type officeWriter struct {
+	out     strings.Builder
+	para    strings.Builder
+	depth   int
+	heading int
+
+	tableDepth int
+	rows       [][]string
+	row        []string
+	cell       strings.Builder
+}
+
+func newOfficeWriter() *officeWriter { return &officeWriter{} }
+
+func (w *officeWriter) startParagraph(heading int) {
+	if w.depth == 0 {
+		w.para.Reset()
+		w.heading = heading
+	}
+	w.depth++
+}
+
+func (w *officeWriter) setHeading(level int) {
+	if w.depth == 1 {
+		w.heading = level
+	}
+}
+
+func (w *officeWriter) write(s string) {
+	if w.depth > 0 {
+		w.para.WriteString(s)
+	}
+}
+
+func (w *officeWriter) endParagraph() {
+	if w.depth == 0 {
+		return
+	}
+	w.depth--
+	if w.depth > 0 {
+		return
+	}
+	text := strings.TrimSpace(w.para.String())
+	if text == "" {
+		return
+	}
+	if w.tableDepth > 0 {
+		if w.cell.Len() > 0 {
+			w.cell.WriteString(" ")
+		}
+		w.cell.WriteString(strings.Join(strings.Fields(text), " "))
+		return
+	}
+	if w.heading > 0 {
+		w.out.WriteString(strings.Repeat("#", min(w.heading, 6)) + " ")
+	}
+	w.out.WriteString(text)
+	w.out.WriteString("\n\n")
+}
+
+func (w *officeWriter) startTable() {
+	w.tableDepth++
+	if w.tableDepth == 1 {
+		w.rows = nil
+	}
+}
+
+func (w *officeWriter) startRow() {
+	if w.tableDepth == 1 {
+		w.row = nil
+	}
+}
+
+func (w *officeWriter) startCell() {
+	if w.tableDepth == 1 {
+		w.cell.Reset()
+	}
+}
+
+func (w *officeWriter) endCell() {
+	if w.tableDepth == 1 {
+		w.row = append(w.row, w.cell.String())
+	}
+}
+
+func (w *officeWriter) endRow() {
+	if w.tableDepth == 1 && len(w.row) > 0 {
+		w.rows = append(w.rows, w.row)
+	}
+}
+
+func (w *officeWriter) endTable() {
+	w.tableDepth--
+	if w.tableDepth > 0 || len(w.rows) == 0 {
+		return
+	}
+	w.out.WriteString(service.MarkdownTable(w.rows[0], w.rows[1:]))
+	w.out.WriteString("\n")
+}
+
+func (w *officeWriter) String() string { return w.out.String() }
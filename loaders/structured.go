@@ -0,0 +1,187 @@
+package loaders
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// extractCSV turns every row of a CSV file into its own record section. The first row holds
+// the column names; the delimiter (comma, semicolon or tab) is detected from it.
+func extractCSV(data []byte) ([]Section, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = sniffDelimiter(data)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+	for i, h := range header {
+		if header[i] = strings.TrimSpace(h); header[i] == "" {
+			header[i] = fmt.Sprintf("column %d", i+1)
+		}
+	}
+	var sections []Section
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV row %d: %w", line, err)
+		}
+		var fields []field
+		for i, v := range row {
+			if i < len(header) {
+				fields = append(fields, field{header[i], v})
+			}
+		}
+		if s, ok := recordSection(fields); ok {
+			sections = append(sections, s)
+		}
+	}
+	return sections, nil
+}
+
+// sniffDelimiter picks the most frequent candidate delimiter of the first line
+func sniffDelimiter(data []byte) rune {
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	best, count := ',', 0
+	for _, d := range []rune{',', ';', '\t'} {
+		if n := bytes.Count(first, []byte(string(d))); n > count {
+			best, count = d, n
+		}
+	}
+	return best
+}
+
+// extractJSON turns a JSON array of objects, a single object or JSON Lines into one record
+// section per object. Nested objects are flattened into dotted names ("address.city").
+func extractJSON(data []byte) ([]Section, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var sections []Section
+	add := func(v any) {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			obj = map[string]any{"value": v}
+		}
+		var fields []field
+		flatten("", obj, &fields)
+		if s, ok := recordSection(fields); ok {
+			sections = append(sections, s)
+		}
+	}
+	// a single array, or a sequence of values (JSON Lines or concatenated objects)
+	for {
+		var v any
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing JSON: %w", err)
+		}
+		if arr, ok := v.([]any); ok {
+			for _, item := range arr {
+				add(item)
+			}
+			continue
+		}
+		add(v)
+	}
+	if len(sections) == 0 {
+		return nil, errors.New("no records found")
+	}
+	return sections, nil
+}
+
+// extractJSONL reads JSON Lines, reporting the line of a malformed record
+func extractJSONL(data []byte) ([]Section, error) {
+	var sections []Section
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64<<10), 10<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		s, err := extractJSON(sc.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		sections = append(sections, s...)
+	}
+	return sections, sc.Err()
+}
+
+type field struct {
+	name, value string
+}
+
+// flatten appends the scalar values of v under dotted names, objects sorted by key
+func flatten(prefix string, v any, out *[]field) {
+	switch x := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			name := k
+			if prefix != "" {
+				name = prefix + "." + k
+			}
+			flatten(name, x[k], out)
+		}
+	case []any:
+		var scalars []string
+		for i, item := range x {
+			switch item.(type) {
+			case map[string]any, []any:
+				flatten(fmt.Sprintf("%s.%d", prefix, i), item, out)
+			default:
+				scalars = append(scalars, scalar(item))
+			}
+		}
+		if len(scalars) > 0 {
+			*out = append(*out, field{prefix, strings.Join(scalars, ", ")})
+		}
+	default:
+		*out = append(*out, field{prefix, scalar(v)})
+	}
+}
+
+func scalar(v any) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// recordSection renders fields as "name: value" lines, skipping empty values; it reports
+// false for records without any value
+func recordSection(fields []field) (Section, bool) {
+	var b strings.Builder
+	meta := make(map[string]string, len(fields))
+	for _, f := range fields {
+		v := strings.TrimSpace(f.value)
+		if v == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\n", f.name, v)
+		meta[f.name] = v
+	}
+	if len(meta) == 0 {
+		return Section{}, false
+	}
+	return Section{Text: strings.TrimSpace(b.String()), Metadata: meta}, true
+}
@@ -0,0 +1,348 @@
+// Package mcp serves the corpus to Model Context Protocol hosts (desktop LLM clients and
+// agents) as retrieval tools: search, list_documents and fetch_document. Messages are
+// JSON-RPC 2.0, one per line, over the stdio transport of MCP.
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// protocolVersions are the MCP revisions the server speaks, newest first
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Limits of tool arguments
+const (
+	defaultSearchK   = 10
+	maxSearchK       = 50
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// Server answers MCP requests with the functions it is given. Search is required; the other
+// tools are only offered when their functions are set.
+type Server struct {
+	Name    string
+	Version string
+	// Search retrieves passages (service.RAGService.SearchPassages)
+	Search         func(ctx context.Context, question string, topK int, filter service.SearchOptions) ([]service.Passage, error)
+	ListDocuments  func(ctx context.Context, opts repo.ListDocumentsOptions) ([]repo.IndexedDocument, int64, error)
+	GetDocument    func(ctx context.Context, id int64) (repo.IndexedDocument, bool, error)
+	DocumentChunks func(ctx context.Context, id int64) ([]repo.Document, error)
+}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+// tool describes a tool in tools/list
+type tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// toolResult is the result of tools/call; failures of the tool itself are results with IsError
+// set, so the model can read them
+type toolResult struct {
+	Content []textContent `json:"content"`
+	IsError bool          `json:"isError,omitempty"`
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Serve reads requests from in and writes responses to out until in ends or ctx is done.
+// Requests are handled one at a time, in order.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	r := bufio.NewReader(in)
+	enc := json.NewEncoder(out)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if resp := s.handle(ctx, line); resp != nil {
+				if err := enc.Encode(resp); err != nil {
+					return fmt.Errorf("error writing response: %w", err)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading request: %w", err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// handle answers one message, nil for notifications
+func (s *Server) handle(ctx context.Context, line []byte) *response {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{codeParseError, fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+	if req.ID == nil {
+		// notifications (initialized, cancelled...) need no answer
+		return nil
+	}
+	resp := &response{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{codeInvalidRequest, "not a JSON-RPC 2.0 request"}
+		return resp
+	}
+	result, err := s.dispatch(ctx, req.Method, req.Params)
+	var rerr *rpcError
+	switch {
+	case errors.As(err, &rerr):
+		resp.Error = rerr
+	case err != nil:
+		resp.Error = &rpcError{codeInvalidRequest, err.Error()}
+	default:
+		resp.Result = result
+	}
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, method string, params json.RawMessage) (any, error) {
+	switch method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		_ = json.Unmarshal(params, &p)
+		version := protocolVersions[0]
+		if slices.Contains(protocolVersions, p.ProtocolVersion) {
+			version = p.ProtocolVersion
+		}
+		return map[string]any{
+			"protocolVersion": version,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]string{"name": s.Name, "version": s.Version},
+			"instructions": "Search the local document index with 'search', then read whole documents " +
+				"with 'fetch_document' using the document IDs the results cite.",
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "tools/list":
+		return map[string]any{"tools": s.tools()}, nil
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, &rpcError{codeInvalidParams, fmt.Sprintf("invalid params: %v", err)}
+		}
+		return s.callTool(ctx, p.Name, p.Arguments)
+	}
+	return nil, &rpcError{codeMethodNotFound, fmt.Sprintf("method %q not found", method)}
+}
+
+func (s *Server) tools() []tool {
+	tools := []tool{{
+		Name:        "search",
+		Description: "Search the local document index for the passages most relevant to a query. Each result cites its source and the ID of its document.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query":      map[string]any{"type": "string", "description": "what to look for, in natural language"},
+				"k":          map[string]any{"type": "integer", "minimum": 1, "maximum": maxSearchK, "description": fmt.Sprintf("number of passages, default %d", defaultSearchK)},
+				"collection": map[string]any{"type": "string", "description": "collection to search, the default one when omitted"},
+			},
+			"required": []string{"query"},
+		},
+	}}
+	if s.ListDocuments != nil {
+		tools = append(tools, tool{
+			Name:        "list_documents",
+			Description: "List the indexed documents, newest first, with their IDs, sources and chunk counts.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"collection": map[string]any{"type": "string", "description": "collection to list, every collection when omitted"},
+					"limit":      map[string]any{"type": "integer", "minimum": 1, "maximum": maxListLimit},
+					"offset":     map[string]any{"type": "integer", "minimum": 0},
+				},
+			},
+		})
+	}
+	if s.GetDocument != nil && s.DocumentChunks != nil {
+		tools = append(tools, tool{
+			Name:        "fetch_document",
+			Description: "Fetch the full text of an indexed document by its ID, as cited by search results.",
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"id": map[string]any{"type": "integer", "minimum": 1, "description": "document ID"},
+				},
+				"required": []string{"id"},
+			},
+		})
+	}
+	return tools
+}
+
+func (s *Server) callTool(ctx context.Context, name string, args json.RawMessage) (toolResult, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var text string
+	var err error
+	switch {
+	case name == "search":
+		text, err = s.search(ctx, args)
+	case name == "list_documents" && s.ListDocuments != nil:
+		text, err = s.listDocuments(ctx, args)
+	case name == "fetch_document" && s.GetDocument != nil && s.DocumentChunks != nil:
+		text, err = s.fetchDocument(ctx, args)
+	default:
+		return toolResult{}, &rpcError{codeInvalidParams, fmt.Sprintf("unknown tool %q", name)}
+	}
+	if err != nil {
+		log.Printf("mcp: %s: %v", name, err)
+		return toolResult{Content: []textContent{{"text", err.Error()}}, IsError: true}, nil
+	}
+	return toolResult{Content: []textContent{{"text", text}}}, nil
+}
+
+func (s *Server) search(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query      string `json:"query"`
+		K          int    `json:"k"`
+		Collection string `json:"collection"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	args.Query = strings.TrimSpace(args.Query)
+	if args.Query == "" {
+		return "", errors.New("'query' is required")
+	}
+	if args.K == 0 {
+		args.K = defaultSearchK
+	}
+	if args.K < 1 || args.K > maxSearchK {
+		return "", fmt.Errorf("'k' must be in [1, %d]", maxSearchK)
+	}
+	passages, err := s.Search(ctx, args.Query, args.K, service.SearchOptions{SearchFilter: repo.SearchFilter{Collection: strings.TrimSpace(args.Collection)}})
+	if err != nil {
+		return "", fmt.Errorf("error searching: %v", err)
+	}
+	if len(passages) == 0 {
+		return "No passages found.", nil
+	}
+	var b strings.Builder
+	for i, p := range passages {
+		fmt.Fprintf(&b, "[%d] %s", i+1, p.Source)
+		if p.DocumentID != 0 {
+			fmt.Fprintf(&b, " (document %d)", p.DocumentID)
+		}
+		fmt.Fprintf(&b, "\n%s\n\n", strings.TrimSpace(p.Content))
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+func (s *Server) listDocuments(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		Collection string `json:"collection"`
+		Limit      int    `json:"limit"`
+		Offset     int    `json:"offset"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	if args.Limit == 0 {
+		args.Limit = defaultListLimit
+	}
+	if args.Limit < 1 || args.Limit > maxListLimit || args.Offset < 0 {
+		return "", fmt.Errorf("'limit' must be in [1, %d] and 'offset' not negative", maxListLimit)
+	}
+	docs, total, err := s.ListDocuments(ctx, repo.ListDocumentsOptions{Collection: strings.TrimSpace(args.Collection), Limit: args.Limit, Offset: args.Offset})
+	if err != nil {
+		return "", fmt.Errorf("error listing documents: %v", err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d documents", total)
+	if len(docs) > 0 {
+		fmt.Fprintf(&b, ", showing %d-%d:", args.Offset+1, args.Offset+len(docs))
+	}
+	for _, d := range docs {
+		fmt.Fprintf(&b, "\n- document %d: %s (collection %s, %d chunks, indexed %s)", d.ID, d.Source, d.Collection, d.Chunks, d.CreatedAt.Format("2006-01-02"))
+	}
+	return b.String(), nil
+}
+
+func (s *Server) fetchDocument(ctx context.Context, raw json.RawMessage) (string, error) {
+	var args struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	if args.ID <= 0 {
+		return "", errors.New("'id' must be a document ID")
+	}
+	doc, found, err := s.GetDocument(ctx, args.ID)
+	if err != nil {
+		return "", fmt.Errorf("error reading document: %v", err)
+	}
+	if !found {
+		return "", fmt.Errorf("no document %d", args.ID)
+	}
+	chunks, err := s.DocumentChunks(ctx, args.ID)
+	if err != nil {
+		return "", fmt.Errorf("error reading document: %v", err)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Document %d: %s (collection %s)\n", doc.ID, doc.Source, doc.Collection)
+	for _, c := range chunks {
+		b.WriteString("\n")
+		if c.Section != "" {
+			fmt.Fprintf(&b, "[%s]\n", c.Section)
+		}
+		b.WriteString(strings.TrimSpace(c.Content))
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
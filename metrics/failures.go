@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Failure classes, stable so alerts can select them
+const (
+	// ClassOllamaUnavailable is Ollama being unreachable, timing out or failing on its side
+	ClassOllamaUnavailable = "ollama_unavailable"
+	// ClassOllamaRejected is Ollama refusing a request (an unknown model...)
+	ClassOllamaRejected = "ollama_rejected"
+	// ClassContextOverflow is an input longer than the context window of a model
+	ClassContextOverflow = "context_overflow"
+	// ClassUpstreamUnavailable is another server (a web page, a bucket, the transcription service)
+	// being unreachable or timing out
+	ClassUpstreamUnavailable = "upstream_unavailable"
+	// ClassDBTimeout is a database statement or connection outlasting its timeout
+	ClassDBTimeout = "db_timeout"
+	// ClassEmbedDimMismatch is an embedding whose dimension differs from its collection's
+	ClassEmbedDimMismatch = "embed_dim_mismatch"
+	// ClassCanceled is the client going away before the work finished
+	ClassCanceled = "canceled"
+	// ClassInternal is every other failure
+	ClassInternal = "internal"
+)
+
+// pgQueryCanceled is the SQLSTATE of statements stopped by statement_timeout or a cancel request
+const pgQueryCanceled = "57014"
+
+// Failures counts failed operations by class and by operation (query, upload...)
+var Failures = NewCounterVec("rag_failures_total", "Failed operations by failure class and operation.", "class", "op")
+
+// Classify returns the failure class of err
+func Classify(err error) string {
+	var dm *repo.DimensionMismatchError
+	if errors.As(err, &dm) {
+		return ClassEmbedDimMismatch
+	}
+	// Ollama errors first: their timeouts are deadline errors too
+	var oe *service.OllamaError
+	if errors.As(err, &oe) {
+		switch {
+		case oe.ContextOverflow():
+			return ClassContextOverflow
+		case oe.Unavailable():
+			return ClassOllamaUnavailable
+		}
+		return ClassOllamaRejected
+	}
+	// HTTP timeouts are deadline errors too, so other servers come before the database
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ClassUpstreamUnavailable
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgQueryCanceled || pgconn.Timeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return ClassDBTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return ClassCanceled
+	}
+	return ClassInternal
+}
+
+// RecordFailure counts err under its class and op and logs it with both, returning the class.
+// requestID ties the log line to the request, empty outside of one.
+func RecordFailure(op, requestID string, err error) string {
+	class := Classify(err)
+	Failures.Inc(class, op)
+	attrs := []any{"class", class, "op", op, "error", err}
+	if requestID != "" {
+		attrs = append(attrs, "request_id", requestID)
+	}
+	slog.Error("operation failed", attrs...)
+	return class
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencySamples is how many of the latest durations a LatencyWindow keeps per operation
+const latencySamples = 1024
+
+// LatencyWindow keeps the latest durations of every operation, for percentiles over recent
+// traffic rather than since startup
+type LatencyWindow struct {
+	mu  sync.Mutex
+	ops map[string]*latencyRing
+}
+
+// latencyRing is a ring buffer of durations with the number ever observed
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+	count   int64
+}
+
+// LatencySummary holds the percentiles of the latest durations of an operation
+type LatencySummary struct {
+	// Count is the number of durations ever observed; the percentiles cover the latest ones only
+	Count         int64
+	P50, P90, P99 time.Duration
+}
+
+// Latencies holds the durations of the API requests, by route
+var Latencies = NewLatencyWindow()
+
+// NewLatencyWindow returns an empty LatencyWindow
+func NewLatencyWindow() *LatencyWindow {
+	return &LatencyWindow{ops: map[string]*latencyRing{}}
+}
+
+// Observe records a duration of op
+func (l *LatencyWindow) Observe(op string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.ops[op]
+	if r == nil {
+		r = &latencyRing{}
+		l.ops[op] = r
+	}
+	if len(r.samples) < latencySamples {
+		r.samples = append(r.samples, d)
+	} else {
+		r.samples[r.next] = d
+		r.next = (r.next + 1) % latencySamples
+	}
+	r.count++
+}
+
+// Summaries returns the percentiles of every observed operation
+func (l *LatencyWindow) Summaries() map[string]LatencySummary {
+	l.mu.Lock()
+	sorted := make(map[string][]time.Duration, len(l.ops))
+	counts := make(map[string]int64, len(l.ops))
+	for op, r := range l.ops {
+		sorted[op], counts[op] = slices.Clone(r.samples), r.count
+	}
+	l.mu.Unlock()
+	out := make(map[string]LatencySummary, len(sorted))
+	for op, s := range sorted {
+		slices.Sort(s)
+		out[op] = LatencySummary{Count: counts[op], P50: percentile(s, 50), P90: percentile(s, 90), P99: percentile(s, 99)}
+	}
+	return out
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted, which is not empty
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)]
+}
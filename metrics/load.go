@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OllamaInFlight counts the requests sent to Ollama and not finished yet, streamed generations
+// until their body is closed: the depth of the queue Ollama works through
+var OllamaInFlight atomic.Int64
+
+// CountInFlight wraps base so every request is counted in n while it is in flight
+func CountInFlight(base http.RoundTripper, n *atomic.Int64) http.RoundTripper {
+	return inFlightTransport{base: base, n: n}
+}
+
+type inFlightTransport struct {
+	base http.RoundTripper
+	n    *atomic.Int64
+}
+
+func (t inFlightTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.n.Add(-1)
+		return nil, err
+	}
+	resp.Body = &inFlightBody{ReadCloser: resp.Body, n: t.n}
+	return resp, nil
+}
+
+// inFlightBody ends the count of its request when closed
+type inFlightBody struct {
+	io.ReadCloser
+	n    *atomic.Int64
+	once sync.Once
+}
+
+func (b *inFlightBody) Close() error {
+	b.once.Do(func() { b.n.Add(-1) })
+	return b.ReadCloser.Close()
+}
+
+// DBLatency holds the durations of the latest database statements
+var DBLatency = NewRecentLatency(30 * time.Second)
+
+// RecentLatency keeps the durations observed over the latest period, so a slow spell stops
+// counting once it is over even when nothing is observed since
+type RecentLatency struct {
+	period time.Duration
+	mu     sync.Mutex
+	ring   []timedDuration
+	next   int
+}
+
+type timedDuration struct {
+	at time.Time
+	d  time.Duration
+}
+
+// NewRecentLatency returns a RecentLatency over period
+func NewRecentLatency(period time.Duration) *RecentLatency {
+	return &RecentLatency{period: period}
+}
+
+// Observe records a duration
+func (l *RecentLatency) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := timedDuration{at: time.Now(), d: d}
+	if len(l.ring) < latencySamples {
+		l.ring = append(l.ring, s)
+		return
+	}
+	l.ring[l.next] = s
+	l.next = (l.next + 1) % latencySamples
+}
+
+// Percentile returns the p-th percentile of the durations of the period, 0 without any
+func (l *RecentLatency) Percentile(p int) time.Duration {
+	since := time.Now().Add(-l.period)
+	l.mu.Lock()
+	var recent []time.Duration
+	for _, s := range l.ring {
+		if s.at.After(since) {
+			recent = append(recent, s.d)
+		}
+	}
+	l.mu.Unlock()
+	if len(recent) == 0 {
+		return 0
+	}
+	slices.Sort(recent)
+	return percentile(recent, p)
+}
+
+// Overload reasons of LoadLimits.Overload
+const (
+	OverloadOllamaQueue = "ollama_queue"
+	OverloadDBLatency   = "db_latency"
+)
+
+// LoadLimits are the thresholds past which the server is overloaded; a zero limit is not checked
+type LoadLimits struct {
+	// MaxOllamaInFlight bounds OllamaInFlight
+	MaxOllamaInFlight int64
+	// MaxDBLatency bounds the 90th percentile of DBLatency
+	MaxDBLatency time.Duration
+}
+
+// Overload returns the reason the server is overloaded, empty when it is not
+func (l LoadLimits) Overload() string {
+	switch {
+	case l.MaxOllamaInFlight > 0 && OllamaInFlight.Load() > l.MaxOllamaInFlight:
+		return OverloadOllamaQueue
+	case l.MaxDBLatency > 0 && DBLatency.Percentile(90) > l.MaxDBLatency:
+		return OverloadDBLatency
+	}
+	return ""
+}
+
+// Shed counts the requests rejected to shed load, by route and overload reason
+var Shed = NewCounterVec("rag_shed_requests_total", "Low-priority requests rejected while the server was overloaded.", "route", "reason")
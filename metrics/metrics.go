@@ -0,0 +1,92 @@
+// Package metrics keeps the counters operators alert on and serves them in the Prometheus text
+// exposition format at /metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CounterVec is a counter per combination of label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	counts map[string]float64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*CounterVec
+)
+
+// NewCounterVec registers a counter named name with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, counts: map[string]float64{}}
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+	return c
+}
+
+// Inc adds one to the counter of the label values, given in the order of the label names
+func (c *CounterVec) Inc(values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	c.mu.Lock()
+	c.counts[key]++
+	c.mu.Unlock()
+}
+
+// Value returns the counter of the label values
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[strings.Join(values, "\x00")]
+}
+
+// write renders the counter in the text exposition format, series in label order
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.counts))
+	for k := range c.counts {
+		keys = append(keys, k)
+	}
+	counts := make(map[string]float64, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	c.mu.Unlock()
+	slices.Sort(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, k := range keys {
+		values := strings.Split(k, "\x00")
+		pairs := make([]string, len(c.labels))
+		for i, l := range c.labels {
+			pairs[i] = fmt.Sprintf("%s=%q", l, values[i])
+		}
+		fmt.Fprintf(w, "%s{%s} %g\n", c.name, strings.Join(pairs, ","), counts[k])
+	}
+}
+
+// Handler serves every registered counter
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registryMu.Lock()
+		counters := slices.Clone(registry)
+		registryMu.Unlock()
+		for _, c := range counters {
+			c.write(w)
+		}
+	})
+}
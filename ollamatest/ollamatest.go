@@ -0,0 +1,338 @@
+// Package ollamatest provides a fake Ollama server for tests, answering the endpoints the RAG
+// server uses (/api/embeddings, /api/embed, /api/generate, /api/chat) deterministically:
+// embeddings are hashed from the words of the text, so texts sharing words are close, and
+// completions come from a replaceable function, streamed word by word like Ollama streams tokens.
+//
+//	srv := ollamatest.NewServer()
+//	defer srv.Close()
+//	svc := service.NewRAGService(repo, srv.Client(), nil, service.Config{OllamaURL: srv.URL, ...})
+package ollamatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultDimension is the size of the embeddings of a new server
+const DefaultDimension = 16
+
+// Request is a call received by the server
+type Request struct {
+	Path  string
+	Model string
+	// Inputs are the texts embedded, or the prompt (or last chat message) of a completion
+	Inputs []string
+}
+
+// Server is a fake Ollama API over httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	dimension int
+	models    map[string]bool
+	respond   func(model, prompt string) string
+	failures  map[string]int
+	removed   map[string]bool
+	requests  []Request
+}
+
+// NewServer starts a server that accepts any model, embeds with DefaultDimension and answers
+// completions with "Mock answer to: <prompt>"
+func NewServer() *Server {
+	s := &Server{
+		dimension: DefaultDimension,
+		respond:   func(model, prompt string) string { return "Mock answer to: " + prompt },
+		failures:  map[string]int{},
+		removed:   map[string]bool{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/embeddings", s.handleEmbeddings)
+	mux.HandleFunc("/api/embed", s.handleEmbed)
+	mux.HandleFunc("/api/generate", s.handleGenerate)
+	mux.HandleFunc("/api/chat", s.handleChat)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		removed := s.removed[r.URL.Path]
+		if removed {
+			s.requests = append(s.requests, Request{Path: r.URL.Path})
+		}
+		s.mu.Unlock()
+		if removed {
+			http.NotFound(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// SetDimension changes the size of the embeddings returned from now on
+func (s *Server) SetDimension(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dimension = n
+}
+
+// SetModels restricts the models served; others get a 404 like a model that was never pulled.
+// No models means any model is accepted.
+func (s *Server) SetModels(models ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.models = nil
+	if len(models) > 0 {
+		s.models = make(map[string]bool, len(models))
+		for _, m := range models {
+			s.models[m] = true
+		}
+	}
+}
+
+// SetResponder replaces the function writing completions
+func (s *Server) SetResponder(fn func(model, prompt string) string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.respond = fn
+}
+
+// FailWith makes the next calls to path (e.g. "/api/embeddings") answer status with an Ollama
+// error body; a status of 0 clears it
+func (s *Server) FailWith(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = status
+}
+
+// Remove makes path (e.g. "/api/embed") answer a plain "404 page not found", like the Ollama
+// versions predating it; removed is false serves it again
+func (s *Server) Remove(path string, removed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed[path] = removed
+}
+
+// Requests returns the calls received so far, oldest first
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Embed returns the embedding the server gives text, so tests can build expected vectors
+func (s *Server) Embed(text string) []float32 {
+	s.mu.Lock()
+	dim := s.dimension
+	s.mu.Unlock()
+	return embed(text, dim)
+}
+
+// begin records a call and reports whether it should proceed, having written the error otherwise
+func (s *Server) begin(w http.ResponseWriter, r *http.Request, model string, inputs []string) bool {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Path: r.URL.Path, Model: model, Inputs: inputs})
+	status := s.failures[r.URL.Path]
+	unknown := s.models != nil && !s.models[model]
+	s.mu.Unlock()
+	switch {
+	case status != 0:
+		writeError(w, status, "injected failure")
+		return false
+	case model == "":
+		writeError(w, http.StatusBadRequest, "model is required")
+		return false
+	case unknown:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("model %q not found, try pulling it first", model))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	if !decode(w, r, &req) || !s.begin(w, r, req.Model, []string{req.Prompt}) {
+		return
+	}
+	writeJSON(w, map[string]any{"embedding": s.Embed(req.Prompt)})
+}
+
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model string          `json:"model"`
+		Input json.RawMessage `json:"input"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	// input is a string or an array of strings
+	var inputs []string
+	var one string
+	if err := json.Unmarshal(req.Input, &one); err == nil {
+		inputs = []string{one}
+	} else if err := json.Unmarshal(req.Input, &inputs); err != nil {
+		writeError(w, http.StatusBadRequest, "input must be a string or an array of strings")
+		return
+	}
+	if !s.begin(w, r, req.Model, inputs) {
+		return
+	}
+	embeddings := make([][]float32, len(inputs))
+	for i, in := range inputs {
+		embeddings[i] = s.Embed(in)
+	}
+	writeJSON(w, map[string]any{"model": req.Model, "embeddings": embeddings})
+}
+
+func (s *Server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+		Stream *bool  `json:"stream"`
+	}
+	if !decode(w, r, &req) || !s.begin(w, r, req.Model, []string{req.Prompt}) {
+		return
+	}
+	answer := s.completion(req.Model, req.Prompt)
+	s.stream(w, req.Stream == nil || *req.Stream, answer, func(part string, done bool) map[string]any {
+		return map[string]any{"model": req.Model, "created_at": time.Now().UTC(), "response": part, "done": done}
+	})
+}
+
+func (s *Server) handleChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		Stream *bool `json:"stream"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	prompt := ""
+	if n := len(req.Messages); n > 0 {
+		prompt = req.Messages[n-1].Content
+	}
+	if !s.begin(w, r, req.Model, []string{prompt}) {
+		return
+	}
+	answer := s.completion(req.Model, prompt)
+	s.stream(w, req.Stream == nil || *req.Stream, answer, func(part string, done bool) map[string]any {
+		return map[string]any{
+			"model":      req.Model,
+			"created_at": time.Now().UTC(),
+			"message":    map[string]string{"role": "assistant", "content": part},
+			"done":       done,
+		}
+	})
+}
+
+func (s *Server) completion(model, prompt string) string {
+	s.mu.Lock()
+	respond := s.respond
+	s.mu.Unlock()
+	return respond(model, prompt)
+}
+
+// stream writes answer as Ollama does: one NDJSON object per token (here per word) and a final
+// object with done=true, or a single object when streaming is off
+func (s *Server) stream(w http.ResponseWriter, streaming bool, answer string, object func(part string, done bool) map[string]any) {
+	if !streaming {
+		final := object(answer, true)
+		final["done_reason"] = "stop"
+		writeJSON(w, final)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for _, part := range splitTokens(answer) {
+		_ = enc.Encode(object(part, false))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	final := object("", true)
+	final["done_reason"] = "stop"
+	_ = enc.Encode(final)
+}
+
+// splitTokens cuts text into words keeping their leading spaces, so the parts concatenate back
+func splitTokens(text string) []string {
+	var parts []string
+	start := 0
+	for i := 1; i < len(text); i++ {
+		if text[i] == ' ' && text[i-1] != ' ' {
+			parts = append(parts, text[start:i])
+			start = i
+		}
+	}
+	if start < len(text) {
+		parts = append(parts, text[start:])
+	}
+	return parts
+}
+
+// embed hashes every lowercase word of text into one of dim buckets and normalizes the counts,
+// so the cosine similarity of two texts grows with the words they share
+func embed(text string, dim int) []float32 {
+	v := make([]float32, dim)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(word))
+		v[h.Sum32()%uint32(dim)]++
+	}
+	var norm float64
+	for _, x := range v {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		// Ollama never returns a zero vector; keep cosine distance defined
+		v[0] = 1
+		return v
+	}
+	for i := range v {
+		v[i] = float32(float64(v[i]) / math.Sqrt(norm))
+	}
+	return v
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,212 @@
+// Package rag embeds the RAG pipeline in Go programs, without the HTTP server
+// (go get github.com/Thaizir/go-local-RAG/rag):
+//
+//	r, err := rag.New(ctx, rag.Options{})
+//	if err != nil { ... }
+//	defer r.Close(ctx)
+//	report, err := r.IndexFile(ctx, "manual.pdf", "")
+//	answer, err := r.Query(ctx, "how do I reset the device?", rag.QueryOptions{})
+//
+// The pieces it wires together are packages of their own: service (chunkers, embeddings,
+// indexing and retrieval), repo (the stores), loaders (file formats) and config.
+package rag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/Thaizir/go-local-RAG/config"
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Options configure New; every field is optional
+type Options struct {
+	// Config holds the settings; nil reads them like the server does, from the defaults and
+	// the RAG_* environment variables
+	Config *config.Config
+	// Repository stores the chunks; nil opens the Postgres database at Config.DatabaseURL,
+	// which Close then closes
+	Repository repo.DocumentRepository
+	// HTTPClient calls Ollama and the other model servers; nil uses one timing out after
+	// Config.HTTPTimeout
+	HTTPClient *http.Client
+	// Chunker splits documents; nil uses the one Config.Service selects
+	Chunker service.Chunker
+	// Reranker reorders the retrieved chunks; nil uses the model of Config.Service.RerankModel,
+	// if any (see service.Reranker)
+	Reranker service.Reranker
+	// Loaders read the files of IndexFile; nil uses loaders.Default
+	Loaders *loaders.Registry
+}
+
+// RAG is an indexing and question-answering pipeline
+type RAG struct {
+	svc      *service.RAGService
+	repo     repo.DocumentRepository
+	ownsRepo bool
+	loaders  *loaders.Registry
+}
+
+// QueryOptions tune a search or a query; the zero value uses the defaults
+type QueryOptions struct {
+	// K is the number of passages retrieved, DefaultK when 0
+	K int
+	// Filter restricts the search (collection, session, dates, entities) and tunes how it ranks
+	// the passages
+	Filter service.SearchOptions
+	// Style is service.StyleConcise, StyleDetailed or StyleBullet, "" for the default
+	Style string
+	// MaxTokens caps the answer length, 0 for the model's default
+	MaxTokens int
+	// Template names the prompt template of service.Config.PromptTemplates the answer is asked
+	// with, "" for service.DefaultPromptTemplate
+	Template string
+	// User, with Filter.Session, makes Query a turn of a conversation: a follow-up question is
+	// rewritten from the earlier turns (see service.RAGService.StandaloneQuestion) and the answer
+	// is saved in the history of the user
+	User string
+}
+
+// DefaultK is the number of passages retrieved per question by default
+const DefaultK = 20
+
+// Answer is the answer to a question with the passages it is based on, numbered in the
+// prompt in their order
+type Answer struct {
+	Text     string
+	Passages []service.Passage
+}
+
+// New builds the pipeline, initializing the repository schema and the configured collections
+func New(ctx context.Context, opts Options) (*RAG, error) {
+	cfg := opts.Config
+	if cfg == nil {
+		var err error
+		if cfg, err = config.Load(nil); err != nil {
+			return nil, err
+		}
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.HTTPTimeout}
+	}
+	r := &RAG{repo: opts.Repository, loaders: opts.Loaders}
+	if r.loaders == nil {
+		r.loaders = loaders.Default()
+	}
+	if r.repo == nil {
+		pg, err := repo.NewPostgresRepository(ctx, cfg.DatabaseURL, repo.PostgresOptions{
+			QueryTimeout: cfg.DBQueryTimeout,
+			MaxConns:     int32(cfg.DBMaxConns),
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.repo, r.ownsRepo = pg, true
+	}
+	if err := r.repo.Init(ctx); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	r.svc = service.NewRAGService(r.repo, httpClient, opts.Chunker, cfg.Service)
+	if opts.Reranker != nil {
+		r.svc.SetReranker(opts.Reranker)
+	}
+	if err := r.svc.RegisterCollections(ctx); err != nil {
+		r.Close(ctx)
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the repository if New opened it
+func (r *RAG) Close(ctx context.Context) error {
+	if !r.ownsRepo {
+		return nil
+	}
+	return r.repo.Close(ctx)
+}
+
+// Service returns the underlying service, for what the pipeline does not wrap (history,
+// evaluations, source syncs...)
+func (r *RAG) Service() *service.RAGService { return r.svc }
+
+// Repository returns the store of the pipeline
+func (r *RAG) Repository() repo.DocumentRepository { return r.repo }
+
+// Index chunks, embeds and stores doc
+func (r *RAG) Index(ctx context.Context, doc service.Document) (service.IndexReport, error) {
+	return r.svc.IndexDocument(ctx, doc)
+}
+
+// IndexFile reads the file at path with the loader of its extension and indexes it into
+// collection ("" for the default one), with the path as source. Indexing a path again replaces
+// its previous version (see service.Document.Replace).
+func (r *RAG) IndexFile(ctx context.Context, path, collection string) (service.IndexReport, error) {
+	loader, ok := r.loaders.Lookup(path, "")
+	if !ok {
+		return service.IndexReport{}, fmt.Errorf("no loader for %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return service.IndexReport{}, err
+	}
+	sections, err := loader.Load(bytes.NewReader(data))
+	if err != nil {
+		return service.IndexReport{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	doc := loaders.ToDocument(sections)
+	doc.Source = path
+	doc.Collection = collection
+	doc.Replace = true
+	if info, err := os.Stat(path); err == nil {
+		doc.Date = info.ModTime()
+	}
+	return r.svc.IndexDocument(ctx, doc)
+}
+
+// Search returns the passages most relevant to question
+func (r *RAG) Search(ctx context.Context, question string, opts QueryOptions) ([]service.Passage, error) {
+	k := opts.K
+	if k <= 0 {
+		k = DefaultK
+	}
+	return r.svc.SearchPassages(ctx, question, k, opts.Filter)
+}
+
+// Query answers question from the passages Search retrieves for it, as many as fit the
+// context budget of the generation model unless opts.Filter sets one (see
+// service.RAGService.ContextBudget)
+func (r *RAG) Query(ctx context.Context, question string, opts QueryOptions) (Answer, error) {
+	if !service.ValidStyle(opts.Style) {
+		return Answer{}, fmt.Errorf("unknown answer style %q", opts.Style)
+	}
+	if opts.Filter.TokenBudget == 0 {
+		opts.Filter.TokenBudget = r.svc.ContextBudget(ctx, r.svc.LLMModel())
+	}
+	standalone := r.svc.StandaloneQuestion(ctx, opts.User, opts.Filter.Session, question)
+	passages, err := r.Search(ctx, standalone, opts)
+	if err != nil {
+		return Answer{}, fmt.Errorf("error looking for context: %w", err)
+	}
+	prompt, err := r.svc.Prompt(opts.Template, standalone, passages, "", opts.Style)
+	if err != nil {
+		return Answer{}, err
+	}
+	text, err := r.svc.Answer(ctx, prompt, opts.MaxTokens)
+	if err != nil {
+		return Answer{}, err
+	}
+	text = service.ProcessAnswer(r.svc.NewAnswerProcessor(passages), text)
+	if opts.User != "" {
+		if _, err := r.svc.RecordAnswer(ctx, opts.User, opts.Filter.Session, question, text, passages); err != nil {
+			return Answer{}, fmt.Errorf("error saving answer to history: %w", err)
+		}
+	}
+	return Answer{Text: text, Passages: passages}, nil
+}
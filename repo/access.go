@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// AccessReport shows how retrieval uses a collection: its most retrieved chunks and the sources
+// none of whose chunks was ever retrieved (see RecordRetrievals)
+type AccessReport struct {
+	// Chunks counts the searchable chunks of the collection, RetrievedChunks those retrieved at
+	// least once
+	Chunks          int64
+	RetrievedChunks int64
+	Hot             []ChunkAccess
+	Cold            []ColdSource
+}
+
+// ChunkAccess is a chunk with the number of times it was retrieved
+type ChunkAccess struct {
+	ID         int
+	Source     string
+	DocumentID int64
+	Position   int
+	// Excerpt is the beginning of the content
+	Excerpt         string
+	Retrievals      int64
+	LastRetrievedAt time.Time
+}
+
+// ColdSource is a source none of whose chunks was ever retrieved
+type ColdSource struct {
+	Source string
+	Chunks int64
+	// IndexedAt is when its oldest document was indexed, zero if unknown
+	IndexedAt time.Time
+}
+
+// excerptRunes is the length of ChunkAccess.Excerpt
+const excerptRunes = 200
+
+// RecordRetrievals counts one retrieval of every chunk of ids
+func (p *PostgresRepository) RecordRetrievals(ctx context.Context, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{ids}
+	// rows are locked in id order so concurrent queries cannot deadlock
+	_, err := p.pool.Exec(ctx,
+		"INSERT INTO chunk_access (chunk_id) SELECT id FROM documents WHERE id = ANY($1) AND "+tenantScope(ctx, "collection", &args)+" ORDER BY id "+
+			"ON CONFLICT (chunk_id) DO UPDATE SET retrievals = chunk_access.retrievals + 1, last_retrieved_at = now()", args...)
+	if err != nil {
+		return fmt.Errorf("error recording retrievals: %w", err)
+	}
+	return nil
+}
+
+// AccessReport reports the retrievals of the current, shared chunks of a collection: up to
+// limit of the most retrieved chunks and of the largest never-retrieved sources
+func (p *PostgresRepository) AccessReport(ctx context.Context, collection string, limit int) (AccessReport, error) {
+	if _, err := p.collectionDimension(ctx, collection); err != nil {
+		return AccessReport{}, err
+	}
+	name := collectionName(ctx, collection)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	const searchable = " WHERE d.collection = $1 AND d.state = 'current' AND d.session = ''"
+	var report AccessReport
+	err := p.pool.QueryRow(ctx,
+		"SELECT count(*), count(a.chunk_id) FROM documents d LEFT JOIN chunk_access a ON a.chunk_id = d.id"+searchable, name,
+	).Scan(&report.Chunks, &report.RetrievedChunks)
+	if err != nil {
+		return AccessReport{}, fmt.Errorf("error counting retrieved chunks: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx,
+		"SELECT d.id, d.source, coalesce(d.document_id, 0), coalesce(d.chunk_position, 0), left(d.content, $3), a.retrievals, a.last_retrieved_at "+
+			"FROM chunk_access a JOIN documents d ON d.id = a.chunk_id"+searchable+" ORDER BY a.retrievals DESC, d.id LIMIT $2",
+		name, limit, excerptRunes)
+	if err != nil {
+		return AccessReport{}, fmt.Errorf("error listing hot chunks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c ChunkAccess
+		if err := rows.Scan(&c.ID, &c.Source, &c.DocumentID, &c.Position, &c.Excerpt, &c.Retrievals, &c.LastRetrievedAt); err != nil {
+			return AccessReport{}, err
+		}
+		report.Hot = append(report.Hot, c)
+	}
+	if err := rows.Err(); err != nil {
+		return AccessReport{}, err
+	}
+
+	rows, err = p.pool.Query(ctx,
+		"SELECT d.source, count(*), min(i.created_at) FROM documents d "+
+			"LEFT JOIN chunk_access a ON a.chunk_id = d.id LEFT JOIN indexed_documents i ON i.id = d.document_id"+searchable+
+			" GROUP BY d.source HAVING count(a.chunk_id) = 0 ORDER BY count(*) DESC, d.source LIMIT $2",
+		name, limit)
+	if err != nil {
+		return AccessReport{}, fmt.Errorf("error listing cold sources: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s ColdSource
+		var indexedAt *time.Time
+		if err := rows.Scan(&s.Source, &s.Chunks, &indexedAt); err != nil {
+			return AccessReport{}, err
+		}
+		if indexedAt != nil {
+			s.IndexedAt = *indexedAt
+		}
+		report.Cold = append(report.Cold, s)
+	}
+	return report, rows.Err()
+}
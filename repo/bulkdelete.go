@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DocumentPredicate selects the indexed documents matching every non-empty field
+type DocumentPredicate struct {
+	// Collection restricts the selection to a collection; empty selects in every collection
+	Collection string
+	// Tags must all be in the MetaTags metadata of a chunk of the document
+	Tags []string
+	// SourcePrefix must start the source of the document
+	SourcePrefix string
+	// UploadedBefore excludes the documents indexed at or after it
+	UploadedBefore time.Time
+}
+
+// where returns the condition selecting the documents of the tenant of ctx matching pr, on
+// indexed_documents aliased d
+func (pr DocumentPredicate) where(ctx context.Context, args *[]any) string {
+	conds := []string{tenantScope(ctx, "d.collection", args)}
+	if pr.Collection != "" {
+		*args = append(*args, collectionName(ctx, pr.Collection))
+		conds = append(conds, fmt.Sprintf("d.collection = $%d", len(*args)))
+	}
+	for _, tag := range pr.Tags {
+		*args = append(*args, tag)
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM documents c WHERE c.document_id = d.id AND $%d = ANY(string_to_array(c.metadata->>'%s', ',')))", len(*args), MetaTags))
+	}
+	if pr.SourcePrefix != "" {
+		*args = append(*args, pr.SourcePrefix)
+		conds = append(conds, fmt.Sprintf("starts_with(d.source, $%d)", len(*args)))
+	}
+	if !pr.UploadedBefore.IsZero() {
+		*args = append(*args, pr.UploadedBefore)
+		conds = append(conds, fmt.Sprintf("d.created_at < $%d", len(*args)))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// CountMatchingDocuments returns how many documents of the tenant of ctx match pr, and their
+// chunks
+func (p *PostgresRepository) CountMatchingDocuments(ctx context.Context, pr DocumentPredicate) (int64, int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var args []any
+	var docs, chunks int64
+	err := p.pool.QueryRow(ctx,
+		"SELECT count(*), coalesce(sum((SELECT count(*) FROM documents c WHERE c.document_id = d.id)), 0) FROM indexed_documents d WHERE "+pr.where(ctx, &args),
+		args...).Scan(&docs, &chunks)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error counting documents: %w", err)
+	}
+	return docs, chunks, nil
+}
+
+// DeleteMatchingDocuments deletes up to limit documents of the tenant of ctx matching pr with
+// their chunks in one transaction, returning how many documents and chunks it deleted
+func (p *PostgresRepository) DeleteMatchingDocuments(ctx context.Context, pr DocumentPredicate, limit int) (int64, int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+	var args []any
+	where := pr.where(ctx, &args)
+	args = append(args, limit)
+	rows, err := tx.Query(ctx, fmt.Sprintf("SELECT d.id FROM indexed_documents d WHERE %s ORDER BY d.id LIMIT $%d FOR UPDATE", where, len(args)), args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error selecting documents: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error selecting documents: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+	chunks, err := tx.Exec(ctx, "DELETE FROM documents WHERE document_id = ANY($1)", ids)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error deleting document chunks: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM quarantine WHERE document_id = ANY($1)", ids); err != nil {
+		return 0, 0, fmt.Errorf("error deleting document chunks: %w", err)
+	}
+	docs, err := tx.Exec(ctx, "DELETE FROM indexed_documents WHERE id = ANY($1)", ids)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error deleting documents: %w", err)
+	}
+	return docs.RowsAffected(), chunks.RowsAffected(), tx.Commit(ctx)
+}
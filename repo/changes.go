@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations of a DocumentChange
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// DocumentChange is an entry of the change feed: a document inserted, updated (its chunks
+// included) or deleted. A transaction leaves one entry per document it changes, or its insert
+// and its delete.
+type DocumentChange struct {
+	Cursor     ChangeCursor
+	Op         string
+	DocumentID int64
+	Collection string
+	Source     string
+	At         time.Time
+}
+
+// ChangeCursor is a position in the change feed, after the change it was read with; the zero
+// cursor is the start of the feed. Changes are ordered by the transaction that made them, so a
+// transaction committing late cannot land behind a cursor already handed out.
+type ChangeCursor struct {
+	TxID uint64
+	ID   int64
+}
+
+// String renders c as ParseChangeCursor reads it, empty for the start of the feed
+func (c ChangeCursor) String() string {
+	if c == (ChangeCursor{}) {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d", c.TxID, c.ID)
+}
+
+// ParseChangeCursor reads a cursor rendered by ChangeCursor.String; empty is the start of the feed
+func ParseChangeCursor(s string) (ChangeCursor, error) {
+	if s == "" {
+		return ChangeCursor{}, nil
+	}
+	tx, id, ok := strings.Cut(s, ".")
+	c := ChangeCursor{}
+	var err error
+	if ok {
+		if c.TxID, err = strconv.ParseUint(tx, 10, 64); err == nil {
+			c.ID, err = strconv.ParseInt(id, 10, 64)
+		}
+	}
+	if !ok || err != nil || c.ID < 0 {
+		return ChangeCursor{}, fmt.Errorf("invalid change cursor %q", s)
+	}
+	return c, nil
+}
+
+// DocumentChanges returns up to limit changes of documents of the tenant of ctx after since, in
+// collection when not empty. Only the changes of transactions older than every one still
+// running are returned, so the feed never skips a change: a long transaction holds it back.
+func (p *PostgresRepository) DocumentChanges(ctx context.Context, since ChangeCursor, collection string, limit int) ([]DocumentChange, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{strconv.FormatUint(since.TxID, 10), since.ID}
+	conds := []string{
+		"(c.xid, c.id) > ($1::text::xid8, $2)",
+		"c.xid < pg_snapshot_xmin(pg_current_snapshot())",
+		changeTenantScope(ctx, &args),
+	}
+	if collection != "" {
+		args = append(args, collectionName(ctx, collection))
+		conds = append(conds, fmt.Sprintf("c.collection = $%d", len(args)))
+	}
+	args = append(args, limit)
+	rows, err := p.pool.Query(ctx, fmt.Sprintf(
+		"SELECT c.xid::text, c.id, c.op, c.document_id, c.collection, c.source, c.changed_at FROM document_changes c WHERE %s ORDER BY c.xid, c.id LIMIT $%d",
+		strings.Join(conds, " AND "), len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading document changes: %w", err)
+	}
+	defer rows.Close()
+	var changes []DocumentChange
+	for rows.Next() {
+		var ch DocumentChange
+		var tx string
+		if err := rows.Scan(&tx, &ch.Cursor.ID, &ch.Op, &ch.DocumentID, &ch.Collection, &ch.Source, &ch.At); err != nil {
+			return nil, err
+		}
+		if ch.Cursor.TxID, err = strconv.ParseUint(tx, 10, 64); err != nil {
+			return nil, fmt.Errorf("error reading document changes: %w", err)
+		}
+		ch.Collection = publicName(ctx, ch.Collection)
+		changes = append(changes, ch)
+	}
+	return changes, rows.Err()
+}
+
+// changeTenantScope restricts the changes to the tenant of ctx by the prefix of their stored
+// collection name, unlike tenantScope: the collection of a delete may be dropped since
+func changeTenantScope(ctx context.Context, args *[]any) string {
+	t := TenantFrom(ctx)
+	if t == "" {
+		return fmt.Sprintf("strpos(c.collection, '%s') = 0", tenantSeparator)
+	}
+	*args = append(*args, t+tenantSeparator)
+	return fmt.Sprintf("starts_with(c.collection, $%d)", len(*args))
+}
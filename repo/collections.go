@@ -0,0 +1,264 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultCollection holds chunks stored without an explicit collection
+const DefaultCollection = "default"
+
+// Collection is a named corpus with its own embedding model. All vectors of a collection share
+// its dimension; each collection has its own ANN index.
+type Collection struct {
+	Name      string
+	Model     string
+	Dimension int
+}
+
+// UnknownCollectionError reports a collection that was never registered
+type UnknownCollectionError struct {
+	Name string
+}
+
+func (e *UnknownCollectionError) Error() string {
+	return fmt.Sprintf("unknown collection %q", e.Name)
+}
+
+// collectionNameRe restricts names to what can be inlined in SQL and index names
+var collectionNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,39}$`)
+
+// ValidCollectionName reports whether name can be used as a collection name. Double underscores
+// are kept for the tenant prefix of stored names.
+func ValidCollectionName(name string) bool {
+	return collectionNameRe.MatchString(name) && !strings.Contains(name, tenantSeparator)
+}
+
+// collectionIndex is the name of the ANN index of a collection, shortened with a hash when a
+// tenant prefix would exceed the identifier length of Postgres
+func collectionIndex(name string) string {
+	if len(name) > 39 {
+		sum := sha256.Sum256([]byte(name))
+		name = name[:24] + "_" + hex.EncodeToString(sum[:])[:14]
+	}
+	return "documents_embedding_" + name + "_idx"
+}
+
+// collectionIndexSQL creates the ANN index of a collection, a partial expression index since the
+// embedding column itself has no fixed dimension
+func collectionIndexSQL(name string, dimension int) string {
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON documents USING ivfflat ((embedding::vector(%d)) vector_cosine_ops) "+
+		"WITH (lists = 100) WHERE collection = '%s'", collectionIndex(name), dimension, name)
+}
+
+// EnsureCollection registers c for the tenant of ctx, creating its vector index, or checks that
+// an existing collection with the same name uses the same model and dimension. A collection
+// migrated from the single-model schema has no recorded model and adopts c.Model. An existing
+// collection with another model or dimension stays registered as stored, and a
+// *ModelMismatchError says so.
+func (p *PostgresRepository) EnsureCollection(ctx context.Context, c Collection) error {
+	if !ValidCollectionName(c.Name) {
+		return fmt.Errorf("invalid collection name %q: use lowercase letters, digits and single underscores", c.Name)
+	}
+	if c.Dimension <= 0 {
+		return fmt.Errorf("collection %q: dimension must be positive", c.Name)
+	}
+	stored, tenant := collectionName(ctx, c.Name), TenantFrom(ctx)
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "INSERT INTO collections (name, model, dimension, tenant) VALUES ($1, $2, $3, $4) ON CONFLICT (name) DO NOTHING",
+		stored, c.Model, c.Dimension, tenant)
+	if err != nil {
+		return fmt.Errorf("error registering collection: %w", err)
+	}
+	var model, owner string
+	var dimension int
+	err = conn.QueryRow(ctx, "SELECT model, dimension, tenant FROM collections WHERE name = $1", stored).Scan(&model, &dimension, &owner)
+	if err != nil {
+		return fmt.Errorf("error reading collection: %w", err)
+	}
+	if owner != tenant {
+		// only a name registered before tenants were scoped can get here
+		return fmt.Errorf("collection %q belongs to another tenant", c.Name)
+	}
+	var mismatch error
+	switch {
+	case model == "" && dimension == c.Dimension:
+		for _, q := range []string{
+			"UPDATE collections SET model = $2 WHERE name = $1",
+			"UPDATE documents SET embedding_model = $2 WHERE collection = $1 AND embedding_model = ''",
+		} {
+			if _, err := conn.Exec(ctx, q, stored, c.Model); err != nil {
+				return fmt.Errorf("error recording collection model: %w", err)
+			}
+		}
+		model = c.Model
+	case model != c.Model || dimension != c.Dimension:
+		// the stored vectors keep serving, with their model, until the collection is re-embedded
+		mismatch = &ModelMismatchError{Collection: c.Name, StoredModel: model, StoredDimension: dimension, Model: c.Model, Dimension: c.Dimension}
+	}
+
+	for _, q := range []string{"SET statement_timeout = 0", collectionIndexSQL(stored, dimension), "RESET statement_timeout"} {
+		if _, err := conn.Exec(ctx, q); err != nil {
+			return fmt.Errorf("error creating index of collection %q: %w", c.Name, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.collections[stored] = dimension
+	p.mu.Unlock()
+	return mismatch
+}
+
+// Collections lists the collections of the tenant of ctx by name
+func (p *PostgresRepository) Collections(ctx context.Context) ([]Collection, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, "SELECT name, model, dimension FROM collections WHERE tenant = $1 ORDER BY name", TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error listing collections: %w", err)
+	}
+	defer rows.Close()
+	var out []Collection
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.Name, &c.Model, &c.Dimension); err != nil {
+			return nil, err
+		}
+		c.Name = publicName(ctx, c.Name)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// CollectionStats is a registered collection with the size of its current corpus
+type CollectionStats struct {
+	Collection
+	Documents int64
+	Chunks    int64
+}
+
+// CollectionStats lists the collections of the tenant of ctx by name with their current documents and chunks
+func (p *PostgresRepository) CollectionStats(ctx context.Context) ([]CollectionStats, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, "SELECT c.name, c.model, c.dimension, "+
+		"(SELECT count(*) FROM indexed_documents d WHERE d.collection = c.name AND d.state = 'current'), "+
+		"(SELECT count(*) FROM documents d WHERE d.collection = c.name AND d.state = 'current') "+
+		"FROM collections c WHERE c.tenant = $1 ORDER BY c.name", TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error listing collections: %w", err)
+	}
+	defer rows.Close()
+	var out []CollectionStats
+	for rows.Next() {
+		var c CollectionStats
+		if err := rows.Scan(&c.Name, &c.Model, &c.Dimension, &c.Documents, &c.Chunks); err != nil {
+			return nil, err
+		}
+		c.Name = publicName(ctx, c.Name)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// DropCollection deletes a collection of the tenant of ctx with everything stored for it (chunks,
+// documents, quarantine, source versions, logged queries) and its index, returning how many
+// chunks were deleted
+func (p *PostgresRepository) DropCollection(ctx context.Context, name string) (int64, error) {
+	if !ValidCollectionName(name) {
+		return 0, &UnknownCollectionError{Name: name}
+	}
+	stored := collectionName(ctx, name)
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Release()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "DELETE FROM collections WHERE name = $1 AND tenant = $2", stored, TenantFrom(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("error deleting collection: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, &UnknownCollectionError{Name: name}
+	}
+	chunks, err := tx.Exec(ctx, "DELETE FROM documents WHERE collection = $1", stored)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting collection chunks: %w", err)
+	}
+	for _, table := range []string{"indexed_documents", "quarantine", "source_versions", "query_log"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE collection = $1", stored); err != nil {
+			return 0, fmt.Errorf("error deleting collection from %s: %w", table, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, "DROP INDEX IF EXISTS "+collectionIndex(stored)); err != nil {
+		return 0, fmt.Errorf("error dropping index of collection %q: %w", name, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	p.mu.Lock()
+	delete(p.collections, stored)
+	p.mu.Unlock()
+	return chunks.RowsAffected(), nil
+}
+
+// loadCollections reads the dimension of every registered collection
+func (p *PostgresRepository) loadCollections(ctx context.Context) error {
+	rows, err := p.pool.Query(ctx, "SELECT name, dimension FROM collections")
+	if err != nil {
+		return fmt.Errorf("error loading collections: %w", err)
+	}
+	defer rows.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for rows.Next() {
+		var name string
+		var dim int
+		if err := rows.Scan(&name, &dim); err != nil {
+			return err
+		}
+		p.collections[name] = dim
+	}
+	return rows.Err()
+}
+
+// collectionDimension returns the dimension of a collection of the tenant of ctx
+func (p *PostgresRepository) collectionDimension(ctx context.Context, name string) (int, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	dim, ok := p.collections[collectionName(ctx, name)]
+	if !ok {
+		if name == "" {
+			name = DefaultCollection
+		}
+		return 0, &UnknownCollectionError{Name: name}
+	}
+	return dim, nil
+}
+
+// collectionNames returns the registered collection names, sorted
+func (p *PostgresRepository) collectionNames() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, 0, len(p.collections))
+	for name := range p.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
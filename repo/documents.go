@@ -0,0 +1,447 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	github_com_pgv "github.com/pgvector/pgvector-go"
+)
+
+// IndexedDocument is a document as it was indexed, the unit its chunks are listed and deleted by.
+// Chunks refer to it through Chunk.DocumentID.
+type IndexedDocument struct {
+	ID          int64
+	Collection  string
+	Source      string
+	Session     string
+	DocType     string
+	ContentHash string
+	// Chunks counts the stored chunks of the document; quarantined ones are not included
+	Chunks    int
+	CreatedAt time.Time
+	// Version numbers the documents indexed for a source, from 1
+	Version int
+	// State is StateCurrent, StateStaged or StateRetired; empty means current for CreateDocument
+	State string
+	// RetiredAt is when a newer version replaced the document, nil while it is not retired
+	RetiredAt *time.Time
+	// ExpiresAt is when DeleteExpired deletes the document with its chunks, nil to keep it
+	ExpiresAt *time.Time
+}
+
+// States of a document version and its chunks: only current ones are searched by default,
+// retired ones only when asked for (SearchFilter.AllVersions) and staged ones never, until
+// PublishDocument makes them current
+const (
+	StateCurrent = "current"
+	StateStaged  = "staged"
+	StateRetired = "retired"
+)
+
+// ListDocumentsOptions selects and pages the documents of ListDocuments
+type ListDocumentsOptions struct {
+	// Collection restricts the list to a collection; empty lists every collection
+	Collection string
+	Limit      int
+	Offset     int
+}
+
+const indexedDocumentColumns = "d.id, d.collection, d.source, d.session, d.doc_type, d.content_hash, d.created_at, d.version, d.state, d.retired_at, d.expires_at, " +
+	"(SELECT count(*) FROM documents c WHERE c.document_id = d.id)"
+
+const selectIndexedDocumentSQL = "SELECT " + indexedDocumentColumns + " FROM indexed_documents d"
+
+// CreateDocument records a document about to be indexed in a collection of the tenant of ctx,
+// returning the ID its chunks refer to. Chunks stored for it take its state.
+func (p *PostgresRepository) CreateDocument(ctx context.Context, doc IndexedDocument) (int64, error) {
+	if _, err := p.collectionDimension(ctx, doc.Collection); err != nil {
+		return 0, err
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	version, state := max(doc.Version, 1), doc.State
+	if state == "" {
+		state = StateCurrent
+	}
+	var id int64
+	err := p.pool.QueryRow(ctx,
+		"INSERT INTO indexed_documents (collection, source, session, doc_type, content_hash, version, state, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		collectionName(ctx, doc.Collection), doc.Source, doc.Session, doc.DocType, doc.ContentHash, version, state, doc.ExpiresAt).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating document: %w", err)
+	}
+	return id, nil
+}
+
+// ListDocuments lists documents of the tenant of ctx, newest first, with the total number of
+// matching documents
+func (p *PostgresRepository) ListDocuments(ctx context.Context, opts ListDocumentsOptions) ([]IndexedDocument, int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	collection := ""
+	if opts.Collection != "" {
+		collection = collectionName(ctx, opts.Collection)
+	}
+	args := []any{collection}
+	where := " WHERE ($1 = '' OR d.collection = $1) AND " + tenantScope(ctx, "d.collection", &args)
+	var total int64
+	if err := p.pool.QueryRow(ctx, "SELECT count(*) FROM indexed_documents d"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting documents: %w", err)
+	}
+	rows, err := p.pool.Query(ctx, selectIndexedDocumentSQL+where+" ORDER BY d.id DESC LIMIT $3 OFFSET $4",
+		append(args, opts.Limit, opts.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing documents: %w", err)
+	}
+	defer rows.Close()
+	var docs []IndexedDocument
+	for rows.Next() {
+		doc, err := scanIndexedDocument(ctx, rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, total, rows.Err()
+}
+
+// GetDocument returns a document, reporting false if the tenant of ctx has none with that ID
+func (p *PostgresRepository) GetDocument(ctx context.Context, id int64) (IndexedDocument, bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{id}
+	doc, err := scanIndexedDocument(ctx, p.pool.QueryRow(ctx, selectIndexedDocumentSQL+" WHERE d.id = $1 AND "+tenantScope(ctx, "d.collection", &args), args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return IndexedDocument{}, false, nil
+	}
+	if err != nil {
+		return IndexedDocument{}, false, fmt.Errorf("error reading document: %w", err)
+	}
+	return doc, true, nil
+}
+
+// DocumentChunks returns the chunks of a document in the order they were stored, without embeddings
+func (p *PostgresRepository) DocumentChunks(ctx context.Context, id int64) ([]Document, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{id}
+	rows, err := p.pool.Query(ctx, "SELECT id, content, source, page, section FROM documents WHERE document_id = $1 AND "+
+		tenantScope(ctx, "collection", &args)+" ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing document chunks: %w", err)
+	}
+	defer rows.Close()
+	var chunks []Document
+	for rows.Next() {
+		var c Document
+		if err := rows.Scan(&c.ID, &c.Content, &c.Source, &c.Page, &c.Section); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// DeleteDocument removes a document with its chunks, stored or quarantined, returning how many
+// stored chunks were deleted and false if the tenant of ctx has no document with that ID
+func (p *PostgresRepository) DeleteDocument(ctx context.Context, id int64) (int64, bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback(ctx)
+	args := []any{id}
+	scope := tenantScope(ctx, "collection", &args)
+	chunks, err := tx.Exec(ctx, "DELETE FROM documents WHERE document_id = $1 AND "+scope, args...)
+	if err != nil {
+		return 0, false, fmt.Errorf("error deleting document chunks: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM quarantine WHERE document_id = $1 AND "+scope, args...); err != nil {
+		return 0, false, fmt.Errorf("error deleting document chunks: %w", err)
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM indexed_documents WHERE id = $1 AND "+scope, args...)
+	if err != nil {
+		return 0, false, fmt.Errorf("error deleting document: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, false, nil
+	}
+	return chunks.RowsAffected(), true, tx.Commit(ctx)
+}
+
+// LatestVersion returns the highest version indexed for a source of collection in session
+// ("" for the shared corpus), whatever its state, or 0 if there is none
+func (p *PostgresRepository) LatestVersion(ctx context.Context, collection, session, source string) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var version int
+	err := p.pool.QueryRow(ctx,
+		"SELECT coalesce(max(version), 0) FROM indexed_documents WHERE collection = $1 AND session = $2 AND source = $3",
+		collectionName(ctx, collection), session, source).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("error reading document version: %w", err)
+	}
+	return version, nil
+}
+
+// PublishDocument makes a staged document of the tenant of ctx the current version of its source in one transaction,
+// retiring the versions that were current and their chunks, and deleting the retired versions
+// beyond the keep most recent ones. It returns how many versions it retired.
+func (p *PostgresRepository) PublishDocument(ctx context.Context, id int64, keep int) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	var collection, session, source string
+	args := []any{id}
+	err = tx.QueryRow(ctx, "SELECT collection, session, source FROM indexed_documents WHERE id = $1 AND "+
+		tenantScope(ctx, "collection", &args)+" FOR UPDATE", args...).Scan(&collection, &session, &source)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("document %d does not exist", id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading document: %w", err)
+	}
+	// the other versions of the source, locked so concurrent publications of it are serialized
+	rows, err := tx.Query(ctx, "SELECT id FROM indexed_documents WHERE collection = $1 AND session = $2 AND source = $3 "+
+		"AND state = 'current' AND id <> $4 FOR UPDATE", collection, session, source, id)
+	if err != nil {
+		return 0, fmt.Errorf("error reading document versions: %w", err)
+	}
+	retired, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, fmt.Errorf("error reading document versions: %w", err)
+	}
+	steps := []struct {
+		sql  string
+		args []any
+	}{
+		{"UPDATE indexed_documents SET state = 'retired', retired_at = now() WHERE id = ANY($1)", []any{retired}},
+		{"UPDATE documents SET state = 'retired' WHERE document_id = ANY($1)", []any{retired}},
+		// quarantined chunks of a retired version are not worth retrying
+		{"DELETE FROM quarantine WHERE document_id = ANY($1)", []any{retired}},
+		{"UPDATE indexed_documents SET state = 'current', retired_at = NULL WHERE id = $1", []any{id}},
+		{"UPDATE documents SET state = 'current' WHERE document_id = $1", []any{id}},
+		// chunks go with their document (ON DELETE CASCADE)
+		{"DELETE FROM indexed_documents WHERE id IN (SELECT id FROM indexed_documents WHERE collection = $1 AND session = $2 " +
+			"AND source = $3 AND state = 'retired' ORDER BY version DESC, id DESC OFFSET $4)", []any{collection, session, source, max(keep, 0)}},
+	}
+	for _, st := range steps {
+		if _, err := tx.Exec(ctx, st.sql, st.args...); err != nil {
+			return 0, fmt.Errorf("error publishing document %d: %w", id, err)
+		}
+	}
+	return len(retired), tx.Commit(ctx)
+}
+
+// SetDocumentEmbedding stores the document-level embedding of a document of the tenant of ctx,
+// searched by SimilarDocuments
+func (p *PostgresRepository) SetDocumentEmbedding(ctx context.Context, id int64, embedding []float32) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var collection string
+	args := []any{id}
+	err := p.pool.QueryRow(ctx, "SELECT collection FROM indexed_documents WHERE id = $1 AND "+tenantScope(ctx, "collection", &args), args...).Scan(&collection)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("document %d does not exist", id)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading document: %w", err)
+	}
+	if _, err := p.checkDimension(ctx, publicName(ctx, collection), embedding); err != nil {
+		return err
+	}
+	if _, err := p.pool.Exec(ctx, "UPDATE indexed_documents SET embedding = $2 WHERE id = $1", id, github_com_pgv.NewVector(embedding)); err != nil {
+		return fmt.Errorf("error storing document embedding: %w", err)
+	}
+	return nil
+}
+
+// DocumentEmbedding returns the document-level embedding of a document of the tenant of ctx, nil
+// if it has none
+func (p *PostgresRepository) DocumentEmbedding(ctx context.Context, id int64) ([]float32, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var emb *github_com_pgv.Vector
+	args := []any{id}
+	err := p.pool.QueryRow(ctx, "SELECT embedding FROM indexed_documents WHERE id = $1 AND "+tenantScope(ctx, "collection", &args), args...).Scan(&emb)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading document embedding: %w", err)
+	}
+	if emb == nil {
+		return nil, nil
+	}
+	return emb.Slice(), nil
+}
+
+// SimilarDocumentsOptions selects the documents searched by SimilarDocuments
+type SimilarDocumentsOptions struct {
+	// Collection is the collection searched; empty means DefaultCollection
+	Collection string
+	// Session adds the documents bound to this session to the shared corpus
+	Session string
+	// ExcludeSource leaves out the documents of a source, e.g. the one related documents are looked for
+	ExcludeSource string
+}
+
+// ScoredDocument is a document with its cosine similarity to a query
+type ScoredDocument struct {
+	IndexedDocument
+	Score float64
+}
+
+// SimilarDocuments returns the topK current documents whose document-level embedding is the most
+// similar to embedding, best first. Documents without one are never returned.
+func (p *PostgresRepository) SimilarDocuments(ctx context.Context, embedding []float32, topK int, opts SimilarDocumentsOptions) ([]ScoredDocument, error) {
+	if _, err := p.checkDimension(ctx, opts.Collection, embedding); err != nil {
+		return nil, err
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, "SELECT "+indexedDocumentColumns+", 1 - (d.embedding <=> $1) FROM indexed_documents d"+
+		" WHERE d.collection = $2 AND d.session IN ('', $3) AND d.state = 'current' AND d.embedding IS NOT NULL AND d.source <> $4"+
+		" ORDER BY d.embedding <=> $1 LIMIT $5",
+		github_com_pgv.NewVector(embedding), collectionName(ctx, opts.Collection), opts.Session, opts.ExcludeSource, topK)
+	if err != nil {
+		return nil, fmt.Errorf("error searching similar documents: %w", err)
+	}
+	defer rows.Close()
+	var out []ScoredDocument
+	for rows.Next() {
+		var d ScoredDocument
+		err := rows.Scan(&d.ID, &d.Collection, &d.Source, &d.Session, &d.DocType, &d.ContentHash, &d.CreatedAt,
+			&d.Version, &d.State, &d.RetiredAt, &d.ExpiresAt, &d.Chunks, &d.Score)
+		if err != nil {
+			return nil, err
+		}
+		d.Collection = publicName(ctx, d.Collection)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// DeleteExpired deletes the documents whose ExpiresAt has passed with their chunks, stored or
+// quarantined, returning how many chunks were deleted. It is maintenance over every tenant.
+func (p *PostgresRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	rows, err := tx.Query(ctx, "SELECT id FROM indexed_documents WHERE expires_at <= now() FOR UPDATE SKIP LOCKED")
+	if err != nil {
+		return 0, fmt.Errorf("error listing expired documents: %w", err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	chunks, err := tx.Exec(ctx, "DELETE FROM documents WHERE document_id = ANY($1)", ids)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired chunks: %w", err)
+	}
+	for _, q := range []string{
+		"DELETE FROM quarantine WHERE document_id = ANY($1)",
+		"DELETE FROM indexed_documents WHERE id = ANY($1)",
+	} {
+		if _, err := tx.Exec(ctx, q, ids); err != nil {
+			return 0, fmt.Errorf("error deleting expired documents: %w", err)
+		}
+	}
+	return chunks.RowsAffected(), tx.Commit(ctx)
+}
+
+// ChunkRef locates a chunk by its document and position
+type ChunkRef struct {
+	DocumentID int64
+	Position   int
+}
+
+// ChunkNeighbors returns the chunks of the tenant of ctx at most window positions away from each
+// ref, the refs themselves included, ordered by document and position, without embeddings
+func (p *PostgresRepository) ChunkNeighbors(ctx context.Context, refs []ChunkRef, window int) ([]Document, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	docIDs := make([]int64, len(refs))
+	positions := make([]int, len(refs))
+	for i, r := range refs {
+		docIDs[i], positions[i] = r.DocumentID, r.Position
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{docIDs, positions, window}
+	rows, err := p.pool.Query(ctx,
+		"SELECT DISTINCT d.id, d.content, d.source, d.page, d.section, d.document_id, d.chunk_position FROM documents d "+
+			"JOIN unnest($1::bigint[], $2::int[]) AS r(document_id, position) ON d.document_id = r.document_id "+
+			"AND d.chunk_position BETWEEN r.position - $3 AND r.position + $3 "+
+			"WHERE "+tenantScope(ctx, "d.collection", &args)+" ORDER BY d.document_id, d.chunk_position",
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading neighboring chunks: %w", err)
+	}
+	defer rows.Close()
+	var chunks []Document
+	for rows.Next() {
+		var c Document
+		if err := rows.Scan(&c.ID, &c.Content, &c.Source, &c.Page, &c.Section, &c.DocumentID, &c.Position); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, rows.Err()
+}
+
+// ChunksByID returns the chunks of the tenant of ctx among ids, with their content and source
+// only, in no particular order; chunks deleted since are left out
+func (p *PostgresRepository) ChunksByID(ctx context.Context, ids []int) ([]Document, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{ids}
+	rows, err := p.pool.Query(ctx, "SELECT id, content, source FROM documents WHERE id = ANY($1) AND "+tenantScope(ctx, "collection", &args), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunks: %w", err)
+	}
+	return collectDocuments(rows, 0, false)
+}
+
+// documentID is the document_id of a chunk, NULL when unknown
+func documentID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// chunkPosition is the chunk_position of a chunk, NULL when unknown
+func chunkPosition(position int) *int {
+	if position == 0 {
+		return nil
+	}
+	return &position
+}
+
+// scanIndexedDocument scans a row of indexedDocumentColumns, naming the collection as the tenant
+// of ctx knows it
+func scanIndexedDocument(ctx context.Context, row pgx.Row) (IndexedDocument, error) {
+	var d IndexedDocument
+	err := row.Scan(&d.ID, &d.Collection, &d.Source, &d.Session, &d.DocType, &d.ContentHash, &d.CreatedAt, &d.Version, &d.State, &d.RetiredAt, &d.ExpiresAt, &d.Chunks)
+	d.Collection = publicName(ctx, d.Collection)
+	return d, err
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EvalRun is the outcome of one run of the golden-question suite
+type EvalRun struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Questions int       `json:"questions"`
+	K         int       `json:"k"`
+	// HitRate is the share of questions with an expected source in the top K
+	HitRate float64 `json:"hit_rate"`
+	// MRR is the mean reciprocal rank of the first expected source (0 when missed)
+	MRR float64 `json:"mrr"`
+	// Recall is the share of expected sources found in the top K, over all questions
+	Recall float64 `json:"recall"`
+	// EmbeddingModel and CorpusVersion tell which model and corpus state the run measured
+	EmbeddingModel string `json:"embedding_model"`
+	CorpusVersion  int64  `json:"corpus_version"`
+	// Missed lists the questions none of whose expected sources were retrieved
+	Missed []string `json:"missed,omitempty"`
+}
+
+// SaveEvalRun stores a run of the golden-question suite for the tenant of ctx
+func (p *PostgresRepository) SaveEvalRun(ctx context.Context, run EvalRun) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	missed, err := json.Marshal(run.Missed)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx,
+		"INSERT INTO eval_runs (questions, k, hit_rate, mrr, recall, embedding_model, corpus_version, missed, tenant) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		run.Questions, run.K, run.HitRate, run.MRR, run.Recall, run.EmbeddingModel, run.CorpusVersion, missed, TenantFrom(ctx))
+	if err != nil {
+		return fmt.Errorf("error saving eval run: %w", err)
+	}
+	return nil
+}
+
+// EvalRuns returns the latest limit runs of the tenant of ctx, oldest first
+func (p *PostgresRepository) EvalRuns(ctx context.Context, limit int) ([]EvalRun, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, created_at, questions, k, hit_rate, mrr, recall, embedding_model, corpus_version, missed FROM "+
+			"(SELECT * FROM eval_runs WHERE tenant = $2 ORDER BY created_at DESC LIMIT $1) latest ORDER BY created_at",
+		limit, TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading eval runs: %w", err)
+	}
+	defer rows.Close()
+	var runs []EvalRun
+	for rows.Next() {
+		var r EvalRun
+		var missed []byte
+		if err := rows.Scan(&r.ID, &r.CreatedAt, &r.Questions, &r.K, &r.HitRate, &r.MRR, &r.Recall,
+			&r.EmbeddingModel, &r.CorpusVersion, &missed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(missed, &r.Missed); err != nil {
+			return nil, fmt.Errorf("error decoding missed questions of eval run %d: %w", r.ID, err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,184 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	github_com_pgv "github.com/pgvector/pgvector-go"
+)
+
+// HistoryEntry is a question a user asked and the answer they got
+type HistoryEntry struct {
+	ID       int64
+	User     string
+	Question string
+	Answer   string
+	// Session is the conversation the question was asked in, empty outside any
+	Session string
+	// Model is the embedding model of Embedding; searches only compare entries of one model
+	Model     string
+	Embedding []float32
+	CreatedAt time.Time
+	// Rating is the user's feedback on the answer: 1 thumbs-up, -1 thumbs-down, 0 none
+	Rating int
+	// Distance is the cosine distance to the search query, set by SearchHistory
+	Distance float64
+	// ChunkIDs are the chunks retrieved for the answer, in the order of its passages; Clicked
+	// the passages (1-based) the user opened. Both are only read by FeedbackHistory.
+	ChunkIDs []int
+	Clicked  []int
+}
+
+// ValidUserID reports whether id can identify a user: like session ids, client-generated
+func ValidUserID(id string) bool { return sessionIDRe.MatchString(id) }
+
+// Users are scoped by tenant: every call only sees the history of the tenant of ctx
+
+// SaveAnswer stores an entry of a user's history, returning its id
+func (p *PostgresRepository) SaveAnswer(ctx context.Context, e HistoryEntry) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	chunkIDs := e.ChunkIDs
+	if chunkIDs == nil {
+		chunkIDs = []int{}
+	}
+	var id int64
+	err := p.pool.QueryRow(ctx,
+		"INSERT INTO qa_history (user_id, question, answer, model, embedding, tenant, chunk_ids, session) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		e.User, e.Question, e.Answer, e.Model, github_com_pgv.NewVector(e.Embedding), TenantFrom(ctx), chunkIDs, e.Session).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving answer: %w", err)
+	}
+	return id, nil
+}
+
+// SearchHistory returns the topK entries of a user closest to emb, among those embedded with model
+func (p *PostgresRepository) SearchHistory(ctx context.Context, user, model string, emb []float32, topK int) ([]HistoryEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		fmt.Sprintf("SELECT id, user_id, question, answer, model, created_at, rating, embedding::vector(%d) <=> $3 AS distance "+
+			"FROM qa_history WHERE tenant = $5 AND user_id = $1 AND model = $2 AND vector_dims(embedding) = %d ORDER BY distance LIMIT $4",
+			len(emb), len(emb)),
+		user, model, github_com_pgv.NewVector(emb), topK, TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error searching history: %w", err)
+	}
+	return collectHistory(rows, true)
+}
+
+// RecentHistory returns the latest entries of a user, most recent first
+func (p *PostgresRepository) RecentHistory(ctx context.Context, user string, limit int) ([]HistoryEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, user_id, question, answer, model, created_at, rating FROM qa_history WHERE tenant = $3 AND user_id = $1 ORDER BY created_at DESC LIMIT $2",
+		user, limit, TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	return collectHistory(rows, false)
+}
+
+// SessionHistory returns the limit latest questions and answers of a user in a conversation,
+// oldest first
+func (p *PostgresRepository) SessionHistory(ctx context.Context, user, session string, limit int) ([]HistoryEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, user_id, question, answer, model, created_at, rating FROM ("+
+			"SELECT * FROM qa_history WHERE tenant = $4 AND user_id = $1 AND session = $2 ORDER BY created_at DESC, id DESC LIMIT $3"+
+			") latest ORDER BY created_at, id",
+		user, session, limit, TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading conversation history: %w", err)
+	}
+	entries, err := collectHistory(rows, false)
+	if err != nil {
+		return nil, fmt.Errorf("error reading conversation history: %w", err)
+	}
+	for i := range entries {
+		entries[i].Session = session
+	}
+	return entries, nil
+}
+
+// RateAnswer sets the rating of a user's history entry and returns it; false if the user has no
+// entry with that id
+func (p *PostgresRepository) RateAnswer(ctx context.Context, user string, id int64, rating int) (HistoryEntry, bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"UPDATE qa_history SET rating = $3 WHERE tenant = $4 AND user_id = $1 AND id = $2 "+
+			"RETURNING id, user_id, question, answer, model, created_at, rating",
+		user, id, rating, TenantFrom(ctx))
+	if err != nil {
+		return HistoryEntry{}, false, fmt.Errorf("error rating answer: %w", err)
+	}
+	entries, err := collectHistory(rows, false)
+	if err != nil {
+		return HistoryEntry{}, false, fmt.Errorf("error rating answer: %w", err)
+	}
+	if len(entries) == 0 {
+		return HistoryEntry{}, false, nil
+	}
+	return entries[0], true, nil
+}
+
+// ClickPassages records that a user opened passages (1-based) of the answer of a history entry;
+// false if the user has no entry with that id. Passages the answer did not have are ignored.
+func (p *PostgresRepository) ClickPassages(ctx context.Context, user string, id int64, passages []int) (bool, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx,
+		"UPDATE qa_history SET clicked = ARRAY(SELECT DISTINCT c FROM unnest(clicked || $3::int[]) c WHERE c BETWEEN 1 AND cardinality(chunk_ids) ORDER BY c) "+
+			"WHERE tenant = $4 AND user_id = $1 AND id = $2",
+		user, id, passages, TenantFrom(ctx))
+	if err != nil {
+		return false, fmt.Errorf("error recording clicks: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// FeedbackHistory returns the entries of every user that were rated or clicked, with the
+// chunks retrieved for them, oldest first
+func (p *PostgresRepository) FeedbackHistory(ctx context.Context) ([]HistoryEntry, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, user_id, question, rating, chunk_ids, clicked FROM qa_history "+
+			"WHERE tenant = $1 AND cardinality(chunk_ids) > 0 AND (rating <> 0 OR cardinality(clicked) > 0) ORDER BY id",
+		TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error reading feedback: %w", err)
+	}
+	defer rows.Close()
+	var out []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		if err := rows.Scan(&e.ID, &e.User, &e.Question, &e.Rating, &e.ChunkIDs, &e.Clicked); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func collectHistory(rows pgx.Rows, withDistance bool) ([]HistoryEntry, error) {
+	defer rows.Close()
+	var out []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		targets := []any{&e.ID, &e.User, &e.Question, &e.Answer, &e.Model, &e.CreatedAt, &e.Rating}
+		if withDistance {
+			targets = append(targets, &e.Distance)
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
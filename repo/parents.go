@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// InsertParents stores the parent sections of a document of the tenant of ctx, in order,
+// returning their IDs for Chunk.ParentID. A parent is a larger section of the document that
+// small chunks are split from: the chunks are matched precisely, the parent gives the model
+// their context.
+func (p *PostgresRepository) InsertParents(ctx context.Context, documentID int64, contents []string) ([]int64, error) {
+	if len(contents) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{documentID, contents}
+	rows, err := p.pool.Query(ctx,
+		"INSERT INTO chunk_parents (document_id, content) SELECT $1, c FROM unnest($2::text[]) WITH ORDINALITY AS t(c, n) "+
+			"WHERE EXISTS (SELECT 1 FROM indexed_documents WHERE id = $1 AND "+tenantScope(ctx, "collection", &args)+") ORDER BY n RETURNING id",
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting parent chunks: %w", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error inserting parent chunks: %w", err)
+	}
+	if len(ids) != len(contents) {
+		return nil, fmt.Errorf("error inserting parent chunks: unknown document %d", documentID)
+	}
+	// IDs are drawn in insertion order, which RETURNING does not promise to keep
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// ParentChunks returns the content of the parents of the tenant of ctx among ids, by ID;
+// parents deleted since are left out
+func (p *PostgresRepository) ParentChunks(ctx context.Context, ids []int64) (map[int64]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{ids}
+	rows, err := p.pool.Query(ctx,
+		"SELECT p.id, p.content FROM chunk_parents p JOIN indexed_documents d ON d.id = p.document_id "+
+			"WHERE p.id = ANY($1) AND "+tenantScope(ctx, "d.collection", &args),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading parent chunks: %w", err)
+	}
+	defer rows.Close()
+	parents := make(map[int64]string, len(ids))
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, err
+		}
+		parents[id] = content
+	}
+	return parents, rows.Err()
+}
+
+// parentID is the parent_id of a chunk, NULL when it has none
+func parentID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,61 @@
+package repo_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	"github.com/Thaizir/go-local-RAG/repo/repotest"
+)
+
+// TestPostgres runs the conformance suite against the database of RAG_TEST_DATABASE_URL (a
+// postgres:// URL with pgvector available), each subtest in a schema of its own dropped
+// afterwards. It is skipped when the variable is unset; "make test-postgres" runs it against a
+// pgvector container, as CI does.
+func TestPostgres(t *testing.T) {
+	dbURL := os.Getenv("RAG_TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("RAG_TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, dbURL)
+	if err != nil {
+		t.Fatalf("connecting: %v", err)
+	}
+	t.Cleanup(admin.Close)
+	// once for every schema, which find it through the search path
+	if _, err := admin.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS vector"); err != nil {
+		t.Fatalf("creating the vector extension: %v", err)
+	}
+
+	n := 0
+	repotest.Run(t, func(t *testing.T) repo.DocumentRepository {
+		n++
+		schema := fmt.Sprintf("rag_test_%d_%d", os.Getpid(), n)
+		if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatalf("creating schema: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+				t.Errorf("dropping schema: %v", err)
+			}
+		})
+		u, err := url.Parse(dbURL)
+		if err != nil {
+			t.Fatalf("parsing RAG_TEST_DATABASE_URL: %v", err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema+",public")
+		u.RawQuery = q.Encode()
+		r, err := repo.NewPostgresRepository(ctx, u.String(), repo.PostgresOptions{})
+		if err != nil {
+			t.Fatalf("NewPostgresRepository: %v", err)
+		}
+		return r
+	})
+}
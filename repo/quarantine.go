@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QuarantinedChunk is a chunk whose embedding or storage failed, kept for a later retry
+type QuarantinedChunk struct {
+	ID int64
+	// Chunk holds everything but the embedding
+	Chunk         Chunk
+	Error         string
+	Attempts      int
+	CreatedAt     time.Time
+	LastAttemptAt time.Time
+}
+
+// Quarantine stores a chunk that could not be indexed, with the error that stopped it
+func (p *PostgresRepository) Quarantine(ctx context.Context, chunk Chunk, cause error) error {
+	entities, err := json.Marshal(chunk.Entities)
+	if err != nil {
+		return fmt.Errorf("error encoding entities: %w", err)
+	}
+	metadata, err := json.Marshal(chunk.Metadata)
+	if err != nil {
+		return fmt.Errorf("error encoding metadata: %w", err)
+	}
+	var docDate *time.Time
+	if !chunk.DocDate.IsZero() {
+		docDate = &chunk.DocDate
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err = p.pool.Exec(ctx,
+		"INSERT INTO quarantine (collection, source, content, entities, doc_date, page, section, metadata, doc_type, session, content_hash, document_id, chunk_position, parent_id, error) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)",
+		collectionName(ctx, chunk.Collection), chunk.Source, chunk.Content, entities, docDate, chunk.Page, chunk.Section, metadata, chunk.DocType, chunk.Session, chunk.ContentHash, documentID(chunk.DocumentID), chunkPosition(chunk.Position), parentID(chunk.ParentID), cause.Error())
+	if err != nil {
+		return fmt.Errorf("error quarantining chunk: %w", err)
+	}
+	return nil
+}
+
+// QuarantinedChunks lists the quarantined chunks of the tenant of ctx, oldest first; empty ids
+// lists them all
+func (p *PostgresRepository) QuarantinedChunks(ctx context.Context, ids []int64) ([]QuarantinedChunk, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{ids}
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, collection, source, content, entities, doc_date, page, section, metadata, doc_type, session, content_hash, coalesce(document_id, 0), coalesce(chunk_position, 0), coalesce(parent_id, 0), error, attempts, created_at, last_attempt_at "+
+			"FROM quarantine WHERE (cardinality($1::bigint[]) = 0 OR id = ANY($1)) AND "+tenantScope(ctx, "collection", &args)+" ORDER BY id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing quarantine: %w", err)
+	}
+	defer rows.Close()
+	var out []QuarantinedChunk
+	for rows.Next() {
+		var q QuarantinedChunk
+		var docDate *time.Time
+		if err := rows.Scan(&q.ID, &q.Chunk.Collection, &q.Chunk.Source, &q.Chunk.Content, &q.Chunk.Entities, &docDate,
+			&q.Chunk.Page, &q.Chunk.Section, &q.Chunk.Metadata, &q.Chunk.DocType, &q.Chunk.Session, &q.Chunk.ContentHash, &q.Chunk.DocumentID, &q.Chunk.Position, &q.Chunk.ParentID, &q.Error, &q.Attempts, &q.CreatedAt, &q.LastAttemptAt); err != nil {
+			return nil, err
+		}
+		if docDate != nil {
+			q.Chunk.DocDate = *docDate
+		}
+		q.Chunk.Collection = publicName(ctx, q.Chunk.Collection)
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// ResolveQuarantined removes a chunk of the tenant of ctx from quarantine once it has been indexed
+func (p *PostgresRepository) ResolveQuarantined(ctx context.Context, id int64) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{id}
+	if _, err := p.pool.Exec(ctx, "DELETE FROM quarantine WHERE id = $1 AND "+tenantScope(ctx, "collection", &args), args...); err != nil {
+		return fmt.Errorf("error resolving quarantined chunk: %w", err)
+	}
+	return nil
+}
+
+// FailQuarantined records another failed attempt at indexing a quarantined chunk of the tenant of ctx
+func (p *PostgresRepository) FailQuarantined(ctx context.Context, id int64, cause error) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{id, cause.Error()}
+	_, err := p.pool.Exec(ctx,
+		"UPDATE quarantine SET error = $2, attempts = attempts + 1, last_attempt_at = now() WHERE id = $1 AND "+tenantScope(ctx, "collection", &args), args...)
+	if err != nil {
+		return fmt.Errorf("error updating quarantined chunk: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LoggedQuery is a question of the query log
+type LoggedQuery struct {
+	Collection string
+	Query      string
+	CreatedAt  time.Time
+}
+
+// LogQuery records a question asked against a collection, for replay by evaluation tools
+func (p *PostgresRepository) LogQuery(ctx context.Context, collection, query string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err := p.pool.Exec(ctx, "INSERT INTO query_log (collection, query) VALUES ($1, $2)", collectionName(ctx, collection), query)
+	if err != nil {
+		return fmt.Errorf("error logging query: %w", err)
+	}
+	return nil
+}
+
+// PruneQueryLog deletes the questions logged before cutoff, returning how many. It is maintenance
+// over every tenant.
+func (p *PostgresRepository) PruneQueryLog(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, "DELETE FROM query_log WHERE created_at < $1", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning query log: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// LoggedQueries returns up to limit distinct logged questions of a collection, most recent first
+func (p *PostgresRepository) LoggedQueries(ctx context.Context, collection string, limit int) ([]string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT query FROM query_log WHERE collection = $1 GROUP BY query ORDER BY max(created_at) DESC LIMIT $2",
+		collectionName(ctx, collection), limit)
+	if err != nil {
+		return nil, fmt.Errorf("error reading query log: %w", err)
+	}
+	defer rows.Close()
+	var queries []string
+	for rows.Next() {
+		var q string
+		if err := rows.Scan(&q); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// RecentQueries returns the limit latest logged questions of every collection of the tenant of
+// ctx, newest first
+func (p *PostgresRepository) RecentQueries(ctx context.Context, limit int) ([]LoggedQuery, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{limit}
+	rows, err := p.pool.Query(ctx,
+		"SELECT collection, query, created_at FROM query_log WHERE "+tenantScope(ctx, "collection", &args)+" ORDER BY created_at DESC, id DESC LIMIT $1",
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading query log: %w", err)
+	}
+	defer rows.Close()
+	var queries []LoggedQuery
+	for rows.Next() {
+		var q LoggedQuery
+		if err := rows.Scan(&q.Collection, &q.Query, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		q.Collection = publicName(ctx, q.Collection)
+		queries = append(queries, q)
+	}
+	return queries, rows.Err()
+}
+
+// SampleChunks returns up to n random chunks of a collection with their embeddings
+func (p *PostgresRepository) SampleChunks(ctx context.Context, collection string, n int) ([]Document, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	fields := FieldEmbedding | FieldSection | FieldPage
+	rows, err := p.pool.Query(ctx, selectColumns(fields)+" FROM documents WHERE collection = $1 ORDER BY random() LIMIT $2",
+		collectionName(ctx, collection), n)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling chunks: %w", err)
+	}
+	return collectDocuments(rows, fields, false)
+}
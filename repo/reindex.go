@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	github_com_pgv "github.com/pgvector/pgvector-go"
+)
+
+// ModelMismatchError reports a collection configured with another embedding model or dimension
+// than its stored vectors were made with. The collection keeps serving with the stored model
+// until it is re-embedded (see SwapEmbeddings).
+type ModelMismatchError struct {
+	Collection      string
+	StoredModel     string
+	StoredDimension int
+	Model           string
+	Dimension       int
+}
+
+func (e *ModelMismatchError) Error() string {
+	return fmt.Sprintf("collection %q was embedded with %q (%d dimensions), not %q (%d dimensions); "+
+		"vectors of different models are not comparable, re-embed it or use another collection for the new model",
+		e.Collection, e.StoredModel, e.StoredDimension, e.Model, e.Dimension)
+}
+
+// ReindexChunk is a chunk waiting to be re-embedded
+type ReindexChunk struct {
+	ID      int
+	Content string
+}
+
+// staleChunkSQL matches the chunks of collection $1 whose vectors do not come from model $2 with
+// dimension $3
+const staleChunkSQL = "collection = $1 AND (embedding_model <> $2 OR vector_dims(embedding) <> $3)"
+
+// CountStaleChunks counts the chunks of collection c embedded with another model or dimension
+func (p *PostgresRepository) CountStaleChunks(ctx context.Context, c Collection) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var n int64
+	err := p.pool.QueryRow(ctx, "SELECT count(*) FROM documents WHERE "+staleChunkSQL,
+		collectionName(ctx, c.Name), c.Model, c.Dimension).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting stale chunks: %w", err)
+	}
+	return n, nil
+}
+
+// PendingReindex returns up to limit stale chunks of collection c (see CountStaleChunks) without
+// a pending embedding from c.Model, in id order
+func (p *PostgresRepository) PendingReindex(ctx context.Context, c Collection, limit int) ([]ReindexChunk, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx,
+		"SELECT id, content FROM documents WHERE "+staleChunkSQL+" AND next_model <> $2 ORDER BY id LIMIT $4",
+		collectionName(ctx, c.Name), c.Model, c.Dimension, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing chunks to re-embed: %w", err)
+	}
+	defer rows.Close()
+	var out []ReindexChunk
+	for rows.Next() {
+		var rc ReindexChunk
+		if err := rows.Scan(&rc.ID, &rc.Content); err != nil {
+			return nil, err
+		}
+		out = append(out, rc)
+	}
+	return out, rows.Err()
+}
+
+// SetPendingEmbeddings stores the embeddings of chunks of the tenant of ctx by model next to
+// their current ones, which keep serving searches until SwapEmbeddings
+func (p *PostgresRepository) SetPendingEmbeddings(ctx context.Context, model string, ids []int, embeddings [][]float32) error {
+	if len(ids) != len(embeddings) {
+		return fmt.Errorf("%d embeddings for %d chunks", len(embeddings), len(ids))
+	}
+	batch := &pgx.Batch{}
+	for i, id := range ids {
+		args := []any{github_com_pgv.NewVector(embeddings[i]), model, id}
+		batch.Queue("UPDATE documents SET next_embedding = $1, next_model = $2 WHERE id = $3 AND "+tenantScope(ctx, "collection", &args), args...)
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error storing re-embedded chunks: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// SwapEmbeddings makes the pending embeddings of collection c of the tenant of ctx the current ones and records
+// c.Model and c.Dimension as the collection's, rebuilding its vector index. It reports false,
+// changing nothing, while stale chunks still lack a pending embedding from c.Model (chunks
+// stored since they were listed).
+func (p *PostgresRepository) SwapEmbeddings(ctx context.Context, c Collection) (bool, error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Release()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+	name := collectionName(ctx, c.Name)
+	// writers wait until the swap is over, so no chunk is stored with the old model after the check
+	for _, q := range []string{"SET LOCAL statement_timeout = 0", "LOCK TABLE documents IN SHARE ROW EXCLUSIVE MODE"} {
+		if _, err := tx.Exec(ctx, q); err != nil {
+			return false, fmt.Errorf("error locking chunks: %w", err)
+		}
+	}
+	var pending int64
+	err = tx.QueryRow(ctx, "SELECT count(*) FROM documents WHERE "+staleChunkSQL+" AND next_model <> $2", name, c.Model, c.Dimension).Scan(&pending)
+	if err != nil {
+		return false, fmt.Errorf("error counting chunks to re-embed: %w", err)
+	}
+	if pending > 0 {
+		return false, nil
+	}
+	steps := []struct {
+		sql  string
+		args []any
+	}{
+		{"DROP INDEX IF EXISTS " + collectionIndex(name), nil},
+		{"UPDATE documents SET embedding = next_embedding, embedding_model = next_model, next_embedding = NULL, next_model = '' " +
+			"WHERE collection = $1 AND next_model = $2", []any{name, c.Model}},
+		{"UPDATE collections SET model = $2, dimension = $3 WHERE name = $1", []any{name, c.Model, c.Dimension}},
+		// document embeddings come from the old model too; the mean of the new chunk vectors replaces them
+		{"UPDATE indexed_documents d SET embedding = (SELECT avg(embedding) FROM documents WHERE document_id = d.id) " +
+			"WHERE d.collection = $1", []any{name}},
+		{collectionIndexSQL(name, c.Dimension), nil},
+	}
+	for _, st := range steps {
+		if _, err := tx.Exec(ctx, st.sql, st.args...); err != nil {
+			return false, fmt.Errorf("error swapping embeddings of collection %q: %w", c.Name, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("error swapping embeddings of collection %q: %w", c.Name, err)
+	}
+	p.mu.Lock()
+	p.collections[name] = c.Dimension
+	p.mu.Unlock()
+	return true, nil
+}
@@ -1,93 +1,971 @@
 package repo
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/jackc/pgx/v5/pgxpool"
 	github_com_pgv "github.com/pgvector/pgvector-go"
 )
 
 // Document represents a stored chunk
 type Document struct {
-	ID      int
-	Content string
-	Source  string
-	Vector  github_com_pgv.Vector
+	ID       int
+	Content  string
+	Source   string
+	Entities []Entity
+	DocDate  *time.Time
+	// Page is the 1-based page the chunk comes from, 0 for unpaginated sources
+	Page int
+	// Section is the heading path of the chunk ("Install > Linux"), empty if unknown
+	Section string
+	// Metadata holds the fields of structured records (CSV rows, JSON objects)
+	Metadata map[string]string
+	// DocType is the kind of document the chunk comes from ("code", "legal"...), empty if untagged
+	DocType string
+	// DocumentID is the IndexedDocument of the chunk, 0 if unknown
+	DocumentID int64
+	// Position is the 1-based place of the chunk in its document, 0 if unknown
+	Position int
+	// ParentID is the parent section of the chunk (see InsertParents), 0 if it has none
+	ParentID int64
+	Vector   github_com_pgv.Vector
+	// Distance is the cosine distance of the chunk to the query embedding, set by SearchSimilar
+	// only
+	Distance *float64
+}
+
+// Entity is a named entity mentioned in a chunk (person, organization, location)
+type Entity struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Chunk is the data needed to store a new chunk
+type Chunk struct {
+	Content   string
+	Source    string
+	Embedding []float32
+	Entities  []Entity
+	// DocDate is the normalized document date; zero when unknown
+	DocDate time.Time
+	// Page is the 1-based page number, 0 when the source has no pages
+	Page int
+	// Section is the heading path the chunk belongs to
+	Section string
+	// Collection is the registered collection the chunk belongs to; empty means DefaultCollection
+	Collection string
+	// Metadata holds the fields of a structured record
+	Metadata map[string]string
+	// DocType tags the kind of document ("code", "legal", "meeting-notes"); empty when untagged
+	DocType string
+	// Session binds the chunk to a conversation: only that session retrieves it, and it is
+	// deleted when the session ends. Empty for the shared corpus.
+	Session string
+	// ContentHash identifies the content of the whole document the chunk comes from, so
+	// identical documents are indexed once (see FindContentHash); empty when unknown
+	ContentHash string
+	// DocumentID is the IndexedDocument the chunk belongs to; 0 when unknown
+	DocumentID int64
+	// Position is the 1-based place of the chunk in its document's text; 0 for chunks outside
+	// of it, such as figure captions
+	Position int
+	// EmbeddingModel is the model Embedding comes from, so chunks of a replaced model are found
+	EmbeddingModel string
+	// ParentID is the larger section of its document the chunk was split from (see
+	// InsertParents); 0 when it has none
+	ParentID int64
+}
+
+// MetaTags is the metadata key holding the tags of a chunk, lowercase and comma-separated
+const MetaTags = "tags"
+
+// SearchFilter restricts vector search to chunks matching every non-empty field
+type SearchFilter struct {
+	// Collection is the collection searched; empty means DefaultCollection. Searches never
+	// span collections, since their vectors come from different models.
+	Collection string
+	// Entities lists entity names (case-insensitive) that must all be mentioned in the chunk
+	Entities []string
+	// Before and After bound the document date (exclusive / inclusive); zero means unbounded.
+	// Chunks without a known date are excluded when either bound is set.
+	Before time.Time
+	After  time.Time
+	// Session adds the chunks bound to this session to the shared corpus
+	Session string
+	// Sources restricts the search to chunks of any of these sources
+	Sources []string
+	// Metadata lists key/value pairs the metadata of every chunk must contain
+	Metadata map[string]string
+	// Tags must all be in the MetaTags metadata of every chunk
+	Tags []string
+	// AllVersions also searches the retired versions of documents still kept (see
+	// PublishDocument); only the current versions are searched otherwise
+	AllVersions bool
+	// DocumentIDs restricts the search to chunks of these documents
+	DocumentIDs []int64
+}
+
+// DimensionMismatchError reports an embedding whose length differs from the
+// dimension of the collection it is stored in or searched against
+type DimensionMismatchError struct {
+	// Model is the embedding model that produced the vector, when known
+	Model      string
+	Collection string
+	Got        int
+	Expected   int
+}
+
+func (e *DimensionMismatchError) Error() string {
+	producer := "the embedding model"
+	if e.Model != "" {
+		producer = fmt.Sprintf("embedding model %q", e.Model)
+	}
+	return fmt.Sprintf("embedding dimension mismatch: %s produced %d-dimensional vectors but collection %q stores vector(%d); "+
+		"use a model with %d dimensions or a separate collection for the new model", producer, e.Got, e.Collection, e.Expected, e.Expected)
+}
+
+// Fields selects the optional columns SearchSimilar returns.
+// ID, Content and Source are always returned.
+type Fields uint16
+
+const (
+	FieldEntities Fields = 1 << iota
+	FieldDocDate
+	FieldPage
+	FieldEmbedding
+	FieldSection
+	FieldMetadata
+	FieldDocType
+	FieldDocumentID
+	FieldPosition
+	FieldParent
+
+	// FieldsAll returns the full row
+	FieldsAll = FieldEntities | FieldDocDate | FieldPage | FieldEmbedding | FieldSection | FieldMetadata | FieldDocType | FieldDocumentID | FieldPosition | FieldParent
+)
+
+// SearchOptions tunes a vector search
+type SearchOptions struct {
+	Filter SearchFilter
+	// Fields lists the optional columns to fetch; the zero value fetches content only
+	Fields Fields
+	// AnyWord makes SearchKeyword match chunks containing any word of the query instead of
+	// every one, ranking those with more of them first
+	AnyWord bool
 }
 
 // DocumentRepository abstracts DB operations for RAG
 type DocumentRepository interface {
 	Init(ctx context.Context) error
-	InsertChunk(ctx context.Context, content, source string, embedding []float32) error
-	SearchSimilar(ctx context.Context, queryEmbedding []float32, topK int) ([]Document, error)
+	// EnsureCollection registers a collection, or checks that it matches the existing one
+	EnsureCollection(ctx context.Context, c Collection) error
+	// Collections lists the registered collections, CollectionStats with their size;
+	// DropCollection deletes one with all its content
+	Collections(ctx context.Context) ([]Collection, error)
+	CollectionStats(ctx context.Context) ([]CollectionStats, error)
+	DropCollection(ctx context.Context, name string) (int64, error)
+	// SetDocumentEmbedding and DocumentEmbedding store and read the document-level embedding
+	// of a document; SimilarDocuments searches documents by it
+	SetDocumentEmbedding(ctx context.Context, id int64, embedding []float32) error
+	DocumentEmbedding(ctx context.Context, id int64) ([]float32, error)
+	SimilarDocuments(ctx context.Context, embedding []float32, topK int, opts SimilarDocumentsOptions) ([]ScoredDocument, error)
+	InsertChunk(ctx context.Context, chunk Chunk) error
+	// InsertChunks stores several chunks at once, all or none
+	InsertChunks(ctx context.Context, chunks []Chunk) error
+	SearchSimilar(ctx context.Context, queryEmbedding []float32, topK int, opts SearchOptions) ([]Document, error)
+	// SearchKeyword ranks chunks containing every word of query (any word with
+	// SearchOptions.AnyWord) by full-text relevance
+	SearchKeyword(ctx context.Context, query string, topK int, opts SearchOptions) ([]Document, error)
+	// SetWeightByID and SetWeightBySource change the ranking weight of chunks, returning how many changed
+	SetWeightByID(ctx context.Context, id int, weight float64) (int64, error)
+	SetWeightBySource(ctx context.Context, collection, source string, weight float64) (int64, error)
+	// LogQuery records a question asked against a collection
+	LogQuery(ctx context.Context, collection, query string) error
+	// RecentQueries returns the latest logged questions of every collection
+	RecentQueries(ctx context.Context, limit int) ([]LoggedQuery, error)
+	// PruneQueryLog deletes the questions logged before cutoff
+	PruneQueryLog(ctx context.Context, cutoff time.Time) (int64, error)
+	// Quarantine keeps a chunk that failed to index; the other quarantine methods list,
+	// resolve and record new failures of such chunks
+	Quarantine(ctx context.Context, chunk Chunk, cause error) error
+	QuarantinedChunks(ctx context.Context, ids []int64) ([]QuarantinedChunk, error)
+	ResolveQuarantined(ctx context.Context, id int64) error
+	FailQuarantined(ctx context.Context, id int64, cause error) error
+	// TouchSession, EndSession and IdleSessions track the conversations that own session-bound chunks
+	TouchSession(ctx context.Context, id string) error
+	EndSession(ctx context.Context, id string) (int64, error)
+	IdleSessions(ctx context.Context, idle time.Duration) ([]string, error)
+	// SetSessionSettings and SessionSettings keep the options of a conversation
+	SetSessionSettings(ctx context.Context, id string, s SessionSettings) error
+	SessionSettings(ctx context.Context, id string) (SessionSettings, error)
+	// FindContentHash returns the source of a document of collection with that content hash,
+	// visible to session, or "" if there is none
+	FindContentHash(ctx context.Context, collection, session, hash string) (string, error)
+	// DeleteBySource removes every chunk of a source
+	DeleteBySource(ctx context.Context, collection, source string) (int64, error)
+	// SourceVersion and SetSourceVersion track what version of an external source is indexed
+	SourceVersion(ctx context.Context, collection, source string) (string, error)
+	SetSourceVersion(ctx context.Context, collection, source, version string) error
+	// CreateDocument records a document about to be indexed; the other document methods list,
+	// read and delete documents with their chunks
+	CreateDocument(ctx context.Context, doc IndexedDocument) (int64, error)
+	ListDocuments(ctx context.Context, opts ListDocumentsOptions) ([]IndexedDocument, int64, error)
+	GetDocument(ctx context.Context, id int64) (IndexedDocument, bool, error)
+	DocumentChunks(ctx context.Context, id int64) ([]Document, error)
+	DeleteDocument(ctx context.Context, id int64) (int64, bool, error)
+	// CountMatchingDocuments and DeleteMatchingDocuments count and delete, in batches, the
+	// documents matching a predicate, with their chunks
+	CountMatchingDocuments(ctx context.Context, pr DocumentPredicate) (int64, int64, error)
+	DeleteMatchingDocuments(ctx context.Context, pr DocumentPredicate, limit int) (int64, int64, error)
+	// DocumentChanges reads the change feed of the documents from a cursor
+	DocumentChanges(ctx context.Context, since ChangeCursor, collection string, limit int) ([]DocumentChange, error)
+	// DeleteExpired deletes the documents past their IndexedDocument.ExpiresAt
+	DeleteExpired(ctx context.Context) (int64, error)
+	// RecordRetrievals counts a retrieval of chunks; AccessReport reports the most and the never
+	// retrieved ones of a collection
+	RecordRetrievals(ctx context.Context, ids []int) error
+	AccessReport(ctx context.Context, collection string, limit int) (AccessReport, error)
+	// ChunkNeighbors returns the chunks around positions of documents
+	ChunkNeighbors(ctx context.Context, refs []ChunkRef, window int) ([]Document, error)
+	// InsertParents stores the parent sections of a document, ParentChunks reads them back
+	InsertParents(ctx context.Context, documentID int64, contents []string) ([]int64, error)
+	ParentChunks(ctx context.Context, ids []int64) (map[int64]string, error)
+	// LatestVersion and PublishDocument version the documents of a source (see Document.Replace)
+	LatestVersion(ctx context.Context, collection, session, source string) (int, error)
+	PublishDocument(ctx context.Context, id int64, keep int) (int, error)
+	// CountStaleChunks counts the chunks of a collection embedded with another model or dimension; the other
+	// reindex methods re-embed a collection next to its current vectors, then swap them in
+	CountStaleChunks(ctx context.Context, c Collection) (int64, error)
+	PendingReindex(ctx context.Context, c Collection, limit int) ([]ReindexChunk, error)
+	SetPendingEmbeddings(ctx context.Context, model string, ids []int, embeddings [][]float32) error
+	SwapEmbeddings(ctx context.Context, c Collection) (bool, error)
+	// SaveAnswer, SearchHistory and RecentHistory keep each user's questions and answers
+	SaveAnswer(ctx context.Context, e HistoryEntry) (int64, error)
+	SearchHistory(ctx context.Context, user, model string, emb []float32, topK int) ([]HistoryEntry, error)
+	RecentHistory(ctx context.Context, user string, limit int) ([]HistoryEntry, error)
+	// SessionHistory returns the latest entries of a user in a conversation, oldest first
+	SessionHistory(ctx context.Context, user, session string, limit int) ([]HistoryEntry, error)
+	// RateAnswer sets the rating of a user's history entry, reporting false if there is none
+	RateAnswer(ctx context.Context, user string, id int64, rating int) (HistoryEntry, bool, error)
+	// ClickPassages records the passages of an answer a user opened; FeedbackHistory lists the
+	// rated or clicked entries of every user
+	ClickPassages(ctx context.Context, user string, id int64, passages []int) (bool, error)
+	FeedbackHistory(ctx context.Context) ([]HistoryEntry, error)
+	// ChunksByID returns the chunks of ids that still exist, in no particular order
+	ChunksByID(ctx context.Context, ids []int) ([]Document, error)
+	// SaveEvalRun and EvalRuns keep the results of the golden-question suite over time
+	SaveEvalRun(ctx context.Context, run EvalRun) error
+	EvalRuns(ctx context.Context, limit int) ([]EvalRun, error)
 	Close(ctx context.Context) error
 }
 
-// PostgresRepository implements DocumentRepository using pgx and pgvector
+// PostgresRepository implements DocumentRepository using a pgx pool and pgvector
 type PostgresRepository struct {
-	conn *pgx.Conn
+	pool *pgxpool.Pool
+	// mu guards collections, the vector dimension of each registered collection
+	mu          sync.RWMutex
+	collections map[string]int
+	// queryTimeout bounds every statement; 0 means no limit besides the caller's context
+	queryTimeout time.Duration
+}
+
+// PostgresOptions tunes the connection pool
+type PostgresOptions struct {
+	// QueryTimeout bounds every statement; 0 means no limit besides the caller's context
+	QueryTimeout time.Duration
+	// MaxConns caps the pool size; 0 keeps pgxpool's default
+	MaxConns int32
+	// StatementCacheCapacity is the number of prepared statements kept per connection; 0 keeps pgx's default
+	StatementCacheCapacity int
+	// WrapDial, when set, wraps the function opening connections (fault injection in tests)
+	WrapDial func(dial DialFunc) DialFunc
+	// ObserveQuery, when set, is called with the duration of every statement (load monitoring)
+	ObserveQuery func(d time.Duration)
 }
 
-func NewPostgresRepository(ctx context.Context, dbURL string) (*PostgresRepository, error) {
-	conn, err := pgx.Connect(ctx, dbURL)
+// DialFunc opens a network connection to the database
+type DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NewPostgresRepository connects to dbURL. Statements are bounded by opts.QueryTimeout (when positive)
+// and by the caller's context: a cancelled context sends a cancel request so the server stops
+// the statement instead of finishing an orphaned scan.
+//
+// Every connection prepares each distinct statement once and reuses it (pgx's statement cache),
+// so repeated inserts and searches skip parsing and planning.
+func NewPostgresRepository(ctx context.Context, dbURL string, opts PostgresOptions) (*PostgresRepository, error) {
+	cfg, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing database URL: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	connCfg := cfg.ConnConfig
+	connCfg.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	if opts.StatementCacheCapacity > 0 {
+		connCfg.StatementCacheCapacity = opts.StatementCacheCapacity
+	}
+	connCfg.BuildContextWatcherHandler = func(conn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{
+			Conn:               conn,
+			CancelRequestDelay: 0,
+			DeadlineDelay:      5 * time.Second,
+		}
+	}
+	if opts.WrapDial != nil {
+		connCfg.DialFunc = opts.WrapDial(connCfg.DialFunc)
+	}
+	if opts.ObserveQuery != nil {
+		connCfg.Tracer = queryTimer{observe: opts.ObserveQuery}
+	}
+	if opts.QueryTimeout > 0 {
+		// server-side safety net in case the cancel request never arrives
+		connCfg.RuntimeParams["statement_timeout"] = strconv.FormatInt((opts.QueryTimeout + time.Second).Milliseconds(), 10)
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error connecting to postgres: %w", err)
 	}
-	return &PostgresRepository{conn: conn}, nil
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("error connecting to postgres: %w", err)
+	}
+	return &PostgresRepository{pool: pool, queryTimeout: opts.QueryTimeout, collections: map[string]int{}}, nil
+}
+
+// queryTimer is a pgx tracer passing the duration of every statement to observe
+type queryTimer struct {
+	observe func(d time.Duration)
+}
+
+type queryStartKey struct{}
+
+func (t queryTimer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, time.Now())
+}
+
+func (t queryTimer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	if start, ok := ctx.Value(queryStartKey{}).(time.Time); ok {
+		t.observe(time.Since(start))
+	}
+}
+
+// withTimeout derives the context a single statement runs under
+func (p *PostgresRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, p.queryTimeout)
 }
 
 func (p *PostgresRepository) Close(ctx context.Context) error {
-	return p.conn.Close(ctx)
+	p.pool.Close()
+	return nil
 }
 
 func (p *PostgresRepository) Init(ctx context.Context) error {
 	queries := []string{
+		// schema changes and index builds may legitimately outlast the per-statement timeout
+		"SET statement_timeout = 0",
 		"CREATE EXTENSION IF NOT EXISTS vector",
 		`CREATE TABLE IF NOT EXISTS documents (
 			id SERIAL PRIMARY KEY,
 			content TEXT NOT NULL,
 			source TEXT NOT NULL,
-			embedding vector(768)
+			embedding vector
+		)`,
+		`CREATE TABLE IF NOT EXISTS collections (
+			name TEXT PRIMARY KEY,
+			model TEXT NOT NULL,
+			dimension INT NOT NULL CHECK (dimension > 0)
+		)`,
+		"ALTER TABLE collections ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''",
+		`CREATE TABLE IF NOT EXISTS query_log (
+			id BIGSERIAL PRIMARY KEY,
+			collection TEXT NOT NULL,
+			query TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"CREATE INDEX IF NOT EXISTS query_log_collection_idx ON query_log (collection, created_at)",
+		"CREATE INDEX IF NOT EXISTS query_log_created_idx ON query_log (created_at)",
+		`CREATE TABLE IF NOT EXISTS quarantine (
+			id BIGSERIAL PRIMARY KEY,
+			collection TEXT NOT NULL,
+			source TEXT NOT NULL,
+			content TEXT NOT NULL,
+			entities JSONB NOT NULL DEFAULT '[]',
+			doc_date DATE,
+			page INT NOT NULL DEFAULT 0,
+			section TEXT NOT NULL DEFAULT '',
+			metadata JSONB NOT NULL DEFAULT '{}',
+			error TEXT NOT NULL,
+			attempts INT NOT NULL DEFAULT 1,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			last_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS entities JSONB NOT NULL DEFAULT '[]'",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS doc_date DATE",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS page INT NOT NULL DEFAULT 0",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS section TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS weight REAL NOT NULL DEFAULT 1 CHECK (weight >= 0)",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}'",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS collection TEXT NOT NULL DEFAULT '" + DefaultCollection + "'",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS doc_type TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS doc_type TEXT NOT NULL DEFAULT ''",
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id TEXT PRIMARY KEY,
+			last_seen TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS session TEXT NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS documents_session_idx ON documents (session) WHERE session <> ''",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS session TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS documents_content_hash_idx ON documents (collection, content_hash) WHERE content_hash <> ''",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT ''",
+		`CREATE TABLE IF NOT EXISTS source_versions (
+			collection TEXT NOT NULL,
+			source TEXT NOT NULL,
+			version TEXT NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (collection, source)
+		)`,
+		"CREATE INDEX IF NOT EXISTS documents_source_idx ON documents (collection, source)",
+		`CREATE TABLE IF NOT EXISTS qa_history (
+			id BIGSERIAL PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			question TEXT NOT NULL,
+			answer TEXT NOT NULL,
+			model TEXT NOT NULL,
+			embedding vector NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"CREATE INDEX IF NOT EXISTS qa_history_user_idx ON qa_history (user_id, created_at)",
+		"ALTER TABLE qa_history ADD COLUMN IF NOT EXISTS rating SMALLINT NOT NULL DEFAULT 0",
+		// the chunks behind each answer and the ones the user opened, for training data
+		"ALTER TABLE qa_history ADD COLUMN IF NOT EXISTS chunk_ids INT[] NOT NULL DEFAULT '{}'",
+		"ALTER TABLE qa_history ADD COLUMN IF NOT EXISTS clicked INT[] NOT NULL DEFAULT '{}'",
+		// the tenant owning sessions, history and eval runs (see WithTenant); a session ID is
+		// unique within its tenant only
+		"ALTER TABLE qa_history ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE sessions DROP CONSTRAINT IF EXISTS sessions_pkey",
+		"CREATE UNIQUE INDEX IF NOT EXISTS sessions_tenant_id_idx ON sessions (tenant, id)",
+		// the options every turn of a conversation is answered with (see SessionSettings)
+		"ALTER TABLE sessions ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}'",
+		// the conversation of each question, read back to rewrite follow-up questions
+		"ALTER TABLE qa_history ADD COLUMN IF NOT EXISTS session TEXT NOT NULL DEFAULT ''",
+		"CREATE INDEX IF NOT EXISTS qa_history_session_idx ON qa_history (tenant, user_id, session, created_at) WHERE session <> ''",
+		`CREATE TABLE IF NOT EXISTS eval_runs (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			questions INT NOT NULL,
+			k INT NOT NULL,
+			hit_rate DOUBLE PRECISION NOT NULL,
+			mrr DOUBLE PRECISION NOT NULL,
+			recall DOUBLE PRECISION NOT NULL,
+			embedding_model TEXT NOT NULL,
+			corpus_version BIGINT NOT NULL,
+			missed JSONB NOT NULL DEFAULT '[]'
+		)`,
+		"CREATE INDEX IF NOT EXISTS documents_collection_idx ON documents (collection)",
+		"ALTER TABLE eval_runs ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT ''",
+		// corpus version, read by result caches: bumped once by every transaction that changes
+		// documents, at commit and in a row that transaction writes, so a reader never sees the new
+		// version before the new rows. It continues the sequence that used to hold it.
+		`CREATE TABLE IF NOT EXISTS documents_version (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			version BIGINT NOT NULL,
+			tx BIGINT NOT NULL DEFAULT 0
+		)`,
+		"CREATE SEQUENCE IF NOT EXISTS documents_version_seq",
+		"INSERT INTO documents_version (version) SELECT last_value FROM documents_version_seq ON CONFLICT DO NOTHING",
+		"DROP SEQUENCE IF EXISTS documents_version_seq",
+		`CREATE OR REPLACE FUNCTION bump_documents_version() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			UPDATE documents_version SET version = version + 1, tx = txid_current() WHERE tx <> txid_current();
+			RETURN NULL;
+		END $$`,
+		"DROP TRIGGER IF EXISTS documents_version_trg ON documents",
+		"CREATE CONSTRAINT TRIGGER documents_version_trg AFTER INSERT OR UPDATE OR DELETE ON documents " +
+			"DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION bump_documents_version()",
+		"CREATE INDEX IF NOT EXISTS documents_entities_idx ON documents USING gin (entities)",
+		"CREATE INDEX IF NOT EXISTS documents_doc_date_idx ON documents (doc_date)",
+		// documents as indexed, each owning its chunks (documents holds the chunks themselves)
+		`CREATE TABLE IF NOT EXISTS indexed_documents (
+			id BIGSERIAL PRIMARY KEY,
+			collection TEXT NOT NULL,
+			source TEXT NOT NULL,
+			session TEXT NOT NULL DEFAULT '',
+			doc_type TEXT NOT NULL DEFAULT '',
+			content_hash TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"CREATE INDEX IF NOT EXISTS indexed_documents_collection_idx ON indexed_documents (collection, id)",
+		"CREATE INDEX IF NOT EXISTS indexed_documents_source_idx ON indexed_documents (collection, source)",
+		"CREATE INDEX IF NOT EXISTS indexed_documents_session_idx ON indexed_documents (session) WHERE session <> ''",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS document_id BIGINT REFERENCES indexed_documents (id) ON DELETE CASCADE",
+		"CREATE INDEX IF NOT EXISTS documents_document_id_idx ON documents (document_id)",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS document_id BIGINT",
+		// chunks stored without a document (before the table existed) get one per source
+		`WITH legacy AS (
+			INSERT INTO indexed_documents (collection, source, session, doc_type, content_hash)
+			SELECT collection, source, session, max(doc_type), max(content_hash)
+			FROM documents WHERE document_id IS NULL GROUP BY collection, source, session
+			RETURNING id, collection, source, session
+		)
+		UPDATE documents d SET document_id = legacy.id FROM legacy
+		WHERE d.document_id IS NULL AND d.collection = legacy.collection AND d.source = legacy.source AND d.session = legacy.session`,
+		// the model of each vector, and the vector of the next model while a collection is re-embedded
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS embedding_model TEXT NOT NULL DEFAULT ''",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS next_embedding vector",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS next_model TEXT NOT NULL DEFAULT ''",
+		"UPDATE documents d SET embedding_model = c.model FROM collections c " +
+			"WHERE d.embedding_model = '' AND d.collection = c.name AND c.model <> ''",
+		// document versions: a source re-uploaded with Replace is staged, then published in
+		// place of its current version, which is retired (see PublishDocument)
+		"ALTER TABLE indexed_documents ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1",
+		"ALTER TABLE indexed_documents ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'current'",
+		"ALTER TABLE indexed_documents ADD COLUMN IF NOT EXISTS retired_at TIMESTAMPTZ",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS state TEXT NOT NULL DEFAULT 'current'",
+		// the place of each chunk in its document, numbered in storage order for older chunks
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS chunk_position INT",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS chunk_position INT",
+		`UPDATE documents d SET chunk_position = n.position FROM (
+			SELECT id, row_number() OVER (PARTITION BY document_id ORDER BY id) AS position FROM documents
+			WHERE document_id IN (SELECT document_id FROM documents WHERE chunk_position IS NULL AND document_id IS NOT NULL)
+		) n WHERE d.id = n.id AND d.chunk_position IS NULL`,
+		"CREATE INDEX IF NOT EXISTS documents_metadata_idx ON documents USING gin (metadata jsonb_path_ops)",
+		"CREATE INDEX IF NOT EXISTS documents_position_idx ON documents (document_id, chunk_position)",
+		// one embedding per document for document-level search, the mean of its chunks for older documents
+		"ALTER TABLE indexed_documents ADD COLUMN IF NOT EXISTS embedding vector",
+		`UPDATE indexed_documents d SET embedding = c.embedding FROM (
+			SELECT document_id, avg(embedding) AS embedding FROM documents
+			WHERE document_id IN (SELECT id FROM indexed_documents WHERE embedding IS NULL) GROUP BY document_id
+		) c WHERE d.id = c.document_id AND d.embedding IS NULL`,
+		// documents indexed with a time-to-live, deleted by DeleteExpired
+		"ALTER TABLE indexed_documents ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ",
+		"CREATE INDEX IF NOT EXISTS indexed_documents_expires_idx ON indexed_documents (expires_at) WHERE expires_at IS NOT NULL",
+		// 'simple' keeps the index language-agnostic (no stemming), matching the mixed-language corpus
+		"CREATE INDEX IF NOT EXISTS documents_content_fts_idx ON documents USING gin (to_tsvector('simple', content))",
+		// how often each chunk was retrieved, apart from the chunks so queries do not rewrite them
+		`CREATE TABLE IF NOT EXISTS chunk_access (
+			chunk_id INT PRIMARY KEY REFERENCES documents (id) ON DELETE CASCADE,
+			retrievals BIGINT NOT NULL DEFAULT 1,
+			last_retrieved_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"CREATE INDEX IF NOT EXISTS chunk_access_retrievals_idx ON chunk_access (retrievals DESC)",
+		// the larger sections small chunks are split from, returned in their place (see InsertParents)
+		`CREATE TABLE IF NOT EXISTS chunk_parents (
+			id BIGSERIAL PRIMARY KEY,
+			document_id BIGINT NOT NULL REFERENCES indexed_documents (id) ON DELETE CASCADE,
+			content TEXT NOT NULL
 		)`,
-		"CREATE INDEX IF NOT EXISTS documents_embedding_idx ON documents USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100)",
+		"CREATE INDEX IF NOT EXISTS chunk_parents_document_idx ON chunk_parents (document_id)",
+		"ALTER TABLE documents ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES chunk_parents (id) ON DELETE SET NULL",
+		"ALTER TABLE quarantine ADD COLUMN IF NOT EXISTS parent_id BIGINT REFERENCES chunk_parents (id) ON DELETE SET NULL",
+		"CREATE INDEX IF NOT EXISTS documents_parent_idx ON documents (parent_id) WHERE parent_id IS NOT NULL",
+		// change feed: the documents inserted, updated (chunks included) or deleted, once per
+		// document and transaction, read in transaction order (see DocumentChanges)
+		`CREATE TABLE IF NOT EXISTS document_changes (
+			id BIGSERIAL PRIMARY KEY,
+			xid xid8 NOT NULL DEFAULT pg_current_xact_id(),
+			op TEXT NOT NULL,
+			document_id BIGINT NOT NULL,
+			collection TEXT NOT NULL,
+			source TEXT NOT NULL,
+			changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+		"CREATE INDEX IF NOT EXISTS document_changes_xid_idx ON document_changes (xid, id)",
+		`CREATE OR REPLACE FUNCTION record_document_change() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				-- deleting the chunks first recorded an update the delete supersedes
+				DELETE FROM document_changes WHERE xid = pg_current_xact_id() AND document_id = OLD.id AND op = 'update';
+				INSERT INTO document_changes (op, document_id, collection, source) VALUES ('delete', OLD.id, OLD.collection, OLD.source);
+			ELSIF NOT EXISTS (SELECT 1 FROM document_changes WHERE xid = pg_current_xact_id() AND document_id = NEW.id) THEN
+				INSERT INTO document_changes (op, document_id, collection, source) VALUES (lower(TG_OP), NEW.id, NEW.collection, NEW.source);
+			END IF;
+			RETURN NULL;
+		END $$`,
+		"DROP TRIGGER IF EXISTS document_changes_trg ON indexed_documents",
+		"CREATE TRIGGER document_changes_trg AFTER INSERT OR UPDATE OR DELETE ON indexed_documents " +
+			"FOR EACH ROW EXECUTE FUNCTION record_document_change()",
+		`CREATE OR REPLACE FUNCTION record_chunk_changes() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			INSERT INTO document_changes (op, document_id, collection, source)
+			SELECT 'update', d.id, d.collection, d.source FROM indexed_documents d
+			WHERE d.id IN (SELECT document_id FROM changed_chunks)
+			AND NOT EXISTS (SELECT 1 FROM document_changes c WHERE c.xid = pg_current_xact_id() AND c.document_id = d.id);
+			RETURN NULL;
+		END $$`,
+		// a trigger with transition tables fires on one event only
+		"DROP TRIGGER IF EXISTS document_chunks_insert_trg ON documents",
+		"CREATE TRIGGER document_chunks_insert_trg AFTER INSERT ON documents " +
+			"REFERENCING NEW TABLE AS changed_chunks FOR EACH STATEMENT EXECUTE FUNCTION record_chunk_changes()",
+		"DROP TRIGGER IF EXISTS document_chunks_update_trg ON documents",
+		"CREATE TRIGGER document_chunks_update_trg AFTER UPDATE ON documents " +
+			"REFERENCING NEW TABLE AS changed_chunks FOR EACH STATEMENT EXECUTE FUNCTION record_chunk_changes()",
+		"DROP TRIGGER IF EXISTS document_chunks_delete_trg ON documents",
+		"CREATE TRIGGER document_chunks_delete_trg AFTER DELETE ON documents " +
+			"REFERENCING OLD TABLE AS changed_chunks FOR EACH STATEMENT EXECUTE FUNCTION record_chunk_changes()",
 	}
+	// one connection for the whole sequence so the SET/RESET pair applies to it
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Release()
 	for _, q := range queries {
-		if _, err := p.conn.Exec(ctx, q); err != nil {
+		if _, err := conn.Exec(ctx, q); err != nil {
 			return fmt.Errorf("error executing init query: %w", err)
 		}
 	}
-	return nil
+
+	// pgvector stores the declared dimension as the column's type modifier (-1 when unconstrained).
+	// A fixed dimension comes from the single-model schema: its rows become the default
+	// collection and the column is relaxed so collections can use other dimensions.
+	var typmod int
+	err = conn.QueryRow(ctx,
+		"SELECT atttypmod FROM pg_attribute WHERE attrelid = 'documents'::regclass AND attname = 'embedding'",
+	).Scan(&typmod)
+	if err != nil {
+		return fmt.Errorf("error reading embedding dimension: %w", err)
+	}
+	if typmod > 0 {
+		migration := []string{
+			fmt.Sprintf("INSERT INTO collections (name, model, dimension) VALUES ('%s', '', %d) ON CONFLICT (name) DO NOTHING", DefaultCollection, typmod),
+			"DROP INDEX IF EXISTS documents_embedding_idx",
+			"ALTER TABLE documents ALTER COLUMN embedding TYPE vector",
+		}
+		for _, q := range migration {
+			if _, err := conn.Exec(ctx, q); err != nil {
+				return fmt.Errorf("error migrating to collections: %w", err)
+			}
+		}
+	}
+	if _, err := conn.Exec(ctx, "RESET statement_timeout"); err != nil {
+		return fmt.Errorf("error executing init query: %w", err)
+	}
+	return p.loadCollections(ctx)
 }
 
-func (p *PostgresRepository) InsertChunk(ctx context.Context, content, source string, embedding []float32) error {
-	_, err := p.conn.Exec(ctx,
-		"INSERT INTO documents (content, source, embedding) VALUES ($1, $2, $3)",
-		content, source, github_com_pgv.NewVector(embedding),
-	)
+// checkDimension returns the dimension of a collection of the tenant of ctx, checking that
+// embedding has it
+func (p *PostgresRepository) checkDimension(ctx context.Context, collection string, embedding []float32) (int, error) {
+	dim, err := p.collectionDimension(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	if len(embedding) != dim {
+		return 0, &DimensionMismatchError{Collection: cmp.Or(collection, DefaultCollection), Got: len(embedding), Expected: dim}
+	}
+	return dim, nil
+}
+
+// Statement texts are constants so the per-connection statement cache reuses their plans
+const (
+	insertChunkSQL = "INSERT INTO documents (content, source, embedding, entities, doc_date, page, section, collection, metadata, doc_type, session, content_hash, document_id, embedding_model, chunk_position, parent_id, state) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, " +
+		"coalesce((SELECT state FROM indexed_documents WHERE id = $13), 'current'))"
+)
+
+func (p *PostgresRepository) InsertChunk(ctx context.Context, chunk Chunk) error {
+	args, err := p.insertChunkArgs(ctx, chunk)
 	if err != nil {
+		return err
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if _, err := p.pool.Exec(ctx, insertChunkSQL, args...); err != nil {
 		return fmt.Errorf("error inserting chunk: %w", err)
 	}
 	return nil
 }
 
-func (p *PostgresRepository) SearchSimilar(ctx context.Context, queryEmbedding []float32, topK int) ([]Document, error) {
-	rows, err := p.conn.Query(ctx,
-		`SELECT id, content, source, embedding FROM documents ORDER BY embedding <=> $1 LIMIT $2`,
-		github_com_pgv.NewVector(queryEmbedding), topK,
-	)
+// InsertChunks stores chunks in one transaction, sending every insert in a single batch so a
+// large document costs one round trip instead of one per chunk. Either all chunks are stored or none.
+func (p *PostgresRepository) InsertChunks(ctx context.Context, chunks []Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	batch := &pgx.Batch{}
+	for _, chunk := range chunks {
+		args, err := p.insertChunkArgs(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		batch.Queue(insertChunkSQL, args...)
+	}
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error inserting chunks: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing chunks: %w", err)
+	}
+	return nil
+}
+
+// insertChunkArgs checks the embedding dimension of chunk and returns the arguments of insertChunkSQL
+func (p *PostgresRepository) insertChunkArgs(ctx context.Context, chunk Chunk) ([]any, error) {
+	collection := collectionName(ctx, chunk.Collection)
+	if _, err := p.checkDimension(ctx, chunk.Collection, chunk.Embedding); err != nil {
+		return nil, err
+	}
+	entities := chunk.Entities
+	if entities == nil {
+		entities = []Entity{}
+	}
+	entitiesJSON, err := json.Marshal(entities)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding entities: %w", err)
+	}
+	metadata := chunk.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding metadata: %w", err)
+	}
+	var docDate *time.Time
+	if !chunk.DocDate.IsZero() {
+		docDate = &chunk.DocDate
+	}
+	return []any{
+		chunk.Content, chunk.Source, github_com_pgv.NewVector(chunk.Embedding), entitiesJSON, docDate, chunk.Page, chunk.Section, collection, metadataJSON, chunk.DocType, chunk.Session, chunk.ContentHash, documentID(chunk.DocumentID), chunk.EmbeddingModel, chunkPosition(chunk.Position), parentID(chunk.ParentID),
+	}, nil
+}
+
+func (p *PostgresRepository) SearchSimilar(ctx context.Context, queryEmbedding []float32, topK int, opts SearchOptions) ([]Document, error) {
+	dim, err := p.checkDimension(ctx, opts.Filter.Collection, queryEmbedding)
+	if err != nil {
+		return nil, err
+	}
+	// the ANN index only orders by distance, so take a wider candidate pool
+	// and re-rank it by weighted similarity
+	args := []any{github_com_pgv.NewVector(queryEmbedding), topK, topK * weightCandidateFactor}
+	where := filterClause(ctx, opts.Filter, &args)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	// the cast matches the collection's partial index expression
+	distance := fmt.Sprintf("embedding::vector(%d) <=> $1", dim)
+	query := selectColumns(opts.Fields) + ", distance FROM (SELECT *, " + distance + " AS distance FROM documents" + where +
+		" ORDER BY " + distance + " LIMIT $3) candidates ORDER BY (1 - distance) * weight DESC LIMIT $2"
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error performing vector search: %w", err)
 	}
-	defer rows.Close()
+	return collectDocuments(rows, opts.Fields, true)
+}
 
+func (p *PostgresRepository) SearchKeyword(ctx context.Context, query string, topK int, opts SearchOptions) ([]Document, error) {
+	if _, err := p.collectionDimension(ctx, opts.Filter.Collection); err != nil {
+		return nil, err
+	}
+	args := []any{query, topK}
+	tsquery := "plainto_tsquery('simple', $1)"
+	if opts.AnyWord {
+		// plainto_tsquery joins the words with &, which every match must then contain
+		tsquery = "replace(" + tsquery + "::text, '&', '|')::tsquery"
+	}
+	where := filterClause(ctx, opts.Filter, &args) + " AND to_tsvector('simple', content) @@ " + tsquery
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, selectColumns(opts.Fields)+" FROM documents"+where+
+		" ORDER BY ts_rank(to_tsvector('simple', content), "+tsquery+") * weight DESC LIMIT $2", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error performing keyword search: %w", err)
+	}
+	return collectDocuments(rows, opts.Fields, false)
+}
+
+// collectDocuments scans every row of a selectColumns(fields) query, followed by the distance
+// column when withDistance is set
+func collectDocuments(rows pgx.Rows, fields Fields, withDistance bool) ([]Document, error) {
+	defer rows.Close()
 	var docs []Document
 	for rows.Next() {
 		var d Document
-		if err := rows.Scan(&d.ID, &d.Content, &d.Source, &d.Vector); err != nil {
+		targets := scanTargets(&d, fields)
+		if withDistance {
+			targets = append(targets, &d.Distance)
+		}
+		if err := rows.Scan(targets...); err != nil {
 			return nil, err
 		}
 		docs = append(docs, d)
 	}
-	return docs, nil
+	return docs, rows.Err()
+}
+
+// optionalColumns maps each optional field to its column, in select order
+var optionalColumns = []struct {
+	field  Fields
+	column string
+	target func(d *Document) any
+}{
+	{FieldEntities, "entities", func(d *Document) any { return &d.Entities }},
+	{FieldDocDate, "doc_date", func(d *Document) any { return &d.DocDate }},
+	{FieldPage, "page", func(d *Document) any { return &d.Page }},
+	{FieldEmbedding, "embedding", func(d *Document) any { return &d.Vector }},
+	{FieldSection, "section", func(d *Document) any { return &d.Section }},
+	{FieldMetadata, "metadata", func(d *Document) any { return &d.Metadata }},
+	{FieldDocType, "doc_type", func(d *Document) any { return &d.DocType }},
+	{FieldDocumentID, "coalesce(document_id, 0)", func(d *Document) any { return &d.DocumentID }},
+	{FieldPosition, "coalesce(chunk_position, 0)", func(d *Document) any { return &d.Position }},
+	{FieldParent, "coalesce(parent_id, 0)", func(d *Document) any { return &d.ParentID }},
+}
+
+// weightCandidateFactor is how many ANN candidates per requested result are re-ranked by weight
+const weightCandidateFactor = 4
+
+// selectColumns renders the SELECT list for fields
+func selectColumns(fields Fields) string {
+	cols := []string{"id", "content", "source"}
+	for _, c := range optionalColumns {
+		if fields&c.field != 0 {
+			cols = append(cols, c.column)
+		}
+	}
+	return "SELECT " + strings.Join(cols, ", ")
+}
+
+// scanTargets returns the Document fields matching selectColumns(fields), in order
+func scanTargets(d *Document, fields Fields) []any {
+	targets := []any{&d.ID, &d.Content, &d.Source}
+	for _, c := range optionalColumns {
+		if fields&c.field != 0 {
+			targets = append(targets, c.target(d))
+		}
+	}
+	return targets
+}
+
+// Ping checks that the database answers
+func (p *PostgresRepository) Ping(ctx context.Context) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	if err := p.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("error pinging database: %w", err)
+	}
+	return nil
+}
+
+// Prewarm loads the documents table and the vector indexes of every collection into shared
+// buffers with pg_prewarm, when the extension is available
+func (p *PostgresRepository) Prewarm(ctx context.Context) error {
+	if _, err := p.pool.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS pg_prewarm"); err != nil {
+		return fmt.Errorf("error enabling pg_prewarm: %w", err)
+	}
+	rels := []string{"documents"}
+	for _, name := range p.collectionNames() {
+		rels = append(rels, collectionIndex(name))
+	}
+	for _, rel := range rels {
+		if _, err := p.pool.Exec(ctx, "SELECT pg_prewarm($1)", rel); err != nil {
+			return fmt.Errorf("error prewarming %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+func (p *PostgresRepository) SetWeightByID(ctx context.Context, id int, weight float64) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	args := []any{id, weight}
+	tag, err := p.pool.Exec(ctx, "UPDATE documents SET weight = $2 WHERE id = $1 AND "+tenantScope(ctx, "collection", &args), args...)
+	if err != nil {
+		return 0, fmt.Errorf("error updating chunk weight: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (p *PostgresRepository) SetWeightBySource(ctx context.Context, collection, source string, weight float64) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tag, err := p.pool.Exec(ctx, "UPDATE documents SET weight = $3 WHERE collection = $1 AND source = $2",
+		collectionName(ctx, collection), source, weight)
+	if err != nil {
+		return 0, fmt.Errorf("error updating source weight: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// filterClause renders filter, on a collection of the tenant of ctx, as a WHERE clause,
+// appending its parameters to args. The collection is inlined rather than bound so the planner
+// can use its partial index with cached (generic) plans; callers check that it is registered,
+// and registered names are restricted to safe characters.
+func filterClause(ctx context.Context, filter SearchFilter, args *[]any) string {
+	conds := []string{fmt.Sprintf("collection = '%s'", collectionName(ctx, filter.Collection))}
+	for _, name := range filter.Entities {
+		*args = append(*args, name)
+		conds = append(conds, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM jsonb_array_elements(entities) e WHERE lower(e->>'text') = lower($%d))", len(*args)))
+	}
+	if !filter.After.IsZero() {
+		*args = append(*args, filter.After)
+		conds = append(conds, fmt.Sprintf("doc_date >= $%d", len(*args)))
+	}
+	if !filter.Before.IsZero() {
+		*args = append(*args, filter.Before)
+		conds = append(conds, fmt.Sprintf("doc_date < $%d", len(*args)))
+	}
+	if filter.Session != "" {
+		*args = append(*args, filter.Session)
+		conds = append(conds, fmt.Sprintf("session IN ('', $%d)", len(*args)))
+	} else {
+		conds = append(conds, "session = ''")
+	}
+	if len(filter.Sources) > 0 {
+		*args = append(*args, filter.Sources)
+		conds = append(conds, fmt.Sprintf("source = ANY($%d)", len(*args)))
+	}
+	if len(filter.Metadata) > 0 {
+		// marshaling a map of strings cannot fail
+		m, _ := json.Marshal(filter.Metadata)
+		*args = append(*args, string(m))
+		conds = append(conds, fmt.Sprintf("metadata @> $%d::jsonb", len(*args)))
+	}
+	for _, tag := range filter.Tags {
+		*args = append(*args, tag)
+		conds = append(conds, fmt.Sprintf("$%d = ANY(string_to_array(metadata->>'%s', ','))", len(*args), MetaTags))
+	}
+	if len(filter.DocumentIDs) > 0 {
+		*args = append(*args, filter.DocumentIDs)
+		conds = append(conds, fmt.Sprintf("document_id = ANY($%d)", len(*args)))
+	}
+	if filter.AllVersions {
+		conds = append(conds, "state <> '"+StateStaged+"'")
+	} else {
+		conds = append(conds, "state = '"+StateCurrent+"'")
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// CorpusVersion returns a counter that changes whenever a write to documents commits
+func (p *PostgresRepository) CorpusVersion(ctx context.Context) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var v int64
+	if err := p.pool.QueryRow(ctx, "SELECT version FROM documents_version").Scan(&v); err != nil {
+		return 0, fmt.Errorf("error reading corpus version: %w", err)
+	}
+	return v, nil
 }
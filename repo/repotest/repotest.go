@@ -0,0 +1,1179 @@
+// Package repotest is a conformance suite for repo.DocumentRepository: every storage backend
+// runs it from its own tests so they all behave alike (search ordering, filters, deletes,
+// sessions...), whatever database sits underneath.
+//
+//	func TestPostgres(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repo.DocumentRepository {
+//			return newEmptyPostgres(t) // a fresh database per subtest
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// dimension is the vector size of the default collection of the suite
+const dimension = 3
+
+// Open returns a repository over an empty store; Run initializes and closes it
+type Open func(t *testing.T) repo.DocumentRepository
+
+// Run checks the repositories returned by open against the DocumentRepository contract, one
+// subtest per area, each with a fresh repository
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, ctx context.Context, r repo.DocumentRepository)
+	}{
+		{"Collections", testCollections},
+		{"SearchOrdering", testSearchOrdering},
+		{"InsertChunks", testInsertChunks},
+		{"Weights", testWeights},
+		{"Filters", testFilters},
+		{"Keyword", testKeyword},
+		{"DeleteBySource", testDeleteBySource},
+		{"SourceVersions", testSourceVersions},
+		{"ContentHash", testContentHash},
+		{"Documents", testDocuments},
+		{"BulkDelete", testBulkDelete},
+		{"Changes", testChanges},
+		{"DocumentEmbeddings", testDocumentEmbeddings},
+		{"Reindex", testReindex},
+		{"ChunkNeighbors", testChunkNeighbors},
+		{"Parents", testParents},
+		{"Versions", testVersions},
+		{"Sessions", testSessions},
+		{"Quarantine", testQuarantine},
+		{"History", testHistory},
+		{"EvalRuns", testEvalRuns},
+		{"Tenants", testTenants},
+		{"Expiry", testExpiry},
+		{"AccessStats", testAccessStats},
+		{"Feedback", testFeedback},
+		{"QueryLog", testQueryLog},
+		{"CorpusVersion", testCorpusVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := open(t)
+			t.Cleanup(func() { _ = r.Close(ctx) })
+			if err := r.Init(ctx); err != nil {
+				t.Fatalf("Init: %v", err)
+			}
+			ensure(t, ctx, r, repo.Collection{Name: repo.DefaultCollection, Model: "test-embed", Dimension: dimension})
+			tt.fn(t, ctx, r)
+		})
+	}
+}
+
+func testCollections(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	ensure(t, ctx, r, repo.Collection{Name: repo.DefaultCollection, Model: "test-embed", Dimension: dimension})
+	if err := r.EnsureCollection(ctx, repo.Collection{Name: repo.DefaultCollection, Model: "test-embed", Dimension: dimension + 1}); err == nil {
+		t.Error("EnsureCollection accepted another dimension for an existing collection")
+	}
+	if err := r.EnsureCollection(ctx, repo.Collection{Name: "Bad-Name", Model: "m", Dimension: 2}); err == nil {
+		t.Error("EnsureCollection accepted an invalid name")
+	}
+
+	var dm *repo.DimensionMismatchError
+	err := r.InsertChunk(ctx, repo.Chunk{Content: "short", Source: "a", Embedding: []float32{1, 0}})
+	if !errors.As(err, &dm) || dm.Got != 2 || dm.Expected != dimension {
+		t.Errorf("InsertChunk of a 2-dimensional vector: got %v, want a DimensionMismatchError", err)
+	}
+	if docs := search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}); len(docs) != 0 {
+		t.Errorf("a rejected insert stored %d chunks", len(docs))
+	}
+
+	var unknown *repo.UnknownCollectionError
+	_, err = r.SearchSimilar(ctx, vec(1, 0), 5, repo.SearchOptions{Filter: repo.SearchFilter{Collection: "missing"}})
+	if !errors.As(err, &unknown) {
+		t.Errorf("SearchSimilar of an unknown collection: got %v, want an UnknownCollectionError", err)
+	}
+
+	// collections are searched separately, even with vectors of the same size
+	ensure(t, ctx, r, repo.Collection{Name: "other", Model: "other-embed", Dimension: 2})
+	insert(t, ctx, r, repo.Chunk{Content: "in other", Source: "o", Embedding: []float32{1, 0}, Collection: "other"})
+	insert(t, ctx, r, repo.Chunk{Content: "in default", Source: "d", Embedding: vec(1, 0, 0)})
+	if got := contents(search(t, ctx, r, []float32{1, 0}, 10, repo.SearchFilter{Collection: "other"})); !slices.Equal(got, []string{"in other"}) {
+		t.Errorf("search of collection other returned %q", got)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, []string{"in default"}) {
+		t.Errorf("search of the default collection returned %q", got)
+	}
+
+	stats, err := r.CollectionStats(ctx)
+	if err != nil || len(stats) != 2 || stats[1].Name != "other" || stats[1].Chunks != 1 {
+		t.Errorf("CollectionStats: %+v, %v; want default and other with 1 chunk", stats, err)
+	}
+	if n, err := r.DropCollection(ctx, "other"); err != nil || n != 1 {
+		t.Errorf("DropCollection: %d, %v; want 1 chunk", n, err)
+	}
+	if _, err := r.SearchSimilar(ctx, []float32{1, 0}, 5, repo.SearchOptions{Filter: repo.SearchFilter{Collection: "other"}}); !errors.As(err, &unknown) {
+		t.Errorf("SearchSimilar of a dropped collection: got %v, want an UnknownCollectionError", err)
+	}
+	if _, err := r.DropCollection(ctx, "other"); !errors.As(err, &unknown) {
+		t.Errorf("DropCollection twice: got %v, want an UnknownCollectionError", err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, []string{"in default"}) {
+		t.Errorf("dropping a collection changed the default one: %q", got)
+	}
+}
+
+func testSearchOrdering(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "far", Source: "s", Embedding: vec(0, 0, 1)},
+		repo.Chunk{Content: "exact", Source: "s", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "close", Source: "s", Embedding: vec(0.9, 0.1, 0)},
+		repo.Chunk{Content: "middle", Source: "s", Embedding: vec(0.5, 0.5, 0)},
+	)
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, []string{"exact", "close", "middle", "far"}) {
+		t.Errorf("results are not ordered by similarity: %q", got)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 2, repo.SearchFilter{})); !slices.Equal(got, []string{"exact", "close"}) {
+		t.Errorf("topK 2 returned %q", got)
+	}
+
+	docs, err := r.SearchSimilar(ctx, vec(1, 0, 0), 1, repo.SearchOptions{Fields: repo.FieldsAll})
+	if err != nil {
+		t.Fatalf("SearchSimilar: %v", err)
+	}
+	if len(docs) != 1 || docs[0].ID == 0 || docs[0].Source != "s" || len(docs[0].Vector.Slice()) != dimension {
+		t.Errorf("FieldsAll returned %+v, want the full row of the exact match", docs)
+	}
+	if len(docs) == 1 && (docs[0].Distance == nil || math.Abs(*docs[0].Distance) > 1e-6) {
+		t.Errorf("the exact match has distance %v, want 0", docs[0].Distance)
+	}
+	docs, err = r.SearchSimilar(ctx, vec(1, 0, 0), 10, repo.SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchSimilar: %v", err)
+	}
+	if len(docs) != 4 || docs[3].Distance == nil || math.Abs(*docs[3].Distance-1) > 1e-6 {
+		t.Errorf("the orthogonal chunk must be at distance 1: got %+v", docs)
+	}
+}
+
+func testInsertChunks(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	if err := r.InsertChunks(ctx, nil); err != nil {
+		t.Errorf("InsertChunks of no chunks: %v", err)
+	}
+	err := r.InsertChunks(ctx, []repo.Chunk{
+		{Content: "valid", Source: "s", Embedding: vec(1, 0, 0)},
+		{Content: "wrong size", Source: "s", Embedding: []float32{1, 0}},
+	})
+	var dm *repo.DimensionMismatchError
+	if !errors.As(err, &dm) {
+		t.Errorf("InsertChunks with a wrong dimension: got %v, want a DimensionMismatchError", err)
+	}
+	if docs := search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}); len(docs) != 0 {
+		t.Errorf("a rejected batch stored %q", contents(docs))
+	}
+
+	// a batch the store rejects partway through is rolled back as a whole
+	docID, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: "tx"})
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	err = r.InsertChunks(ctx, []repo.Chunk{
+		{Content: "before", Source: "tx", Embedding: vec(1, 0, 0), DocumentID: docID},
+		{Content: "unknown document", Source: "tx", Embedding: vec(1, 0.1, 0), DocumentID: docID + 1000},
+		{Content: "after", Source: "tx", Embedding: vec(1, 0.2, 0), DocumentID: docID},
+	})
+	if err == nil {
+		t.Error("InsertChunks with a chunk of an unknown document succeeded")
+	}
+	if docs := search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}); len(docs) != 0 {
+		t.Errorf("a batch failing in the store kept %q", contents(docs))
+	}
+
+	chunks := make([]repo.Chunk, 20)
+	for i := range chunks {
+		chunks[i] = repo.Chunk{Content: "chunk", Source: "bulk", Embedding: vec(1, float32(i), 0), Page: i + 1,
+			Metadata: map[string]string{"i": "x"}, Entities: []repo.Entity{{Text: "Ada", Type: "person"}}}
+	}
+	if err := r.InsertChunks(ctx, chunks); err != nil {
+		t.Fatalf("InsertChunks: %v", err)
+	}
+	docs, err := r.SearchSimilar(ctx, vec(1, 0, 0), 100, repo.SearchOptions{Fields: repo.FieldPage | repo.FieldMetadata | repo.FieldEntities})
+	if err != nil {
+		t.Fatalf("SearchSimilar: %v", err)
+	}
+	if len(docs) != len(chunks) {
+		t.Fatalf("InsertChunks stored %d of %d chunks", len(docs), len(chunks))
+	}
+	if docs[0].Page != 1 || docs[0].Metadata["i"] != "x" || len(docs[0].Entities) != 1 {
+		t.Errorf("InsertChunks lost fields: %+v", docs[0])
+	}
+}
+
+func testWeights(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "best", Source: "demoted", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "second", Source: "kept", Embedding: vec(0.9, 0.2, 0)},
+	)
+	n, err := r.SetWeightBySource(ctx, "", "demoted", 0.1)
+	if err != nil || n != 1 {
+		t.Fatalf("SetWeightBySource: %d, %v; want 1 chunk", n, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 2, repo.SearchFilter{})); !slices.Equal(got, []string{"second", "best"}) {
+		t.Errorf("a demoted chunk still ranks first: %q", got)
+	}
+	docs := search(t, ctx, r, vec(1, 0, 0), 2, repo.SearchFilter{})
+	if n, err := r.SetWeightByID(ctx, docs[1].ID, 1); err != nil || n != 1 {
+		t.Fatalf("SetWeightByID: %d, %v; want 1 chunk", n, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 2, repo.SearchFilter{})); !slices.Equal(got, []string{"best", "second"}) {
+		t.Errorf("restoring the weight did not restore the order: %q", got)
+	}
+	if n, err := r.SetWeightByID(ctx, -1, 2); err != nil || n != 0 {
+		t.Errorf("SetWeightByID of a missing chunk: %d, %v; want 0 chunks", n, err)
+	}
+}
+
+func testFilters(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	day := func(d int) time.Time { return time.Date(2024, time.January, d, 0, 0, 0, 0, time.UTC) }
+	insert(t, ctx, r,
+		repo.Chunk{Content: "ada early", Source: "a", Embedding: vec(1, 0, 0), DocDate: day(1),
+			Entities: []repo.Entity{{Text: "Ada Lovelace", Type: "person"}},
+			Metadata: map[string]string{"author": "ana", repo.MetaTags: "history,math"}},
+		repo.Chunk{Content: "ada late", Source: "b", Embedding: vec(1, 0.1, 0), DocDate: day(20),
+			Entities: []repo.Entity{{Text: "Ada Lovelace", Type: "person"}, {Text: "London", Type: "location"}},
+			Metadata: map[string]string{"author": "bob", repo.MetaTags: "mathematics"}},
+		repo.Chunk{Content: "undated", Source: "c", Embedding: vec(1, 0.2, 0)},
+		repo.Chunk{Content: "private", Source: "d", Embedding: vec(1, 0.3, 0), Session: "s1"},
+	)
+	q := vec(1, 0, 0)
+	cases := []struct {
+		name   string
+		filter repo.SearchFilter
+		want   []string
+	}{
+		{"none", repo.SearchFilter{}, []string{"ada early", "ada late", "undated"}},
+		{"entity", repo.SearchFilter{Entities: []string{"ada lovelace"}}, []string{"ada early", "ada late"}},
+		{"all entities", repo.SearchFilter{Entities: []string{"Ada Lovelace", "london"}}, []string{"ada late"}},
+		{"after", repo.SearchFilter{After: day(20)}, []string{"ada late"}},
+		{"before", repo.SearchFilter{Before: day(20)}, []string{"ada early"}},
+		{"session", repo.SearchFilter{Session: "s1"}, []string{"ada early", "ada late", "undated", "private"}},
+		{"other session", repo.SearchFilter{Session: "s2"}, []string{"ada early", "ada late", "undated"}},
+		{"sources", repo.SearchFilter{Sources: []string{"b", "c"}}, []string{"ada late", "undated"}},
+		{"metadata", repo.SearchFilter{Metadata: map[string]string{"author": "ana"}}, []string{"ada early"}},
+		{"tag", repo.SearchFilter{Tags: []string{"math"}}, []string{"ada early"}},
+		{"all tags", repo.SearchFilter{Tags: []string{"math", "history"}, Sources: []string{"a"}}, []string{"ada early"}},
+	}
+	for _, c := range cases {
+		if got := contents(search(t, ctx, r, q, 10, c.filter)); !slices.Equal(got, c.want) {
+			t.Errorf("filter %s returned %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func testKeyword(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "the invoice total is due", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "invoice", Source: "b", Embedding: vec(0, 1, 0)},
+		repo.Chunk{Content: "nothing relevant here", Source: "c", Embedding: vec(0, 0, 1)},
+	)
+	docs, err := r.SearchKeyword(ctx, "invoice total", 10, repo.SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchKeyword: %v", err)
+	}
+	if got := contents(docs); !slices.Equal(got, []string{"the invoice total is due"}) {
+		t.Errorf("SearchKeyword must match every word: got %q", got)
+	}
+	docs, err = r.SearchKeyword(ctx, "invoice", 10, repo.SearchOptions{})
+	if err != nil {
+		t.Fatalf("SearchKeyword: %v", err)
+	}
+	if len(docs) != 2 {
+		t.Errorf("SearchKeyword of a shared word returned %q", contents(docs))
+	}
+	for _, d := range docs {
+		if d.Distance != nil {
+			t.Errorf("SearchKeyword set a distance on %q", d.Content)
+		}
+	}
+	docs, err = r.SearchKeyword(ctx, "invoice total", 10, repo.SearchOptions{AnyWord: true})
+	if err != nil {
+		t.Fatalf("SearchKeyword with AnyWord: %v", err)
+	}
+	if got := contents(docs); len(got) != 2 || got[0] != "the invoice total is due" {
+		t.Errorf("SearchKeyword with AnyWord must rank the chunk with both words first: got %q", got)
+	}
+}
+
+func testDeleteBySource(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "a1", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "a2", Source: "a", Embedding: vec(0, 1, 0)},
+		repo.Chunk{Content: "b1", Source: "b", Embedding: vec(0, 0, 1)},
+	)
+	n, err := r.DeleteBySource(ctx, "", "a")
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteBySource: %d, %v; want 2 chunks", n, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, []string{"b1"}) {
+		t.Errorf("after deleting source a, search returned %q", got)
+	}
+	if n, err := r.DeleteBySource(ctx, "", "a"); err != nil || n != 0 {
+		t.Errorf("deleting a deleted source: %d, %v; want 0 chunks", n, err)
+	}
+}
+
+func testSourceVersions(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	version := func(want string) {
+		t.Helper()
+		got, err := r.SourceVersion(ctx, "", "doc")
+		if err != nil || got != want {
+			t.Errorf("SourceVersion: %q, %v; want %q", got, err, want)
+		}
+	}
+	version("")
+	for _, v := range []string{"v1", "v2"} {
+		if err := r.SetSourceVersion(ctx, "", "doc", v); err != nil {
+			t.Fatalf("SetSourceVersion: %v", err)
+		}
+		version(v)
+	}
+	if got, err := r.SourceVersion(ctx, "other", "doc"); err != nil || got != "" {
+		t.Errorf("versions leak across collections: %q, %v", got, err)
+	}
+	if err := r.SetSourceVersion(ctx, "", "doc", ""); err != nil {
+		t.Fatalf("SetSourceVersion: %v", err)
+	}
+	version("")
+}
+
+func testContentHash(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	find := func(session, want string) {
+		t.Helper()
+		got, err := r.FindContentHash(ctx, "", session, "h1")
+		if err != nil || got != want {
+			t.Errorf("FindContentHash(session %q): %q, %v; want %q", session, got, err, want)
+		}
+	}
+	find("", "")
+	insert(t, ctx, r, repo.Chunk{Content: "private", Source: "mine", Embedding: vec(1, 0, 0), Session: "s1", ContentHash: "h1"})
+	find("", "")
+	find("s2", "")
+	find("s1", "mine")
+	insert(t, ctx, r, repo.Chunk{Content: "shared", Source: "doc", Embedding: vec(1, 0, 0), ContentHash: "h1"})
+	find("s2", "doc")
+	if got, err := r.FindContentHash(ctx, "", "", "h2"); err != nil || got != "" {
+		t.Errorf("FindContentHash of an unknown hash: %q, %v", got, err)
+	}
+}
+
+func testDocuments(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	create := func(source string) int64 {
+		t.Helper()
+		id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: source, DocType: "legal", ContentHash: "h-" + source})
+		if err != nil {
+			t.Fatalf("CreateDocument: %v", err)
+		}
+		return id
+	}
+	a, b := create("a"), create("b")
+	insert(t, ctx, r,
+		repo.Chunk{Content: "a1", Source: "a", Embedding: vec(1, 0, 0), DocumentID: a},
+		repo.Chunk{Content: "a2", Source: "a", Embedding: vec(0, 1, 0), DocumentID: a},
+		repo.Chunk{Content: "b1", Source: "b", Embedding: vec(0, 0, 1), DocumentID: b},
+	)
+
+	docs, total, err := r.ListDocuments(ctx, repo.ListDocumentsOptions{Limit: 1})
+	if err != nil || total != 2 || len(docs) != 1 || docs[0].ID != b {
+		t.Fatalf("ListDocuments(limit 1): %+v, %d, %v; want document b of 2", docs, total, err)
+	}
+	if docs, _, err = r.ListDocuments(ctx, repo.ListDocumentsOptions{Limit: 10, Offset: 1}); err != nil || len(docs) != 1 || docs[0].ID != a {
+		t.Errorf("ListDocuments(offset 1): %+v, %v; want document a", docs, err)
+	}
+	if _, total, err = r.ListDocuments(ctx, repo.ListDocumentsOptions{Collection: "other", Limit: 10}); err != nil || total != 0 {
+		t.Errorf("documents leak across collections: %d, %v", total, err)
+	}
+
+	doc, found, err := r.GetDocument(ctx, a)
+	if err != nil || !found || doc.Source != "a" || doc.Chunks != 2 || doc.DocType != "legal" || doc.ContentHash != "h-a" || doc.Collection != repo.DefaultCollection {
+		t.Errorf("GetDocument: %+v, %v, %v", doc, found, err)
+	}
+	chunks, err := r.DocumentChunks(ctx, a)
+	if got := contents(chunks); err != nil || !slices.Equal(got, []string{"a1", "a2"}) {
+		t.Errorf("DocumentChunks: %q, %v; want [a1 a2]", got, err)
+	}
+
+	if n, found, err := r.DeleteDocument(ctx, a); err != nil || !found || n != 2 {
+		t.Fatalf("DeleteDocument: %d, %v, %v; want 2 chunks", n, found, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, []string{"b1"}) {
+		t.Errorf("after deleting document a, search returned %q", got)
+	}
+	if _, found, err := r.GetDocument(ctx, a); err != nil || found {
+		t.Errorf("GetDocument of a deleted document: %v, %v", found, err)
+	}
+	if _, found, err := r.DeleteDocument(ctx, a); err != nil || found {
+		t.Errorf("deleting a deleted document: %v, %v", found, err)
+	}
+
+	if _, err := r.DeleteBySource(ctx, "", "b"); err != nil {
+		t.Fatalf("DeleteBySource: %v", err)
+	}
+	if _, found, err := r.GetDocument(ctx, b); err != nil || found {
+		t.Errorf("DeleteBySource kept the document: %v, %v", found, err)
+	}
+}
+
+func testBulkDelete(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	for _, source := range []string{"wiki/a", "wiki/b", "wiki/c", "blog/a"} {
+		id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: source})
+		if err != nil {
+			t.Fatalf("CreateDocument: %v", err)
+		}
+		tags := "old"
+		if source == "wiki/c" {
+			tags = "new"
+		}
+		insert(t, ctx, r, repo.Chunk{Content: source, Source: source, Embedding: vec(1, 0, 0), DocumentID: id,
+			Metadata: map[string]string{repo.MetaTags: tags}})
+	}
+	pr := repo.DocumentPredicate{SourcePrefix: "wiki/", Tags: []string{"old"}}
+	if docs, chunks, err := r.CountMatchingDocuments(ctx, pr); err != nil || docs != 2 || chunks != 2 {
+		t.Errorf("CountMatchingDocuments: %d documents, %d chunks, %v; want 2 and 2", docs, chunks, err)
+	}
+	if docs, _, err := r.CountMatchingDocuments(ctx, repo.DocumentPredicate{UploadedBefore: time.Now().Add(-time.Hour)}); err != nil || docs != 0 {
+		t.Errorf("CountMatchingDocuments counted documents uploaded later: %d, %v", docs, err)
+	}
+	if docs, _, err := r.CountMatchingDocuments(repo.WithTenant(ctx, "alice"), repo.DocumentPredicate{}); err != nil || docs != 0 {
+		t.Errorf("CountMatchingDocuments counted documents of another tenant: %d, %v", docs, err)
+	}
+
+	if docs, chunks, err := r.DeleteMatchingDocuments(ctx, pr, 1); err != nil || docs != 1 || chunks != 1 {
+		t.Fatalf("DeleteMatchingDocuments(limit 1): %d documents, %d chunks, %v", docs, chunks, err)
+	}
+	if docs, _, err := r.DeleteMatchingDocuments(ctx, pr, 10); err != nil || docs != 1 {
+		t.Fatalf("DeleteMatchingDocuments: %d documents, %v; want the other match", docs, err)
+	}
+	got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}))
+	if slices.Sort(got); !slices.Equal(got, []string{"blog/a", "wiki/c"}) {
+		t.Errorf("after the bulk delete search returned %q", got)
+	}
+}
+
+func testChanges(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: "a"})
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	insert(t, ctx, r, repo.Chunk{Content: "a1", Source: "a", Embedding: vec(1, 0, 0), DocumentID: id})
+	if _, _, err := r.DeleteDocument(ctx, id); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+
+	var ops []string
+	var cursor repo.ChangeCursor
+	for range 4 {
+		changes, err := r.DocumentChanges(ctx, cursor, "", 1)
+		if err != nil {
+			t.Fatalf("DocumentChanges: %v", err)
+		}
+		if len(changes) == 0 {
+			break
+		}
+		if ch := changes[0]; ch.DocumentID != id || ch.Source != "a" || ch.Collection != repo.DefaultCollection {
+			t.Errorf("DocumentChanges returned %+v; want a change of document a", ch)
+		}
+		ops = append(ops, changes[0].Op)
+		cursor = changes[0].Cursor
+	}
+	if want := []string{repo.ChangeInsert, repo.ChangeUpdate, repo.ChangeDelete}; !slices.Equal(ops, want) {
+		t.Errorf("paging the change feed read %q; want %q", ops, want)
+	}
+	if c, err := repo.ParseChangeCursor(cursor.String()); err != nil || c != cursor {
+		t.Errorf("ParseChangeCursor(%q): %+v, %v", cursor, c, err)
+	}
+
+	if changes, err := r.DocumentChanges(ctx, repo.ChangeCursor{}, "other", 10); err != nil || len(changes) != 0 {
+		t.Errorf("changes leak across collections: %+v, %v", changes, err)
+	}
+	if changes, err := r.DocumentChanges(repo.WithTenant(ctx, "alice"), repo.ChangeCursor{}, "", 10); err != nil || len(changes) != 0 {
+		t.Errorf("changes leak across tenants: %+v, %v", changes, err)
+	}
+}
+
+func testDocumentEmbeddings(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	ids := map[string]int64{}
+	for _, source := range []string{"a", "b", "c"} {
+		id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: source})
+		if err != nil {
+			t.Fatalf("CreateDocument: %v", err)
+		}
+		ids[source] = id
+	}
+	insert(t, ctx, r,
+		repo.Chunk{Content: "a1", Source: "a", Embedding: vec(1, 0, 0), DocumentID: ids["a"]},
+		repo.Chunk{Content: "b1", Source: "b", Embedding: vec(0.9, 0.1, 0), DocumentID: ids["b"]},
+		repo.Chunk{Content: "c1", Source: "c", Embedding: vec(0, 0, 1), DocumentID: ids["c"]},
+	)
+	for source, emb := range map[string][]float32{"a": vec(1, 0, 0), "b": vec(0.9, 0.1, 0)} {
+		if err := r.SetDocumentEmbedding(ctx, ids[source], emb); err != nil {
+			t.Fatalf("SetDocumentEmbedding: %v", err)
+		}
+	}
+	if err := r.SetDocumentEmbedding(ctx, ids["c"], []float32{1, 0}); err == nil {
+		t.Error("SetDocumentEmbedding accepted a vector of another dimension")
+	}
+	if emb, err := r.DocumentEmbedding(ctx, ids["c"]); err != nil || emb != nil {
+		t.Errorf("DocumentEmbedding of a document without one: %v, %v", emb, err)
+	}
+
+	// c has no embedding and is never returned
+	docs, err := r.SimilarDocuments(ctx, vec(1, 0, 0), 10, repo.SimilarDocumentsOptions{})
+	if err != nil || len(docs) != 2 || docs[0].Source != "a" || docs[1].Source != "b" || docs[0].Score < docs[1].Score {
+		t.Fatalf("SimilarDocuments: %+v, %v; want a then b", docs, err)
+	}
+	if docs, err = r.SimilarDocuments(ctx, vec(1, 0, 0), 10, repo.SimilarDocumentsOptions{ExcludeSource: "a"}); err != nil || len(docs) != 1 || docs[0].Source != "b" {
+		t.Errorf("SimilarDocuments excluding a: %+v, %v; want b", docs, err)
+	}
+
+	filter := repo.SearchFilter{DocumentIDs: []int64{ids["c"]}}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, filter)); !slices.Equal(got, []string{"c1"}) {
+		t.Errorf("search restricted to document c returned %q", got)
+	}
+}
+
+func testReindex(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "a", Source: "s", Embedding: vec(1, 0, 0), EmbeddingModel: "test-embed"},
+		repo.Chunk{Content: "b", Source: "s", Embedding: vec(0, 1, 0), EmbeddingModel: "test-embed"},
+	)
+	next := repo.Collection{Name: repo.DefaultCollection, Model: "next-embed", Dimension: 2}
+	var mm *repo.ModelMismatchError
+	if err := r.EnsureCollection(ctx, next); !errors.As(err, &mm) || mm.StoredModel != "test-embed" || mm.StoredDimension != dimension {
+		t.Fatalf("EnsureCollection with another model: got %v, want a ModelMismatchError", err)
+	}
+	if n, err := r.CountStaleChunks(ctx, next); err != nil || n != 2 {
+		t.Fatalf("CountStaleChunks: %d, %v; want 2", n, err)
+	}
+
+	reembed := func(want int) {
+		t.Helper()
+		pending, err := r.PendingReindex(ctx, next, 10)
+		if err != nil || len(pending) != want {
+			t.Fatalf("PendingReindex: %d chunks, %v; want %d", len(pending), err, want)
+		}
+		ids := make([]int, len(pending))
+		embs := make([][]float32, len(pending))
+		for i, c := range pending {
+			ids[i] = c.ID
+			embs[i] = []float32{1, float32(i)}
+		}
+		if err := r.SetPendingEmbeddings(ctx, next.Model, ids, embs); err != nil {
+			t.Fatalf("SetPendingEmbeddings: %v", err)
+		}
+	}
+	reembed(2)
+	// a chunk stored meanwhile with the old model holds the swap back
+	insert(t, ctx, r, repo.Chunk{Content: "c", Source: "s", Embedding: vec(0, 0, 1), EmbeddingModel: "test-embed"})
+	if swapped, err := r.SwapEmbeddings(ctx, next); err != nil || swapped {
+		t.Fatalf("SwapEmbeddings with a chunk left: %v, %v; want false", swapped, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); len(got) != 3 {
+		t.Errorf("before the swap, search with the old model returned %q", got)
+	}
+	reembed(1)
+	if swapped, err := r.SwapEmbeddings(ctx, next); err != nil || !swapped {
+		t.Fatalf("SwapEmbeddings: %v, %v; want true", swapped, err)
+	}
+
+	if got := contents(search(t, ctx, r, []float32{1, 0}, 10, repo.SearchFilter{})); len(got) != 3 {
+		t.Errorf("after the swap, search with the new model returned %q", got)
+	}
+	if n, err := r.CountStaleChunks(ctx, next); err != nil || n != 0 {
+		t.Errorf("CountStaleChunks after the swap: %d, %v; want 0", n, err)
+	}
+	ensure(t, ctx, r, next)
+}
+
+func testChunkNeighbors(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: "long"})
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	for i, c := range []string{"p1", "p2", "p3", "p4", "p5", "p6"} {
+		insert(t, ctx, r, repo.Chunk{Content: c, Source: "long", Embedding: vec(1, float32(i), 0), DocumentID: id, Position: i + 1})
+	}
+	insert(t, ctx, r, repo.Chunk{Content: "figure", Source: "long", Embedding: vec(0, 0, 1), DocumentID: id})
+
+	chunks, err := r.ChunkNeighbors(ctx, []repo.ChunkRef{{DocumentID: id, Position: 2}, {DocumentID: id, Position: 3}, {DocumentID: id, Position: 6}}, 1)
+	if got := contents(chunks); err != nil || !slices.Equal(got, []string{"p1", "p2", "p3", "p4", "p5", "p6"}) {
+		t.Errorf("ChunkNeighbors(2, 3, 6 ±1): %q, %v; want p1 to p6 once each", got, err)
+	}
+	chunks, err = r.ChunkNeighbors(ctx, []repo.ChunkRef{{DocumentID: id, Position: 1}}, 1)
+	if err != nil || len(chunks) != 2 || chunks[0].Position != 1 || chunks[1].Position != 2 || chunks[1].DocumentID != id {
+		t.Errorf("ChunkNeighbors(1 ±1): %+v, %v; want positions 1 and 2", chunks, err)
+	}
+
+	docs, err := r.SearchSimilar(ctx, vec(1, 0, 0), 1, repo.SearchOptions{Fields: repo.FieldPosition})
+	if err != nil || len(docs) != 1 || docs[0].Position != 1 {
+		t.Errorf("SearchSimilar with FieldPosition: %+v, %v; want position 1", docs, err)
+	}
+}
+
+func testParents(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: "manual"})
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	parents, err := r.InsertParents(ctx, id, []string{"section one", "section two"})
+	if err != nil || len(parents) != 2 {
+		t.Fatalf("InsertParents: %v, %v; want 2 IDs", parents, err)
+	}
+	insert(t, ctx, r,
+		repo.Chunk{Content: "one", Source: "manual", Embedding: vec(1, 0, 0), DocumentID: id, Position: 1, ParentID: parents[0]},
+		repo.Chunk{Content: "two", Source: "manual", Embedding: vec(0, 1, 0), DocumentID: id, Position: 2, ParentID: parents[1]},
+		repo.Chunk{Content: "orphan", Source: "other", Embedding: vec(0, 0, 1)},
+	)
+	docs, err := r.SearchSimilar(ctx, vec(0, 1, 0), 3, repo.SearchOptions{Fields: repo.FieldParent})
+	if err != nil || len(docs) != 3 || docs[0].ParentID != parents[1] {
+		t.Fatalf("SearchSimilar with FieldParent: %+v, %v; want the second section first", docs, err)
+	}
+	for _, d := range docs[1:] {
+		if d.Content == "orphan" && d.ParentID != 0 {
+			t.Errorf("a chunk without a parent has parent %d", d.ParentID)
+		}
+	}
+	got, err := r.ParentChunks(ctx, parents)
+	if err != nil || got[parents[0]] != "section one" || got[parents[1]] != "section two" {
+		t.Errorf("ParentChunks: %v, %v; want both sections in order of insertion", got, err)
+	}
+	if got, err := r.ParentChunks(repo.WithTenant(ctx, "alice"), parents); err != nil || len(got) != 0 {
+		t.Errorf("ParentChunks of another tenant: %v, %v; want none", got, err)
+	}
+	if _, err := r.InsertParents(repo.WithTenant(ctx, "alice"), id, []string{"intruder"}); err == nil {
+		t.Error("InsertParents accepted a document of another tenant")
+	}
+	if _, _, err := r.DeleteDocument(ctx, id); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	if got, err := r.ParentChunks(ctx, parents); err != nil || len(got) != 0 {
+		t.Errorf("ParentChunks after DeleteDocument: %v, %v; want none", got, err)
+	}
+}
+
+func testVersions(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	index := func(version int, content string) int64 {
+		t.Helper()
+		state := repo.StateCurrent
+		if version > 1 {
+			state = repo.StateStaged
+		}
+		id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: "manual", Version: version, State: state, ContentHash: content})
+		if err != nil {
+			t.Fatalf("CreateDocument: %v", err)
+		}
+		insert(t, ctx, r, repo.Chunk{Content: content, Source: "manual", Embedding: vec(1, 0, 0), DocumentID: id, ContentHash: content})
+		return id
+	}
+	current := func(f repo.SearchFilter) []string {
+		t.Helper()
+		got := contents(search(t, ctx, r, vec(1, 0, 0), 10, f))
+		slices.Sort(got)
+		return got
+	}
+
+	index(1, "v1")
+	if v, err := r.LatestVersion(ctx, "", "", "manual"); err != nil || v != 1 {
+		t.Fatalf("LatestVersion: %d, %v; want 1", v, err)
+	}
+	v2 := index(2, "v2")
+	if got := current(repo.SearchFilter{}); !slices.Equal(got, []string{"v1"}) {
+		t.Errorf("a staged version is searched: %q", got)
+	}
+	if n, err := r.PublishDocument(ctx, v2, 1); err != nil || n != 1 {
+		t.Fatalf("PublishDocument(v2): %d, %v; want 1 retired", n, err)
+	}
+	if got := current(repo.SearchFilter{}); !slices.Equal(got, []string{"v2"}) {
+		t.Errorf("after publishing v2, search returned %q", got)
+	}
+	if got := current(repo.SearchFilter{AllVersions: true}); !slices.Equal(got, []string{"v1", "v2"}) {
+		t.Errorf("search of all versions returned %q", got)
+	}
+	if src, err := r.FindContentHash(ctx, "", "", "v1"); err != nil || src != "" {
+		t.Errorf("FindContentHash of a retired version: %q, %v; want none", src, err)
+	}
+
+	// with one version kept, publishing v3 deletes v1
+	v3 := index(3, "v3")
+	if _, err := r.PublishDocument(ctx, v3, 1); err != nil {
+		t.Fatalf("PublishDocument(v3): %v", err)
+	}
+	if got := current(repo.SearchFilter{AllVersions: true}); !slices.Equal(got, []string{"v2", "v3"}) {
+		t.Errorf("after publishing v3, search of all versions returned %q", got)
+	}
+	doc, found, err := r.GetDocument(ctx, v2)
+	if err != nil || !found || doc.State != repo.StateRetired || doc.RetiredAt == nil || doc.Version != 2 {
+		t.Errorf("GetDocument of the retired version: %+v, %v, %v", doc, found, err)
+	}
+}
+
+func testSessions(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	for _, id := range []string{"s1", "s2"} {
+		if err := r.TouchSession(ctx, id); err != nil {
+			t.Fatalf("TouchSession: %v", err)
+		}
+	}
+	insert(t, ctx, r,
+		repo.Chunk{Content: "shared", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "s1 a", Source: "b", Embedding: vec(1, 0.1, 0), Session: "s1"},
+		repo.Chunk{Content: "s1 b", Source: "c", Embedding: vec(1, 0.2, 0), Session: "s1"},
+		repo.Chunk{Content: "s2", Source: "d", Embedding: vec(1, 0.3, 0), Session: "s2"},
+	)
+	if err := r.Quarantine(ctx, repo.Chunk{Content: "s1 broken", Source: "e", Session: "s1"}, errors.New("boom")); err != nil {
+		t.Fatalf("Quarantine: %v", err)
+	}
+
+	idle, err := r.IdleSessions(ctx, time.Hour)
+	if err != nil || len(idle) != 0 {
+		t.Errorf("IdleSessions(1h) right after activity: %q, %v", idle, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	idle, err = r.IdleSessions(ctx, time.Millisecond)
+	if err != nil || !slices.Contains(idle, "s1") || !slices.Contains(idle, "s2") {
+		t.Errorf("IdleSessions(1ms): %q, %v; want s1 and s2", idle, err)
+	}
+
+	// ending a session removes its chunks, its quarantined chunks and the session itself
+	n, err := r.EndSession(ctx, "s1")
+	if err != nil || n != 2 {
+		t.Fatalf("EndSession: %d, %v; want 2 chunks", n, err)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{Session: "s1"})); !slices.Equal(got, []string{"shared"}) {
+		t.Errorf("after EndSession the session still retrieves %q", got)
+	}
+	if got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{Session: "s2"})); !slices.Equal(got, []string{"shared", "s2"}) {
+		t.Errorf("ending s1 changed s2: %q", got)
+	}
+	if q, err := r.QuarantinedChunks(ctx, nil); err != nil || len(q) != 0 {
+		t.Errorf("EndSession left %d quarantined chunks (%v)", len(q), err)
+	}
+	if idle, err := r.IdleSessions(ctx, time.Millisecond); err != nil || slices.Contains(idle, "s1") {
+		t.Errorf("an ended session is still listed: %q, %v", idle, err)
+	}
+
+	temperature := 0.2
+	want := repo.SessionSettings{Model: "m", Temperature: &temperature, Collection: repo.DefaultCollection,
+		Tags: []string{"math"}, Metadata: map[string]string{"author": "ana"}}
+	if err := r.SetSessionSettings(ctx, "s3", want); err != nil {
+		t.Fatalf("SetSessionSettings: %v", err)
+	}
+	got, err := r.SessionSettings(ctx, "s3")
+	if err != nil || got.Model != "m" || got.Temperature == nil || *got.Temperature != temperature ||
+		got.Collection != repo.DefaultCollection || !slices.Equal(got.Tags, want.Tags) || got.Metadata["author"] != "ana" {
+		t.Errorf("SessionSettings: %+v, %v; want %+v", got, err, want)
+	}
+	if got, err := r.SessionSettings(repo.WithTenant(ctx, "alice"), "s3"); err != nil || got.Model != "" {
+		t.Errorf("another tenant read the session settings: %+v, %v", got, err)
+	}
+	if got, err := r.SessionSettings(ctx, "unknown"); err != nil || got.Model != "" {
+		t.Errorf("SessionSettings of an unknown session: %+v, %v", got, err)
+	}
+}
+
+func testQuarantine(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	chunk := repo.Chunk{Content: "bad chunk", Source: "a", Page: 2, Section: "Intro",
+		Metadata: map[string]string{"k": "v"}, Entities: []repo.Entity{{Text: "Ada", Type: "person"}}}
+	for _, c := range []repo.Chunk{chunk, {Content: "other", Source: "b"}} {
+		if err := r.Quarantine(ctx, c, errors.New("embedding failed")); err != nil {
+			t.Fatalf("Quarantine: %v", err)
+		}
+	}
+	all, err := r.QuarantinedChunks(ctx, nil)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("QuarantinedChunks: %d, %v; want 2", len(all), err)
+	}
+	got := all[0]
+	if got.Chunk.Content != chunk.Content || got.Chunk.Page != 2 || got.Chunk.Section != "Intro" ||
+		got.Chunk.Metadata["k"] != "v" || len(got.Chunk.Entities) != 1 || got.Error != "embedding failed" {
+		t.Errorf("quarantined chunk lost data: %+v", got)
+	}
+
+	if err := r.FailQuarantined(ctx, got.ID, errors.New("still failing")); err != nil {
+		t.Fatalf("FailQuarantined: %v", err)
+	}
+	one, err := r.QuarantinedChunks(ctx, []int64{got.ID})
+	if err != nil || len(one) != 1 || one[0].Error != "still failing" || one[0].Attempts != got.Attempts+1 {
+		t.Errorf("after FailQuarantined: %+v, %v", one, err)
+	}
+
+	if err := r.ResolveQuarantined(ctx, got.ID); err != nil {
+		t.Fatalf("ResolveQuarantined: %v", err)
+	}
+	if rest, err := r.QuarantinedChunks(ctx, nil); err != nil || len(rest) != 1 || rest[0].ID == got.ID {
+		t.Errorf("after ResolveQuarantined: %+v, %v", rest, err)
+	}
+}
+
+func testHistory(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	save := func(user, question string, emb []float32) int64 {
+		t.Helper()
+		id, err := r.SaveAnswer(ctx, repo.HistoryEntry{User: user, Question: question, Answer: "answer to " + question, Model: "test-embed", Embedding: emb})
+		if err != nil {
+			t.Fatalf("SaveAnswer: %v", err)
+		}
+		return id
+	}
+	first := save("u1", "first", vec(1, 0, 0))
+	save("u1", "second", vec(0, 1, 0))
+	save("u2", "other user", vec(1, 0, 0))
+
+	recent, err := r.RecentHistory(ctx, "u1", 10)
+	if err != nil {
+		t.Fatalf("RecentHistory: %v", err)
+	}
+	if got := questions(recent); len(got) != 2 || !slices.Contains(got, "first") || !slices.Contains(got, "second") {
+		t.Errorf("RecentHistory of u1 returned %q", got)
+	}
+
+	found, err := r.SearchHistory(ctx, "u1", "test-embed", vec(1, 0, 0), 10)
+	if err != nil {
+		t.Fatalf("SearchHistory: %v", err)
+	}
+	if got := questions(found); !slices.Equal(got, []string{"first", "second"}) || found[0].Distance > found[1].Distance {
+		t.Errorf("SearchHistory returned %q, want the closest entry of u1 first", got)
+	}
+	if found, err := r.SearchHistory(ctx, "u1", "another-model", vec(1, 0, 0), 10); err != nil || len(found) != 0 {
+		t.Errorf("SearchHistory compared entries of another model: %q, %v", questions(found), err)
+	}
+
+	if e, ok, err := r.RateAnswer(ctx, "u1", first, 1); err != nil || !ok || e.Rating != 1 || e.Question != "first" {
+		t.Errorf("RateAnswer: %+v, %v, %v", e, ok, err)
+	}
+	if _, ok, err := r.RateAnswer(ctx, "u2", first, -1); err != nil || ok {
+		t.Errorf("a user rated another user's answer: %v, %v", ok, err)
+	}
+
+	for _, q := range []string{"s1 one", "s1 two", "s1 three"} {
+		if _, err := r.SaveAnswer(ctx, repo.HistoryEntry{User: "u1", Session: "s1", Question: q, Answer: "a", Model: "test-embed", Embedding: vec(1, 0, 0)}); err != nil {
+			t.Fatalf("SaveAnswer: %v", err)
+		}
+	}
+	conversation, err := r.SessionHistory(ctx, "u1", "s1", 2)
+	if err != nil {
+		t.Fatalf("SessionHistory: %v", err)
+	}
+	if got := questions(conversation); !slices.Equal(got, []string{"s1 two", "s1 three"}) {
+		t.Errorf("SessionHistory returned %q, want the latest entries of the session oldest first", got)
+	}
+	if other, err := r.SessionHistory(ctx, "u2", "s1", 10); err != nil || len(other) != 0 {
+		t.Errorf("SessionHistory returned entries of another user: %q, %v", questions(other), err)
+	}
+}
+
+func testFeedback(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "right", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "wrong", Source: "b", Embedding: vec(0, 1, 0)},
+	)
+	ids := map[string]int{}
+	for _, d := range search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}) {
+		ids[d.Content] = d.ID
+	}
+	chunkIDs := []int{ids["wrong"], ids["right"]}
+	clicked, err := r.SaveAnswer(ctx, repo.HistoryEntry{User: "u1", Question: "clicked", Answer: "a", Model: "m", Embedding: vec(1, 0, 0), ChunkIDs: chunkIDs})
+	if err != nil {
+		t.Fatalf("SaveAnswer: %v", err)
+	}
+	if _, err := r.SaveAnswer(ctx, repo.HistoryEntry{User: "u1", Question: "ignored", Answer: "a", Model: "m", Embedding: vec(1, 0, 0), ChunkIDs: chunkIDs}); err != nil {
+		t.Fatalf("SaveAnswer: %v", err)
+	}
+	if ok, err := r.ClickPassages(ctx, "u1", clicked, []int{2, 2, 7}); err != nil || !ok {
+		t.Fatalf("ClickPassages: %v, %v", ok, err)
+	}
+	if ok, err := r.ClickPassages(ctx, "u2", clicked, []int{1}); err != nil || ok {
+		t.Errorf("a user clicked another user's answer: %v, %v", ok, err)
+	}
+
+	entries, err := r.FeedbackHistory(ctx)
+	if err != nil {
+		t.Fatalf("FeedbackHistory: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Question != "clicked" || !slices.Equal(entries[0].ChunkIDs, chunkIDs) || !slices.Equal(entries[0].Clicked, []int{2}) {
+		t.Errorf("FeedbackHistory: %+v; want the clicked entry with passage 2", entries)
+	}
+	chunks, err := r.ChunksByID(ctx, []int{ids["right"], -1})
+	if err != nil || len(chunks) != 1 || chunks[0].Content != "right" {
+		t.Errorf("ChunksByID: %+v, %v", chunks, err)
+	}
+}
+
+func testEvalRuns(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	for _, hit := range []float64{0.5, 0.7, 0.9} {
+		run := repo.EvalRun{Questions: 10, K: 5, HitRate: hit, MRR: hit / 2, Recall: hit, EmbeddingModel: "test-embed", Missed: []string{"q"}}
+		if err := r.SaveEvalRun(ctx, run); err != nil {
+			t.Fatalf("SaveEvalRun: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	runs, err := r.EvalRuns(ctx, 2)
+	if err != nil {
+		t.Fatalf("EvalRuns: %v", err)
+	}
+	if len(runs) != 2 || runs[0].HitRate != 0.7 || runs[1].HitRate != 0.9 {
+		t.Errorf("EvalRuns(2) must return the latest runs oldest first: %+v", runs)
+	}
+	if len(runs) > 0 && !slices.Equal(runs[0].Missed, []string{"q"}) {
+		t.Errorf("EvalRuns lost the missed questions: %q", runs[0].Missed)
+	}
+}
+
+func testTenants(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	alice, bob := repo.WithTenant(ctx, "alice"), repo.WithTenant(ctx, "bob")
+	for _, tctx := range []context.Context{alice, bob} {
+		ensure(t, tctx, r, repo.Collection{Name: repo.DefaultCollection, Model: "test-embed", Dimension: dimension})
+	}
+	id, err := r.CreateDocument(alice, repo.IndexedDocument{Source: "a"})
+	if err != nil {
+		t.Fatalf("CreateDocument: %v", err)
+	}
+	insert(t, alice, r, repo.Chunk{Content: "alice", Source: "a", Embedding: vec(1, 0, 0), DocumentID: id})
+	insert(t, ctx, r, repo.Chunk{Content: "unscoped", Source: "a", Embedding: vec(1, 0, 0)})
+
+	for name, tc := range map[string]struct {
+		ctx  context.Context
+		want []string
+	}{"alice": {alice, []string{"alice"}}, "bob": {bob, []string{}}, "unscoped": {ctx, []string{"unscoped"}}} {
+		if got := contents(search(t, tc.ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})); !slices.Equal(got, tc.want) {
+			t.Errorf("%s retrieves %q, want %q", name, got, tc.want)
+		}
+	}
+	// documents of another tenant are not found by ID
+	if doc, ok, err := r.GetDocument(alice, id); err != nil || !ok || doc.Collection != repo.DefaultCollection {
+		t.Errorf("GetDocument by its tenant: %+v, %t, %v", doc, ok, err)
+	}
+	if _, ok, err := r.GetDocument(bob, id); err != nil || ok {
+		t.Errorf("GetDocument by another tenant: %t, %v; want not found", ok, err)
+	}
+	if _, ok, err := r.DeleteDocument(bob, id); err != nil || ok {
+		t.Errorf("DeleteDocument by another tenant: %t, %v; want not found", ok, err)
+	}
+	if docs, total, err := r.ListDocuments(bob, repo.ListDocumentsOptions{Limit: 10}); err != nil || total != 0 || len(docs) != 0 {
+		t.Errorf("ListDocuments of another tenant: %d documents, %v", total, err)
+	}
+	// a stored name of another tenant is no collection
+	if _, err := r.SearchSimilar(ctx, vec(1, 0, 0), 10, repo.SearchOptions{Filter: repo.SearchFilter{Collection: "alice__default"}}); err == nil {
+		t.Error("SearchSimilar accepted the stored name of a tenant collection")
+	}
+	if cols, err := r.Collections(bob); err != nil || len(cols) != 1 || cols[0].Name != repo.DefaultCollection {
+		t.Errorf("Collections of a tenant: %+v, %v; want its default collection only", cols, err)
+	}
+
+	// sessions with the same ID are distinct per tenant
+	for _, tctx := range []context.Context{alice, bob} {
+		if err := r.TouchSession(tctx, "s"); err != nil {
+			t.Fatalf("TouchSession: %v", err)
+		}
+	}
+	insert(t, alice, r, repo.Chunk{Content: "alice session", Source: "b", Embedding: vec(1, 0, 0), Session: "s"})
+	if n, err := r.EndSession(bob, "s"); err != nil || n != 0 {
+		t.Errorf("EndSession by another tenant deleted %d chunks (%v)", n, err)
+	}
+	if got := contents(search(t, alice, r, vec(1, 0, 0), 10, repo.SearchFilter{Session: "s"})); len(got) != 2 {
+		t.Errorf("alice's session retrieves %q after bob ended his", got)
+	}
+}
+
+func testExpiry(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	ids := map[string]int64{}
+	for source, expires := range map[string]*time.Time{"expired": &past, "later": &future, "kept": nil} {
+		id, err := r.CreateDocument(ctx, repo.IndexedDocument{Source: source, ExpiresAt: expires})
+		if err != nil {
+			t.Fatalf("CreateDocument: %v", err)
+		}
+		ids[source] = id
+		insert(t, ctx, r, repo.Chunk{Content: source, Source: source, Embedding: vec(1, 0, 0), DocumentID: id})
+	}
+	if err := r.Quarantine(ctx, repo.Chunk{Content: "broken", Source: "expired", DocumentID: ids["expired"]}, errors.New("boom")); err != nil {
+		t.Fatalf("Quarantine: %v", err)
+	}
+	if doc, _, err := r.GetDocument(ctx, ids["later"]); err != nil || doc.ExpiresAt == nil || !doc.ExpiresAt.Equal(future.Truncate(time.Microsecond)) {
+		t.Errorf("GetDocument: ExpiresAt %v, %v; want %v", doc.ExpiresAt, err, future)
+	}
+
+	n, err := r.DeleteExpired(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteExpired: %d, %v; want 1 chunk", n, err)
+	}
+	got := contents(search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}))
+	if slices.Sort(got); !slices.Equal(got, []string{"kept", "later"}) {
+		t.Errorf("after DeleteExpired the corpus holds %q", got)
+	}
+	if _, ok, err := r.GetDocument(ctx, ids["expired"]); err != nil || ok {
+		t.Errorf("GetDocument of an expired document: %t, %v; want not found", ok, err)
+	}
+	if q, err := r.QuarantinedChunks(ctx, nil); err != nil || len(q) != 0 {
+		t.Errorf("DeleteExpired left %d quarantined chunks (%v)", len(q), err)
+	}
+}
+
+func testAccessStats(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	insert(t, ctx, r,
+		repo.Chunk{Content: "hot", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "warm", Source: "a", Embedding: vec(0, 1, 0)},
+		repo.Chunk{Content: "cold 1", Source: "b", Embedding: vec(0, 0, 1)},
+		repo.Chunk{Content: "cold 2", Source: "b", Embedding: vec(0, 0, 1)},
+	)
+	ids := map[string]int{}
+	for _, d := range search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{}) {
+		ids[d.Content] = d.ID
+	}
+	for _, batch := range [][]int{{ids["hot"], ids["warm"]}, {ids["hot"]}, nil} {
+		if err := r.RecordRetrievals(ctx, batch); err != nil {
+			t.Fatalf("RecordRetrievals: %v", err)
+		}
+	}
+	report, err := r.AccessReport(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("AccessReport: %v", err)
+	}
+	if report.Chunks != 4 || report.RetrievedChunks != 2 {
+		t.Errorf("AccessReport counts %d chunks, %d retrieved; want 4, 2", report.Chunks, report.RetrievedChunks)
+	}
+	if len(report.Hot) != 2 || report.Hot[0].Excerpt != "hot" || report.Hot[0].Retrievals != 2 || report.Hot[1].Retrievals != 1 {
+		t.Errorf("AccessReport hot chunks: %+v", report.Hot)
+	}
+	if len(report.Cold) != 1 || report.Cold[0].Source != "b" || report.Cold[0].Chunks != 2 {
+		t.Errorf("AccessReport cold sources: %+v; want b with 2 chunks", report.Cold)
+	}
+	var unknown *repo.UnknownCollectionError
+	if _, err := r.AccessReport(ctx, "missing", 10); !errors.As(err, &unknown) {
+		t.Errorf("AccessReport of an unknown collection: got %v, want an UnknownCollectionError", err)
+	}
+}
+
+func ensure(t *testing.T, ctx context.Context, r repo.DocumentRepository, c repo.Collection) {
+	t.Helper()
+	if err := r.EnsureCollection(ctx, c); err != nil {
+		t.Fatalf("EnsureCollection(%s): %v", c.Name, err)
+	}
+}
+
+func insert(t *testing.T, ctx context.Context, r repo.DocumentRepository, chunks ...repo.Chunk) {
+	t.Helper()
+	for _, c := range chunks {
+		if err := r.InsertChunk(ctx, c); err != nil {
+			t.Fatalf("InsertChunk(%q): %v", c.Content, err)
+		}
+	}
+}
+
+func search(t *testing.T, ctx context.Context, r repo.DocumentRepository, q []float32, k int, f repo.SearchFilter) []repo.Document {
+	t.Helper()
+	docs, err := r.SearchSimilar(ctx, q, k, repo.SearchOptions{Filter: f})
+	if err != nil {
+		t.Fatalf("SearchSimilar: %v", err)
+	}
+	return docs
+}
+
+func vec(v ...float32) []float32 { return v }
+
+func contents(docs []repo.Document) []string {
+	out := make([]string, len(docs))
+	for i, d := range docs {
+		out[i] = d.Content
+	}
+	return out
+}
+
+func questions(entries []repo.HistoryEntry) []string {
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		out[i] = e.Question
+	}
+	return out
+}
+
+func testQueryLog(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	alice := repo.WithTenant(ctx, "alice")
+	ensure(t, alice, r, repo.Collection{Name: repo.DefaultCollection, Model: "test-embed", Dimension: dimension})
+	for _, q := range []string{"first", "second", "first"} {
+		if err := r.LogQuery(ctx, repo.DefaultCollection, q); err != nil {
+			t.Fatalf("LogQuery: %v", err)
+		}
+	}
+	if err := r.LogQuery(alice, repo.DefaultCollection, "tenant"); err != nil {
+		t.Fatalf("LogQuery: %v", err)
+	}
+	recent, err := r.RecentQueries(ctx, 2)
+	if err != nil {
+		t.Fatalf("RecentQueries: %v", err)
+	}
+	if len(recent) != 2 || recent[0].Query != "first" || recent[1].Query != "second" || recent[0].Collection != repo.DefaultCollection {
+		t.Errorf("RecentQueries(2) must return the latest questions of the tenant: %+v", recent)
+	}
+	if recent, err := r.RecentQueries(alice, 10); err != nil || len(recent) != 1 || recent[0].Collection != repo.DefaultCollection {
+		t.Errorf("RecentQueries of a tenant: %+v, %v; want its question under the public collection name", recent, err)
+	}
+	if n, err := r.PruneQueryLog(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PruneQueryLog of an hour ago: %d, %v; want nothing pruned", n, err)
+	}
+	if n, err := r.PruneQueryLog(ctx, time.Now().Add(time.Hour)); err != nil || n != 4 {
+		t.Errorf("PruneQueryLog: %d, %v; want the 4 questions of every tenant pruned", n, err)
+	}
+	if recent, err := r.RecentQueries(ctx, 10); err != nil || len(recent) != 0 {
+		t.Errorf("RecentQueries after pruning: %+v, %v; want none", recent, err)
+	}
+}
+
+func testCorpusVersion(t *testing.T, ctx context.Context, r repo.DocumentRepository) {
+	v, ok := r.(interface {
+		CorpusVersion(ctx context.Context) (int64, error)
+	})
+	if !ok {
+		t.Skip("no corpus version")
+	}
+	version := func() int64 {
+		t.Helper()
+		n, err := v.CorpusVersion(ctx)
+		if err != nil {
+			t.Fatalf("CorpusVersion: %v", err)
+		}
+		return n
+	}
+	v0 := version()
+	search(t, ctx, r, vec(1, 0, 0), 10, repo.SearchFilter{})
+	if v1 := version(); v1 != v0 {
+		t.Errorf("a search moved the corpus version from %d to %d", v0, v1)
+	}
+	// a batch of several chunks is one write
+	insert(t, ctx, r,
+		repo.Chunk{Content: "a1", Source: "a", Embedding: vec(1, 0, 0)},
+		repo.Chunk{Content: "a2", Source: "a", Embedding: vec(0, 1, 0)},
+	)
+	v1 := version()
+	if v1 <= v0 {
+		t.Fatalf("corpus version %d after an insert, want above %d", v1, v0)
+	}
+	// a rolled back write leaves it alone
+	if err := r.InsertChunks(ctx, []repo.Chunk{
+		{Content: "ok", Source: "b", Embedding: vec(1, 0, 0)},
+		{Content: "unknown document", Source: "b", Embedding: vec(1, 0, 0), DocumentID: 1 << 40},
+	}); err == nil {
+		t.Fatal("InsertChunks with a chunk of an unknown document succeeded")
+	}
+	if v2 := version(); v2 != v1 {
+		t.Errorf("a failed insert moved the corpus version from %d to %d", v1, v2)
+	}
+	if _, err := r.DeleteBySource(ctx, "", "a"); err != nil {
+		t.Fatalf("DeleteBySource: %v", err)
+	}
+	if v3 := version(); v3 <= v1 {
+		t.Errorf("corpus version %d after a delete, want above %d", v3, v1)
+	}
+}
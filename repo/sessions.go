@@ -0,0 +1,132 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// sessionIDRe accepts UUIDs and similar client-generated identifiers
+var sessionIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidSessionID reports whether id can identify a session
+func ValidSessionID(id string) bool { return sessionIDRe.MatchString(id) }
+
+// TouchSession records activity in a session of the tenant of ctx, starting it if needed
+func (p *PostgresRepository) TouchSession(ctx context.Context, id string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	_, err := p.pool.Exec(ctx,
+		"INSERT INTO sessions (tenant, id) VALUES ($1, $2) ON CONFLICT (tenant, id) DO UPDATE SET last_seen = now()", TenantFrom(ctx), id)
+	if err != nil {
+		return fmt.Errorf("error touching session: %w", err)
+	}
+	return nil
+}
+
+// EndSession deletes a session of the tenant of ctx and every chunk bound to it, returning the
+// deleted chunks
+func (p *PostgresRepository) EndSession(ctx context.Context, id string) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	args := []any{id}
+	scope := tenantScope(ctx, "collection", &args)
+	tag, err := tx.Exec(ctx, "DELETE FROM documents WHERE session = $1 AND "+scope, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting session chunks: %w", err)
+	}
+	for _, q := range []string{
+		"DELETE FROM quarantine WHERE session = $1 AND " + scope,
+		"DELETE FROM indexed_documents WHERE session = $1 AND " + scope,
+		"DELETE FROM sessions WHERE id = $1 AND tenant = $2",
+	} {
+		if _, err := tx.Exec(ctx, q, args...); err != nil {
+			return 0, fmt.Errorf("error ending session: %w", err)
+		}
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// IdleSessions lists the sessions of the tenant of ctx without activity for longer than idle
+func (p *PostgresRepository) IdleSessions(ctx context.Context, idle time.Duration) ([]string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	rows, err := p.pool.Query(ctx, "SELECT id FROM sessions WHERE tenant = $2 AND last_seen < now() - make_interval(secs => $1)",
+		idle.Seconds(), TenantFrom(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error listing idle sessions: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SessionSettings are the options every turn of a conversation is answered with unless the
+// request sets its own; empty fields are unset
+type SessionSettings struct {
+	// Model is the generation model of the answers
+	Model string `json:"model,omitempty"`
+	// Temperature is the sampling temperature of the answers, nil for the model's default
+	Temperature *float64 `json:"temperature,omitempty"`
+	// Collection, Sources, Tags and Metadata restrict the search like the fields of SearchFilter
+	Collection string            `json:"collection,omitempty"`
+	Sources    []string          `json:"sources,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// SetSessionSettings replaces the settings of a session of the tenant of ctx, starting it if
+// needed
+func (p *PostgresRepository) SetSessionSettings(ctx context.Context, id string, s SessionSettings) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	settings, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx,
+		"INSERT INTO sessions (tenant, id, settings) VALUES ($1, $2, $3) "+
+			"ON CONFLICT (tenant, id) DO UPDATE SET settings = excluded.settings, last_seen = now()",
+		TenantFrom(ctx), id, settings)
+	if err != nil {
+		return fmt.Errorf("error saving session settings: %w", err)
+	}
+	return nil
+}
+
+// SessionSettings returns the settings of a session of the tenant of ctx, empty for a session
+// that has none or does not exist
+func (p *PostgresRepository) SessionSettings(ctx context.Context, id string) (SessionSettings, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var raw []byte
+	err := p.pool.QueryRow(ctx, "SELECT settings FROM sessions WHERE tenant = $1 AND id = $2", TenantFrom(ctx), id).Scan(&raw)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SessionSettings{}, nil
+	}
+	if err != nil {
+		return SessionSettings{}, fmt.Errorf("error reading session settings: %w", err)
+	}
+	var s SessionSettings
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return SessionSettings{}, fmt.Errorf("error parsing session settings: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DeleteBySource removes every chunk and document of a source in a collection, returning how many
+// chunks were deleted
+func (p *PostgresRepository) DeleteBySource(ctx context.Context, collection, source string) (int64, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	tag, err := tx.Exec(ctx, "DELETE FROM documents WHERE collection = $1 AND source = $2", collectionName(ctx, collection), source)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting source: %w", err)
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM indexed_documents WHERE collection = $1 AND source = $2", collectionName(ctx, collection), source); err != nil {
+		return 0, fmt.Errorf("error deleting source: %w", err)
+	}
+	return tag.RowsAffected(), tx.Commit(ctx)
+}
+
+// FindContentHash returns the source of a document of collection stored with that content hash,
+// in the shared corpus or in session, or "" if there is none; retired versions are not considered
+func (p *PostgresRepository) FindContentHash(ctx context.Context, collection, session, hash string) (string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var source string
+	err := p.pool.QueryRow(ctx,
+		"SELECT source FROM documents WHERE collection = $1 AND content_hash = $2 AND session IN ('', $3) AND state <> 'retired' LIMIT 1",
+		collectionName(ctx, collection), hash, session).Scan(&source)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error looking up content hash: %w", err)
+	}
+	return source, nil
+}
+
+// SourceVersion returns the version recorded for a source of a collection by connectors
+// that sync external content (a content hash, an ETag, a commit...), or "" if none is
+func (p *PostgresRepository) SourceVersion(ctx context.Context, collection, source string) (string, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var version string
+	err := p.pool.QueryRow(ctx, "SELECT version FROM source_versions WHERE collection = $1 AND source = $2",
+		collectionName(ctx, collection), source).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading source version: %w", err)
+	}
+	return version, nil
+}
+
+// SetSourceVersion records the version of a source once it is indexed; an empty version forgets it
+func (p *PostgresRepository) SetSourceVersion(ctx context.Context, collection, source, version string) error {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+	var err error
+	if version == "" {
+		_, err = p.pool.Exec(ctx, "DELETE FROM source_versions WHERE collection = $1 AND source = $2", collectionName(ctx, collection), source)
+	} else {
+		_, err = p.pool.Exec(ctx,
+			"INSERT INTO source_versions (collection, source, version) VALUES ($1, $2, $3) "+
+				"ON CONFLICT (collection, source) DO UPDATE SET version = EXCLUDED.version, updated_at = now()",
+			collectionName(ctx, collection), source, version)
+	}
+	if err != nil {
+		return fmt.Errorf("error recording source version: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Every repository call is scoped to the tenant of its context (see WithTenant). A tenant has its
+// own collections, stored under its prefix (see collectionName), and records reached by ID are
+// only found within them, so no call can read or change the data of another tenant. The empty
+// tenant is the unscoped corpus of a single-user server and of background syncs.
+
+type tenantKey struct{}
+
+// WithTenant scopes the repository calls made with the returned context to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of ctx, "" when unscoped
+func TenantFrom(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+// tenantRe keeps tenant IDs short enough to prefix collection names, without underscores so the
+// prefix cannot be mistaken for part of a name
+var tenantRe = regexp.MustCompile(`^[a-z][a-z0-9]{0,11}$`)
+
+// ValidTenant reports whether id can identify a tenant
+func ValidTenant(id string) bool { return tenantRe.MatchString(id) }
+
+// tenantSeparator joins a tenant and a collection name in the stored name
+const tenantSeparator = "__"
+
+// collectionName maps a collection name of the caller, empty for DefaultCollection, to the name
+// it is stored under for the tenant of ctx
+func collectionName(ctx context.Context, name string) string {
+	if name == "" {
+		name = DefaultCollection
+	}
+	if strings.Contains(name, tenantSeparator) {
+		// not a name any tenant can use, so never one that is stored
+		return "-" + name
+	}
+	if t := TenantFrom(ctx); t != "" {
+		return t + tenantSeparator + name
+	}
+	return name
+}
+
+// publicName maps a stored collection name back to the name the tenant of ctx knows it by
+func publicName(ctx context.Context, stored string) string {
+	if t := TenantFrom(ctx); t != "" {
+		return strings.TrimPrefix(stored, t+tenantSeparator)
+	}
+	return stored
+}
+
+// tenantScope renders a condition restricting column (a collection name) to the collections of
+// the tenant of ctx, appending its parameter to args
+func tenantScope(ctx context.Context, column string, args *[]any) string {
+	*args = append(*args, TenantFrom(ctx))
+	return fmt.Sprintf("%s IN (SELECT name FROM collections WHERE tenant = $%d)", column, len(*args))
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// AccessReport reports the most retrieved chunks of a collection and its sources never
+// retrieved, up to limit of each; retrievals are counted with Config.AccessStats
+func (s *RAGService) AccessReport(ctx context.Context, collection string, limit int) (repo.AccessReport, error) {
+	col, err := s.Collection(ctx, collection)
+	if err != nil {
+		return repo.AccessReport{}, err
+	}
+	return s.repo.AccessReport(ctx, col.Name, limit)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Metadata keys of transcript chunks
+const (
+	MetaStart = "start"
+	MetaEnd   = "end"
+)
+
+// TranscriptDocument transcribes a recording into a document whose records are consecutive
+// transcript segments grouped up to the chunk size. Each record starts with its time range
+// ("[00:12:05 - 00:13:40]") so answers can point into the recording, and carries the range
+// as "start"/"end" metadata. Recordings are typed as meeting notes.
+func (s *RAGService) TranscriptDocument(ctx context.Context, audio []byte, filename string) (Document, error) {
+	segments, err := s.TranscribeSegments(ctx, audio, filename)
+	if err != nil {
+		return Document{}, err
+	}
+	z := Sizing{Size: s.cfg.ChunkSize, Unit: s.cfg.ChunkUnit}
+	var doc Document
+	var texts []string
+	var cur []Segment
+	size := 0
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		start, end := formatTimestamp(cur[0].Start), formatTimestamp(cur[len(cur)-1].End)
+		parts := make([]string, len(cur))
+		for i, sg := range cur {
+			parts[i] = sg.Text
+		}
+		text := fmt.Sprintf("[%s - %s] %s", start, end, strings.Join(parts, " "))
+		doc.Records = append(doc.Records, Record{Text: text, Metadata: map[string]string{MetaStart: start, MetaEnd: end}})
+		texts = append(texts, text)
+		cur, size = nil, 0
+	}
+	for _, sg := range segments {
+		n := z.measure(sg.Text)
+		if size > 0 && size+n > z.Size {
+			flush()
+		}
+		cur = append(cur, sg)
+		size += n
+	}
+	flush()
+	doc.Content = strings.Join(texts, "\n\n")
+	doc.Type = DocTypeMeetingNotes
+	return doc, nil
+}
+
+// formatTimestamp renders seconds as hh:mm:ss
+func formatTimestamp(seconds float64) string {
+	t := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", t/3600, t%3600/60, t%60)
+}
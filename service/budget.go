@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ollamaDefaultContext is the context window Ollama runs a model with when its Modelfile
+	// sets no num_ctx
+	ollamaDefaultContext = 4096
+	// contextReserveTokens is the part of the context window of a model kept for the prompt
+	// instructions, the question and the answer
+	contextReserveTokens = 1536
+	// minContextBudget is the smallest budget given to the retrieved passages
+	minContextBudget = 512
+	// minTruncatedTokens is the smallest part of a passage overflowing the budget worth keeping
+	minTruncatedTokens = 64
+)
+
+// ContextBudget returns the tokens of retrieved passages a prompt for model may hold: the
+// configured Config.ContextBudget, or the context window Ollama runs model with less room for the
+// rest of the prompt and the answer; 0 when budgeting is disabled
+func (s *RAGService) ContextBudget(ctx context.Context, model string) int {
+	switch {
+	case s.cfg.ContextBudget > 0:
+		return s.cfg.ContextBudget
+	case s.cfg.ContextBudget < 0:
+		return 0
+	}
+	window, ok := s.windows.Load(model)
+	if !ok {
+		n, err := s.contextWindow(ctx, model)
+		if err != nil {
+			// not cached: the next question retries
+			log.Printf("warning: assuming a %d-token context for %s: %v", ollamaDefaultContext, model, err)
+			n = ollamaDefaultContext
+		} else {
+			s.windows.Store(model, n)
+		}
+		window = n
+	}
+	return max(window.(int)-contextReserveTokens, minContextBudget)
+}
+
+// contextWindow asks Ollama for the context window it runs model with: the num_ctx of its
+// Modelfile, else the default of Ollama within the context length of the model
+func (s *RAGService) contextWindow(ctx context.Context, model string) (int, error) {
+	body, _ := json.Marshal(map[string]string{"model": model})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.OllamaURL+"/api/show", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, &OllamaError{Op: "show", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &OllamaError{Op: "show", Status: resp.StatusCode}
+	}
+	var show struct {
+		Parameters string         `json:"parameters"`
+		ModelInfo  map[string]any `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, fmt.Errorf("error parsing show JSON: %w", err)
+	}
+	for line := range strings.Lines(show.Parameters) {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "num_ctx" {
+			if n, err := strconv.Atoi(f[1]); err == nil && n > 0 {
+				return n, nil
+			}
+		}
+	}
+	window := ollamaDefaultContext
+	for key, v := range show.ModelInfo {
+		if n, ok := v.(float64); ok && strings.HasSuffix(key, ".context_length") && n > 0 {
+			window = min(window, int(n))
+		}
+	}
+	return window, nil
+}
+
+// numCtx returns the context window generation models are run with, the num_ctx option of
+// Ollama: room for a configured Config.ContextBudget and the rest of the prompt, or 0 to keep the
+// window of the model when the budget is derived from it or disabled
+func (s *RAGService) numCtx() int {
+	if s.cfg.ContextBudget > 0 {
+		return s.cfg.ContextBudget + contextReserveTokens
+	}
+	return 0
+}
+
+// BudgetedSearch returns SearchPassages keeping the passages that fit the smallest
+// ContextBudget of models, for the context of answers given by any of them. A budget set in the
+// search options is kept.
+func (s *RAGService) BudgetedSearch(models ...string) func(ctx context.Context, question string, topK int, search SearchOptions) ([]Passage, error) {
+	return func(ctx context.Context, question string, topK int, search SearchOptions) ([]Passage, error) {
+		if search.TokenBudget == 0 {
+			for _, m := range models {
+				if b := s.ContextBudget(ctx, m); b > 0 && (search.TokenBudget == 0 || b < search.TokenBudget) {
+					search.TokenBudget = b
+				}
+			}
+		}
+		return s.SearchPassages(ctx, question, topK, search)
+	}
+}
+
+// fitBudget keeps the leading passages whose tokens add up to at most budget, the lowest ranked
+// ones being dropped. The passage overflowing the budget is cut to the room left when that is
+// at least minTruncatedTokens, and the first one always is.
+func fitBudget(passages []Passage, budget int) []Passage {
+	used := 0
+	for i, p := range passages {
+		n := CountTokens(p.Content)
+		if used+n <= budget {
+			used += n
+			continue
+		}
+		if room := budget - used; room >= minTruncatedTokens || i == 0 {
+			passages[i].Content = truncateTokens(p.Content, room)
+			return passages[:i+1]
+		}
+		return passages[:i]
+	}
+	return passages
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// bulkDeleteBatch is the number of documents BulkDelete deletes per transaction, so a large
+// cleanup does not hold locks on the whole corpus at once
+const bulkDeleteBatch = 100
+
+// BulkDeleteReport counts the documents and chunks a bulk delete removed, or would remove in a
+// dry run
+type BulkDeleteReport struct {
+	Documents int64
+	Chunks    int64
+}
+
+// BulkDelete deletes the documents of the tenant of ctx matching pr, with their chunks, in
+// batches of bulkDeleteBatch; with dryRun it only counts them. On failure the report counts
+// the batches already deleted.
+func (s *RAGService) BulkDelete(ctx context.Context, pr repo.DocumentPredicate, dryRun bool) (BulkDeleteReport, error) {
+	if pr.Collection != "" {
+		col, err := s.Collection(ctx, pr.Collection)
+		if err != nil {
+			return BulkDeleteReport{}, err
+		}
+		pr.Collection = col.Name
+	}
+	var report BulkDeleteReport
+	if dryRun {
+		var err error
+		report.Documents, report.Chunks, err = s.repo.CountMatchingDocuments(ctx, pr)
+		return report, err
+	}
+	for {
+		docs, chunks, err := s.repo.DeleteMatchingDocuments(ctx, pr, bulkDeleteBatch)
+		report.Documents += docs
+		report.Chunks += chunks
+		if err != nil || docs < bulkDeleteBatch {
+			return report, err
+		}
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+	}
+}
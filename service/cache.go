@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// versioner is implemented by repositories that expose a counter bumped on every write
+type versioner interface {
+	CorpusVersion(ctx context.Context) (int64, error)
+}
+
+// retrievalCache keeps vector search results keyed by a hash of the query embedding and
+// search options. Entries expire after ttl and are dropped as soon as the corpus version
+// changes, so results never outlive an insert, delete or re-weighting. It holds at most
+// maxSize entries and about maxBytes of them (see docsSize). Results are copied in and out,
+// so callers may reorder or filter them in place.
+type retrievalCache struct {
+	ttl      time.Duration
+	maxSize  int
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// bytes is the estimated size of the entries
+	bytes int64
+	// version is the corpus version the entries were computed against
+	version int64
+}
+
+type cacheEntry struct {
+	docs    []repo.Document
+	size    int64
+	expires time.Time
+}
+
+func newRetrievalCache(ttl time.Duration, maxSize int, maxBytes int64) *retrievalCache {
+	if ttl <= 0 || maxSize <= 0 || maxBytes <= 0 {
+		return nil
+	}
+	return &retrievalCache{ttl: ttl, maxSize: maxSize, maxBytes: maxBytes, entries: make(map[string]cacheEntry)}
+}
+
+// docOverhead approximates the memory of a repo.Document besides its strings and vector
+const docOverhead = 256
+
+// docsSize estimates the memory held by docs
+func docsSize(docs []repo.Document) int64 {
+	n := int64(0)
+	for _, d := range docs {
+		n += docOverhead + int64(len(d.Content)+len(d.Source)+len(d.Section)+len(d.DocType)+4*len(d.Vector.Slice()))
+		for k, v := range d.Metadata {
+			n += int64(len(k) + len(v))
+		}
+		for _, e := range d.Entities {
+			n += int64(len(e.Text) + len(e.Type))
+		}
+	}
+	return n
+}
+
+// cacheKey hashes everything that determines a vector search result
+func cacheKey(tenant string, emb []float32, topK int, opts repo.SearchOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q|", tenant)
+	var buf [4]byte
+	for _, f := range emb {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(f))
+		h.Write(buf[:])
+	}
+	f := opts.Filter
+	// maps print with sorted keys
+	fmt.Fprintf(h, "|%d|%d|%q|%q|%d|%d|%q|%q|%q|%q|%t|%d", topK, opts.Fields, f.Collection, f.Entities, f.Before.Unix(), f.After.Unix(), f.Session,
+		f.Sources, f.Tags, f.Metadata, f.AllVersions, f.DocumentIDs)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// get returns the cached result for key, if fresh and computed against version
+func (c *retrievalCache) get(key string, version int64) ([]repo.Document, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		c.entries, c.bytes, c.version = make(map[string]cacheEntry), 0, version
+		return nil, false
+	}
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		c.remove(key)
+		return nil, false
+	}
+	return slices.Clone(e.docs), true
+}
+
+func (c *retrievalCache) put(key string, version int64, docs []repo.Document) {
+	size := docsSize(docs)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version || size > c.maxBytes {
+		return
+	}
+	c.remove(key)
+	if len(c.entries) >= c.maxSize || c.bytes+size > c.maxBytes {
+		c.evict(size)
+	}
+	c.entries[key] = cacheEntry{docs: slices.Clone(docs), size: size, expires: time.Now().Add(c.ttl)}
+	c.bytes += size
+}
+
+func (c *retrievalCache) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.bytes -= e.size
+		delete(c.entries, key)
+	}
+}
+
+// evict drops expired entries, then arbitrary ones until a tenth of the cache is free besides
+// the need bytes of the entry to add
+func (c *retrievalCache) evict(need int64) {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			c.remove(k)
+		}
+	}
+	for k := range c.entries {
+		if len(c.entries) < c.maxSize*9/10 && c.bytes+need <= c.maxBytes*9/10 {
+			break
+		}
+		c.remove(k)
+	}
+}
+
+// searchSimilar runs a vector search through the retrieval cache, when enabled
+func (s *RAGService) searchSimilar(ctx context.Context, emb []float32, topK int, opts repo.SearchOptions) ([]repo.Document, error) {
+	if s.cache == nil {
+		return s.repo.SearchSimilar(ctx, emb, topK, opts)
+	}
+	var version int64
+	if v, ok := s.repo.(versioner); ok {
+		var err error
+		if version, err = v.CorpusVersion(ctx); err != nil {
+			log.Printf("warning: retrieval cache bypassed: %v", err)
+			return s.repo.SearchSimilar(ctx, emb, topK, opts)
+		}
+	}
+	key := cacheKey(repo.TenantFrom(ctx), emb, topK, opts)
+	if docs, ok := s.cache.get(key, version); ok {
+		return docs, nil
+	}
+	docs, err := s.repo.SearchSimilar(ctx, emb, topK, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.cache.put(key, version, docs)
+	return docs, nil
+}
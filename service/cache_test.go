@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	github_com_pgv "github.com/pgvector/pgvector-go"
+)
+
+func TestRetrievalCache(t *testing.T) {
+	docs := []repo.Document{{ID: 1, Content: "one", Distance: distance(0.1)}, {ID: 2, Content: "two", Distance: distance(0.2)}}
+
+	c := newRetrievalCache(time.Minute, 10, 1<<20)
+	if _, ok := c.get("k", 0); ok {
+		t.Error("hit on an empty cache")
+	}
+	c.put("k", 0, docs)
+	if got, ok := c.get("k", 0); !ok || !slices.Equal(docIDs(got), []int{1, 2}) {
+		t.Errorf("get after put: %v, %v; want chunks 1 and 2", docIDs(got), ok)
+	}
+	if _, ok := c.get("other", 0); ok {
+		t.Error("hit on another key")
+	}
+
+	// callers may change what they put or got without touching the cache
+	docs[0].Content = "changed by the caller"
+	got, _ := c.get("k", 0)
+	got[1].Content = "changed too"
+	_ = slices.DeleteFunc(got, func(d repo.Document) bool { return d.ID == 1 })
+	if got, _ := c.get("k", 0); len(got) != 2 || got[0].Content != "one" || got[1].Content != "two" {
+		t.Errorf("cached %+v after callers changed their copies, want the results as put", got)
+	}
+
+	// another corpus version drops every entry, and results of the old one are not stored
+	if _, ok := c.get("k", 1); ok {
+		t.Error("hit after the corpus version changed")
+	}
+	if _, ok := c.get("k", 0); ok {
+		t.Error("hit on an entry of a previous version")
+	}
+	c.put("stale", 0, docs)
+	if _, ok := c.get("stale", 1); ok {
+		t.Error("stored results computed against a previous version")
+	}
+
+	expiring := newRetrievalCache(time.Nanosecond, 10, 1<<20)
+	expiring.put("k", 0, docs)
+	time.Sleep(time.Millisecond)
+	if _, ok := expiring.get("k", 0); ok {
+		t.Error("hit on an expired entry")
+	}
+}
+
+func TestRetrievalCacheBounds(t *testing.T) {
+	small := []repo.Document{{ID: 1, Content: "x"}}
+	c := newRetrievalCache(time.Minute, 10, 1<<20)
+	for i := range 25 {
+		c.put(strings.Repeat("k", i+1), 0, small)
+	}
+	if len(c.entries) > 10 {
+		t.Errorf("%d entries, want at most 10", len(c.entries))
+	}
+
+	big := []repo.Document{{ID: 1, Content: strings.Repeat("x", 1000)}}
+	size := docsSize(big)
+	c = newRetrievalCache(time.Minute, 100, 3*size)
+	for i := range 10 {
+		c.put(strings.Repeat("k", i+1), 0, big)
+	}
+	if c.bytes > 3*size || len(c.entries) > 3 {
+		t.Errorf("%d bytes in %d entries, want at most %d", c.bytes, len(c.entries), 3*size)
+	}
+	c.put("huge", 0, []repo.Document{{ID: 2, Content: strings.Repeat("x", 5000)}})
+	if _, ok := c.get("huge", 0); ok {
+		t.Error("stored results larger than the whole cache")
+	}
+
+	withVectors := []repo.Document{{ID: 1, Content: "x", Vector: github_com_pgv.NewVector(make([]float32, 1024))}}
+	if got, want := docsSize(withVectors), docsSize(small)+4*1024; got != want {
+		t.Errorf("docsSize with a 1024-dimensional vector = %d, want %d", got, want)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	emb := []float32{0.1, 0.2}
+	opts := repo.SearchOptions{Filter: repo.SearchFilter{Collection: "docs"}}
+	key := cacheKey("acme", emb, 5, opts)
+	if cacheKey("acme", []float32{0.1, 0.2}, 5, opts) != key {
+		t.Error("the same search gave another key")
+	}
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"another tenant", cacheKey("globex", emb, 5, opts)},
+		{"the unscoped corpus", cacheKey("", emb, 5, opts)},
+		{"another embedding", cacheKey("acme", []float32{0.1, 0.3}, 5, opts)},
+		{"another topK", cacheKey("acme", emb, 6, opts)},
+		{"another collection", cacheKey("acme", emb, 5, repo.SearchOptions{Filter: repo.SearchFilter{Collection: "other"}})},
+		{"other fields", cacheKey("acme", emb, 5, repo.SearchOptions{Filter: opts.Filter, Fields: repo.FieldEmbedding})},
+	}
+	for _, tt := range tests {
+		if tt.key == key {
+			t.Errorf("%s shares the key of the search", tt.name)
+		}
+	}
+}
+
+func TestSearchSimilarCache(t *testing.T) {
+	r := &searchRepo{docs: []repo.Document{{ID: 1, Content: "one"}, {ID: 2, Content: "two"}}}
+	svc := NewRAGService(r, nil, nil, Config{RetrievalCacheTTL: time.Minute, RetrievalCacheSize: 10, RetrievalCacheMB: 1})
+	acme := repo.WithTenant(context.Background(), "acme")
+	search := func(ctx context.Context) []repo.Document {
+		t.Helper()
+		docs, err := svc.searchSimilar(ctx, []float32{1, 0}, 5, repo.SearchOptions{})
+		if err != nil {
+			t.Fatalf("searchSimilar: %v", err)
+		}
+		return docs
+	}
+
+	docs := search(acme)
+	docs[0] = repo.Document{}
+	if got := docIDs(search(acme)); !slices.Equal(got, []int{1, 2}) || r.searches != 1 {
+		t.Errorf("second search: %v after %d repository searches, want chunks 1 and 2 from the cache", got, r.searches)
+	}
+	search(context.Background())
+	if r.searches != 2 {
+		t.Errorf("%d repository searches, want another tenant to miss the cache", r.searches)
+	}
+	r.version++
+	search(acme)
+	if r.searches != 3 {
+		t.Errorf("%d repository searches, want a write to invalidate the cache", r.searches)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import "strings"
+
+// Chunk is a piece of a document ready to be embedded
+type Chunk struct {
+	Text string
+	// Section is the heading path of the chunk ("Install > Linux"), empty if unknown
+	Section string
+}
+
+// Chunker splits a document's text into chunks. meta describes the document (see MetaFormat,
+// MetaSource); chunkers ignore keys they do not use.
+type Chunker interface {
+	Chunk(text string, meta map[string]string) []Chunk
+}
+
+// Keys of the metadata passed to Chunker.Chunk
+const (
+	// MetaFormat is the document format, FormatMarkdown or empty for plain text
+	MetaFormat = "format"
+	MetaSource = "source"
+)
+
+// Sizing bounds chunk sizes for the built-in chunkers
+type Sizing struct {
+	// Size is the maximum chunk size and Overlap the size shared by consecutive chunks,
+	// measured in Unit (ChunkUnitTokens or ChunkUnitWords; empty means tokens)
+	Size    int
+	Overlap int
+	Unit    string
+}
+
+// sizing returns z, so the sizing of chunkers embedding it can be found
+func (z Sizing) sizing() Sizing { return z }
+
+// chunkFits reports whether text fits in one chunk of chunker, measured in the chunker's unit.
+// Chunkers without a Sizing are assumed to follow the size and unit of cfg.
+func chunkFits(chunker Chunker, cfg Config, text string) bool {
+	z := Sizing{Size: cfg.ChunkSize, Unit: cfg.ChunkUnit}
+	if sized, ok := chunker.(interface{ sizing() Sizing }); ok {
+		z = sized.sizing()
+	}
+	return z.measure(text) <= z.Size
+}
+
+// WindowChunker slides a fixed-size window over the words of the text
+type WindowChunker struct {
+	Sizing
+}
+
+// Chunk splits text into windows of at most Size units, each overlapping the previous one
+// by about Overlap units
+func (c WindowChunker) Chunk(text string, _ map[string]string) []Chunk {
+	return toChunks(c.split(text))
+}
+
+func (c WindowChunker) split(text string) []string {
+	if c.Size <= 0 {
+		return []string{text}
+	}
+	words, sizes := c.units(text)
+	var chunks []string
+	for i := 0; i < len(words); {
+		end, n := i, 0
+		for end < len(words) && (end == i || n+sizes[end] <= c.Size) {
+			n += sizes[end]
+			end++
+		}
+		chunks = append(chunks, strings.Join(words[i:end], " "))
+		if end == len(words) {
+			break
+		}
+		// step back over the overlap, always moving forward at least one word
+		next, back := end, 0
+		for next > i+1 && back+sizes[next-1] <= c.Overlap {
+			next--
+			back += sizes[next]
+		}
+		i = next
+	}
+	return chunks
+}
+
+// DocumentChunker chunks markdown documents with Markdown and everything else with Text,
+// keeping tables found in plain text as standalone markdown chunks
+type DocumentChunker struct {
+	Sizing
+	Markdown Chunker
+	Text     Chunker
+}
+
+func (c DocumentChunker) Chunk(text string, meta map[string]string) []Chunk {
+	if meta[MetaFormat] == FormatMarkdown {
+		return c.Markdown.Chunk(text, meta)
+	}
+	var chunks []Chunk
+	for _, seg := range splitTables(text) {
+		if seg.table != nil {
+			chunks = append(chunks, toChunks(tableChunks(seg.table, c.Size, c.measure))...)
+			continue
+		}
+		chunks = append(chunks, c.Text.Chunk(seg.text, meta)...)
+	}
+	return chunks
+}
+
+// NewChunker builds the chunker selected by cfg: markdown documents are chunked by section
+// and plain text with cfg.ChunkStrategy
+func NewChunker(cfg Config) Chunker {
+	sizing := Sizing{Size: cfg.ChunkSize, Overlap: cfg.ChunkOverlap, Unit: cfg.ChunkUnit}
+	var text Chunker = WindowChunker{sizing}
+	switch cfg.ChunkStrategy {
+	case ChunkBySentences:
+		text = SentenceChunker{sizing}
+	case ChunkRecursive:
+		text = RecursiveChunker{Sizing: sizing}
+	}
+	return DocumentChunker{
+		Sizing:   sizing,
+		Markdown: MarkdownChunker{Sizing: sizing, Text: text},
+		Text:     text,
+	}
+}
+
+func toChunks(texts []string) []Chunk {
+	chunks := make([]Chunk, len(texts))
+	for i, t := range texts {
+		chunks[i] = Chunk{Text: t}
+	}
+	return chunks
+}
+
+// chunkTexts returns the text of every chunk
+func chunkTexts(chunks []Chunk) []string {
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		texts[i] = c.Text
+	}
+	return texts
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestWindowChunker(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizing Sizing
+		text   string
+		want   []string
+	}{
+		{"fits", Sizing{Size: 4, Unit: ChunkUnitWords}, "a b c", []string{"a b c"}},
+		{"no overlap", Sizing{Size: 3, Unit: ChunkUnitWords}, "a b c d e f g", []string{"a b c", "d e f", "g"}},
+		{"overlap", Sizing{Size: 4, Overlap: 1, Unit: ChunkUnitWords}, "a b c d e f g", []string{"a b c d", "d e f g"}},
+		{"overlap as large as the chunk", Sizing{Size: 2, Overlap: 2, Unit: ChunkUnitWords}, "a b c", []string{"a b", "b c"}},
+		{"tokens", Sizing{Size: 2}, "abcd efgh ijkl", []string{"abcd efgh", "ijkl"}},
+		{"word longer than a chunk", Sizing{Size: 2}, "abcdefghijkl", []string{"ab cd", "efghijkl"}},
+		{"no size", Sizing{Unit: ChunkUnitWords}, "a  b c", []string{"a  b c"}},
+		{"empty", Sizing{Size: 3, Unit: ChunkUnitWords}, "  ", nil},
+	}
+	for _, tt := range tests {
+		if got := chunkTexts(WindowChunker{tt.sizing}.Chunk(tt.text, nil)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSentenceChunker(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizing Sizing
+		text   string
+		want   []string
+	}{
+		{"packs sentences", Sizing{Size: 6, Unit: ChunkUnitWords}, "One two three. Four five six. Seven eight.",
+			[]string{"One two three. Four five six.", "Seven eight."}},
+		{"closes at a paragraph", Sizing{Size: 8, Unit: ChunkUnitWords}, "One two three four five six.\n\nSeven eight.",
+			[]string{"One two three four five six.", "Seven eight."}},
+		{"same paragraph", Sizing{Size: 8, Unit: ChunkUnitWords}, "One two three four five six. Seven eight.",
+			[]string{"One two three four five six. Seven eight."}},
+		{"overlaps whole sentences", Sizing{Size: 5, Overlap: 2, Unit: ChunkUnitWords}, "Aa bb cc. Dd ee. Ff gg hh.",
+			[]string{"Aa bb cc. Dd ee.", "Dd ee. Ff gg hh."}},
+		{"abbreviation", Sizing{Size: 3, Unit: ChunkUnitWords}, "Dr. Smith arrived. He sat.",
+			[]string{"Dr. Smith arrived.", "He sat."}},
+		{"decimal", Sizing{Size: 4, Unit: ChunkUnitWords}, "Version 3.5 is out. Done now.",
+			[]string{"Version 3.5 is out.", "Done now."}},
+		{"sentence longer than a chunk", Sizing{Size: 2, Unit: ChunkUnitWords}, "One two three four.",
+			[]string{"One two", "three four."}},
+		{"no size", Sizing{Unit: ChunkUnitWords}, "One. Two.", []string{"One. Two."}},
+	}
+	for _, tt := range tests {
+		if got := chunkTexts(SentenceChunker{tt.sizing}.Chunk(tt.text, nil)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecursiveChunker(t *testing.T) {
+	words := Sizing{Size: 4, Unit: ChunkUnitWords}
+	tests := []struct {
+		name    string
+		chunker RecursiveChunker
+		text    string
+		want    []string
+	}{
+		{"fits", RecursiveChunker{Sizing: words}, " aa bb\n", []string{"aa bb"}},
+		{"merges paragraphs", RecursiveChunker{Sizing: words}, "aa bb\n\ncc\n\ndd ee ff",
+			[]string{"aa bb\n\ncc", "dd ee ff"}},
+		{"sentences", RecursiveChunker{Sizing: words}, "Aa bb cc. Dd ee ff. Gg.",
+			[]string{"Aa bb cc.", "Dd ee ff. Gg."}},
+		{"falls back to a window", RecursiveChunker{Sizing: words}, "aa bb.\n\ncc dd ee ff gg.\n\nhh",
+			[]string{"aa bb.", "cc dd ee ff", "gg.", "hh"}},
+		{"custom separators", RecursiveChunker{Sizing: words, Separators: []string{"|"}}, "aa bb cc|dd ee ff",
+			[]string{"aa bb cc|", "dd ee ff"}},
+		{"no size", RecursiveChunker{}, " aa ", []string{" aa "}},
+	}
+	for _, tt := range tests {
+		if got := chunkTexts(tt.chunker.Chunk(tt.text, nil)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMarkdownChunker(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		text     string
+		want     []string
+		sections []string
+	}{
+		{"sections", 100, "# Install\n\nIntro.\n\n## Linux\n\nRun it.\n",
+			[]string{"Install\n\nIntro.", "Install > Linux\n\nRun it."}, []string{"Install", "Install > Linux"}},
+		{"skipped levels", 100, "# A\n\n### B\n\ntext",
+			[]string{"A > B\n\ntext"}, []string{"A > B"}},
+		{"text before any heading", 100, "preface\n\n# A\n\nbody",
+			[]string{"preface", "A\n\nbody"}, []string{"", "A"}},
+		{"packs paragraphs", 3, "# A\n\none two\n\nthree\n\nfour five",
+			[]string{"A\n\none two\n\nthree", "A\n\nfour five"}, []string{"A", "A"}},
+		{"code blocks stay whole", 3, "# A\n\n```\none two three four five\n```",
+			[]string{"A\n\n```\none two three four five\n```"}, []string{"A"}},
+		{"headings inside code are text", 100, "```\n# not a heading\n```",
+			[]string{"```\n# not a heading\n```"}, []string{""}},
+		{"oversized paragraphs are re-chunked", 2, "# A\n\none two three",
+			[]string{"A\n\none two", "A\n\nthree"}, []string{"A", "A"}},
+	}
+	for _, tt := range tests {
+		z := Sizing{Size: tt.size, Unit: ChunkUnitWords}
+		chunks := MarkdownChunker{Sizing: z, Text: WindowChunker{z}}.Chunk(tt.text, map[string]string{MetaFormat: FormatMarkdown})
+		if got := chunkTexts(chunks); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i, c := range chunks {
+			if c.Section != tt.sections[i] {
+				t.Errorf("%s: chunk %d in section %q, want %q", tt.name, i, c.Section, tt.sections[i])
+			}
+		}
+	}
+}
+
+func TestNewChunker(t *testing.T) {
+	cfg := Config{ChunkSize: 3, ChunkUnit: ChunkUnitWords}
+	tests := []struct {
+		strategy string
+		want     Chunker
+	}{
+		{ChunkByWindow, WindowChunker{}},
+		{ChunkByWords, WindowChunker{}},
+		{ChunkBySentences, SentenceChunker{}},
+		{ChunkRecursive, RecursiveChunker{}},
+	}
+	for _, tt := range tests {
+		cfg.ChunkStrategy = tt.strategy
+		if got := NewChunker(cfg).(DocumentChunker).Text; reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("strategy %q: text chunker %T, want %T", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+// fixedChunker has no Sizing, so chunkFits falls back to the Config
+type fixedChunker struct{}
+
+func (fixedChunker) Chunk(text string, _ map[string]string) []Chunk { return []Chunk{{Text: text}} }
+
+func TestChunkFits(t *testing.T) {
+	cfg := Config{ChunkSize: 3, ChunkUnit: ChunkUnitWords}
+	tests := []struct {
+		name    string
+		chunker Chunker
+		text    string
+		want    bool
+	}{
+		{"within the size", WindowChunker{Sizing{Size: 3, Unit: ChunkUnitWords}}, "a b c", true},
+		{"above the size", WindowChunker{Sizing{Size: 3, Unit: ChunkUnitWords}}, "a b c d", false},
+		{"measured in tokens", WindowChunker{Sizing{Size: 3}}, "internationalization", false},
+		{"the chunker's size wins", NewChunker(Config{ChunkSize: 10, ChunkUnit: ChunkUnitWords}), "a b c d", true},
+		{"unsized chunker within the config", fixedChunker{}, "a b c", true},
+		{"unsized chunker above the config", fixedChunker{}, "a b c d", false},
+	}
+	for _, tt := range tests {
+		if got := chunkFits(tt.chunker, cfg, tt.text); got != tt.want {
+			t.Errorf("%s: chunkFits(%q) = %v, want %v", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// Collection resolves a collection name (empty for the default one) of the tenant of ctx to the
+// settings it is searched and indexed with
+func (s *RAGService) Collection(ctx context.Context, name string) (repo.Collection, error) {
+	c, err := s.configuredCollection(ctx, name)
+	if err != nil {
+		return c, err
+	}
+	s.collMu.RLock()
+	defer s.collMu.RUnlock()
+	if stored, ok := s.stale[tenantCollection(ctx, c.Name)]; ok {
+		return stored, nil
+	}
+	return c, nil
+}
+
+// tenantCollection keys the collection state of the service (stale, runtime and reindexing
+// collections) by tenant, since every tenant has its own collections under the same names
+func tenantCollection(ctx context.Context, name string) string {
+	return repo.TenantFrom(ctx) + "/" + name
+}
+
+// configuredCollection returns the collection as configured, even while it still serves with
+// the model its vectors were stored with. Every tenant has the default and the configured
+// collections.
+func (s *RAGService) configuredCollection(ctx context.Context, name string) (repo.Collection, error) {
+	if name == "" || name == repo.DefaultCollection {
+		return repo.Collection{Name: repo.DefaultCollection, Model: s.cfg.EmbeddingModel, Dimension: s.cfg.EmbeddingDimension}, nil
+	}
+	for _, c := range s.cfg.Collections {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	s.collMu.RLock()
+	defer s.collMu.RUnlock()
+	if s.runtime[tenantCollection(ctx, name)] {
+		return repo.Collection{Name: name, Model: s.cfg.EmbeddingModel, Dimension: s.cfg.EmbeddingDimension}, nil
+	}
+	return repo.Collection{}, &repo.UnknownCollectionError{Name: name}
+}
+
+// isConfigured reports whether name is the default collection or one from the configuration
+func (s *RAGService) isConfigured(name string) bool {
+	if name == repo.DefaultCollection {
+		return true
+	}
+	for _, c := range s.cfg.Collections {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionNames lists the default collection, the configured ones and then the ones the
+// tenant of ctx created at runtime by name
+func (s *RAGService) collectionNames(ctx context.Context) []string {
+	names := []string{repo.DefaultCollection}
+	for _, c := range s.cfg.Collections {
+		names = append(names, c.Name)
+	}
+	prefix := tenantCollection(ctx, "")
+	s.collMu.RLock()
+	var created []string
+	for key := range s.runtime {
+		if name, ok := strings.CutPrefix(key, prefix); ok {
+			created = append(created, name)
+		}
+	}
+	s.collMu.RUnlock()
+	sort.Strings(created)
+	return append(names, created...)
+}
+
+// RegisterCollections registers the default collection and every configured one with the
+// repository, for the unscoped corpus and every tenant of Config.Tenants, and picks up the
+// collections created at runtime by an earlier run. A collection stored with another model keeps
+// serving with that model until Reindex re-embeds it; it only fails when that model is unknown.
+func (s *RAGService) RegisterCollections(ctx context.Context) error {
+	for _, tenant := range append([]string{""}, s.cfg.Tenants...) {
+		if err := s.registerCollections(repo.WithTenant(ctx, tenant)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerCollections registers the collections of the tenant of ctx (see RegisterCollections)
+func (s *RAGService) registerCollections(ctx context.Context) error {
+	stored, err := s.repo.Collections(ctx)
+	if err != nil {
+		return err
+	}
+	s.collMu.Lock()
+	for _, c := range stored {
+		if !s.isConfigured(c.Name) {
+			if s.runtime == nil {
+				s.runtime = map[string]bool{}
+			}
+			s.runtime[tenantCollection(ctx, c.Name)] = true
+		}
+	}
+	s.collMu.Unlock()
+	for _, name := range s.collectionNames(ctx) {
+		c, _ := s.configuredCollection(ctx, name)
+		err := s.repo.EnsureCollection(ctx, c)
+		var mm *repo.ModelMismatchError
+		if errors.As(err, &mm) && mm.StoredModel != "" {
+			log.Printf("WARNING: %v; serving it with %q until it is re-embedded", err, mm.StoredModel)
+			s.setStale(ctx, repo.Collection{Name: c.Name, Model: mm.StoredModel, Dimension: mm.StoredDimension})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n, err := s.repo.CountStaleChunks(ctx, c)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("WARNING: %d chunks of collection %q were embedded with another model than %q; re-embed them", n, c.Name, c.Model)
+		}
+	}
+	return nil
+}
+
+// ErrCollectionExists and ErrCollectionConfigured reject creating a collection that already
+// exists and deleting one that comes from the configuration
+var (
+	ErrCollectionExists     = errors.New("collection already exists")
+	ErrCollectionConfigured = errors.New("collection is configured, remove it from the configuration instead")
+)
+
+// CollectionInfo is a collection with the size of its current corpus
+type CollectionInfo struct {
+	Name       string `json:"name"`
+	Model      string `json:"model"`
+	Dimension  int    `json:"dimension"`
+	Documents  int64  `json:"documents"`
+	Chunks     int64  `json:"chunks"`
+	Configured bool   `json:"configured"`
+}
+
+// ListCollections lists every registered collection with the model it serves with
+func (s *RAGService) ListCollections(ctx context.Context) ([]CollectionInfo, error) {
+	stats, err := s.repo.CollectionStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]CollectionInfo, 0, len(stats))
+	for _, st := range stats {
+		info := CollectionInfo{
+			Name:       st.Name,
+			Model:      st.Model,
+			Dimension:  st.Dimension,
+			Documents:  st.Documents,
+			Chunks:     st.Chunks,
+			Configured: s.isConfigured(st.Name),
+		}
+		if c, err := s.Collection(ctx, st.Name); err == nil {
+			info.Model, info.Dimension = c.Model, c.Dimension
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// CreateCollection registers a new, empty collection embedded with the default model. It is
+// kept across restarts and can be uploaded into and queried like a configured one.
+func (s *RAGService) CreateCollection(ctx context.Context, name string) (repo.Collection, error) {
+	if !repo.ValidCollectionName(name) {
+		return repo.Collection{}, fmt.Errorf("invalid collection name %q: use lowercase letters, digits and single underscores", name)
+	}
+	if _, err := s.configuredCollection(ctx, name); err == nil {
+		return repo.Collection{}, ErrCollectionExists
+	}
+	c := repo.Collection{Name: name, Model: s.cfg.EmbeddingModel, Dimension: s.cfg.EmbeddingDimension}
+	if err := s.repo.EnsureCollection(ctx, c); err != nil {
+		return repo.Collection{}, err
+	}
+	key := tenantCollection(ctx, name)
+	s.collMu.Lock()
+	defer s.collMu.Unlock()
+	if s.runtime[key] {
+		return repo.Collection{}, ErrCollectionExists
+	}
+	if s.runtime == nil {
+		s.runtime = map[string]bool{}
+	}
+	s.runtime[key] = true
+	return c, nil
+}
+
+// DeleteCollection deletes a collection created with CreateCollection together with everything
+// stored in it, returning how many chunks were deleted
+func (s *RAGService) DeleteCollection(ctx context.Context, name string) (int64, error) {
+	if s.isConfigured(name) {
+		return 0, ErrCollectionConfigured
+	}
+	key := tenantCollection(ctx, name)
+	s.collMu.RLock()
+	known := s.runtime[key]
+	s.collMu.RUnlock()
+	if !known {
+		return 0, &repo.UnknownCollectionError{Name: name}
+	}
+	if _, busy := s.reindexing.LoadOrStore(key, true); busy {
+		return 0, ErrReindexRunning
+	}
+	defer s.reindexing.Delete(key)
+	n, err := s.repo.DropCollection(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	s.collMu.Lock()
+	delete(s.runtime, key)
+	delete(s.stale, key)
+	s.collMu.Unlock()
+	log.Printf("collection %q deleted: %d chunks", name, n)
+	return n, nil
+}
+
+// annotateDimensionErr records which embedding model produced a mismatched vector
+func annotateDimensionErr(err error, c repo.Collection) error {
+	var dm *repo.DimensionMismatchError
+	if errors.As(err, &dm) && dm.Model == "" {
+		dm.Model = c.Model
+	}
+	return err
+}
+
+// embedFor embeds text with the model of collection c
+func (s *RAGService) embedFor(ctx context.Context, c repo.Collection, text string) ([]float32, error) {
+	emb, err := s.embedWith(ctx, c.Model, text)
+	if err != nil {
+		return nil, fmt.Errorf("embedding for collection %q: %w", c.Name, err)
+	}
+	return emb, nil
+}
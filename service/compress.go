@@ -0,0 +1,154 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Compression methods of Config.Compression
+const (
+	// CompressExtractive keeps the sentences of a passage sharing a word with the question
+	CompressExtractive = "extractive"
+	// CompressModel asks Config.CompressionModel for the sentences of a passage relevant to the
+	// question, dropping the passages it finds none in
+	CompressModel = "model"
+)
+
+// compressConcurrency is the number of passages compressed by a model at once
+const compressConcurrency = 4
+
+// compressPrompt asks for the sentences of a passage relevant to a question, copied verbatim
+const compressPrompt = `Copy, word for word, the sentences of the passage below that help answer the question,
+in their order. Do not rephrase or add anything. If no sentence helps, answer %s only.
+
+Question: %s
+
+Passage:
+%s`
+
+// compressNone is the reply of the compression model for a passage without relevant sentences
+const compressNone = "NONE"
+
+// compress keeps the part of every passage relevant to question with the method of
+// Config.Compression, so the prompt carries less noise. A passage the model cannot compress is
+// kept whole: compression sharpens the context but is not needed to answer.
+func (s *RAGService) compress(ctx context.Context, question string, passages []Passage) []Passage {
+	switch s.cfg.Compression {
+	case CompressExtractive:
+		words := contentWords(question)
+		for i := range passages {
+			passages[i].Content = extractRelevant(passages[i].Content, words)
+		}
+		return passages
+	case CompressModel:
+		return s.compressWithModel(ctx, question, passages)
+	}
+	return passages
+}
+
+// compressWithModel runs every passage through the compression model
+func (s *RAGService) compressWithModel(ctx context.Context, question string, passages []Passage) []Passage {
+	model := cmp.Or(s.cfg.CompressionModel, s.cfg.LLMModel)
+	keep := make([]bool, len(passages))
+	sem := make(chan struct{}, compressConcurrency)
+	var wg sync.WaitGroup
+	for i, p := range passages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			keep[i] = true
+			out, err := s.generate(ctx, map[string]any{
+				"model":   model,
+				"prompt":  fmt.Sprintf(compressPrompt, compressNone, question, p.Content),
+				"stream":  false,
+				"options": map[string]any{"temperature": 0, "num_predict": CountTokens(p.Content) + 16},
+			})
+			if err != nil {
+				log.Printf("warning: keeping passage %d whole: error compressing with %s: %v", i+1, model, err)
+				return
+			}
+			switch out = strings.TrimSpace(out); {
+			case out == "":
+			case strings.EqualFold(strings.Trim(out, ".\"'"), compressNone):
+				keep[i] = false
+			case utf8.RuneCountInString(out) < utf8.RuneCountInString(p.Content):
+				passages[i].Content = out
+			}
+		}()
+	}
+	wg.Wait()
+	out := passages[:0]
+	for i, p := range passages {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// stemRunes is the prefix length two longer words are compared on, so inflections match
+// ("facturas" and "facturación")
+const stemRunes = 5
+
+// extractRelevant keeps the sentences of text containing one of words, marking the gaps with an
+// ellipsis; text is kept whole when none does, since it was retrieved for its meaning
+func extractRelevant(text string, words []string) string {
+	if len(words) == 0 {
+		return text
+	}
+	sentences := splitSentences(text)
+	var out strings.Builder
+	last, kept := -1, 0
+	for i, sn := range sentences {
+		if !mentionsAny(sn.text, words) {
+			continue
+		}
+		switch {
+		case last < 0 && i > 0:
+			out.WriteString("… ")
+		case last >= 0 && i == last+1:
+			out.WriteString(" ")
+		case last >= 0:
+			out.WriteString(" … ")
+		}
+		out.WriteString(sn.text)
+		last, kept = i, kept+1
+	}
+	if kept == 0 || kept == len(sentences) {
+		return text
+	}
+	if last < len(sentences)-1 {
+		out.WriteString(" …")
+	}
+	return out.String()
+}
+
+// mentionsAny reports whether sentence contains one of words, or a word sharing its stem
+func mentionsAny(sentence string, words []string) bool {
+	for _, w := range contentWords(sentence) {
+		for _, q := range words {
+			if w == q || (utf8.RuneCountInString(w) >= stemRunes && utf8.RuneCountInString(q) >= stemRunes && runePrefix(w) == runePrefix(q)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runePrefix returns the first stemRunes runes of w
+func runePrefix(w string) string {
+	n := 0
+	for i := range w {
+		if n == stemRunes {
+			return w[:i]
+		}
+		n++
+	}
+	return w
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const summaryPrompt = `Summarize what the document below is about in ONE sentence, naming its subject,
+type (manual, contract, article, chat...) and any product, organization or period it concerns.
+Answer with the sentence only, in the language of the document.
+
+Document (may be truncated):
+%s`
+
+// summaryInputTokens caps how much of a document is sent to the summary model
+const summaryInputTokens = 3000
+
+// SummarizeDocument asks the context model for a one-sentence summary of a document,
+// reading at most its first summaryInputTokens tokens
+func (s *RAGService) SummarizeDocument(ctx context.Context, text string) (string, error) {
+	head := WindowChunker{Sizing{Size: summaryInputTokens}}.split(text)
+	if len(head) == 0 {
+		return "", nil
+	}
+	out, err := s.Generate(ctx, s.cfg.ContextModel, fmt.Sprintf(summaryPrompt, head[0]), "")
+	if err != nil {
+		return "", fmt.Errorf("summarizing document: %w", err)
+	}
+	// keep the first line in case the model adds commentary
+	out, _, _ = strings.Cut(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(out), nil
+}
+
+// withContext prefixes a chunk with its document summary so it can be retrieved on its own
+func withContext(summary, chunk string) string {
+	if summary == "" {
+		return chunk
+	}
+	return summary + "\n\n" + chunk
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// Dependency states of DependencyStatus
+const (
+	DependencyOK = "ok"
+	// DependencyDegraded is a reachable dependency missing something the service needs, such as
+	// a configured model Ollama has not pulled
+	DependencyDegraded = "degraded"
+	DependencyDown     = "down"
+)
+
+// dependencyTimeout bounds each dependency check of the dashboard
+const dependencyTimeout = 3 * time.Second
+
+// DependencyStatus is the outcome of a check of a server the service relies on
+type DependencyStatus struct {
+	Name    string
+	Status  string
+	Latency time.Duration
+	// Detail says what is wrong, empty when the dependency is ok
+	Detail string
+}
+
+// Dashboard is the state of the corpus and its dependencies at a glance
+type Dashboard struct {
+	Collections []CollectionInfo
+	// RecentQueries is empty unless questions are logged (Config.QueryLog)
+	RecentQueries []repo.LoggedQuery
+	RecentUploads []repo.IndexedDocument
+	// Uploads counts every indexed document
+	Uploads      int64
+	Dependencies []DependencyStatus
+}
+
+// pinger is implemented by repositories that can check their database connection
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Dashboard gathers the collections of the tenant of ctx with their size, its limit latest
+// questions and uploads, and checks the database and Ollama. A dependency being down is reported
+// in Dependencies rather than returned, unless the data cannot be read at all.
+func (s *RAGService) Dashboard(ctx context.Context, limit int) (Dashboard, error) {
+	deps := make(chan []DependencyStatus, 1)
+	go func() { deps <- s.checkDependencies(ctx) }()
+
+	var d Dashboard
+	var err error
+	if d.Collections, err = s.ListCollections(ctx); err != nil {
+		return Dashboard{}, err
+	}
+	if d.RecentQueries, err = s.repo.RecentQueries(ctx, limit); err != nil {
+		return Dashboard{}, err
+	}
+	if d.RecentUploads, d.Uploads, err = s.repo.ListDocuments(ctx, repo.ListDocumentsOptions{Limit: limit}); err != nil {
+		return Dashboard{}, err
+	}
+	d.Dependencies = <-deps
+	return d, nil
+}
+
+// checkDependencies checks the database and Ollama concurrently
+func (s *RAGService) checkDependencies(ctx context.Context) []DependencyStatus {
+	checks := []struct {
+		name string
+		fn   func(ctx context.Context) (string, string)
+	}{
+		{"database", s.checkDatabase},
+		{"ollama", s.checkOllama},
+	}
+	out := make([]DependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+			defer cancel()
+			start := time.Now()
+			status, detail := c.fn(ctx)
+			out[i] = DependencyStatus{Name: c.name, Status: status, Latency: time.Since(start), Detail: detail}
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func (s *RAGService) checkDatabase(ctx context.Context) (string, string) {
+	p, ok := s.repo.(pinger)
+	if !ok {
+		return DependencyOK, ""
+	}
+	if err := p.Ping(ctx); err != nil {
+		return DependencyDown, err.Error()
+	}
+	return DependencyOK, ""
+}
+
+// checkOllama lists the models Ollama serves, degraded when a configured one is missing
+func (s *RAGService) checkOllama(ctx context.Context) (string, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.OllamaURL+"/api/tags", nil)
+	if err != nil {
+		return DependencyDown, err.Error()
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return DependencyDown, err.Error()
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return DependencyDown, fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return DependencyDown, fmt.Sprintf("error decoding models: %v", err)
+	}
+	var served []string
+	for _, m := range tags.Models {
+		served = append(served, m.Name, strings.TrimSuffix(m.Name, ":latest"))
+	}
+	var missing []string
+	for _, m := range []string{s.cfg.EmbeddingModel, s.cfg.LLMModel} {
+		if m != "" && !slices.Contains(served, m) && !slices.Contains(missing, m) {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		return DependencyDegraded, "models not pulled: " + strings.Join(missing, ", ")
+	}
+	return DependencyOK, ""
+}
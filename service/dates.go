@@ -0,0 +1,93 @@
+package service
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	isoDateRe     = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	numericDateRe = regexp.MustCompile(`\b(\d{1,2})/(\d{1,2})/(\d{4})\b`)
+	// "March 15, 2024" and "15 March 2024" / "15 de marzo de 2024"
+	monthFirstRe = regexp.MustCompile(`(?i)\b([a-z]+)\.? (\d{1,2})(?:st|nd|rd|th)?,? (\d{4})\b`)
+	dayFirstRe   = regexp.MustCompile(`(?i)\b(\d{1,2}) (?:de )?([a-z]+)\.?,? (?:de )?(\d{4})\b`)
+)
+
+var monthNames = map[string]time.Month{
+	"january": time.January, "jan": time.January, "enero": time.January,
+	"february": time.February, "feb": time.February, "febrero": time.February,
+	"march": time.March, "mar": time.March, "marzo": time.March,
+	"april": time.April, "apr": time.April, "abril": time.April,
+	"may": time.May, "mayo": time.May,
+	"june": time.June, "jun": time.June, "junio": time.June,
+	"july": time.July, "jul": time.July, "julio": time.July,
+	"august": time.August, "aug": time.August, "agosto": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September, "septiembre": time.September,
+	"october": time.October, "oct": time.October, "octubre": time.October,
+	"november": time.November, "nov": time.November, "noviembre": time.November,
+	"december": time.December, "dec": time.December, "diciembre": time.December,
+}
+
+// ExtractDate returns the first explicit, valid calendar date mentioned in text.
+// Numeric dates are read day-first (dd/mm/yyyy).
+func ExtractDate(text string) (time.Time, bool) {
+	best := -1
+	var found time.Time
+	try := func(idx []int, d time.Time, ok bool) {
+		if ok && (best < 0 || idx[0] < best) {
+			best, found = idx[0], d
+		}
+	}
+
+	for _, m := range isoDateRe.FindAllStringSubmatchIndex(text, -1) {
+		if d, ok := makeDate(text[m[2]:m[3]], monthNumber(text[m[4]:m[5]]), text[m[6]:m[7]]); ok {
+			try(m, d, ok)
+			break
+		}
+	}
+	for _, m := range numericDateRe.FindAllStringSubmatchIndex(text, -1) {
+		if d, ok := makeDate(text[m[6]:m[7]], monthNumber(text[m[4]:m[5]]), text[m[2]:m[3]]); ok {
+			try(m, d, ok)
+			break
+		}
+	}
+	for _, m := range monthFirstRe.FindAllStringSubmatchIndex(text, -1) {
+		month, known := monthNames[strings.ToLower(text[m[2]:m[3]])]
+		if d, ok := makeDate(text[m[6]:m[7]], month, text[m[4]:m[5]]); known && ok {
+			try(m, d, ok)
+			break
+		}
+	}
+	for _, m := range dayFirstRe.FindAllStringSubmatchIndex(text, -1) {
+		month, known := monthNames[strings.ToLower(text[m[4]:m[5]])]
+		if d, ok := makeDate(text[m[6]:m[7]], month, text[m[2]:m[3]]); known && ok {
+			try(m, d, ok)
+			break
+		}
+	}
+	return found, best >= 0
+}
+
+func monthNumber(s string) time.Month {
+	n, _ := strconv.Atoi(s)
+	return time.Month(n)
+}
+
+// makeDate validates the parts, rejecting overflowing days like 31/02
+func makeDate(year string, month time.Month, day string) (time.Time, bool) {
+	y, err := strconv.Atoi(year)
+	if err != nil || y < 1000 || month < time.January || month > time.December {
+		return time.Time{}, false
+	}
+	dd, err := strconv.Atoi(day)
+	if err != nil || dd < 1 {
+		return time.Time{}, false
+	}
+	t := time.Date(y, month, dd, 0, 0, 0, 0, time.UTC)
+	if t.Day() != dd {
+		return time.Time{}, false
+	}
+	return t, true
+}
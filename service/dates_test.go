@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtractDate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string // empty when no date is found
+	}{
+		{"iso", "Released 2024-03-15 today", "2024-03-15"},
+		{"numeric, day first", "signed on 15/03/2024", "2024-03-15"},
+		{"month first", "Updated March 15, 2024.", "2024-03-15"},
+		{"month first with ordinal", "due March 1st, 2024", "2024-03-01"},
+		{"abbreviated month", "since Sept. 3 2023", "2023-09-03"},
+		{"day first", "meeting of 7 June 2022", "2022-06-07"},
+		{"spanish", "Madrid, 15 de marzo de 2024", "2024-03-15"},
+		{"earliest mention wins", "signed 1/2/2023, due 2024-05-01", "2023-02-01"},
+		{"invalid day skipped", "31/02/2024 then 2024-01-10", "2024-01-10"},
+		{"invalid month", "build 2024-13-01", ""},
+		{"unknown month name", "Version 12 2024", ""},
+		{"no date", "no dates here", ""},
+	}
+	for _, tt := range tests {
+		d, ok := ExtractDate(tt.text)
+		got := ""
+		if ok {
+			got = d.Format(time.DateOnly)
+		}
+		if got != tt.want {
+			t.Errorf("%s: ExtractDate(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ContentHash identifies a document by its text and figures, so the same content uploaded twice
+// (under any name) is indexed once. Whitespace runs are collapsed, so copies differing only in
+// line endings or indentation match.
+func ContentHash(text string, figures []Figure) string {
+	h := sha256.New()
+	h.Write([]byte(strings.Join(strings.Fields(text), " ")))
+	for _, fig := range figures {
+		fh := sha256.Sum256(fig.Data)
+		h.Write([]byte{0})
+		h.Write(fh[:])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
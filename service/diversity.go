@@ -0,0 +1,25 @@
+package service
+
+import "github.com/Thaizir/go-local-RAG/repo"
+
+// sourceCapOverfetch multiplies the candidates retrieved when Config.MaxChunksPerSource is set,
+// so the top-K can still be filled from other sources once a verbose one is capped
+const sourceCapOverfetch = 3
+
+// capPerSource keeps, in rank order, at most perSource chunks of each source and at most topK
+// chunks overall
+func capPerSource(docs []repo.Document, perSource, topK int) []repo.Document {
+	counts := make(map[string]int)
+	out := make([]repo.Document, 0, min(len(docs), topK))
+	for _, d := range docs {
+		if len(out) == topK {
+			break
+		}
+		if counts[d.Source] == perSource {
+			continue
+		}
+		counts[d.Source]++
+		out = append(out, d)
+	}
+	return out
+}
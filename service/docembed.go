@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// meanEmbedding accumulates the document-level embedding of a document: the mean of its chunk
+// vectors, each normalized and weighted by the length of its text so short fragments (a title,
+// a page footer) do not pull the document towards them
+type meanEmbedding struct {
+	sum []float64
+}
+
+func (m *meanEmbedding) add(emb []float32, weight int) {
+	var norm float64
+	for _, f := range emb {
+		norm += float64(f) * float64(f)
+	}
+	if norm == 0 || weight <= 0 {
+		return
+	}
+	if m.sum == nil {
+		m.sum = make([]float64, len(emb))
+	}
+	scale := float64(weight) / math.Sqrt(norm)
+	for i, f := range emb {
+		m.sum[i] += float64(f) * scale
+	}
+}
+
+// vector returns the normalized mean, nil when nothing was added
+func (m *meanEmbedding) vector() []float32 {
+	var norm float64
+	for _, f := range m.sum {
+		norm += f * f
+	}
+	if norm == 0 {
+		return nil
+	}
+	norm = math.Sqrt(norm)
+	out := make([]float32, len(m.sum))
+	for i, f := range m.sum {
+		out[i] = float32(f / norm)
+	}
+	return out
+}
+
+// summaryFirst restricts filter to the Config.SummaryFirst documents most similar to the query
+// embedding. Without document embeddings yet (nothing indexed since they were introduced and
+// no chunk to average), the filter is left as is.
+func (s *RAGService) summaryFirst(ctx context.Context, col repo.Collection, emb []float32, filter repo.SearchFilter) (repo.SearchFilter, error) {
+	if s.cfg.SummaryFirst <= 0 || len(filter.DocumentIDs) > 0 || filter.AllVersions {
+		return filter, nil
+	}
+	docs, err := s.repo.SimilarDocuments(ctx, emb, s.cfg.SummaryFirst, repo.SimilarDocumentsOptions{Collection: col.Name, Session: filter.Session})
+	if err != nil {
+		return filter, annotateDimensionErr(err, col)
+	}
+	for _, d := range docs {
+		filter.DocumentIDs = append(filter.DocumentIDs, d.ID)
+	}
+	return filter, nil
+}
+
+// RelatedDocuments returns the topK current documents of the same collection most similar to
+// document id, other versions of its source excluded, reporting false if there is no such
+// document. A document indexed without chunks has no embedding and no related documents.
+func (s *RAGService) RelatedDocuments(ctx context.Context, id int64, topK int) ([]repo.ScoredDocument, bool, error) {
+	doc, found, err := s.repo.GetDocument(ctx, id)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	emb, err := s.repo.DocumentEmbedding(ctx, id)
+	if err != nil || emb == nil {
+		return nil, true, err
+	}
+	related, err := s.repo.SimilarDocuments(ctx, emb, topK, repo.SimilarDocumentsOptions{
+		Collection:    doc.Collection,
+		Session:       doc.Session,
+		ExcludeSource: doc.Source,
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	return related, true, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Document types with dedicated answer instructions; any other valid tag is stored as is
+const (
+	DocTypeCode         = "code"
+	DocTypeLegal        = "legal"
+	DocTypeMeetingNotes = "meeting-notes"
+)
+
+var docTypeRe = regexp.MustCompile(`^[a-z][a-z0-9-]{0,39}$`)
+
+// Passage is a retrieved chunk with what the prompt needs to know about it
+type Passage struct {
+	Content string `json:"content"`
+	Source  string `json:"source"`
+	// Type is the document type of the chunk, empty if untagged
+	Type string `json:"type,omitempty"`
+	// DocumentID is the indexed document the chunk belongs to, 0 if unknown
+	DocumentID int64 `json:"document_id,omitempty"`
+	// Position is the place of the chunk in its document, 0 if unknown; a passage merged from
+	// neighboring chunks keeps the position of the retrieved one
+	Position int `json:"position,omitempty"`
+	// Page and Section locate the chunk in its document, zero if unknown
+	Page    int    `json:"page,omitempty"`
+	Section string `json:"section,omitempty"`
+	// Score is the cosine similarity of the chunk to the question, nil for a chunk found by
+	// keyword only
+	Score *float64 `json:"score,omitempty"`
+	// chunkID is the retrieved chunk, for the access statistics
+	chunkID int
+	// parentID is the parent section of the chunk with Config.ParentRetrieval, 0 otherwise; once
+	// expandParents ran, set only on passages holding their section
+	parentID int64
+}
+
+// ChunkID returns the ID of the retrieved chunk, 0 for a passage not read from the store
+func (p Passage) ChunkID() int { return p.chunkID }
+
+// ParseDocType normalizes a document type tag: lowercase letters, digits and dashes
+func ParseDocType(v string) (string, error) {
+	t := strings.ToLower(strings.TrimSpace(v))
+	if t != "" && !docTypeRe.MatchString(t) {
+		return "", fmt.Errorf("invalid document type %q: use lowercase letters, digits and dashes", v)
+	}
+	return t, nil
+}
+
+// DominantType returns the type shared by more than half of the passages, or "" if none is
+func DominantType(passages []Passage) string {
+	counts := make(map[string]int)
+	for _, p := range passages {
+		if p.Type != "" {
+			counts[p.Type]++
+		}
+	}
+	for t, n := range counts {
+		if n*2 > len(passages) {
+			return t
+		}
+	}
+	return ""
+}
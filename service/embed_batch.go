@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// GenerateEmbeddings embeds texts with the default collection's model, in batches of
+// Config.EmbedBatchSize; the result is in the order of texts
+func (s *RAGService) GenerateEmbeddings(texts []string) ([][]float32, error) {
+	return s.embedBatch(context.Background(), s.cfg.EmbeddingModel, texts)
+}
+
+// embedBatch embeds texts with model through Ollama's /api/embed, several inputs per call.
+// Ollama versions without that endpoint get one /api/embeddings call per text instead.
+func (s *RAGService) embedBatch(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	size := max(s.cfg.EmbedBatchSize, 1)
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += size {
+		batch := texts[start:min(start+size, len(texts))]
+		var embs [][]float32
+		var err error
+		if size > 1 && !s.legacyEmbed.Load() {
+			embs, err = s.embedInputs(ctx, model, batch)
+		}
+		if size == 1 || s.legacyEmbed.Load() {
+			embs, err = s.embedEach(ctx, model, batch)
+		}
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, embs...)
+	}
+	return embeddings, nil
+}
+
+// embedEach embeds texts one call at a time
+func (s *RAGService) embedEach(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	embs := make([][]float32, len(texts))
+	for i, text := range texts {
+		var err error
+		if embs[i], err = s.embedWith(ctx, model, text); err != nil {
+			return nil, err
+		}
+	}
+	return embs, nil
+}
+
+// embedInputs embeds texts in one /api/embed call. When the server does not know the endpoint,
+// it switches the service to /api/embeddings and returns no error and no embeddings.
+func (s *RAGService) embedInputs(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	reqBody := map[string]any{
+		"model": model,
+		"input": texts,
+	}
+	jsonData, err := json.Marshal(s.withKeepAlive(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.OllamaURL+"/api/embed", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, &OllamaError{Op: "embed", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var raw struct {
+			Error string `json:"error"`
+		}
+		// an unknown model is a JSON error; an unknown endpoint is a plain "404 page not found"
+		if resp.StatusCode == http.StatusNotFound && (json.Unmarshal(body, &raw) != nil || raw.Error == "") {
+			if !s.legacyEmbed.Swap(true) {
+				log.Printf("warning: Ollama at %s has no /api/embed, embedding one chunk per call; upgrade it for faster indexing", s.cfg.OllamaURL)
+			}
+			return nil, nil
+		}
+		return nil, &OllamaError{Op: "embed", Status: resp.StatusCode, Message: string(bytes.TrimSpace(body))}
+	}
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing embed JSON: %w", err)
+	}
+	if len(result.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ollama embed returned %d embeddings for %d inputs", len(result.Embeddings), len(texts))
+	}
+	for i, emb := range result.Embeddings {
+		if len(emb) == 0 {
+			return nil, fmt.Errorf("ollama embed returned an empty vector for input %d", i)
+		}
+	}
+	return result.Embeddings, nil
+}
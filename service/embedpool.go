@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// embeddedChunk is a chunk prepared for storage by embedChunks
+type embeddedChunk struct {
+	chunk repo.Chunk
+	// text is the chunk without the document summary, which entities are extracted from
+	// when ner is set
+	text string
+	ner  bool
+	// nerErr fails the whole document; embedErr only quarantines the chunk
+	nerErr   error
+	embedErr error
+	// ready is closed once the chunk is prepared
+	ready chan struct{}
+}
+
+// embedChunks extracts the entities of the items that ask for it and embeds them in the
+// background, in batches of Config.EmbedBatchSize with up to Config.EmbedConcurrency batches in
+// flight, and returns at once. Each item is prepared when its ready channel is closed; once ctx
+// is done, remaining items never are.
+func (s *RAGService) embedChunks(ctx context.Context, col repo.Collection, items []*embeddedChunk) {
+	size := max(s.cfg.EmbedBatchSize, 1)
+	var batches [][]*embeddedChunk
+	for start := 0; start < len(items); start += size {
+		batches = append(batches, items[start:min(start+size, len(items))])
+	}
+	next := make(chan []*embeddedChunk)
+	go func() {
+		defer close(next)
+		for _, b := range batches {
+			select {
+			case <-ctx.Done():
+				return
+			case next <- b:
+			}
+		}
+	}()
+	for range min(max(s.cfg.EmbedConcurrency, 1), len(batches)) {
+		go func() {
+			for b := range next {
+				s.prepareBatch(ctx, col, b)
+				for _, it := range b {
+					close(it.ready)
+				}
+			}
+		}()
+	}
+}
+
+// prepareBatch fills the entities and the embeddings of the chunks of batch. When the batch
+// call fails, its chunks are embedded one by one so only the failing ones are quarantined.
+func (s *RAGService) prepareBatch(ctx context.Context, col repo.Collection, batch []*embeddedChunk) {
+	var pending []*embeddedChunk
+	for _, it := range batch {
+		if it.ner {
+			if it.chunk.Entities, it.nerErr = s.ExtractEntities(ctx, it.text); it.nerErr != nil {
+				continue
+			}
+		}
+		if it.chunk.Embedding != nil {
+			// precomputed (see Record.Embedding)
+			continue
+		}
+		pending = append(pending, it)
+	}
+	if len(pending) > 1 {
+		texts := make([]string, len(pending))
+		for i, it := range pending {
+			texts[i] = it.chunk.Content
+		}
+		if embs, err := s.embedBatch(ctx, col.Model, texts); err == nil {
+			for i, it := range pending {
+				it.chunk.Embedding = embs[i]
+			}
+			return
+		}
+	}
+	for _, it := range pending {
+		it.chunk.Embedding, it.embedErr = s.embedFor(ctx, col, it.chunk.Content)
+	}
+}
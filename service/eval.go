@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// regressionDrop is the fall in hit rate or MRR between two runs reported as a regression
+const regressionDrop = 0.05
+
+// GoldenQuestion is a question of the evaluation suite with the sources that answer it
+type GoldenQuestion struct {
+	Question string `json:"question"`
+	// Collection is searched for the question; empty means the default one
+	Collection string `json:"collection,omitempty"`
+	// ExpectedSources hold the answer; retrieving any of them counts as a hit
+	ExpectedSources []string `json:"expected_sources"`
+}
+
+// LoadGoldenSet reads a JSON array of golden questions
+func LoadGoldenSet(path string) ([]GoldenQuestion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading golden set: %w", err)
+	}
+	var golden []GoldenQuestion
+	if err := json.Unmarshal(data, &golden); err != nil {
+		return nil, fmt.Errorf("error parsing golden set %s: %w", path, err)
+	}
+	for i, g := range golden {
+		if g.Question == "" || len(g.ExpectedSources) == 0 {
+			return nil, fmt.Errorf("golden question %d needs a question and expected sources", i+1)
+		}
+	}
+	return golden, nil
+}
+
+// Evaluate runs the golden questions through retrieval and measures how well the expected
+// sources are found in the top k. Questions are not logged, so evaluations do not skew the query log.
+func (s *RAGService) Evaluate(ctx context.Context, golden []GoldenQuestion, k int) (repo.EvalRun, error) {
+	run := repo.EvalRun{Questions: len(golden), K: k, EmbeddingModel: s.cfg.EmbeddingModel}
+	if v, ok := s.repo.(versioner); ok {
+		var err error
+		if run.CorpusVersion, err = v.CorpusVersion(ctx); err != nil {
+			return run, err
+		}
+	}
+	hits, found, expected := 0, 0, 0
+	var rr float64
+	for _, g := range golden {
+		col, err := s.Collection(ctx, g.Collection)
+		if err != nil {
+			return run, err
+		}
+		passages, err := s.retrieve(ctx, col, g.Question, k, SearchOptions{SearchFilter: repo.SearchFilter{Collection: col.Name}})
+		if err != nil {
+			return run, fmt.Errorf("evaluating %q: %w", g.Question, err)
+		}
+		rank := 0
+		for i, p := range passages {
+			if slices.Contains(g.ExpectedSources, p.Source) {
+				rank = i + 1
+				break
+			}
+		}
+		if rank > 0 {
+			hits++
+			rr += 1 / float64(rank)
+		} else {
+			run.Missed = append(run.Missed, g.Question)
+		}
+		expected += len(g.ExpectedSources)
+		for _, src := range g.ExpectedSources {
+			if slices.ContainsFunc(passages, func(p Passage) bool { return p.Source == src }) {
+				found++
+			}
+		}
+	}
+	if n := float64(len(golden)); n > 0 {
+		run.HitRate, run.MRR = float64(hits)/n, rr/n
+	}
+	if expected > 0 {
+		run.Recall = float64(found) / float64(expected)
+	}
+	return run, nil
+}
+
+// RunEvaluations evaluates the golden questions every interval, storing each run, until ctx is
+// done. The first run waits for the interval to elapse since the last stored one, so restarts
+// do not evaluate again.
+func (s *RAGService) RunEvaluations(ctx context.Context, golden []GoldenQuestion, k int, interval time.Duration) {
+	var lastAttempt time.Time
+	for {
+		wait := time.Duration(0)
+		if runs, err := s.repo.EvalRuns(ctx, 1); err != nil {
+			log.Printf("warning: %v", err)
+		} else if len(runs) > 0 {
+			wait = time.Until(runs[0].CreatedAt.Add(interval))
+		}
+		// failed attempts are retried at the next interval rather than in a loop
+		if !lastAttempt.IsZero() {
+			wait = max(wait, time.Until(lastAttempt.Add(interval)))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		lastAttempt = time.Now()
+		run, err := s.Evaluate(ctx, golden, k)
+		if err != nil {
+			log.Printf("warning: evaluation failed: %v", err)
+			continue
+		}
+		if err := s.repo.SaveEvalRun(ctx, run); err != nil {
+			log.Printf("warning: %v", err)
+			continue
+		}
+		log.Printf("Evaluation: hit rate %.2f, MRR %.3f, recall %.2f over %d questions", run.HitRate, run.MRR, run.Recall, run.Questions)
+		if trends, err := s.EvalTrends(ctx, 2); err == nil && len(trends) == 2 && trends[1].Regression {
+			log.Printf("warning: retrieval quality regressed since the previous evaluation (hit rate %+.2f, MRR %+.3f); missed: %q",
+				trends[1].DeltaHitRate, trends[1].DeltaMRR, run.Missed)
+		}
+	}
+}
+
+// EvalTrend is a stored evaluation run compared with the previous one
+type EvalTrend struct {
+	repo.EvalRun
+	DeltaHitRate float64 `json:"delta_hit_rate"`
+	DeltaMRR     float64 `json:"delta_mrr"`
+	// Regression is set when hit rate or MRR fell by more than regressionDrop
+	Regression bool `json:"regression"`
+}
+
+// EvalTrends returns the latest limit evaluation runs, oldest first, each compared with the run before
+func (s *RAGService) EvalTrends(ctx context.Context, limit int) ([]EvalTrend, error) {
+	runs, err := s.repo.EvalRuns(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	trends := make([]EvalTrend, len(runs))
+	for i, run := range runs {
+		trends[i].EvalRun = run
+		if i == 0 {
+			continue
+		}
+		prev := runs[i-1]
+		trends[i].DeltaHitRate = run.HitRate - prev.HitRate
+		trends[i].DeltaMRR = run.MRR - prev.MRR
+		trends[i].Regression = trends[i].DeltaHitRate < -regressionDrop || trends[i].DeltaMRR < -regressionDrop
+	}
+	return trends, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Origins of a document (see Document.Origin), the keys of Config.TTLs
+const (
+	OriginUpload = "upload"
+	OriginURL    = "url"
+	OriginFeed   = "feed"
+)
+
+// Origins lists the known document origins
+var Origins = []string{OriginUpload, OriginURL, OriginFeed}
+
+// expiresAt is when an indexed document expires: after its own TTL, or else the TTL of its
+// origin; nil when it never does
+func (s *RAGService) expiresAt(doc Document) *time.Time {
+	ttl := doc.TTL
+	if ttl <= 0 {
+		ttl = s.cfg.TTLs[doc.Origin]
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	t := time.Now().Add(ttl)
+	return &t
+}
+
+// PruneQueryLog deletes the logged questions older than Config.QueryLogRetention, checking once
+// an hour until ctx is done
+func (s *RAGService) PruneQueryLog(ctx context.Context) {
+	if s.cfg.QueryLogRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		n, err := s.repo.PruneQueryLog(ctx, time.Now().Add(-s.cfg.QueryLogRetention))
+		if err != nil {
+			log.Printf("warning: pruning the query log: %v", err)
+		} else if n > 0 {
+			log.Printf("Query log pruned, %d questions deleted", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireDocuments deletes the documents past their time-to-live with their chunks, checking
+// once a minute until ctx is done
+func (s *RAGService) ExpireDocuments(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		n, err := s.repo.DeleteExpired(ctx)
+		if err != nil {
+			log.Printf("warning: deleting expired documents: %v", err)
+		} else if n > 0 {
+			log.Printf("Expired documents deleted, %d chunks", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
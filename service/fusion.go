@@ -0,0 +1,52 @@
+package service
+
+import (
+	"slices"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// rrfK dampens the weight of top ranks in reciprocal rank fusion (the usual value from the RRF paper)
+const rrfK = 60
+
+// shortQueryKeywordWeight makes keyword hits dominate vector hits for short queries
+const shortQueryKeywordWeight = 2.0
+
+// weightedRanking is one ranked result list taking part in a fusion
+type weightedRanking struct {
+	docs   []repo.Document
+	weight float64
+}
+
+// fuseRankings merges rankings with weighted reciprocal rank fusion,
+// deduplicating chunks by ID and keeping the best topK
+func fuseRankings(topK int, rankings ...weightedRanking) []repo.Document {
+	scores := make(map[int]float64)
+	byID := make(map[int]repo.Document)
+	for _, r := range rankings {
+		for rank, d := range r.docs {
+			scores[d.ID] += r.weight / float64(rrfK+rank+1)
+			// keep the vector distance of a chunk first found by keyword
+			if seen, ok := byID[d.ID]; !ok || seen.Distance == nil {
+				byID[d.ID] = d
+			}
+		}
+	}
+	fused := make([]repo.Document, 0, len(byID))
+	for _, d := range byID {
+		fused = append(fused, d)
+	}
+	slices.SortFunc(fused, func(a, b repo.Document) int {
+		if scores[a.ID] != scores[b.ID] {
+			if scores[a.ID] > scores[b.ID] {
+				return -1
+			}
+			return 1
+		}
+		return a.ID - b.ID
+	})
+	if len(fused) > topK {
+		fused = fused[:topK]
+	}
+	return fused
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// docsOf returns documents with the given chunk IDs, in order
+func docsOf(ids ...int) []repo.Document {
+	docs := make([]repo.Document, len(ids))
+	for i, id := range ids {
+		docs[i] = repo.Document{ID: id}
+	}
+	return docs
+}
+
+func docIDs(docs []repo.Document) []int {
+	ids := make([]int, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+func TestFuseRankings(t *testing.T) {
+	tests := []struct {
+		name     string
+		topK     int
+		rankings []weightedRanking
+		want     []int
+	}{
+		{"one ranking", 10, []weightedRanking{{docsOf(3, 1, 2), 1}}, []int{3, 1, 2}},
+		{"keeps topK", 2, []weightedRanking{{docsOf(3, 1, 2), 1}}, []int{3, 1}},
+		{"agreement wins", 10, []weightedRanking{{docsOf(1, 2, 3), 1}, {docsOf(3, 2, 4), 1}}, []int{3, 2, 1, 4}},
+		{"weighted keywords", 10, []weightedRanking{{docsOf(1), shortQueryKeywordWeight}, {docsOf(2), 1}}, []int{1, 2}},
+		{"weighted vectors", 10, []weightedRanking{{docsOf(1), 1}, {docsOf(2), shortQueryKeywordWeight}}, []int{2, 1}},
+		{"ties by ID", 10, []weightedRanking{{docsOf(5), 1}, {docsOf(2), 1}}, []int{2, 5}},
+		{"empty", 10, []weightedRanking{{nil, 1}, {nil, 1}}, []int{}},
+	}
+	for _, tt := range tests {
+		if got := docIDs(fuseRankings(tt.topK, tt.rankings...)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFuseRankingsKeepsDistance(t *testing.T) {
+	distance := 0.2
+	keyword := []repo.Document{{ID: 1, Content: "keyword"}}
+	vector := []repo.Document{{ID: 1, Content: "vector", Distance: &distance}}
+	fused := fuseRankings(10, weightedRanking{keyword, 1}, weightedRanking{vector, 1})
+	if len(fused) != 1 || fused[0].Distance == nil || *fused[0].Distance != distance {
+		t.Errorf("fused %+v, want the one chunk with the vector distance", fused)
+	}
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// RecordAnswer adds a question and its answer to a user's history, embedded together so later
+// searches match either, and returns the entry id. The chunks of the passages the answer was
+// built from are kept for TrainingExamples, and the conversation session, if any, for
+// StandaloneQuestion.
+func (s *RAGService) RecordAnswer(ctx context.Context, user, session, question, answer string, passages []Passage) (int64, error) {
+	emb, err := s.GenerateEmbedding(question + "\n\n" + answer)
+	if err != nil {
+		return 0, fmt.Errorf("embedding answer: %w", err)
+	}
+	chunkIDs := make([]int, len(passages))
+	for i, p := range passages {
+		chunkIDs[i] = p.chunkID
+	}
+	return s.repo.SaveAnswer(ctx, repo.HistoryEntry{
+		User:      user,
+		Session:   session,
+		Question:  question,
+		Answer:    answer,
+		Model:     s.cfg.EmbeddingModel,
+		Embedding: emb,
+		ChunkIDs:  chunkIDs,
+	})
+}
+
+// ClickPassages records that a user opened passages (1-based) of an answer of their history,
+// the clicks TrainingExamples learns from
+func (s *RAGService) ClickPassages(ctx context.Context, user string, id int64, passages []int) (bool, error) {
+	return s.repo.ClickPassages(ctx, user, id, passages)
+}
+
+// SearchHistory finds the past questions and answers of a user closest to query
+func (s *RAGService) SearchHistory(ctx context.Context, user, query string, topK int) ([]repo.HistoryEntry, error) {
+	emb, err := s.GenerateEmbedding(query)
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	return s.repo.SearchHistory(ctx, user, s.cfg.EmbeddingModel, emb, topK)
+}
+
+// RecentHistory returns the latest questions and answers of a user
+func (s *RAGService) RecentHistory(ctx context.Context, user string, limit int) ([]repo.HistoryEntry, error) {
+	return s.repo.RecentHistory(ctx, user, limit)
+}
+
+// answerPassages caps the validated answers mixed into the passages of a query
+const answerPassages = 2
+
+// RateAnswer records a user's rating of an answer of their history (1 up, -1 down, 0 none),
+// reporting false if there is no such entry. With an AnswersCollection, a thumbs-up indexes the
+// question and answer into it and any other rating removes them.
+func (s *RAGService) RateAnswer(ctx context.Context, user string, id int64, rating int) (bool, error) {
+	entry, found, err := s.repo.RateAnswer(ctx, user, id, rating)
+	if err != nil || !found || s.cfg.AnswersCollection == "" {
+		return found, err
+	}
+	source := "answer:" + strconv.FormatInt(id, 10)
+	if rating <= 0 {
+		_, err := s.RemoveSource(ctx, s.cfg.AnswersCollection, source)
+		return true, err
+	}
+	doc := Document{
+		Content:    "Question: " + entry.Question + "\n\nAnswer: " + entry.Answer,
+		Source:     source,
+		Date:       entry.CreatedAt,
+		Collection: s.cfg.AnswersCollection,
+	}
+	// the entry never changes, so its id is enough of a version to skip repeated thumbs-up
+	if _, _, err := s.SyncSource(ctx, doc, source); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// searchAnswers retrieves the validated answers closest to question, with the filters of the query
+func (s *RAGService) searchAnswers(ctx context.Context, question string, filter repo.SearchFilter) ([]repo.Document, error) {
+	col, err := s.Collection(ctx, s.cfg.AnswersCollection)
+	if err != nil {
+		return nil, err
+	}
+	emb, err := s.embedFor(ctx, col, question)
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	filter.Collection = col.Name
+	docs, err := s.searchSimilar(ctx, emb, answerPassages, repo.SearchOptions{Filter: filter, Fields: repo.FieldDocType})
+	if err != nil {
+		return nil, annotateDimensionErr(err, col)
+	}
+	return docs, nil
+}
+
+// condensePrompt asks for a follow-up question rewritten to stand on its own
+const condensePrompt = `Below is a conversation between a user and an assistant, then a follow-up question of
+the user. Rewrite the follow-up question as a standalone question that can be understood
+without the conversation: replace pronouns and references ("the second one", "that", "it")
+with what they refer to. If it already stands on its own, repeat it unchanged. Answer with the
+question only, in its language.
+
+Conversation:
+%s
+Follow-up question: %s`
+
+// condenseAnswerRunes caps how much of every past answer the condense prompt quotes
+const condenseAnswerRunes = 600
+
+// StandaloneQuestion rewrites a follow-up question of a user in the conversation session into a
+// question that stands on its own, from the Config.FollowUpTurns latest turns, so "what about
+// the second one?" is embedded with what it refers to. The question is returned as is outside a
+// conversation or on failure: the rewrite improves retrieval but is not needed for it.
+func (s *RAGService) StandaloneQuestion(ctx context.Context, user, session, question string) string {
+	if s.cfg.FollowUpTurns <= 0 || user == "" || session == "" {
+		return question
+	}
+	turns, err := s.repo.SessionHistory(ctx, user, session, s.cfg.FollowUpTurns)
+	if err != nil {
+		log.Printf("warning: not rewriting follow-up question: %v", err)
+		return question
+	}
+	if len(turns) == 0 {
+		return question
+	}
+	var conversation strings.Builder
+	for _, t := range turns {
+		fmt.Fprintf(&conversation, "User: %s\nAssistant: %s\n", t.Question, truncateRunes(t.Answer, condenseAnswerRunes))
+	}
+	model := cmp.Or(s.cfg.CondenseModel, s.cfg.LLMModel)
+	out, err := s.generate(ctx, map[string]any{
+		"model":   model,
+		"prompt":  fmt.Sprintf(condensePrompt, conversation.String(), question),
+		"stream":  false,
+		"options": map[string]any{"temperature": 0},
+	})
+	if err != nil {
+		log.Printf("warning: not rewriting follow-up question with %s: %v", model, err)
+		return question
+	}
+	// keep the first line in case the model adds commentary
+	out, _, _ = strings.Cut(strings.TrimSpace(out), "\n")
+	return cmp.Or(strings.TrimSpace(out), question)
+}
+
+// truncateRunes cuts s to at most n runes, marking the cut with an ellipsis
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// hydePrompt asks for a passage answering a question, whatever its accuracy
+const hydePrompt = `Write a short passage, as it could appear in a document, that answers the question
+below. Use the vocabulary and style of such a document. If you do not know the answer, make up a
+plausible one. Answer with the passage only, in the language of the question.
+
+Question: %s`
+
+// hydeTokens caps the length of the hypothetical passage, about that of a chunk
+const hydeTokens = 256
+
+// hypotheticalPassage asks the HyDE model for a passage answering question, which embeds
+// closer to the chunks answering it than the question itself (hypothetical document
+// embeddings). On failure the question is returned: the passage improves retrieval but is not
+// needed for it.
+func (s *RAGService) hypotheticalPassage(ctx context.Context, question string) string {
+	model := cmp.Or(s.cfg.HyDEModel, s.cfg.LLMModel)
+	out, err := s.generate(ctx, map[string]any{
+		"model":   model,
+		"prompt":  fmt.Sprintf(hydePrompt, question),
+		"stream":  false,
+		"options": map[string]any{"num_predict": hydeTokens},
+	})
+	if out = strings.TrimSpace(out); err != nil || out == "" {
+		if err == nil {
+			err = fmt.Errorf("%s wrote nothing", model)
+		}
+		log.Printf("warning: searching with the question instead of a hypothetical passage: %v", err)
+		return question
+	}
+	return out
+}
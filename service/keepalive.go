@@ -0,0 +1,37 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ParseKeepAlive converts a keep-alive setting into the value Ollama expects for keep_alive:
+// a plain number is seconds (negative keeps the model loaded forever, 0 unloads it right away),
+// anything else must be a Go duration such as "10m". Empty returns nil (server default).
+func ParseKeepAlive(v string) (any, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(v); err == nil {
+		return n, nil
+	}
+	if _, err := time.ParseDuration(v); err != nil {
+		return nil, fmt.Errorf("keep alive %q is neither seconds nor a duration", v)
+	}
+	return v, nil
+}
+
+// KeepAlive returns the keep_alive value sent to Ollama, or nil to use the server default
+func (s *RAGService) KeepAlive() any {
+	v, _ := ParseKeepAlive(s.cfg.KeepAlive)
+	return v
+}
+
+// withKeepAlive adds the configured keep_alive to an Ollama request body
+func (s *RAGService) withKeepAlive(body map[string]any) map[string]any {
+	if v := s.KeepAlive(); v != nil {
+		body["keep_alive"] = v
+	}
+	return body
+}
@@ -0,0 +1,26 @@
+package service
+
+import "testing"
+
+func TestParseKeepAlive(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    any
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"300", 300, false},
+		{"-1", -1, false},
+		{"0", 0, false},
+		{"10m", "10m", false},
+		{"1h30m", "1h30m", false},
+		{"soon", nil, true},
+		{"10 m", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeepAlive(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseKeepAlive(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+)
+
+var headingRe = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+// mdBlock is an indivisible unit of a markdown section: a paragraph, a fenced code block or a table
+type mdBlock struct {
+	text  string
+	code  bool
+	table *table
+}
+
+// MarkdownChunker splits markdown on headings, packing each section's blocks into chunks of at
+// most Size units. Fenced code blocks are never split, even when longer than Size; oversized
+// paragraphs are chunked with Text. Every chunk is prefixed with its heading path
+// ("Install > Linux") so it carries its structural context.
+type MarkdownChunker struct {
+	Sizing
+	Text Chunker
+}
+
+func (c MarkdownChunker) Chunk(text string, meta map[string]string) []Chunk {
+	var chunks []Chunk
+	var path []string
+	var body []string
+
+	flush := func() {
+		section := strings.Join(path, " > ")
+		for _, t := range c.packBlocks(markdownBlocks(body), meta) {
+			if section != "" {
+				t = section + "\n\n" + t
+			}
+			chunks = append(chunks, Chunk{Text: t, Section: section})
+		}
+		body = nil
+	}
+
+	fence := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			body = append(body, line)
+			continue
+		}
+		if f := fenceMarker(trimmed); f != "" {
+			fence = f
+			body = append(body, line)
+			continue
+		}
+		if m := headingRe.FindStringSubmatch(trimmed); m != nil {
+			flush()
+			level := len(m[1])
+			if level <= len(path) {
+				path = path[:level-1]
+			}
+			for len(path) < level-1 {
+				path = append(path, "")
+			}
+			path = append(path, m[2])
+			path = compactPath(path)
+			continue
+		}
+		body = append(body, line)
+	}
+	flush()
+	return chunks
+}
+
+// compactPath drops the placeholders left by skipped heading levels (# then ###)
+func compactPath(path []string) []string {
+	out := path[:0]
+	for _, p := range path {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func fenceMarker(line string) string {
+	for _, f := range []string{"```", "~~~"} {
+		if strings.HasPrefix(line, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// markdownBlocks groups section lines into paragraphs, fenced code blocks and tables
+func markdownBlocks(lines []string) []mdBlock {
+	var blocks []mdBlock
+	var para []string
+	flushPara := func() {
+		if t := strings.TrimSpace(strings.Join(para, "\n")); t != "" {
+			blocks = append(blocks, mdBlock{text: t})
+		}
+		para = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if f := fenceMarker(trimmed); f != "" {
+			flushPara()
+			start := i
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), f); i++ {
+			}
+			end := min(i+1, len(lines))
+			blocks = append(blocks, mdBlock{text: strings.Join(lines[start:end], "\n"), code: true})
+			continue
+		}
+		if t, n := parsePipeTable(lines[i:]); n > 0 {
+			flushPara()
+			blocks = append(blocks, mdBlock{table: t})
+			i += n - 1
+			continue
+		}
+		if trimmed == "" {
+			flushPara()
+			continue
+		}
+		para = append(para, lines[i])
+	}
+	flushPara()
+	return blocks
+}
+
+// packBlocks joins consecutive blocks into chunks of at most Size units.
+// Oversized paragraphs are re-chunked and oversized tables split with repeated headers;
+// code blocks stay whole.
+func (c MarkdownChunker) packBlocks(blocks []mdBlock, meta map[string]string) []string {
+	var chunks []string
+	var cur []string
+	size := 0
+	flush := func() {
+		if len(cur) > 0 {
+			chunks = append(chunks, strings.Join(cur, "\n\n"))
+		}
+		cur, size = nil, 0
+	}
+
+	for _, b := range blocks {
+		var parts []string
+		switch {
+		case b.table != nil:
+			parts = tableChunks(b.table, c.Size, c.measure)
+		case !b.code && c.measure(b.text) > c.Size:
+			parts = chunkTexts(c.Text.Chunk(b.text, meta))
+		default:
+			parts = []string{b.text}
+		}
+		for _, p := range parts {
+			n := c.measure(p)
+			if size > 0 && size+n > c.Size {
+				flush()
+			}
+			cur = append(cur, p)
+			size += n
+		}
+	}
+	flush()
+	return chunks
+}
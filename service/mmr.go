@@ -0,0 +1,71 @@
+package service
+
+import (
+	"math"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// mmrOverfetch multiplies the candidates retrieved when SearchOptions.MMRLambda is set, so
+// there are other chunks to pick than the near-duplicates at the top
+const mmrOverfetch = 3
+
+// diversify picks up to k of docs by maximal marginal relevance: each next chunk maximizes
+// lambda*sim(question) - (1-lambda)*max sim(chunk already picked), the similarities being the
+// cosines of their vectors (repo.FieldEmbedding). Lambda 1 keeps the relevance order; lower
+// values favor chunks unlike the ones before them. Each pick costs a pass over the candidates,
+// so only the k kept are ranked.
+func diversify(question []float32, docs []repo.Document, lambda float64, k int) []repo.Document {
+	vecs := make([][]float32, len(docs))
+	relevance := make([]float64, len(docs))
+	for i, d := range docs {
+		vecs[i] = d.Vector.Slice()
+		relevance[i] = cosine(question, vecs[i])
+	}
+	// redundancy[i] is the highest similarity of candidate i to a picked chunk
+	redundancy := make([]float64, len(docs))
+	picked := make([]bool, len(docs))
+	k = min(k, len(docs))
+	out := make([]repo.Document, 0, k)
+	for range k {
+		best, bestScore := -1, math.Inf(-1)
+		for i := range docs {
+			if picked[i] {
+				continue
+			}
+			score := lambda*relevance[i] - (1-lambda)*redundancy[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		picked[best] = true
+		out = append(out, docs[best])
+		if len(out) == k {
+			break
+		}
+		for i := range docs {
+			if !picked[i] {
+				redundancy[i] = max(redundancy[i], cosine(vecs[best], vecs[i]))
+			}
+		}
+	}
+	return out
+}
+
+// cosine is the cosine similarity of a and b, 0 when they cannot be compared (a missing vector,
+// or vectors of different models)
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
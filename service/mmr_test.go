@@ -0,0 +1,78 @@
+package service
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+	github_com_pgv "github.com/pgvector/pgvector-go"
+)
+
+func TestDiversify(t *testing.T) {
+	question := []float32{1, 0.2}
+	docs := []repo.Document{
+		{ID: 1, Vector: github_com_pgv.NewVector([]float32{1, 0})},
+		{ID: 2, Vector: github_com_pgv.NewVector([]float32{1, 0.05})},
+		{ID: 3, Vector: github_com_pgv.NewVector([]float32{0, 1})},
+	}
+	tests := []struct {
+		name   string
+		lambda float64
+		k      int
+		want   []int
+	}{
+		{"relevance only", 1, 3, []int{2, 1, 3}},
+		{"near-duplicate pushed down", 0.3, 3, []int{2, 3, 1}},
+		{"stops at k", 0.3, 2, []int{2, 3}},
+		{"k above the candidates", 0.3, 10, []int{2, 3, 1}},
+		{"k zero", 0.3, 0, []int{}},
+	}
+	for _, tt := range tests {
+		if got := docIDs(diversify(question, docs, tt.lambda, tt.k)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same direction", []float32{1, 1}, []float32{2, 2}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 3}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"missing vector", nil, []float32{1, 0}, 0},
+		{"different dimensions", []float32{1, 0}, []float32{1, 0, 0}, 0},
+		{"zero vector", []float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		if got := cosine(tt.a, tt.b); got < tt.want-1e-9 || got > tt.want+1e-9 {
+			t.Errorf("%s: cosine = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCapPerSource(t *testing.T) {
+	docs := []repo.Document{
+		{ID: 1, Source: "a"}, {ID: 2, Source: "a"}, {ID: 3, Source: "b"},
+		{ID: 4, Source: "c"}, {ID: 5, Source: "b"}, {ID: 6, Source: "d"},
+	}
+	tests := []struct {
+		name      string
+		perSource int
+		topK      int
+		want      []int
+	}{
+		{"one per source", 1, 10, []int{1, 3, 4, 6}},
+		{"two per source", 2, 10, []int{1, 2, 3, 4, 5, 6}},
+		{"keeps topK", 1, 3, []int{1, 3, 4}},
+		{"rank order", 2, 4, []int{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if got := docIDs(capPerSource(docs, tt.perSource, tt.topK)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
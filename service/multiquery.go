@@ -0,0 +1,89 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// queryVariantsPrompt asks for reformulations of a question, one per line
+const queryVariantsPrompt = `Write %d different reformulations of the question below, to search a document
+collection for passages answering it. Vary the wording: use synonyms, spell out abbreviations,
+name the likely terms of the documents, make a terse question explicit. Keep the language of the
+question. Answer with one reformulation per line, without numbering or commentary.
+
+Question: %s`
+
+// variantPrefixRe matches the numbering or bullet models put before a line anyway
+var variantPrefixRe = regexp.MustCompile(`^(?:\d+[.)]|[-*•])\s*`)
+
+// queryVariants asks the query variant model for Config.QueryVariants reformulations of
+// question, distinct from it and from each other
+func (s *RAGService) queryVariants(ctx context.Context, question string) ([]string, error) {
+	model := cmp.Or(s.cfg.QueryVariantModel, s.cfg.LLMModel)
+	out, err := s.Generate(ctx, model, fmt.Sprintf(queryVariantsPrompt, s.cfg.QueryVariants, question), "")
+	if err != nil {
+		return nil, fmt.Errorf("error reformulating question with %s: %w", model, err)
+	}
+	seen := map[string]bool{strings.ToLower(strings.TrimSpace(question)): true}
+	var variants []string
+	for line := range strings.Lines(out) {
+		line = strings.Trim(variantPrefixRe.ReplaceAllString(strings.TrimSpace(line), ""), `"`)
+		if key := strings.ToLower(line); line != "" && !seen[key] {
+			seen[key] = true
+			variants = append(variants, line)
+		}
+		if len(variants) == s.cfg.QueryVariants {
+			break
+		}
+	}
+	return variants, nil
+}
+
+// searchVariants runs the vector search of SearchMultiQuery: docs, the results for the
+// question, are fused with those of its reformulations, so chunks worded unlike a terse question
+// are found too. A chunk keeps the similarity to the first query that found it, the question's
+// when it did. On failure docs are returned as they are: the reformulations widen the results
+// but are not needed to answer.
+func (s *RAGService) searchVariants(ctx context.Context, col repo.Collection, question string, docs []repo.Document, topK int, opts repo.SearchOptions) []repo.Document {
+	variants, err := s.queryVariants(ctx, question)
+	if err == nil && len(variants) == 0 {
+		return docs
+	}
+	var embs [][]float32
+	if err == nil {
+		if embs, err = s.embedBatch(ctx, col.Model, variants); err != nil {
+			err = fmt.Errorf("embedding reformulations: %w", err)
+		}
+	}
+	if err != nil {
+		log.Printf("warning: searching the question alone: %v", err)
+		return docs
+	}
+	rankings := make([]weightedRanking, len(embs)+1)
+	rankings[0] = weightedRanking{docs, 1}
+	errs := make([]error, len(embs))
+	var wg sync.WaitGroup
+	for i, emb := range embs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := s.searchSimilar(ctx, emb, topK, opts)
+			rankings[i+1], errs[i] = weightedRanking{found, 1}, err
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("warning: searching the question alone: %v", annotateDimensionErr(err, col))
+			return docs
+		}
+	}
+	return fuseRankings(topK, rankings...)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// expandNeighbors widens every passage with the chunks up to Config.NeighborChunks positions
+// away in its document (window, when non-zero, overriding it; see
+// SearchOptions.NeighborChunks), so the prompt gets whole paragraphs instead of clipped
+// windows.
+// Passages of one document whose neighborhoods touch become a single passage, ranked where the
+// best of them was. Passages without a position (figures, older chunks) and passages already
+// holding their parent section are kept as they are.
+func (s *RAGService) expandNeighbors(ctx context.Context, passages []Passage, window int) ([]Passage, error) {
+	if window == 0 {
+		window = s.cfg.NeighborChunks
+	}
+	if window <= 0 {
+		return passages, nil
+	}
+	var refs []repo.ChunkRef
+	for _, p := range passages {
+		if p.DocumentID != 0 && p.Position != 0 && p.parentID == 0 {
+			refs = append(refs, repo.ChunkRef{DocumentID: p.DocumentID, Position: p.Position})
+		}
+	}
+	if len(refs) == 0 {
+		return passages, nil
+	}
+	chunks, err := s.repo.ChunkNeighbors(ctx, refs, window)
+	if err != nil {
+		return nil, err
+	}
+
+	// runs of consecutive positions, in the order ChunkNeighbors returns them
+	type run struct {
+		contents []string
+		emitted  bool
+	}
+	var runs []*run
+	runOf := make(map[repo.ChunkRef]*run, len(chunks))
+	for i, c := range chunks {
+		if i == 0 || c.DocumentID != chunks[i-1].DocumentID || c.Position != chunks[i-1].Position+1 {
+			runs = append(runs, &run{})
+		}
+		r := runs[len(runs)-1]
+		r.contents = append(r.contents, c.Content)
+		runOf[repo.ChunkRef{DocumentID: c.DocumentID, Position: c.Position}] = r
+	}
+
+	out := make([]Passage, 0, len(passages))
+	for _, p := range passages {
+		r, ok := runOf[repo.ChunkRef{DocumentID: p.DocumentID, Position: p.Position}]
+		if !ok || p.Position == 0 || p.parentID != 0 {
+			out = append(out, p)
+			continue
+		}
+		if r.emitted {
+			continue
+		}
+		r.emitted = true
+		p.Content = mergeChunks(r.contents)
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// mergeChunks joins consecutive chunks of a document into one text, keeping once the document
+// summary prefixed to each (see withContext) and the text consecutive chunks share
+func mergeChunks(contents []string) string {
+	if len(contents) == 1 {
+		return contents[0]
+	}
+	summary := sharedSummary(contents)
+	var b strings.Builder
+	b.WriteString(summary)
+	var prev string
+	for i, c := range contents {
+		c = strings.TrimPrefix(c, summary)
+		if i > 0 {
+			if n := overlapLen(prev, c); n > 0 {
+				b.WriteString(c[n:])
+				prev = c
+				continue
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(c)
+		prev = c
+	}
+	return b.String()
+}
+
+// sharedSummary returns the first paragraph of the chunks, with its separator, if every chunk
+// starts with it
+func sharedSummary(contents []string) string {
+	head, _, ok := strings.Cut(contents[0], "\n\n")
+	if !ok {
+		return ""
+	}
+	head += "\n\n"
+	for _, c := range contents[1:] {
+		if !strings.HasPrefix(c, head) {
+			return ""
+		}
+	}
+	return head
+}
+
+// minOverlap is the shortest shared text taken for chunk overlap rather than a coincidence
+const minOverlap = 16
+
+// overlapLen returns the length of the longest start of next that ends prev and stops at a
+// word boundary, the text a sliding-window chunker repeats between consecutive chunks
+func overlapLen(prev, next string) int {
+	for n := min(len(prev), len(next)); n >= minOverlap; n-- {
+		if n < len(next) && next[n] != ' ' && next[n] != '\n' {
+			continue
+		}
+		if n < len(prev) && prev[len(prev)-n-1] != ' ' && prev[len(prev)-n-1] != '\n' {
+			continue
+		}
+		if strings.HasSuffix(prev, next[:n]) {
+			return n
+		}
+	}
+	return 0
+}
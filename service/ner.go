@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+const nerPrompt = `Extract the named entities mentioned in the text below.
+Answer ONLY with JSON of the form {"people": [], "organizations": [], "locations": []}.
+Use the names exactly as written in the text and leave a list empty if there are none.
+
+Text:
+%s`
+
+// ExtractEntities asks the NER model for the people, organizations and locations mentioned in text
+func (s *RAGService) ExtractEntities(ctx context.Context, text string) ([]repo.Entity, error) {
+	out, err := s.Generate(ctx, s.cfg.NERModel, fmt.Sprintf(nerPrompt, text), "json")
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		People        []string `json:"people"`
+		Organizations []string `json:"organizations"`
+		Locations     []string `json:"locations"`
+	}
+	if err := json.Unmarshal([]byte(out), &parsed); err != nil {
+		return nil, fmt.Errorf("error parsing entities JSON: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var entities []repo.Entity
+	add := func(names []string, typ string) {
+		for _, n := range names {
+			n = strings.TrimSpace(n)
+			key := typ + "\x00" + strings.ToLower(n)
+			if n == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			entities = append(entities, repo.Entity{Text: n, Type: typ})
+		}
+	}
+	add(parsed.People, "person")
+	add(parsed.Organizations, "organization")
+	add(parsed.Locations, "location")
+	return entities, nil
+}
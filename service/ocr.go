@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const ocrPrompt = "Transcribe all the text in this image exactly as written, in reading order, keeping line breaks " +
+	"and the original language. Render tables as markdown tables. Answer with the transcription only, " +
+	"without comments; answer with nothing if the image has no text."
+
+// OCRImage transcribes the text of a scanned page or screenshot with the OCR model
+func (s *RAGService) OCRImage(ctx context.Context, img []byte) (string, error) {
+	if s.cfg.OCRModel == "" {
+		return "", fmt.Errorf("no OCR model configured")
+	}
+	out, err := s.generate(ctx, map[string]any{
+		"model":  s.cfg.OCRModel,
+		"prompt": ocrPrompt,
+		"images": []string{base64.StdEncoding.EncodeToString(img)},
+		"stream": false,
+		// transcription, not creativity
+		"options": map[string]any{"temperature": 0},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running OCR: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// OCREnabled reports whether uploaded images are transcribed
+func (s *RAGService) OCREnabled() bool { return s.cfg.OCRModel != "" }
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OllamaError is a failed call to Ollama: Status is 0 when the server could not be reached
+// (Err says why), otherwise the HTTP status of its answer, with the error it returned as Message
+type OllamaError struct {
+	// Op is the API called: "embed", "embeddings" or "generate"
+	Op      string
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *OllamaError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("error calling ollama %s: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("ollama %s status %d: %s", e.Op, e.Status, e.Message)
+}
+
+func (e *OllamaError) Unwrap() error { return e.Err }
+
+// Unavailable reports whether Ollama could not serve the call: unreachable, timing out or failing
+// on its side, as opposed to rejecting the request
+func (e *OllamaError) Unavailable() bool {
+	return e.Status == 0 || e.Status >= 500 && !e.ContextOverflow()
+}
+
+// ContextOverflow reports whether the input did not fit the context window of the model
+func (e *OllamaError) ContextOverflow() bool {
+	msg := strings.ToLower(e.Message)
+	return strings.Contains(msg, "context length") || strings.Contains(msg, "context window") ||
+		strings.Contains(msg, "exceeds") && strings.Contains(msg, "context")
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Thaizir/go-local-RAG/ollamatest"
+)
+
+func newTestService(srv *ollamatest.Server, batchSize int) *RAGService {
+	return NewRAGService(nil, srv.Client(), nil, Config{
+		OllamaURL:      srv.URL,
+		EmbeddingModel: "test-embed",
+		LLMModel:       "test-llm",
+		EmbedBatchSize: batchSize,
+	})
+}
+
+func paths(reqs []ollamatest.Request) []string {
+	var ps []string
+	for _, r := range reqs {
+		ps = append(ps, r.Path)
+	}
+	return ps
+}
+
+func TestGenerateEmbeddingsBatches(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	svc := newTestService(srv, 2)
+
+	texts := []string{"alpha beta", "gamma", "delta epsilon"}
+	embs, err := svc.GenerateEmbeddings(texts)
+	if err != nil {
+		t.Fatalf("GenerateEmbeddings: %v", err)
+	}
+	for i, text := range texts {
+		if !slices.Equal(embs[i], srv.Embed(text)) {
+			t.Errorf("embedding %d is not the one of %q", i, text)
+		}
+	}
+	reqs := srv.Requests()
+	if got := paths(reqs); !slices.Equal(got, []string{"/api/embed", "/api/embed"}) {
+		t.Fatalf("calls = %v, want two /api/embed batches", got)
+	}
+	if !slices.Equal(reqs[0].Inputs, texts[:2]) || !slices.Equal(reqs[1].Inputs, texts[2:]) {
+		t.Errorf("batches = %q and %q", reqs[0].Inputs, reqs[1].Inputs)
+	}
+	if reqs[0].Model != "test-embed" {
+		t.Errorf("model = %q", reqs[0].Model)
+	}
+}
+
+func TestGenerateEmbeddingsLegacyFallback(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	srv.Remove("/api/embed", true)
+	svc := newTestService(srv, 2)
+
+	texts := []string{"alpha beta", "gamma", "delta epsilon"}
+	embs, err := svc.GenerateEmbeddings(texts)
+	if err != nil {
+		t.Fatalf("GenerateEmbeddings: %v", err)
+	}
+	for i, text := range texts {
+		if !slices.Equal(embs[i], srv.Embed(text)) {
+			t.Errorf("embedding %d is not the one of %q", i, text)
+		}
+	}
+	// /api/embed is tried once, then every text goes through /api/embeddings
+	want := []string{"/api/embed", "/api/embeddings", "/api/embeddings", "/api/embeddings"}
+	if got := paths(srv.Requests()); !slices.Equal(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestGenerateEmbeddingsUnknownModel(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	srv.SetModels("other-embed")
+	svc := newTestService(srv, 2)
+
+	// a JSON 404 is an unknown model, not a missing endpoint
+	_, err := svc.GenerateEmbeddings([]string{"alpha", "beta"})
+	oe, ok := err.(*OllamaError)
+	if !ok || oe.Status != 404 {
+		t.Fatalf("err = %v, want an OllamaError with status 404", err)
+	}
+	if svc.legacyEmbed.Load() {
+		t.Error("an unknown model switched the service to /api/embeddings")
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	srv.SetResponder(func(model, prompt string) string { return model + " answers " + prompt })
+	svc := newTestService(srv, 1)
+
+	got, err := svc.Answer(context.Background(), "why?", 0)
+	if err != nil {
+		t.Fatalf("Answer: %v", err)
+	}
+	if want := "test-llm answers why?"; got != want {
+		t.Errorf("Answer = %q, want %q", got, want)
+	}
+}
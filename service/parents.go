@@ -0,0 +1,40 @@
+package service
+
+import "context"
+
+// expandParents replaces every passage whose chunk has a parent section (see
+// Config.ParentChunkSize) with that section, so the prompt gets the context a small chunk was
+// cut from. Passages of one section become a single passage, ranked where the best of them was;
+// chunks without a parent, or whose parent was deleted, are kept as they are.
+func (s *RAGService) expandParents(ctx context.Context, passages []Passage) ([]Passage, error) {
+	var ids []int64
+	for _, p := range passages {
+		if p.parentID != 0 {
+			ids = append(ids, p.parentID)
+		}
+	}
+	if len(ids) == 0 {
+		return passages, nil
+	}
+	parents, err := s.repo.ParentChunks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Passage, 0, len(passages))
+	emitted := make(map[int64]bool, len(parents))
+	for _, p := range passages {
+		content, ok := parents[p.parentID]
+		if !ok {
+			p.parentID = 0
+			out = append(out, p)
+			continue
+		}
+		if emitted[p.parentID] {
+			continue
+		}
+		emitted[p.parentID] = true
+		p.Content = content
+		out = append(out, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Pipeline is a named ingestion recipe for a family of documents: how their text is extracted,
+// normalized, chunked and enriched, and the collection (hence the embedding model) they are
+// stored in. Pipelines are declared in a JSON file (see LoadPipelines) and selected per upload;
+// zero fields keep the server settings.
+type Pipeline struct {
+	// Extractor parses every file as this file type (an extension without the dot, e.g. "md"),
+	// whatever its name; empty picks the loader by file name
+	Extractor string `json:"extractor,omitempty"`
+	// Normalizers run in order over the extracted text (see normalizers)
+	Normalizers []string `json:"normalizers,omitempty"`
+	// Chunker overrides the chunking settings
+	Chunker PipelineChunker `json:"chunker,omitzero"`
+	// Enrich lists the model calls made at ingestion, EnrichEntities and EnrichContext, when
+	// their model is configured; nil keeps both, an empty list disables them
+	Enrich []string `json:"enrich,omitempty"`
+	// Embedder is the embedding model the collection must use; indexing fails otherwise
+	Embedder string `json:"embedder,omitempty"`
+	// Collection stores the documents unless the upload names one; DocType tags them unless the
+	// upload does, and Metadata is stored with every chunk under the upload's own
+	Collection string            `json:"collection,omitempty"`
+	DocType    string            `json:"doc_type,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// PipelineChunker overrides the chunking settings of Config for a pipeline
+type PipelineChunker struct {
+	Strategy string `json:"strategy,omitempty"`
+	Size     int    `json:"size,omitempty"`
+	Overlap  int    `json:"overlap,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+}
+
+// Enrichment steps of Pipeline.Enrich
+const (
+	EnrichEntities = "entities"
+	EnrichContext  = "context"
+)
+
+// MetaPipeline is the metadata key recording the pipeline a chunk was indexed with
+const MetaPipeline = "pipeline"
+
+// normalizers are the text normalizations a pipeline can apply, by name
+var normalizers = map[string]func(string) string{
+	// trim removes the spaces around every line
+	"trim": func(s string) string {
+		lines := strings.Split(s, "\n")
+		for i, l := range lines {
+			lines[i] = strings.TrimSpace(l)
+		}
+		return strings.Join(lines, "\n")
+	},
+	// collapse-whitespace merges runs of spaces and tabs, and of more than one blank line
+	"collapse-whitespace": func(s string) string {
+		s = spaceRunRe.ReplaceAllString(s, " ")
+		return blankLinesRe.ReplaceAllString(s, "\n\n")
+	},
+	// dehyphenate joins words broken across lines ("exam-\nple")
+	"dehyphenate": func(s string) string { return hyphenBreakRe.ReplaceAllString(s, "$1$2") },
+	// strip-page-numbers drops lines holding only a page number ("12", "Page 3 of 10", "- 4 -")
+	"strip-page-numbers": func(s string) string { return pageNumberRe.ReplaceAllString(s, "") },
+}
+
+var (
+	spaceRunRe    = regexp.MustCompile(`[ \t]+`)
+	blankLinesRe  = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+	hyphenBreakRe = regexp.MustCompile(`(\p{L})-[ \t]*\n[ \t]*(\p{Ll})`)
+	pageNumberRe  = regexp.MustCompile(`(?mi)^[ \t]*(?:-[ \t]*\d+[ \t]*-|(?:page|p\.|página)?[ \t]*\d+(?:[ \t]*(?:of|/|de)[ \t]*\d+)?)[ \t]*$\n?`)
+)
+
+// LoadPipelines reads pipelines from a JSON file holding an object of pipelines by name
+func LoadPipelines(path string) (map[string]Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pipelines: %w", err)
+	}
+	var pipelines map[string]Pipeline
+	if err := json.Unmarshal(data, &pipelines); err != nil {
+		return nil, fmt.Errorf("error parsing pipelines %s: %w", path, err)
+	}
+	return pipelines, nil
+}
+
+// Validate checks the pipeline's steps against the server settings cfg
+func (p Pipeline) Validate(cfg Config) error {
+	var errs []string
+	for _, n := range p.Normalizers {
+		if normalizers[n] == nil {
+			errs = append(errs, fmt.Sprintf("unknown normalizer %q (one of %s)", n, strings.Join(slices.Sorted(maps.Keys(normalizers)), ", ")))
+		}
+	}
+	for _, e := range p.Enrich {
+		if e != EnrichEntities && e != EnrichContext {
+			errs = append(errs, fmt.Sprintf("unknown enrichment %q (one of %s, %s)", e, EnrichEntities, EnrichContext))
+		}
+	}
+	c := p.Chunker
+	switch c.Strategy {
+	case "", ChunkByWindow, ChunkByWords, ChunkBySentences, ChunkRecursive:
+	default:
+		errs = append(errs, fmt.Sprintf("chunk strategy %q is not one of %s, %s, %s", c.Strategy, ChunkByWindow, ChunkBySentences, ChunkRecursive))
+	}
+	switch c.Unit {
+	case "", ChunkUnitTokens, ChunkUnitWords:
+	default:
+		errs = append(errs, fmt.Sprintf("chunk unit %q is not one of %s, %s", c.Unit, ChunkUnitTokens, ChunkUnitWords))
+	}
+	size := cmp.Or(c.Size, cfg.ChunkSize)
+	if c.Size < 0 || c.Overlap < 0 || c.Overlap >= size {
+		errs = append(errs, fmt.Sprintf("chunk size and overlap must not be negative, and overlap must be below %d", size))
+	}
+	if cmp.Or(c.Unit, cfg.ChunkUnit) == ChunkUnitTokens && size > cfg.EmbeddingMaxTokens {
+		errs = append(errs, fmt.Sprintf("chunk size %d exceeds the embedding model's %d tokens", size, cfg.EmbeddingMaxTokens))
+	}
+	if p.DocType != "" {
+		if _, err := ParseDocType(p.DocType); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Pipeline returns the pipeline declared with this name
+func (s *RAGService) Pipeline(name string) (Pipeline, bool) {
+	p, ok := s.cfg.Pipelines[name]
+	return p, ok
+}
+
+// Pipelines lists the declared pipelines by name
+func (s *RAGService) Pipelines() map[string]Pipeline { return s.cfg.Pipelines }
+
+// enriches reports whether the pipeline runs an enrichment step
+func (p Pipeline) enriches(step string) bool {
+	return p.Enrich == nil || slices.Contains(p.Enrich, step)
+}
+
+// apply sets the pipeline's collection, type and metadata on doc and normalizes its text
+func (p Pipeline) apply(name string, doc Document) Document {
+	if doc.Collection == "" {
+		doc.Collection = p.Collection
+	}
+	if doc.Type == "" {
+		doc.Type, _ = ParseDocType(p.DocType)
+	}
+	meta := maps.Clone(p.Metadata)
+	if meta == nil {
+		meta = make(map[string]string, len(doc.Metadata)+1)
+	}
+	maps.Copy(meta, doc.Metadata)
+	meta[MetaPipeline] = name
+	doc.Metadata = meta
+	if len(p.Normalizers) == 0 {
+		return doc
+	}
+	normalize := func(text string) string {
+		for _, n := range p.Normalizers {
+			text = normalizers[n](text)
+		}
+		return text
+	}
+	doc.Content = normalize(doc.Content)
+	if doc.Pages != nil {
+		pages := make([]string, len(doc.Pages))
+		for i, page := range doc.Pages {
+			pages[i] = normalize(page)
+		}
+		doc.Pages = pages
+	}
+	if doc.Records != nil {
+		records := make([]Record, len(doc.Records))
+		for i, rec := range doc.Records {
+			records[i] = Record{Text: normalize(rec.Text), Metadata: rec.Metadata, Embedding: rec.Embedding}
+		}
+		doc.Records = records
+	}
+	return doc
+}
+
+// chunker returns the chunker of the pipeline, def when it keeps the server settings
+func (p Pipeline) chunker(cfg Config, def Chunker) Chunker {
+	c := p.Chunker
+	if c == (PipelineChunker{}) {
+		return def
+	}
+	if c.Strategy != "" {
+		cfg.ChunkStrategy = c.Strategy
+	}
+	if c.Size > 0 {
+		// the server overlap keeps its share of the chunk
+		cfg.ChunkSize, cfg.ChunkOverlap = c.Size, cfg.ChunkOverlap*c.Size/max(cfg.ChunkSize, 1)
+	}
+	if c.Overlap > 0 {
+		cfg.ChunkOverlap = c.Overlap
+	}
+	if c.Unit != "" {
+		cfg.ChunkUnit = c.Unit
+	}
+	return NewChunker(cfg)
+}
@@ -0,0 +1,449 @@
+package service
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// AnswerProcessor rewrites an answer as it is generated. Write takes every piece of the answer
+// and returns the text ready to be shown, holding back what it cannot decide on yet; Flush
+// returns what is still held once the answer is complete. A processor serves a single answer.
+type AnswerProcessor interface {
+	Write(text string) string
+	Flush() string
+}
+
+// AnswerProcessorFunc returns a processor for an answer given from passages
+type AnswerProcessorFunc func(passages []Passage) AnswerProcessor
+
+// Answer post-processors of Config.AnswerProcessors
+const (
+	// ProcessStripThinking drops the <think>...</think> reasoning of reasoning models
+	ProcessStripThinking = "strip-thinking"
+	// ProcessDropEchoes drops the lines of the answer copying a passage of the context
+	ProcessDropEchoes = "drop-echoes"
+	// ProcessCitations rewrites citations as [n], one per passage ("[Fuente 1, 2]" becomes
+	// "[1][2]"), dropping those of passages the prompt did not have
+	ProcessCitations = "citations"
+	// ProcessStripScaffolding drops the parts of the prompt models leak into their answer
+	// ("Relevant context:", "Pregunta:"...) and the "Respuesta:" label some start it with
+	ProcessStripScaffolding = "strip-scaffolding"
+)
+
+// answerProcessors are the built-in post-processors by name
+var answerProcessors = map[string]AnswerProcessorFunc{
+	ProcessStripThinking:    func([]Passage) AnswerProcessor { return &thinkStripper{} },
+	ProcessDropEchoes:       newEchoDropper,
+	ProcessCitations:        func(passages []Passage) AnswerProcessor { return &citationFormatter{passages: len(passages)} },
+	ProcessStripScaffolding: func([]Passage) AnswerProcessor { return &scaffoldStripper{holding: true} },
+}
+
+// AnswerProcessorNames lists the built-in answer post-processors
+var AnswerProcessorNames = []string{ProcessStripThinking, ProcessDropEchoes, ProcessCitations, ProcessStripScaffolding}
+
+// ValidAnswerProcessor reports whether name is a built-in answer post-processor
+func ValidAnswerProcessor(name string) bool {
+	_, ok := answerProcessors[name]
+	return ok
+}
+
+// SetAnswerProcessors post-processes answers with fns, in order, instead of the processors of
+// Config.AnswerProcessors; none disables post-processing. It must be called before the service
+// is used.
+func (s *RAGService) SetAnswerProcessors(fns ...AnswerProcessorFunc) { s.processors = fns }
+
+// NewAnswerProcessor returns the chain of post-processors for an answer given from passages,
+// nil when there is none
+func (s *RAGService) NewAnswerProcessor(passages []Passage) AnswerProcessor {
+	if len(s.processors) == 0 {
+		return nil
+	}
+	chain := make(answerChain, len(s.processors))
+	for i, fn := range s.processors {
+		chain[i] = fn(passages)
+	}
+	return chain
+}
+
+// ProcessAnswer runs a complete answer through p, which may be nil
+func ProcessAnswer(p AnswerProcessor, answer string) string {
+	if p == nil {
+		return answer
+	}
+	return p.Write(answer) + p.Flush()
+}
+
+// answerChain feeds the output of every processor to the next
+type answerChain []AnswerProcessor
+
+func (c answerChain) Write(text string) string {
+	for _, p := range c {
+		text = p.Write(text)
+	}
+	return text
+}
+
+func (c answerChain) Flush() string {
+	var out string
+	for _, p := range c {
+		out = p.Write(out) + p.Flush()
+	}
+	return out
+}
+
+// heldTagLen returns the length of the longest end of s that starts tag, the text to hold back
+// in case the next piece completes it
+func heldTagLen(s, tag string) int {
+	for n := min(len(s), len(tag)-1); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+const (
+	thinkOpen  = "<think>"
+	thinkClose = "</think>"
+)
+
+// thinkStripper drops the reasoning blocks of reasoning models and the blank space after them.
+// A block left open drops the rest of the answer: it was all reasoning.
+type thinkStripper struct {
+	pending string
+	inside  bool
+	// trim drops the whitespace following a block
+	trim bool
+}
+
+func (t *thinkStripper) Write(text string) string {
+	t.pending += text
+	var out strings.Builder
+	for {
+		if t.inside {
+			i := strings.Index(t.pending, thinkClose)
+			if i < 0 {
+				t.pending = t.pending[len(t.pending)-heldTagLen(t.pending, thinkClose):]
+				return out.String()
+			}
+			t.pending, t.inside, t.trim = t.pending[i+len(thinkClose):], false, true
+		}
+		if t.trim {
+			if t.pending = strings.TrimLeftFunc(t.pending, unicode.IsSpace); t.pending == "" {
+				return out.String()
+			}
+			t.trim = false
+		}
+		i := strings.Index(t.pending, thinkOpen)
+		if i < 0 {
+			n := len(t.pending) - heldTagLen(t.pending, thinkOpen)
+			out.WriteString(t.pending[:n])
+			t.pending = t.pending[n:]
+			return out.String()
+		}
+		out.WriteString(t.pending[:i])
+		t.pending, t.inside = t.pending[i+len(thinkOpen):], true
+	}
+}
+
+func (t *thinkStripper) Flush() string {
+	out := t.pending
+	if t.inside {
+		out = ""
+	}
+	t.pending = ""
+	return out
+}
+
+// minEchoRunes is the shortest line taken for a copy of a passage rather than a shared phrase
+const minEchoRunes = 40
+
+// citationPrefixRe matches the "[n] " numbering of passages in the prompt, which copies keep
+var citationPrefixRe = regexp.MustCompile(`^\[\d+\]\s*`)
+
+// echoDropper drops the lines of an answer that copy a passage of the context, which models
+// sometimes echo before or instead of answering. A line is held back only while it reads like
+// the start of a passage, so the answer keeps streaming.
+type echoDropper struct {
+	passages []string
+	line     strings.Builder
+	// holding is set while the current line may still be a copy
+	holding bool
+}
+
+func newEchoDropper(passages []Passage) AnswerProcessor {
+	d := &echoDropper{holding: true}
+	for _, p := range passages {
+		d.passages = append(d.passages, normalizeSpace(p.Content))
+	}
+	return d
+}
+
+// normalizeSpace collapses every run of whitespace into a space
+func normalizeSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// copied reports whether line, normalized, is part of a passage
+func (d *echoDropper) copied(line string) bool {
+	line = citationPrefixRe.ReplaceAllString(normalizeSpace(line), "")
+	return slices.ContainsFunc(d.passages, func(p string) bool { return strings.Contains(p, line) })
+}
+
+func (d *echoDropper) Write(text string) string {
+	var out strings.Builder
+	for text != "" {
+		piece, rest, newline := strings.Cut(text, "\n")
+		text = rest
+		if d.holding {
+			d.line.WriteString(piece)
+			if !d.copied(d.line.String()) {
+				out.WriteString(d.line.String())
+				d.line.Reset()
+				d.holding = false
+			}
+		} else {
+			out.WriteString(piece)
+		}
+		if newline {
+			if line, keep := d.endLine(); keep {
+				out.WriteString(line + "\n")
+			}
+		}
+	}
+	return out.String()
+}
+
+// endLine ends the current line, returning what is left to write of it and whether to keep it:
+// a line held back to its end is a copy, dropped with its newline when long enough
+func (d *echoDropper) endLine() (string, bool) {
+	held := d.holding
+	line := d.line.String()
+	d.line.Reset()
+	d.holding = true
+	if !held {
+		return "", true
+	}
+	return line, utf8.RuneCountInString(strings.TrimSpace(line)) < minEchoRunes
+}
+
+func (d *echoDropper) Flush() string {
+	line, _ := d.endLine()
+	if utf8.RuneCountInString(strings.TrimSpace(line)) >= minEchoRunes {
+		return ""
+	}
+	return line
+}
+
+// maxHeldCitation bounds how much text is held back waiting for the ']' of a possible citation
+const maxHeldCitation = 32
+
+// citationRe matches the citations models write for numbered passages: "[2]", "[Fuente 2]",
+// "[1, 3]", "[documento 1 y 2]"
+var citationRe = regexp.MustCompile(`(?i)^\[(?:(?:fuentes?|sources?|documentos?|docs?|pasajes?|contexto)\s*)?(\d+(?:\s*(?:,|y|and|&)\s*\d+)*)\]$`)
+
+var digitsRe = regexp.MustCompile(`\d+`)
+
+// citationFormatter rewrites the citations of an answer as one [n] per passage
+type citationFormatter struct {
+	passages int
+	held     strings.Builder
+	// prev is the last rune written, a citation following a word being an index ("a[1]")
+	prev rune
+}
+
+func (c *citationFormatter) Write(text string) string {
+	var out strings.Builder
+	for _, r := range text {
+		switch {
+		case c.held.Len() > 0:
+			c.held.WriteRune(r)
+			switch {
+			case r == ']':
+				out.WriteString(c.format(c.held.String()))
+				c.held.Reset()
+			case r == '[':
+				// the held text was no citation, this may start one
+				held := c.held.String()
+				out.WriteString(held[:len(held)-1])
+				c.held.Reset()
+				c.held.WriteRune(r)
+			case r == '\n' || c.held.Len() > maxHeldCitation:
+				out.WriteString(c.held.String())
+				c.held.Reset()
+			}
+		case r == '[' && !unicode.IsLetter(c.prev) && !unicode.IsDigit(c.prev) && c.prev != '_':
+			c.held.WriteRune(r)
+		default:
+			out.WriteRune(r)
+		}
+		c.prev = r
+	}
+	return out.String()
+}
+
+// format rewrites a complete bracketed text if it is a citation
+func (c *citationFormatter) format(text string) string {
+	m := citationRe.FindStringSubmatch(text)
+	if m == nil {
+		return text
+	}
+	var out strings.Builder
+	for _, f := range digitsRe.FindAllString(m[1], -1) {
+		// a passage the prompt did not have is a made-up source
+		if n, _ := strconv.Atoi(f); n >= 1 && n <= c.passages {
+			out.WriteString("[" + strconv.Itoa(n) + "]")
+		}
+	}
+	return out.String()
+}
+
+// bracketedRe matches the bracketed texts of an answer that may be citations, not following a
+// word (an index: "a[1]")
+var bracketedRe = regexp.MustCompile(`(?:^|[^\pL\pN_])(\[[^\[\]\n]{1,` + strconv.Itoa(maxHeldCitation) + `}\])`)
+
+// Citations returns the numbers of the passages cited in answer, in increasing order, among
+// the passages of its prompt; citations are read as ProcessCitations reads them
+func Citations(answer string, passages int) []int {
+	var cited []int
+	for _, b := range bracketedRe.FindAllStringSubmatch(answer, -1) {
+		m := citationRe.FindStringSubmatch(b[1])
+		if m == nil {
+			continue
+		}
+		for _, f := range digitsRe.FindAllString(m[1], -1) {
+			if n, _ := strconv.Atoi(f); n >= 1 && n <= passages {
+				cited = append(cited, n)
+			}
+		}
+	}
+	slices.Sort(cited)
+	return slices.Compact(cited)
+}
+
+func (c *citationFormatter) Flush() string {
+	out := c.held.String()
+	c.held.Reset()
+	return out
+}
+
+// promptLabels start the sections of a prompt (see builtinPromptTemplate), with their usual English
+// forms; a line of the answer starting with one leaks the prompt
+var promptLabels = []string{"relevant context:", "context:", "contexto:", "pregunta:", "question:", "instrucciones:", "instructions:", "imagen adjunta por el usuario:"}
+
+// answerLabels end a prompt where the answer starts (see builtinPromptTemplate and WrapUpPrompt)
+var answerLabels = []string{"respuesta:", "answer:", "continuación:"}
+
+// labelKinds of the start of a line for scaffoldStripper
+const (
+	lineUndecided = iota
+	linePlain
+	linePromptLabel
+	lineAnswerLabel
+)
+
+// scaffoldStripper drops the prompt sections models echo in their answer: from a line starting
+// with a prompt label to the next line starting with an answer label, whose text after the
+// label is kept, or to the end of the answer once part of it was written (the model making up
+// the next turn). The start of a line is held back only while it may still be a label.
+type scaffoldStripper struct {
+	line strings.Builder
+	// holding is set while the start of the current line may be a label
+	holding bool
+	// dropping is set inside a leaked section
+	dropping bool
+	// bare is set while the current line is all label, so its newline is dropped too
+	bare bool
+	// lead is set after an answer label, until the text following it
+	lead bool
+	// wrote is set once some of the answer was written
+	wrote bool
+}
+
+// classify returns the kind of label line starts with, lineUndecided while it may be one, and
+// where its text after a label starts. Markdown emphasis around a label is skipped.
+func classify(line string) (int, int) {
+	trimmed := strings.TrimLeft(line, " \t*#>_")
+	start := len(line) - len(trimmed)
+	undecided := trimmed == ""
+	for _, kl := range []struct {
+		kind   int
+		labels []string
+	}{{linePromptLabel, promptLabels}, {lineAnswerLabel, answerLabels}} {
+		for _, l := range kl.labels {
+			switch {
+			case len(trimmed) >= len(l) && strings.EqualFold(trimmed[:len(l)], l):
+				return kl.kind, start + len(l)
+			case strings.EqualFold(trimmed, l[:min(len(trimmed), len(l))]):
+				undecided = true
+			}
+		}
+	}
+	if undecided {
+		return lineUndecided, 0
+	}
+	return linePlain, 0
+}
+
+func (s *scaffoldStripper) Write(text string) string {
+	var out strings.Builder
+	emit := func(t string) {
+		if s.lead {
+			if t = strings.TrimLeft(t, " \t*_"); t == "" {
+				return
+			}
+			s.lead = false
+		}
+		if strings.TrimSpace(t) != "" {
+			s.bare, s.wrote = false, true
+		}
+		out.WriteString(t)
+	}
+	for text != "" {
+		piece, rest, newline := strings.Cut(text, "\n")
+		text = rest
+		switch {
+		case s.holding:
+			s.line.WriteString(piece)
+			line := s.line.String()
+			kind, at := classify(line)
+			if kind == lineUndecided && !newline {
+				continue
+			}
+			s.holding = false
+			s.line.Reset()
+			switch {
+			case kind == linePromptLabel || kind == lineAnswerLabel && s.wrote:
+				s.dropping, s.bare = true, true
+			case kind == lineAnswerLabel:
+				s.dropping, s.bare, s.lead = false, true, true
+				emit(line[at:])
+			case !s.dropping:
+				emit(line)
+			}
+		case !s.dropping:
+			emit(piece)
+		}
+		if newline {
+			// blank lines before the answer are dropped with the scaffolding
+			if !s.dropping && !s.bare && s.wrote {
+				out.WriteString("\n")
+			}
+			s.holding, s.bare, s.lead = true, false, false
+		}
+	}
+	return out.String()
+}
+
+func (s *scaffoldStripper) Flush() string {
+	line := s.line.String()
+	s.line.Reset()
+	if s.dropping {
+		return ""
+	}
+	return line
+}
@@ -0,0 +1,20 @@
+package service
+
+import "context"
+
+type progressKey struct{}
+
+// WithProgress returns a context under which IndexDocument calls fn after every chunk with the
+// chunks processed and the chunks of the document (Reindex, with the chunks of the collection),
+// so background jobs can report progress
+func WithProgress(ctx context.Context, fn func(done, total int)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressFrom returns the progress callback of ctx, or one that does nothing
+func progressFrom(ctx context.Context) func(done, total int) {
+	if fn, ok := ctx.Value(progressKey{}).(func(done, total int)); ok {
+		return fn
+	}
+	return func(int, int) {}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// answerInstructions are the instructions closing every answer prompt
+const answerInstructions = "Responde la pregunta basándote ÚNICAMENTE en el contexto proporcionado. Si la información no está en el contexto, indica que no tienes suficiente información. " +
+	"Cita cada fragmento del contexto en que te basas con su número entre corchetes, como [1] o [2]."
+
+// typeInstructions are appended to answerInstructions when most retrieved chunks share a document type
+var typeInstructions = map[string]string{
+	DocTypeCode: "El contexto es código fuente: incluye los fragmentos relevantes en bloques de código con su lenguaje " +
+		"(```go ... ```) y nombra los archivos, funciones y tipos exactos.",
+	DocTypeLegal: "El contexto es un texto legal: cita el artículo, cláusula o sección exacta en que te basas, " +
+		"conserva la terminología del texto y no interpretes más allá de lo que dice.",
+	DocTypeMeetingNotes: "El contexto son notas o transcripciones de reuniones: indica quién dijo o decidió qué, " +
+		"los acuerdos y tareas pendientes, y el momento de la grabación ([hh:mm:ss]) cuando aparezca.",
+}
+
+// Answer styles, selecting how long and how laid out answers are
+const (
+	StyleConcise  = "concise"
+	StyleDetailed = "detailed"
+	StyleBullet   = "bullet"
+)
+
+// styleInstructions shape the length and layout of the answer
+var styleInstructions = map[string]string{
+	StyleConcise:  "Responde de forma breve y directa, en una a tres frases, sin introducciones ni repetir la pregunta.",
+	StyleDetailed: "Responde de forma completa y detallada, explicando el razonamiento y los matices relevantes del contexto.",
+	StyleBullet:   "Responde con una lista de viñetas (-), una idea por viñeta, sin párrafos introductorios.",
+}
+
+// ValidStyle reports whether style is an answer style, "" being the default one
+func ValidStyle(style string) bool {
+	_, ok := styleInstructions[style]
+	return ok || style == ""
+}
+
+// instructionsFor returns the answer instructions suited to the retrieved passages and the
+// requested style ("" for the default)
+func instructionsFor(passages []Passage, style string) string {
+	out := answerInstructions
+	if extra, ok := typeInstructions[DominantType(passages)]; ok {
+		out += " " + extra
+	}
+	if extra, ok := styleInstructions[style]; ok {
+		out += " " + extra
+	}
+	return out
+}
+
+// wrapUpInstructions ask the model to finish an answer cut short by its deadline
+const wrapUpInstructions = "Se acabó el tiempo para responder: termina la respuesta anterior en una o dos frases, " +
+	"continuando exactamente donde se interrumpe, sin repetir nada de lo ya escrito."
+
+// WrapUpPrompt builds the prompt finishing partial, the answer to prompt generated until its
+// deadline, in a few words
+func WrapUpPrompt(prompt, partial string) string {
+	return fmt.Sprintf("%s %s\n\nInstrucciones: %s\nContinuación:", prompt, partial, wrapUpInstructions)
+}
+
+// ModelOptions returns the Ollama options every answer is generated with: the context window
+// of a configured budget (see Config.ContextBudget) and Config.StopSequences. The map is the
+// caller's to extend.
+func (s *RAGService) ModelOptions() map[string]any {
+	options := map[string]any{}
+	if n := s.numCtx(); n > 0 {
+		options["num_ctx"] = n
+	}
+	if len(s.cfg.StopSequences) > 0 {
+		options["stop"] = s.cfg.StopSequences
+	}
+	return options
+}
+
+// Answer generates the answer to prompt with the LLM model, without streaming; maxTokens caps
+// its length, 0 for the model's default
+func (s *RAGService) Answer(ctx context.Context, prompt string, maxTokens int) (string, error) {
+	reqBody := map[string]any{
+		"model":  s.cfg.LLMModel,
+		"prompt": prompt,
+		"stream": false,
+	}
+	options := s.ModelOptions()
+	if maxTokens > 0 {
+		options["num_predict"] = maxTokens
+	}
+	if len(options) > 0 {
+		reqBody["options"] = options
+	}
+	return s.generate(ctx, reqBody)
+}
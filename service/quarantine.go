@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// RetryReport tells how a retry of quarantined chunks went
+type RetryReport struct {
+	Retried   int `json:"retried"`
+	Succeeded int `json:"succeeded"`
+	// Failed chunks stay quarantined with their new error
+	Failed int `json:"failed"`
+}
+
+// storeChunk embeds chunk.Content with the collection's model, unless chunk.Embedding is already
+// set, and stores the chunk
+func (s *RAGService) storeChunk(ctx context.Context, col repo.Collection, chunk repo.Chunk) error {
+	if chunk.Embedding == nil {
+		emb, err := s.embedFor(ctx, col, chunk.Content)
+		if err != nil {
+			return err
+		}
+		chunk.Embedding = emb
+	}
+	chunk.EmbeddingModel = col.Model
+	return annotateDimensionErr(s.repo.InsertChunk(ctx, chunk), col)
+}
+
+// storeOrQuarantine stores chunk, or moves it to the quarantine when embedding or storing it
+// fails, so one bad chunk does not lose the rest of the document. It returns false for a
+// quarantined chunk. Errors that would fail every chunk (cancellation, a dimension mismatch)
+// and a failing quarantine abort the indexing instead.
+func (s *RAGService) storeOrQuarantine(ctx context.Context, col repo.Collection, chunk repo.Chunk, report *IndexReport) (bool, error) {
+	return s.quarantineOnError(ctx, chunk, s.storeChunk(ctx, col, chunk), report)
+}
+
+// storeBatch stores embedded chunks with one repository call, returning how many were stored. When
+// the batch fails, the chunks are stored one by one so only the failing ones are quarantined.
+func (s *RAGService) storeBatch(ctx context.Context, col repo.Collection, chunks []repo.Chunk, report *IndexReport) (int, error) {
+	for i := range chunks {
+		chunks[i].EmbeddingModel = col.Model
+	}
+	err := annotateDimensionErr(s.repo.InsertChunks(ctx, chunks), col)
+	if err == nil {
+		return len(chunks), nil
+	}
+	var dm *repo.DimensionMismatchError
+	if ctx.Err() != nil || errors.As(err, &dm) {
+		return 0, err
+	}
+	stored := 0
+	for _, chunk := range chunks {
+		ok, err := s.storeOrQuarantine(ctx, col, chunk, report)
+		if err != nil {
+			return stored, err
+		}
+		if ok {
+			stored++
+		}
+	}
+	return stored, nil
+}
+
+// quarantineOnError handles the outcome err of storing chunk like storeOrQuarantine
+func (s *RAGService) quarantineOnError(ctx context.Context, chunk repo.Chunk, err error, report *IndexReport) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	var dm *repo.DimensionMismatchError
+	if ctx.Err() != nil || errors.As(err, &dm) {
+		return false, err
+	}
+	log.Printf("warning: quarantining a chunk of %s: %v", chunk.Source, err)
+	if qerr := s.repo.Quarantine(ctx, chunk, err); qerr != nil {
+		return false, fmt.Errorf("%w (quarantine failed too: %v)", err, qerr)
+	}
+	report.Quarantined++
+	return false, nil
+}
+
+// QuarantinedChunks lists the chunks waiting in quarantine
+func (s *RAGService) QuarantinedChunks(ctx context.Context) ([]repo.QuarantinedChunk, error) {
+	return s.repo.QuarantinedChunks(ctx, nil)
+}
+
+// RetryQuarantined tries again to index the quarantined chunks with the given ids, or all of
+// them when ids is empty. Indexed chunks leave the quarantine; the others record the new error.
+func (s *RAGService) RetryQuarantined(ctx context.Context, ids []int64) (RetryReport, error) {
+	var rep RetryReport
+	items, err := s.repo.QuarantinedChunks(ctx, ids)
+	if err != nil {
+		return rep, err
+	}
+	for _, q := range items {
+		rep.Retried++
+		col, err := s.Collection(ctx, q.Chunk.Collection)
+		if err == nil {
+			err = s.storeChunk(ctx, col, q.Chunk)
+		}
+		if ctx.Err() != nil {
+			return rep, ctx.Err()
+		}
+		if err != nil {
+			rep.Failed++
+			if ferr := s.repo.FailQuarantined(ctx, q.ID, err); ferr != nil {
+				return rep, ferr
+			}
+			continue
+		}
+		rep.Succeeded++
+		if err := s.repo.ResolveQuarantined(ctx, q.ID); err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
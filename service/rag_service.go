@@ -2,79 +2,301 @@ package service
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
-	"IA_RAG/repo"
+	"github.com/Thaizir/go-local-RAG/repo"
 	"net/http"
 )
 
 // RAGService orchestrates chunking, embeddings and repository operations
 // and LLM non-stream generation if needed.
 type RAGService struct {
-	repo           repo.DocumentRepository
-	httpClient     *http.Client
-	ollamaURL      string
-	embeddingModel string
-	llmModel       string
-	chunkSize      int
-	chunkOverlap   int
+	repo       repo.DocumentRepository
+	httpClient *http.Client
+	chunker    Chunker
+	// parentChunker splits documents into parent sections before chunking; nil when disabled
+	parentChunker Chunker
+	cfg           Config
+	// cache holds recent vector search results; nil when disabled
+	cache *retrievalCache
+	// reranker reorders the retrieved chunks; nil when disabled
+	reranker Reranker
+	// processors post-process every answer, in order (see NewAnswerProcessor)
+	processors []AnswerProcessorFunc
+	// legacyEmbed is set once Ollama turns out not to serve /api/embed
+	legacyEmbed atomic.Bool
+	// stale holds the collections stored with another model than configured, with the model
+	// they keep serving with until Reindex; runtime holds the collections created through
+	// CreateCollection, embedded with the default model. Both are keyed by tenantCollection.
+	collMu  sync.RWMutex
+	stale   map[string]repo.Collection
+	runtime map[string]bool
+	// reindexing holds the collections being re-embedded, keyed by tenantCollection
+	reindexing sync.Map
+	// windows caches the context window of generation models, by model (see ContextBudget)
+	windows sync.Map
+}
+
+// Config holds the service settings
+type Config struct {
+	OllamaURL      string
+	EmbeddingModel string
+	// EmbeddingDimension is the vector size of EmbeddingModel, used by the default collection
+	EmbeddingDimension int
+	// Collections are additional corpora, each embedded with its own model
+	Collections []repo.Collection
+	LLMModel    string
+	// NERModel extracts people/organizations/locations per chunk at ingestion; empty disables it
+	NERModel string
+	// ContextModel writes a one-sentence document summary prepended to every chunk at
+	// ingestion ("contextual retrieval"); empty disables it
+	ContextModel string
+	// RerankModel grades retrieved chunks against the question (see Reranker), among
+	// RerankCandidates times the chunks asked for, at most maxRerankCandidates; empty disables
+	// reranking. It is a generative model prompted for a grade, not a cross-encoder.
+	RerankModel      string
+	RerankCandidates int
+	// QueryVariants is the number of reformulations of the question searched with
+	// SearchMultiQuery, written by QueryVariantModel (empty uses LLMModel)
+	QueryVariants     int
+	QueryVariantModel string
+	// ContextBudget is the tokens of retrieved passages given to the generation model per
+	// question (see BudgetedSearch); 0 derives it from the context window of the model, negative
+	// disables it. A positive budget sets the context window of the model instead (see ModelOptions).
+	ContextBudget int
+	// HyDEModel writes the hypothetical passages searched with SearchOptions.HyDE; empty
+	// uses LLMModel
+	HyDEModel string
+	// FollowUpTurns is the number of past turns of a conversation read to rewrite a follow-up
+	// question into a standalone one before retrieval (see StandaloneQuestion), by CondenseModel
+	// (empty uses LLMModel); 0 disables the rewriting
+	FollowUpTurns int
+	CondenseModel string
+	// Compression cuts the passages of searches with SearchOptions.Compress down to their
+	// part relevant to the question: CompressExtractive or CompressModel, by CompressionModel
+	// (empty uses LLMModel); empty disables it
+	Compression      string
+	CompressionModel string
+	// VisionModel captions figures and describes query images (e.g. "llava"); empty disables it
+	VisionModel string
+	// OCRModel is a vision model that transcribes uploaded images (scans, screenshots);
+	// empty disables image uploads
+	OCRModel string
+	// WhisperURL is a Whisper-compatible transcription server; empty disables audio input
+	WhisperURL string
+	// KeepAlive is how long Ollama keeps models loaded after a request (seconds or a
+	// duration, see ParseKeepAlive); empty uses the server default
+	KeepAlive string
+	// ChunkSize and ChunkOverlap are measured in ChunkUnit: ChunkUnitTokens (estimated with
+	// CountTokens) or ChunkUnitWords
+	ChunkSize    int
+	ChunkOverlap int
+	ChunkUnit    string
+	// EmbeddingMaxTokens is the context length of the embedding model (0 unknown); chunks estimated
+	// above it (long code blocks) are logged since the model truncates them
+	EmbeddingMaxTokens int
+	// ChunkStrategy selects the plain-text chunker of NewChunker: ChunkByWindow (fixed-size
+	// sliding window, also named ChunkByWords), ChunkBySentences or ChunkRecursive
+	ChunkStrategy string
+	// QueryLog records every question so evaluation tools can replay real traffic. Questions can
+	// hold personal data: they are kept for QueryLogRetention (0 keeps them forever, see
+	// PruneQueryLog).
+	QueryLog          bool
+	QueryLogRetention time.Duration
+	// AccessStats counts how often every chunk is retrieved, for the access report (see
+	// RAGService.AccessReport)
+	AccessStats bool
+	// RetrievalCacheTTL keeps vector search results of repeated questions for this long;
+	// 0 disables the cache. RetrievalCacheSize caps its entries and RetrievalCacheMB their
+	// estimated memory: results carry whole chunks, and their vectors when MMR asks for them.
+	RetrievalCacheTTL  time.Duration
+	RetrievalCacheSize int
+	RetrievalCacheMB   int
+	// ShortQueryWords is the number of non-stopword words at or below which a query is
+	// answered with keyword-heavy hybrid retrieval; 0 disables it
+	ShortQueryWords int
+	// KeepVersions is the number of replaced versions of a document kept searchable with
+	// repo.SearchFilter.AllVersions (see Document.Replace); older ones are deleted
+	KeepVersions int
+	// MaxChunksPerSource caps the chunks of one source among the retrieved ones, so a verbose
+	// document cannot crowd out the other relevant sources; 0 disables it
+	MaxChunksPerSource int
+	// NeighborChunks widens every retrieved chunk with up to this many adjacent chunks of its
+	// document on each side, merged into one contiguous passage; 0 disables it
+	NeighborChunks int
+	// ParentChunkSize, in ChunkUnit, first splits documents into sections of this size, each
+	// then chunked as usual, so the small chunks are matched precisely while their section is
+	// kept as their parent (see repo.InsertParents); 0 disables it. Records are not split.
+	ParentChunkSize int
+	// ParentRetrieval returns the parent section of every retrieved chunk that has one instead
+	// of the chunk (see expandParents)
+	ParentRetrieval bool
+	// PromptTemplates are the answer prompt templates selectable per question (see Prompt), nil
+	// for the built-in one only
+	PromptTemplates *PromptTemplates
+	// StopSequences end the generation of an answer where the model writes one of them, for
+	// models running on past their answer
+	StopSequences []string
+	// AnswerProcessors names the built-in post-processors every answer goes through, in order
+	// (ProcessStripThinking...); unknown names are ignored
+	AnswerProcessors []string
+	// Pipelines are the ingestion pipelines documents can be indexed with, by name (see
+	// Document.Pipeline)
+	Pipelines map[string]Pipeline
+	// SummaryFirst first selects this many documents by their document-level embedding and
+	// retrieves chunks only from them, so passages come from the documents about the question
+	// as a whole; 0 disables it
+	SummaryFirst int
+	// AnswersCollection receives the answers users rate thumbs-up (see RateAnswer) and is searched
+	// next to the queried collection, so recurring questions benefit from validated answers;
+	// empty disables it
+	AnswersCollection string
+	// EmbedConcurrency is the number of embedding calls in flight while indexing a document
+	// (entity extraction included); values below 1 mean 1
+	EmbedConcurrency int
+	// EmbedBatchSize is the number of chunks embedded per Ollama call; 1 (or less) sends one
+	// chunk per call
+	EmbedBatchSize int
+	// SessionTTL ends conversations idle for this long, deleting their session-bound documents;
+	// 0 keeps them until explicitly ended
+	SessionTTL time.Duration
+	// TTLs are the default time-to-live of documents by origin (see Document.TTL); documents
+	// of other origins are kept until deleted
+	TTLs map[string]time.Duration
+	// Tenants are the tenants served next to the unscoped corpus (see repo.WithTenant), each
+	// with its own default, configured and runtime collections
+	Tenants []string
+}
+
+// Document is a piece of content to be indexed
+type Document struct {
+	Content string
+	// Pages holds the text of each page for paginated formats (PDF); when set it replaces
+	// Content and every chunk records the page it comes from
+	Pages  []string
+	Source string
+	// Format is "markdown" for content with markdown structure (headings, code fences),
+	// which is then chunked by section; empty for plain text
+	Format string
+	// Date is the document date taken from file metadata; when zero it is
+	// extracted from the content instead.
+	Date time.Time
+	// Figures are images found in the document, captioned and indexed when a vision model is set
+	Figures []Figure
+	// Collection is the collection the document is stored in; empty means the default one
+	Collection string
+	// Records are the rows of structured documents (CSV, JSON). When set they replace Content
+	// and Pages: every record becomes its own chunk, stored with its fields as metadata.
+	Records []Record
+	// Type tags the kind of document (see DocTypeCode...); answers drawn mostly from one
+	// type get instructions suited to it
+	Type string
+	// Session binds the document to a conversation (see repo.Chunk.Session); empty for the
+	// shared corpus
+	Session string
+	// Metadata is stored with every chunk of the document (a file path, a commit...);
+	// record fields take precedence over it
+	Metadata map[string]string
+	// Replace indexes the document as a new version of its source: it is staged while indexing,
+	// then replaces the current version in one step (see repo.PublishDocument). Without it the
+	// document is added next to the ones already stored for its source.
+	Replace bool
+	// Pipeline names the ingestion pipeline (see Config.Pipelines) that normalizes, chunks and
+	// enriches the document; empty uses the server settings
+	Pipeline string
+	// TTL deletes the document with its chunks once it is this old (see ExpireDocuments); 0
+	// uses the TTL of its Origin (see Config.TTLs)
+	TTL time.Duration
+	// Origin is how the document was ingested (OriginUpload...), empty when unknown
+	Origin string
+}
+
+// Record is one row of a structured document
+type Record struct {
+	Text     string
+	Metadata map[string]string
+	// Embedding is a vector of Text computed elsewhere (an imported export), stored instead of
+	// embedding Text when it has the dimension of the collection and Text fits in one chunk.
+	// Only the dimension is checked: it must come from the collection's embedding model.
+	Embedding []float32
 }
 
 type ollamaEmbedResp struct {
 	Embedding []float32 `json:"embedding"`
 }
 
-func NewRAGService(r repo.DocumentRepository, httpClient *http.Client, ollamaURL, embeddingModel, llmModel string, chunkSize, chunkOverlap int) *RAGService {
-	return &RAGService{
-		repo:           r,
-		httpClient:     httpClient,
-		ollamaURL:      ollamaURL,
-		embeddingModel: embeddingModel,
-		llmModel:       llmModel,
-		chunkSize:      chunkSize,
-		chunkOverlap:   chunkOverlap,
+// NewRAGService builds the service. chunker splits documents before embedding; nil selects
+// NewChunker(cfg).
+func NewRAGService(r repo.DocumentRepository, httpClient *http.Client, chunker Chunker, cfg Config) *RAGService {
+	if chunker == nil {
+		chunker = NewChunker(cfg)
 	}
-}
-
-func (s *RAGService) ChunkText(text string) []string {
-	words := strings.Fields(text)
-	var chunks []string
-	if s.chunkSize <= 0 {
-		return []string{text}
+	s := &RAGService{
+		repo:       r,
+		httpClient: httpClient,
+		chunker:    chunker,
+		cfg:        cfg,
+		cache:      newRetrievalCache(cfg.RetrievalCacheTTL, cfg.RetrievalCacheSize, int64(cfg.RetrievalCacheMB)<<20),
 	}
-	step := s.chunkSize - s.chunkOverlap
-	if step <= 0 {
-		step = s.chunkSize
+	if cfg.RerankModel != "" {
+		s.reranker = ollamaReranker{s: s, model: cfg.RerankModel}
 	}
-	for i := 0; i < len(words); i += step {
-		end := i + s.chunkSize
-		if end > len(words) {
-			end = len(words)
-		}
-		chunk := strings.Join(words[i:end], " ")
-		chunks = append(chunks, chunk)
-		if end == len(words) {
-			break
+	for _, name := range cfg.AnswerProcessors {
+		if fn, ok := answerProcessors[name]; ok {
+			s.processors = append(s.processors, fn)
 		}
 	}
-	return chunks
+	if cfg.ParentChunkSize > 0 {
+		// sections follow one another: their chunks already overlap
+		parentCfg := cfg
+		parentCfg.ChunkSize, parentCfg.ChunkOverlap = cfg.ParentChunkSize, 0
+		s.parentChunker = NewChunker(parentCfg)
+	}
+	return s
 }
 
+// FormatMarkdown marks documents chunked by markdown section
+const FormatMarkdown = "markdown"
+
+// GenerateEmbedding embeds text with the default collection's model
 func (s *RAGService) GenerateEmbedding(text string) ([]float32, error) {
+	return s.EmbedWith(s.cfg.EmbeddingModel, text)
+}
+
+// EmbedWith embeds text with any embedding model served by Ollama
+func (s *RAGService) EmbedWith(model, text string) ([]float32, error) {
+	return s.embedWith(context.Background(), model, text)
+}
+
+// embedWith is EmbedWith, abandoning the call when ctx is done
+func (s *RAGService) embedWith(ctx context.Context, model, text string) ([]float32, error) {
 	reqBody := map[string]any{
-		"model":  s.embeddingModel,
+		"model":  model,
 		"prompt": text,
 	}
-	jsonData, err := json.Marshal(reqBody)
+	jsonData, err := json.Marshal(s.withKeepAlive(reqBody))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := s.httpClient.Post(s.ollamaURL+"/api/embeddings", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.OllamaURL+"/api/embeddings", bytes.NewReader(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("error calling ollama embeddings: %w", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, &OllamaError{Op: "embeddings", Err: err}
 	}
 	defer resp.Body.Close()
 	var result ollamaEmbedResp
@@ -82,7 +304,7 @@ func (s *RAGService) GenerateEmbedding(text string) ([]float32, error) {
 	if resp.StatusCode != http.StatusOK {
 		var raw map[string]any
 		_ = dec.Decode(&raw)
-		return nil, fmt.Errorf("ollama embeddings status %d: %v", resp.StatusCode, raw)
+		return nil, &OllamaError{Op: "embeddings", Status: resp.StatusCode, Message: fmt.Sprint(raw)}
 	}
 	if err := dec.Decode(&result); err != nil {
 		return nil, fmt.Errorf("error parsing embeddings JSON: %w", err)
@@ -93,40 +315,576 @@ func (s *RAGService) GenerateEmbedding(text string) ([]float32, error) {
 	return result.Embedding, nil
 }
 
-// IndexDocument chunks the content, embeds each chunk and stores it via repository
-func (s *RAGService) IndexDocument(ctx context.Context, content, source string) error {
-	chunks := s.ChunkText(content)
-	for i, ch := range chunks {
-		emb, err := s.GenerateEmbedding(ch)
+// Generate runs a non-streaming completion with the given model.
+// format may be "json" to force a JSON answer, or empty for free text.
+func (s *RAGService) Generate(ctx context.Context, model, prompt, format string) (string, error) {
+	reqBody := map[string]any{
+		"model":  model,
+		"prompt": prompt,
+		"stream": false,
+	}
+	if format != "" {
+		reqBody["format"] = format
+	}
+	return s.generate(ctx, reqBody)
+}
+
+func (s *RAGService) generate(ctx context.Context, reqBody map[string]any) (string, error) {
+	jsonData, err := json.Marshal(s.withKeepAlive(reqBody))
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.OllamaURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", &OllamaError{Op: "generate", Err: err}
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var raw map[string]any
+		_ = dec.Decode(&raw)
+		return "", &OllamaError{Op: "generate", Status: resp.StatusCode, Message: fmt.Sprint(raw)}
+	}
+	var result struct {
+		Response string `json:"response"`
+	}
+	if err := dec.Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing generate JSON: %w", err)
+	}
+	return result.Response, nil
+}
+
+// insertBatchSize is the number of chunks stored per repository call while indexing
+const insertBatchSize = 200
+
+// IndexDocument chunks the content, embeds each chunk and stores it via repository,
+// reporting what was stored. A document whose content is already indexed in the collection
+// (see ContentHash) is not stored again; its report names the source holding it.
+// A document with a Pipeline is normalized, chunked and enriched as it declares.
+func (s *RAGService) IndexDocument(ctx context.Context, doc Document) (report IndexReport, err error) {
+	var pipeline Pipeline
+	if doc.Pipeline != "" {
+		var ok bool
+		if pipeline, ok = s.Pipeline(doc.Pipeline); !ok {
+			return report, fmt.Errorf("unknown pipeline %q", doc.Pipeline)
+		}
+		doc = pipeline.apply(doc.Pipeline, doc)
+	}
+	chunker := pipeline.chunker(s.cfg, s.chunker)
+	col, err := s.Collection(ctx, doc.Collection)
+	if err != nil {
+		return report, err
+	}
+	if pipeline.Embedder != "" && pipeline.Embedder != col.Model {
+		return report, fmt.Errorf("pipeline %q embeds with %q but collection %q uses %q", doc.Pipeline, pipeline.Embedder, col.Name, col.Model)
+	}
+	if doc.Session != "" {
+		if err := s.repo.TouchSession(ctx, doc.Session); err != nil {
+			return report, err
+		}
+	}
+	pages := doc.Pages
+	if pages == nil {
+		pages = []string{doc.Content}
+	}
+	if doc.Records != nil {
+		pages = make([]string, len(doc.Records))
+		for i, rec := range doc.Records {
+			pages[i] = rec.Text
+		}
+	}
+	docDate := doc.Date
+	if docDate.IsZero() {
+		docDate, _ = ExtractDate(strings.Join(pages, "\n"))
+	}
+	allText := strings.Join(pages, "\n\n")
+	report.Format = "text"
+	switch {
+	case doc.Records != nil:
+		report.Format, report.Records = "records", len(doc.Records)
+	case doc.Pages != nil:
+		report.Format, report.Pages = "paginated", len(doc.Pages)
+	case doc.Format == FormatMarkdown:
+		report.Format = FormatMarkdown
+	}
+	report.Language = DetectLanguage(allText)
+	report.Characters = utf8.RuneCountInString(allText)
+	if !docDate.IsZero() {
+		report.Date = docDate.Format(time.DateOnly)
+	}
+	report.checkText(allText)
+	report.ContentHash = ContentHash(allText, doc.Figures)
+	if report.AlreadyIndexed, err = s.repo.FindContentHash(ctx, col.Name, doc.Session, report.ContentHash); err != nil {
+		return report, err
+	}
+	if report.AlreadyIndexed != "" {
+		// storing it again would double its weight in retrieval
+		return report, nil
+	}
+
+	type pageChunk struct {
+		Chunk
+		page     int
+		date     time.Time
+		metadata map[string]string
+		// embedding is the precomputed vector of the chunk, nil to embed it
+		embedding []float32
+		// parent is the 1-based index of the chunk's section in parents, 0 if it has none
+		parent int
+	}
+	var chunks []pageChunk
+	var parents []Chunk
+	meta := map[string]string{MetaFormat: doc.Format, MetaSource: doc.Source}
+	if doc.Records != nil {
+		mismatched := 0
+		for _, rec := range doc.Records {
+			date := doc.Date
+			if date.IsZero() {
+				// rows usually carry their own date (an order, an event...)
+				date, _ = ExtractDate(rec.Text)
+			}
+			parts := []Chunk{{Text: rec.Text}}
+			if !chunkFits(chunker, s.cfg, rec.Text) {
+				parts = chunker.Chunk(rec.Text, meta)
+			}
+			var embedding []float32
+			if len(rec.Embedding) > 0 && len(parts) == 1 {
+				if len(rec.Embedding) == col.Dimension {
+					embedding = rec.Embedding
+				} else {
+					mismatched++
+				}
+			}
+			for _, ch := range parts {
+				chunks = append(chunks, pageChunk{Chunk: ch, date: date, metadata: mergeMetadata(doc.Metadata, rec.Metadata), embedding: embedding})
+			}
+		}
+		if mismatched > 0 {
+			report.warnf("%d precomputed embeddings ignored: collection %q stores %d dimensions", mismatched, col.Name, col.Dimension)
+		}
+	} else {
+		for p, text := range pages {
+			page := 0
+			if doc.Pages != nil {
+				page = p + 1
+			}
+			if s.parentChunker == nil {
+				pageChunks := chunker.Chunk(text, meta)
+				if len(pageChunks) == 0 && page > 0 {
+					report.Skipped = append(report.Skipped, fmt.Sprintf("page %d: no text (scanned page without a text layer?)", page))
+				}
+				for _, ch := range pageChunks {
+					chunks = append(chunks, pageChunk{Chunk: ch, page: page, date: docDate, metadata: doc.Metadata})
+				}
+				continue
+			}
+			sections := s.parentChunker.Chunk(text, meta)
+			if len(sections) == 0 && page > 0 {
+				report.Skipped = append(report.Skipped, fmt.Sprintf("page %d: no text (scanned page without a text layer?)", page))
+			}
+			for _, section := range sections {
+				parents = append(parents, section)
+				for _, ch := range chunker.Chunk(section.Text, meta) {
+					// the heading of the section is not in the text of its later chunks
+					ch.Section = cmp.Or(ch.Section, section.Section)
+					chunks = append(chunks, pageChunk{Chunk: ch, page: page, date: docDate, metadata: doc.Metadata, parent: len(parents)})
+				}
+			}
+		}
+	}
+
+	// a lone chunk already holds the whole document
+	var summary string
+	if s.cfg.ContextModel != "" && pipeline.enriches(EnrichContext) && len(chunks) > 1 {
+		if summary, err = s.SummarizeDocument(ctx, allText); err != nil {
+			return report, err
+		}
+	}
+
+	staged := false
+	if len(chunks) > 0 || len(doc.Figures) > 0 {
+		indexed := repo.IndexedDocument{
+			Collection:  col.Name,
+			Source:      doc.Source,
+			Session:     doc.Session,
+			DocType:     doc.Type,
+			ContentHash: report.ContentHash,
+			ExpiresAt:   s.expiresAt(doc),
+		}
+		report.ExpiresAt = indexed.ExpiresAt
+		if doc.Replace {
+			latest, err := s.repo.LatestVersion(ctx, col.Name, doc.Session, doc.Source)
+			if err != nil {
+				return report, err
+			}
+			// a first version has nothing to replace and is current right away
+			indexed.Version, staged = latest+1, latest > 0
+			if staged {
+				indexed.State = repo.StateStaged
+			}
+		}
+		report.Version = max(indexed.Version, 1)
+		report.DocumentID, err = s.repo.CreateDocument(ctx, indexed)
+		if err != nil {
+			return report, err
+		}
+		if staged {
+			// a version that fails to index is dropped and the current one stays
+			defer func() {
+				if err != nil {
+					if _, _, derr := s.repo.DeleteDocument(context.WithoutCancel(ctx), report.DocumentID); derr != nil {
+						log.Printf("warning: dropping failed version %d of %s: %v", report.Version, doc.Source, derr)
+					}
+				}
+			}()
+		}
+	}
+
+	var parentIDs []int64
+	if len(parents) > 0 {
+		contents := make([]string, len(parents))
+		for i, p := range parents {
+			contents[i] = withContext(summary, p.Text)
+		}
+		if parentIDs, err = s.repo.InsertParents(ctx, report.DocumentID, contents); err != nil {
+			return report, err
+		}
+		report.Parents = len(parentIDs)
+	}
+
+	items := make([]*embeddedChunk, len(chunks))
+	for i, pc := range chunks {
+		ch := withContext(summary, pc.Text)
+		if n := CountTokens(ch); s.cfg.EmbeddingMaxTokens > 0 && n > s.cfg.EmbeddingMaxTokens {
+			log.Printf("warning: chunk %d of %s has ~%d tokens, the embedding model only reads %d", i, doc.Source, n, s.cfg.EmbeddingMaxTokens)
+			report.warnf("chunk %d has ~%d tokens, above the embedding model's %d; its end is ignored for retrieval", i, n, s.cfg.EmbeddingMaxTokens)
+		}
+		if pc.embedding != nil {
+			report.Precomputed++
+		}
+		var parentID int64
+		if pc.parent > 0 {
+			parentID = parentIDs[pc.parent-1]
+		}
+		items[i] = &embeddedChunk{
+			chunk: repo.Chunk{
+				Content:     ch,
+				Source:      doc.Source,
+				DocDate:     pc.date,
+				Page:        pc.page,
+				Section:     pc.Section,
+				Collection:  col.Name,
+				Metadata:    pc.metadata,
+				DocType:     doc.Type,
+				Session:     doc.Session,
+				ContentHash: report.ContentHash,
+				DocumentID:  report.DocumentID,
+				Position:    i + 1,
+				Embedding:   pc.embedding,
+				ParentID:    parentID,
+			},
+			text:  pc.Text,
+			ner:   s.cfg.NERModel != "" && pipeline.enriches(EnrichEntities),
+			ready: make(chan struct{}),
+		}
+	}
+
+	// chunks are embedded in parallel but stored in document order, in batches of
+	// insertBatchSize, so the report, the quarantine and progress are handled as with sequential indexing
+	embedCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	s.embedChunks(embedCtx, col, items)
+	progress := progressFrom(ctx)
+	progress(0, len(items))
+	var pending []repo.Chunk
+	var docEmb meanEmbedding
+	done := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		stored, err := s.storeBatch(ctx, col, pending, &report)
 		if err != nil {
-			return fmt.Errorf("embedding chunk %d: %w", i, err)
+			return fmt.Errorf("storing chunks %d-%d: %w", done, done+len(pending)-1, err)
+		}
+		report.Chunks += stored
+		done += len(pending)
+		pending = pending[:0]
+		progress(done, len(items))
+		return nil
+	}
+	for i, it := range items {
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		case <-it.ready:
+		}
+		if it.nerErr != nil {
+			return report, fmt.Errorf("extracting entities of chunk %d: %w", i, it.nerErr)
+		}
+		if it.embedErr == nil {
+			docEmb.add(it.chunk.Embedding, utf8.RuneCountInString(it.text))
+			pending = append(pending, it.chunk)
+			if len(pending) == insertBatchSize {
+				if err := flush(); err != nil {
+					return report, err
+				}
+			}
+			continue
+		}
+		// flush first so chunks keep their order and progress counts them
+		if err := flush(); err != nil {
+			return report, err
+		}
+		if _, err := s.quarantineOnError(ctx, it.chunk, it.embedErr, &report); err != nil {
+			return report, fmt.Errorf("storing chunk %d: %w", i, err)
+		}
+		done++
+		progress(done, len(items))
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	if emb := docEmb.vector(); emb != nil {
+		if err := s.repo.SetDocumentEmbedding(ctx, report.DocumentID, emb); err != nil {
+			return report, err
+		}
+	}
+	if s.cfg.VisionModel != "" {
+		if err := s.indexFigures(ctx, doc, docDate, col, &report); err != nil {
+			return report, err
 		}
-		if err := s.repo.InsertChunk(ctx, ch, source, emb); err != nil {
-			return fmt.Errorf("storing chunk %d: %w", i, err)
+	} else if len(doc.Figures) > 0 {
+		report.warnf("%d figures ignored: no vision model configured", len(doc.Figures))
+	}
+	if report.Quarantined > 0 {
+		report.warnf("%d chunks failed to index and were quarantined; retry them with /api/quarantine/retry", report.Quarantined)
+	}
+	if staged {
+		if report.Replaced, err = s.repo.PublishDocument(ctx, report.DocumentID, s.cfg.KeepVersions); err != nil {
+			return report, err
 		}
 	}
-	return nil
+	return report, nil
+}
+
+// mergeMetadata returns the union of base and over, over winning on conflicts
+func mergeMetadata(base, over map[string]string) map[string]string {
+	if len(base) == 0 {
+		return over
+	}
+	merged := maps.Clone(base)
+	maps.Copy(merged, over)
+	return merged
+}
+
+// SearchOptions are the settings of a search: the repo.SearchFilter restricting the chunks
+// searched, and how the service ranks and shapes the passages it keeps
+type SearchOptions struct {
+	repo.SearchFilter
+	// Mode picks how the chunks are ranked, one of SearchModes ("" lets the service decide)
+	Mode string
+	// MMRLambda, in (0, 1], diversifies the chunks kept with maximal marginal relevance, lower
+	// values trading more relevance for diversity; 0 disables it
+	MMRLambda float64
+	// MinScore, in (0, 1], drops the chunks whose cosine similarity to the question is below it;
+	// chunks found by keyword only are kept
+	MinScore float64
+	// NeighborChunks, when positive, widens every chunk with this many adjacent chunks on each
+	// side instead of Config.NeighborChunks; negative disables it
+	NeighborChunks int
+	// TokenBudget, when positive, keeps the best ranked passages whose estimated tokens fit it,
+	// topK being then an upper bound
+	TokenBudget int
+	// HyDE searches with the embedding of a passage a model writes to answer the question rather
+	// than of the question itself, similarities being then to that passage
+	HyDE bool
+	// Compress keeps only the part of every passage relevant to the question, with the method of
+	// Config.Compression
+	Compress bool
 }
 
-// SearchSimilarContents embeds the question and retrieves similar chunks' contents only
-func (s *RAGService) SearchSimilarContents(ctx context.Context, question string, topK int) ([]string, error) {
-	emb, err := s.GenerateEmbedding(question)
+// Search modes of SearchOptions.Mode
+const (
+	// SearchVector ranks chunks by embedding similarity only
+	SearchVector = "vector"
+	// SearchHybrid also ranks them by full-text relevance to the words of the question and
+	// fuses both rankings, for exact matches (IDs, error codes, proper nouns) embeddings miss
+	SearchHybrid = "hybrid"
+	// SearchMultiQuery also ranks them by similarity to reformulations of the question written
+	// by a model and fuses all rankings, for terse questions worded unlike the documents
+	SearchMultiQuery = "multi-query"
+)
+
+// SearchModes lists the search modes a request may select
+var SearchModes = []string{SearchVector, SearchHybrid, SearchMultiQuery}
+
+// SearchPassages embeds the question and retrieves the most similar chunks.
+// Short questions (see Config.ShortQueryWords) also run a keyword search and favor its hits,
+// since dense embeddings of one or two words are unreliable; search.Mode forces a vector-only
+// or a hybrid search (see SearchHybrid) instead, or searches reformulations of the question
+// too (see SearchMultiQuery); search.HyDE embeds a hypothetical answer instead of the
+// question, and search.MMRLambda diversifies the
+// chunks kept (see diversify). With Config.ParentRetrieval chunks are replaced by their parent
+// section (see expandParents), and with Config.NeighborChunks or search.NeighborChunks the other
+// chunks are widened with their neighbors (see expandNeighbors). search.Compress then cuts the
+// passages down to their relevant part (see Config.Compression) and search.TokenBudget keeps the
+// best ranked passages that fit it.
+func (s *RAGService) SearchPassages(ctx context.Context, question string, topK int, search SearchOptions) ([]Passage, error) {
+	col, err := s.Collection(ctx, search.Collection)
 	if err != nil {
-		return nil, fmt.Errorf("embedding query: %w", err)
+		return nil, err
+	}
+	search.Collection = col.Name
+	if search.Session != "" {
+		// keep the conversation's documents alive while it is in use
+		if err := s.repo.TouchSession(ctx, search.Session); err != nil {
+			return nil, err
+		}
 	}
-	docs, err := s.repo.SearchSimilar(ctx, emb, topK)
+	passages, err := s.retrieve(ctx, col, question, topK, search)
 	if err != nil {
 		return nil, err
 	}
-	contents := make([]string, 0, len(docs))
+	if passages, err = s.expandParents(ctx, passages); err != nil {
+		return nil, err
+	}
+	if passages, err = s.expandNeighbors(ctx, passages, search.NeighborChunks); err != nil {
+		return nil, err
+	}
+	if search.Compress {
+		passages = s.compress(ctx, question, passages)
+	}
+	if search.TokenBudget > 0 {
+		passages = fitBudget(passages, search.TokenBudget)
+	}
+	if s.cfg.QueryLog {
+		if err := s.repo.LogQuery(ctx, col.Name, question); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	if s.cfg.AccessStats {
+		ids := make([]int, 0, len(passages))
+		for _, p := range passages {
+			if p.chunkID != 0 {
+				ids = append(ids, p.chunkID)
+			}
+		}
+		if err := s.repo.RecordRetrievals(ctx, ids); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	return passages, nil
+}
+
+// retrieve runs the retrieval of SearchPassages against col, without its side effects
+func (s *RAGService) retrieve(ctx context.Context, col repo.Collection, question string, topK int, search SearchOptions) ([]Passage, error) {
+	searchText := question
+	if search.HyDE {
+		searchText = s.hypotheticalPassage(ctx, question)
+	}
+	emb, err := s.embedFor(ctx, col, searchText)
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if search.SearchFilter, err = s.summaryFirst(ctx, col, emb, search.SearchFilter); err != nil {
+		return nil, err
+	}
+	opts := repo.SearchOptions{Filter: search.SearchFilter, Fields: repo.FieldDocType | repo.FieldDocumentID | repo.FieldPosition | repo.FieldPage | repo.FieldSection}
+	// keep is the chunks selected before reranking, which the reranker orders to pick topK
+	keep := topK
+	if s.reranker != nil {
+		keep = max(topK, min(topK*max(s.cfg.RerankCandidates, 1), maxRerankCandidates))
+	}
+	fetchK := keep
+	if s.cfg.MaxChunksPerSource > 0 {
+		fetchK = keep * sourceCapOverfetch
+	}
+	if s.cfg.ParentRetrieval {
+		opts.Fields |= repo.FieldParent
+	}
+	if search.MMRLambda > 0 {
+		// diversify compares the stored vectors
+		opts.Fields |= repo.FieldEmbedding
+		fetchK *= mmrOverfetch
+	}
+	docs, err := s.searchSimilar(ctx, emb, fetchK, opts)
+	if err != nil {
+		return nil, annotateDimensionErr(err, col)
+	}
+	if search.Mode == SearchMultiQuery {
+		docs = s.searchVariants(ctx, col, question, docs, fetchK, opts)
+	}
+	switch words := contentWords(question); {
+	case len(words) == 0 || search.Mode == SearchVector:
+	case search.Mode == SearchHybrid:
+		// both rankings count the same, and a chunk needs only some of the words
+		keywordOpts := opts
+		keywordOpts.AnyWord = true
+		keywordDocs, err := s.repo.SearchKeyword(ctx, strings.Join(words, " "), fetchK, keywordOpts)
+		if err != nil {
+			return nil, err
+		}
+		docs = fuseRankings(fetchK, weightedRanking{docs, 1}, weightedRanking{keywordDocs, 1})
+	case len(words) <= s.cfg.ShortQueryWords:
+		keywordDocs, err := s.repo.SearchKeyword(ctx, strings.Join(words, " "), fetchK, opts)
+		if err != nil {
+			return nil, err
+		}
+		docs = fuseRankings(fetchK, weightedRanking{keywordDocs, shortQueryKeywordWeight}, weightedRanking{docs, 1})
+	}
+	if answers := s.cfg.AnswersCollection; answers != "" && col.Name != answers {
+		answerDocs, err := s.searchAnswers(ctx, question, search.SearchFilter)
+		if err != nil {
+			return nil, err
+		}
+		docs = fuseRankings(fetchK, weightedRanking{docs, 1}, weightedRanking{answerDocs, 1})
+	}
+	if search.MinScore > 0 {
+		// docs may be the slice held by the retrieval cache: filter a copy
+		docs = slices.DeleteFunc(slices.Clone(docs), func(d repo.Document) bool {
+			return d.Distance != nil && 1-*d.Distance < search.MinScore
+		})
+	}
+	if search.MMRLambda > 0 {
+		// with a source cap, diversify leaves it candidates to skip over
+		picks := keep
+		if s.cfg.MaxChunksPerSource > 0 {
+			picks = keep * sourceCapOverfetch
+		}
+		docs = diversify(emb, docs, search.MMRLambda, picks)
+	}
+	if s.cfg.MaxChunksPerSource > 0 {
+		docs = capPerSource(docs, s.cfg.MaxChunksPerSource, keep)
+	} else if len(docs) > keep {
+		docs = docs[:keep]
+	}
+	if s.reranker != nil {
+		// reranking runs last, on the diversified candidates, so its order is the one kept
+		docs = s.rerank(ctx, question, docs)
+	}
+	if len(docs) > topK {
+		docs = docs[:topK]
+	}
+	passages := make([]Passage, 0, len(docs))
 	for _, d := range docs {
-		contents = append(contents, d.Content)
+		p := Passage{Content: d.Content, Source: d.Source, Type: d.DocType, DocumentID: d.DocumentID, Position: d.Position, Page: d.Page, Section: d.Section, chunkID: d.ID, parentID: d.ParentID}
+		if d.Distance != nil {
+			score := 1 - *d.Distance
+			p.Score = &score
+		}
+		passages = append(passages, p)
 	}
-	return contents, nil
+	return passages, nil
 }
 
 func (s *RAGService) HTTPClient() *http.Client { return s.httpClient }
 
-func (s *RAGService) LLMModel() string { return s.llmModel }
+func (s *RAGService) LLMModel() string { return s.cfg.LLMModel }
 
-func (s *RAGService) OllamaURL() string { return s.ollamaURL }
+func (s *RAGService) OllamaURL() string { return s.cfg.OllamaURL }
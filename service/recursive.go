@@ -0,0 +1,83 @@
+package service
+
+import "strings"
+
+// defaultSeparators are tried in order, from the coarsest structure to the finest
+var defaultSeparators = []string{"\n\n", "\n", ". ", "; ", ", "}
+
+// RecursiveChunker splits text on the coarsest separator that yields pieces within Size,
+// recursing into pieces that are still too large with the next separator, then merges
+// neighbouring pieces back up to Size. Text that no separator can split is cut with the
+// WindowChunker. Chunks only overlap inside such windows: the separators already mark
+// natural boundaries.
+type RecursiveChunker struct {
+	Sizing
+	// Separators overrides defaultSeparators
+	Separators []string
+}
+
+func (c RecursiveChunker) Chunk(text string, _ map[string]string) []Chunk {
+	if c.Size <= 0 {
+		return toChunks([]string{text})
+	}
+	seps := c.Separators
+	if seps == nil {
+		seps = defaultSeparators
+	}
+	return toChunks(c.split(strings.TrimSpace(text), seps))
+}
+
+func (c RecursiveChunker) split(text string, seps []string) []string {
+	if text == "" {
+		return nil
+	}
+	if c.measure(text) <= c.Size {
+		return []string{text}
+	}
+	if len(seps) == 0 {
+		return WindowChunker{c.Sizing}.split(text)
+	}
+	sep := seps[0]
+	parts := strings.Split(text, sep)
+	if len(parts) == 1 {
+		return c.split(text, seps[1:])
+	}
+
+	// punctuation separators stay attached to the piece before them, so rejoin with a space
+	punct := strings.TrimSpace(sep)
+	joiner := sep
+	if punct != "" {
+		joiner = " "
+	}
+	var chunks []string
+	var cur []string
+	size := 0
+	flush := func() {
+		if len(cur) > 0 {
+			chunks = append(chunks, strings.Join(cur, joiner))
+		}
+		cur, size = nil, 0
+	}
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if i < len(parts)-1 {
+			p += punct
+		}
+		n := c.measure(p)
+		if n > c.Size {
+			flush()
+			chunks = append(chunks, c.split(p, seps[1:])...)
+			continue
+		}
+		if size > 0 && size+n > c.Size {
+			flush()
+		}
+		cur = append(cur, p)
+		size += n
+	}
+	flush()
+	return chunks
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+const (
+	// reindexBatchSize is the number of chunks re-embedded per round trip to the repository
+	reindexBatchSize = 100
+	// reindexSwapAttempts bounds the catch-up rounds for chunks stored during a re-embedding
+	reindexSwapAttempts = 3
+)
+
+// ErrReindexRunning is returned by Reindex for a collection already being re-embedded
+var ErrReindexRunning = errors.New("collection is already being re-embedded")
+
+// ReindexReport tells how a re-embedding went
+type ReindexReport struct {
+	Collection string `json:"collection"`
+	Model      string `json:"model"`
+	Dimension  int    `json:"dimension"`
+	// Chunks counts the re-embedded chunks
+	Chunks int `json:"chunks"`
+}
+
+// ReindexStatus describes a collection whose chunks do not all come from its configured model
+type ReindexStatus struct {
+	Collection string `json:"collection"`
+	// Model is the configured model, ServingModel the one searches use until the re-embedding
+	Model        string `json:"model"`
+	Dimension    int    `json:"dimension"`
+	ServingModel string `json:"serving_model"`
+	StaleChunks  int64  `json:"stale_chunks"`
+	Running      bool   `json:"running"`
+}
+
+func (s *RAGService) setStale(ctx context.Context, c repo.Collection) {
+	s.collMu.Lock()
+	defer s.collMu.Unlock()
+	if s.stale == nil {
+		s.stale = map[string]repo.Collection{}
+	}
+	s.stale[tenantCollection(ctx, c.Name)] = c
+}
+
+// ReindexStatuses lists the collections with chunks embedded with another model or dimension
+// than configured
+func (s *RAGService) ReindexStatuses(ctx context.Context) ([]ReindexStatus, error) {
+	var out []ReindexStatus
+	for _, name := range s.collectionNames(ctx) {
+		target, _ := s.configuredCollection(ctx, name)
+		n, err := s.repo.CountStaleChunks(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			continue
+		}
+		serving, _ := s.Collection(ctx, name)
+		_, running := s.reindexing.Load(tenantCollection(ctx, target.Name))
+		out = append(out, ReindexStatus{
+			Collection:   target.Name,
+			Model:        target.Model,
+			Dimension:    target.Dimension,
+			ServingModel: serving.Model,
+			StaleChunks:  n,
+			Running:      running,
+		})
+	}
+	return out, nil
+}
+
+// Reindex re-embeds with its configured model every chunk of a collection ("" for the default
+// one) embedded with another model or dimension. The new vectors are stored next to the current
+// ones, which keep serving searches, and replace them at once when every chunk is done; chunks
+// stored meanwhile are caught up before that.
+func (s *RAGService) Reindex(ctx context.Context, collection string) (ReindexReport, error) {
+	target, err := s.configuredCollection(ctx, collection)
+	if err != nil {
+		return ReindexReport{}, err
+	}
+	key := tenantCollection(ctx, target.Name)
+	if _, busy := s.reindexing.LoadOrStore(key, true); busy {
+		return ReindexReport{}, fmt.Errorf("%w: %q", ErrReindexRunning, target.Name)
+	}
+	defer s.reindexing.Delete(key)
+
+	report := ReindexReport{Collection: target.Name, Model: target.Model, Dimension: target.Dimension}
+	total, err := s.repo.CountStaleChunks(ctx, target)
+	if err != nil {
+		return report, err
+	}
+	progress := progressFrom(ctx)
+	progress(0, int(total))
+	for attempt := 1; ; attempt++ {
+		for {
+			batch, err := s.repo.PendingReindex(ctx, target, reindexBatchSize)
+			if err != nil {
+				return report, err
+			}
+			if len(batch) == 0 {
+				break
+			}
+			ids := make([]int, len(batch))
+			texts := make([]string, len(batch))
+			for i, c := range batch {
+				ids[i], texts[i] = c.ID, c.Content
+			}
+			embs, err := s.embedBatch(ctx, target.Model, texts)
+			if err != nil {
+				return report, fmt.Errorf("error re-embedding collection %q: %w", target.Name, err)
+			}
+			for _, emb := range embs {
+				if len(emb) != target.Dimension {
+					return report, &repo.DimensionMismatchError{Model: target.Model, Collection: target.Name, Got: len(emb), Expected: target.Dimension}
+				}
+			}
+			if err := s.repo.SetPendingEmbeddings(ctx, target.Model, ids, embs); err != nil {
+				return report, err
+			}
+			report.Chunks += len(batch)
+			progress(report.Chunks, max(int(total), report.Chunks))
+		}
+		swapped, err := s.repo.SwapEmbeddings(ctx, target)
+		if err != nil {
+			return report, err
+		}
+		if swapped {
+			break
+		}
+		if attempt == reindexSwapAttempts {
+			return report, fmt.Errorf("chunks of collection %q keep being stored with another model; retry the re-embedding", target.Name)
+		}
+	}
+	s.collMu.Lock()
+	delete(s.stale, key)
+	s.collMu.Unlock()
+	log.Printf("collection %q re-embedded with %q: %d chunks", target.Name, target.Model, report.Chunks)
+	return report, nil
+}
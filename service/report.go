@@ -0,0 +1,110 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// IndexReport describes what IndexDocument stored, so users can check an upload did what they expected
+type IndexReport struct {
+	// Format is how the content was chunked: "markdown", "text", "paginated" or "records"
+	Format   string `json:"format"`
+	Language string `json:"language"`
+	// Characters counts the indexed text
+	Characters int `json:"characters"`
+	Chunks     int `json:"chunks"`
+	Pages      int `json:"pages,omitempty"`
+	Records    int `json:"records,omitempty"`
+	// Parents counts the sections the chunks were split from (see Config.ParentChunkSize)
+	Parents int `json:"parents,omitempty"`
+	Figures int `json:"figures,omitempty"`
+	// Precomputed counts the chunks stored with the embedding they came with (see Record.Embedding)
+	Precomputed int `json:"precomputed,omitempty"`
+	// Quarantined counts chunks that failed to embed or store and wait for a retry
+	Quarantined int `json:"quarantined,omitempty"`
+	// Date is the document date used for filtering, empty when unknown
+	Date string `json:"date,omitempty"`
+	// ContentHash identifies the content of the document (see ContentHash)
+	ContentHash string `json:"content_hash"`
+	// DocumentID identifies the stored document (see /api/documents), 0 when nothing was stored
+	DocumentID int64 `json:"document_id,omitempty"`
+	// Version is the version of its source the document became, Replaced how many versions it
+	// replaced (see Document.Replace)
+	Version  int `json:"version,omitempty"`
+	Replaced int `json:"replaced,omitempty"`
+	// ExpiresAt is when the document is deleted (see Document.TTL), nil when it is kept
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// AlreadyIndexed is the source already holding identical content, in which case nothing was stored
+	AlreadyIndexed string `json:"already_indexed,omitempty"`
+	// Skipped lists parts of the document that produced no chunk
+	Skipped  []string `json:"skipped,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (r *IndexReport) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// languageMarkers are frequent function words distinctive of each language
+var languageMarkers = map[string]map[string]bool{
+	"en": toSet(strings.Fields("the and of to is that with for are this was have from not which be")),
+	"es": toSet(strings.Fields("el la los las y de del que con para por una es son se está pero como más")),
+}
+
+// DetectLanguage guesses the language of text ("en", "es") from its function words,
+// returning "unknown" when there is too little evidence
+func DetectLanguage(text string) string {
+	counts := map[string]int{}
+	total := 0
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		for lang, markers := range languageMarkers {
+			if markers[w] {
+				counts[lang]++
+				total++
+			}
+		}
+		// a sample is enough
+		if total >= 200 {
+			break
+		}
+	}
+	best, bestN := "unknown", 0
+	for lang, n := range counts {
+		if n > bestN {
+			best, bestN = lang, n
+		}
+	}
+	if bestN < 3 || bestN*3 < total*2 {
+		return "unknown"
+	}
+	return best
+}
+
+// checkText warns about content that is unlikely to index well
+func (r *IndexReport) checkText(text string) {
+	if text == "" {
+		return
+	}
+	runes, spaces, bad := 0, 0, 0
+	for _, c := range text {
+		runes++
+		switch {
+		case unicode.IsSpace(c):
+			spaces++
+		case c == utf8.RuneError, unicode.IsControl(c):
+			bad++
+		}
+	}
+	if spaces*2 > runes {
+		r.warnf("file mostly whitespace (%d%% of characters)", 100*spaces/runes)
+	}
+	if bad*20 > runes {
+		r.warnf("%d%% of characters are invalid or control characters; the file may be binary or use an unsupported encoding", 100*bad/runes)
+	}
+	if words := len(strings.Fields(text)); words < 20 {
+		r.warnf("very short content (%d words); answers will have little context", words)
+	}
+}
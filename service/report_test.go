@@ -0,0 +1,25 @@
+package service
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "The cat and the dog are in the garden with a ball", "en"},
+		{"spanish", "El perro y la casa de los abuelos con el jardín", "es"},
+		{"accents and punctuation", "¿Está la casa, o no? Pero sí, más que nada.", "es"},
+		{"case insensitive", "THE END AND THE START OF IT", "en"},
+		{"too little evidence", "the cat", "unknown"},
+		{"mixed", "the and of el la los", "unknown"},
+		{"no function words", "xyz qwerty 42", "unknown"},
+		{"empty", "", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("%s: DetectLanguage(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// Reranker scores retrieved chunks against the question, seeing both together, which orders
+// them better than the similarity of separately computed embeddings. Retrieval selects
+// Config.RerankCandidates times the chunks asked for, at most maxRerankCandidates, after
+// diversification and the per-source cap, and keeps the best scored (see RAGService.SetReranker).
+// A cross-encoder such as bge-reranker, which Ollama cannot serve, plugs in here.
+type Reranker interface {
+	// Rerank returns the relevance of every text to question, in order; higher is more relevant
+	Rerank(ctx context.Context, question string, texts []string) ([]float64, error)
+}
+
+// SetReranker reranks the retrieved chunks with r; nil disables reranking. It replaces the
+// Ollama reranker of Config.RerankModel and must be called before the service is used.
+func (s *RAGService) SetReranker(r Reranker) { s.reranker = r }
+
+// rerankConcurrency is the number of pairs an ollamaReranker scores at once
+const rerankConcurrency = 4
+
+// maxRerankCandidates caps the chunks a question reranks whatever topK and
+// Config.RerankCandidates, since each one costs a model call
+const maxRerankCandidates = 40
+
+// ollamaReranker scores every (question, chunk) pair with a generative model served by Ollama,
+// asked for a 0-10 relevance grade: an LLM judging relevance, one /api/generate call per chunk,
+// not a cross-encoder (Ollama has no scoring endpoint; use SetReranker for one)
+type ollamaReranker struct {
+	s     *RAGService
+	model string
+}
+
+// rerankPrompt asks for the relevance grade of a chunk
+const rerankPrompt = "Query: %s\nDocument: %s\n\nHow relevant is the document to the query, from 0 (unrelated) to 10 " +
+	"(answers it)? Reply with the number only.\nRelevance:"
+
+// gradeRe finds the grade in a reranker reply
+var gradeRe = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// Rerank implements Reranker
+func (o ollamaReranker) Rerank(ctx context.Context, question string, texts []string) ([]float64, error) {
+	scores := make([]float64, len(texts))
+	errs := make([]error, len(texts))
+	sem := make(chan struct{}, rerankConcurrency)
+	var wg sync.WaitGroup
+	for i, text := range texts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			reply, err := o.s.generate(ctx, map[string]any{
+				"model":   o.model,
+				"prompt":  fmt.Sprintf(rerankPrompt, question, text),
+				"stream":  false,
+				"options": map[string]any{"num_predict": 4, "temperature": 0},
+			})
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			// a reply without a grade ranks last
+			scores[i], _ = strconv.ParseFloat(gradeRe.FindString(reply), 64)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error reranking with %s: %w", o.model, err)
+		}
+	}
+	return scores, nil
+}
+
+// rerank orders docs by their Reranker score, ties keeping the retrieval order. On failure the
+// retrieval order is kept: reranking refines the results but is not needed to answer.
+func (s *RAGService) rerank(ctx context.Context, question string, docs []repo.Document) []repo.Document {
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		texts[i] = d.Content
+	}
+	scores, err := s.reranker.Rerank(ctx, question, texts)
+	if err == nil && len(scores) != len(docs) {
+		err = fmt.Errorf("reranker returned %d scores for %d chunks", len(scores), len(docs))
+	}
+	if err != nil {
+		log.Printf("warning: keeping the retrieval order: %v", err)
+		return docs
+	}
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int { return cmp.Compare(scores[b], scores[a]) })
+	out := make([]repo.Document, len(docs))
+	for i, j := range order {
+		out[i] = docs[j]
+	}
+	return out
+}
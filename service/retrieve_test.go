@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/ollamatest"
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// searchRepo serves fixed vector search results; the other repository methods are not used
+type searchRepo struct {
+	repo.DocumentRepository
+	docs     []repo.Document
+	version  int64
+	searches int
+}
+
+func (r *searchRepo) SearchSimilar(_ context.Context, _ []float32, topK int, _ repo.SearchOptions) ([]repo.Document, error) {
+	r.searches++
+	return slices.Clone(r.docs[:min(topK, len(r.docs))]), nil
+}
+
+func (r *searchRepo) CorpusVersion(context.Context) (int64, error) { return r.version, nil }
+
+func distance(d float64) *float64 { return &d }
+
+func passageIDs(passages []Passage) []int {
+	ids := make([]int, len(passages))
+	for i, p := range passages {
+		ids[i] = p.chunkID
+	}
+	return ids
+}
+
+func TestRetrieveMinScoreThroughCache(t *testing.T) {
+	srv := ollamatest.NewServer()
+	defer srv.Close()
+	r := &searchRepo{docs: []repo.Document{
+		{ID: 1, Content: "close", Source: "a", Distance: distance(0.1)},
+		{ID: 2, Content: "far", Source: "b", Distance: distance(0.8)},
+		{ID: 3, Content: "near", Source: "c", Distance: distance(0.2)},
+	}}
+	svc := NewRAGService(r, srv.Client(), nil, Config{
+		OllamaURL:          srv.URL,
+		EmbeddingModel:     "test-embed",
+		RetrievalCacheTTL:  time.Minute,
+		RetrievalCacheSize: 10,
+		RetrievalCacheMB:   1,
+	})
+	col := repo.Collection{Name: repo.DefaultCollection, Model: "test-embed"}
+	search := SearchOptions{Mode: SearchVector, MinScore: 0.5}
+
+	for i := range 2 {
+		passages, err := svc.retrieve(context.Background(), col, "how are backups made", 3, search)
+		if err != nil {
+			t.Fatalf("retrieve %d: %v", i, err)
+		}
+		if got := passageIDs(passages); !slices.Equal(got, []int{1, 3}) {
+			t.Errorf("retrieve %d: passages %v, want chunks 1 and 3 above the threshold", i, got)
+		}
+	}
+	if r.searches != 1 {
+		t.Errorf("%d searches, want the second retrieval served by the cache", r.searches)
+	}
+}
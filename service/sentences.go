@@ -0,0 +1,173 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Chunking strategies selectable with Config.ChunkStrategy
+const (
+	ChunkByWindow    = "window"
+	ChunkBySentences = "sentences"
+	ChunkRecursive   = "recursive"
+	// ChunkByWords is the former name of ChunkByWindow, still accepted
+	//
+	// Deprecated: use ChunkByWindow.
+	ChunkByWords = "words"
+)
+
+// abbreviations that end with a period without ending the sentence
+var abbreviations = toSet(strings.Fields("e.g i.e etc vs mr mrs ms dr prof sr sra dra st no fig approx cf p pp vol ej p.ej aprox núm"))
+
+// sentence is one sentence of the input; paragraphEnd marks the last sentence of a paragraph
+type sentence struct {
+	text         string
+	size         int
+	paragraphEnd bool
+}
+
+// SentenceChunker accumulates complete sentences up to Size units. Chunks close early at a
+// paragraph break once they are three quarters full, and consecutive chunks overlap by the
+// trailing whole sentences that fit in Overlap. A sentence longer than Size is split with
+// the WindowChunker.
+type SentenceChunker struct {
+	Sizing
+}
+
+func (c SentenceChunker) Chunk(text string, _ map[string]string) []Chunk {
+	return toChunks(c.split(text))
+}
+
+func (c SentenceChunker) split(text string) []string {
+	size, overlap := c.Size, c.Overlap
+	if size <= 0 {
+		return []string{text}
+	}
+	var chunks []string
+	var cur []sentence
+	filled := 0
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		parts := make([]string, len(cur))
+		for i, sn := range cur {
+			parts[i] = sn.text
+		}
+		chunks = append(chunks, strings.Join(parts, " "))
+		// keep the trailing sentences that fit in the overlap
+		keep, kept := len(cur), 0
+		for keep > 0 && kept+cur[keep-1].size <= overlap {
+			keep--
+			kept += cur[keep].size
+		}
+		if keep == 0 {
+			// the whole chunk fits in the overlap: start fresh to guarantee progress
+			cur, filled = nil, 0
+			return
+		}
+		cur, filled = append([]sentence(nil), cur[keep:]...), kept
+	}
+
+	for _, sn := range splitSentences(text) {
+		sn.size = c.measure(sn.text)
+		if sn.size > size {
+			flush()
+			cur, filled = nil, 0
+			chunks = append(chunks, WindowChunker{c.Sizing}.split(sn.text)...)
+			continue
+		}
+		if filled+sn.size > size {
+			flush()
+		}
+		cur = append(cur, sn)
+		filled += sn.size
+		if sn.paragraphEnd && filled*4 >= size*3 {
+			flush()
+		}
+	}
+	if filled > 0 && (len(chunks) == 0 || !endsWith(chunks[len(chunks)-1], cur)) {
+		flush()
+	}
+	return chunks
+}
+
+// endsWith reports whether chunk already ends with every sentence of tail (pure overlap leftovers)
+func endsWith(chunk string, tail []sentence) bool {
+	parts := make([]string, len(tail))
+	for i, sn := range tail {
+		parts[i] = sn.text
+	}
+	return strings.HasSuffix(chunk, strings.Join(parts, " "))
+}
+
+// splitSentences splits text into sentences, treating blank lines as paragraph breaks
+func splitSentences(text string) []sentence {
+	var out []sentence
+	for _, para := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		para = strings.Join(strings.Fields(para), " ")
+		if para == "" {
+			continue
+		}
+		start := 0
+		runes := []rune(para)
+		for i := 0; i < len(runes); i++ {
+			if !isSentenceEnd(runes, i) {
+				continue
+			}
+			// include closing quotes and brackets
+			j := i + 1
+			for j < len(runes) && strings.ContainsRune(`"'”’)]»`, runes[j]) {
+				j++
+			}
+			if j < len(runes) && runes[j] != ' ' {
+				continue
+			}
+			out = appendSentence(out, string(runes[start:j]))
+			start = j
+			i = j
+		}
+		out = appendSentence(out, string(runes[start:]))
+		if len(out) > 0 {
+			out[len(out)-1].paragraphEnd = true
+		}
+	}
+	return out
+}
+
+func appendSentence(out []sentence, text string) []sentence {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return out
+	}
+	return append(out, sentence{text: text})
+}
+
+// isSentenceEnd reports whether runes[i] terminates a sentence
+func isSentenceEnd(runes []rune, i int) bool {
+	switch runes[i] {
+	case '!', '?', '…':
+		return true
+	case '.':
+	default:
+		return false
+	}
+	// decimals and version numbers: 3.5, v1.2
+	if i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+		return false
+	}
+	// the word before the period
+	start := i
+	for start > 0 && runes[start-1] != ' ' {
+		start--
+	}
+	word := strings.ToLower(strings.Trim(string(runes[start:i]), `"'(“‘`))
+	if abbreviations[word] {
+		return false
+	}
+	// initials such as "J. Smith"
+	if len([]rune(word)) == 1 && unicode.IsLetter([]rune(word)[0]) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// EndSession deletes the chunks bound to a session, returning how many were deleted
+func (s *RAGService) EndSession(ctx context.Context, id string) (int64, error) {
+	return s.repo.EndSession(ctx, id)
+}
+
+// SessionSettings returns the settings of a conversation, empty when it has none
+func (s *RAGService) SessionSettings(ctx context.Context, id string) (repo.SessionSettings, error) {
+	return s.repo.SessionSettings(ctx, id)
+}
+
+// SetSessionSettings replaces the settings of a conversation, once its collection, if any, is
+// known
+func (s *RAGService) SetSessionSettings(ctx context.Context, id string, settings repo.SessionSettings) error {
+	if settings.Collection != "" {
+		if _, err := s.Collection(ctx, settings.Collection); err != nil {
+			return err
+		}
+	}
+	return s.repo.SetSessionSettings(ctx, id, settings)
+}
+
+// ExpireSessions ends every session idle for longer than Config.SessionTTL, of the unscoped
+// corpus and of every tenant, checking once a minute until ctx is done. It returns immediately
+// when SessionTTL is 0.
+func (s *RAGService) ExpireSessions(ctx context.Context) {
+	if s.cfg.SessionTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		for _, tenant := range append([]string{""}, s.cfg.Tenants...) {
+			s.expireSessions(repo.WithTenant(ctx, tenant))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expireSessions ends the idle sessions of the tenant of ctx
+func (s *RAGService) expireSessions(ctx context.Context) {
+	ids, err := s.repo.IdleSessions(ctx, s.cfg.SessionTTL)
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+	for _, id := range ids {
+		n, err := s.repo.EndSession(ctx, id)
+		if err != nil {
+			log.Printf("warning: expiring session %s: %v", id, err)
+			continue
+		}
+		log.Printf("Session %s expired, %d chunks deleted", id, n)
+	}
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// IndexedVersion returns the version of a source recorded by SyncSource, "" if none
+func (s *RAGService) IndexedVersion(ctx context.Context, collection, source string) (string, error) {
+	col, err := s.Collection(ctx, collection)
+	if err != nil {
+		return "", err
+	}
+	return s.repo.SourceVersion(ctx, col.Name, source)
+}
+
+// SyncSource indexes doc as the new version of its source (see Document.Replace), unless version
+// (a content hash, an ETag...) is the one indexed last time. It reports whether it indexed.
+// Connectors that mirror external content use it so unchanged items are not re-embedded.
+func (s *RAGService) SyncSource(ctx context.Context, doc Document, version string) (IndexReport, bool, error) {
+	col, err := s.Collection(ctx, doc.Collection)
+	if err != nil {
+		return IndexReport{}, false, err
+	}
+	current, err := s.repo.SourceVersion(ctx, col.Name, doc.Source)
+	if err != nil {
+		return IndexReport{}, false, err
+	}
+	if current != "" && current == version {
+		return IndexReport{}, false, nil
+	}
+	// the new version is staged while indexing and swapped in at once, so the source stays
+	// searchable meanwhile and keeps its current version if indexing fails (the recorded version
+	// is unchanged too, so the next sync retries)
+	doc.Replace = true
+	report, err := s.IndexDocument(ctx, doc)
+	if err != nil {
+		return report, false, fmt.Errorf("indexing %s: %w", doc.Source, err)
+	}
+	return report, true, s.repo.SetSourceVersion(ctx, col.Name, doc.Source, version)
+}
+
+// RemoveSource deletes the chunks of a source and forgets its synced version
+func (s *RAGService) RemoveSource(ctx context.Context, collection, source string) (int64, error) {
+	col, err := s.Collection(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	n, err := s.repo.DeleteBySource(ctx, col.Name, source)
+	if err != nil {
+		return 0, err
+	}
+	return n, s.repo.SetSourceVersion(ctx, col.Name, source, "")
+}
+
+// RecordVersion records a version without indexing anything, for connectors that track the state
+// of a whole container (the last synced commit of a repository...) next to its items
+func (s *RAGService) RecordVersion(ctx context.Context, collection, source, version string) error {
+	col, err := s.Collection(ctx, collection)
+	if err != nil {
+		return err
+	}
+	return s.repo.SetSourceVersion(ctx, col.Name, source, version)
+}
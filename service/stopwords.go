@@ -0,0 +1,36 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopwords are English and Spanish function words ignored when judging query length
+var stopwords = toSet(strings.Fields(`
+a an and are as at be but by for from how i in is it of on or that the this to was what when where which who why will with
+you your do does did can about me my we our
+el la los las un una unos unas y o de del al en a por para con sin que qué como cómo cuál cuando cuándo donde dónde quién
+es son fue ser está están lo le les se su sus mi mis tu tus me nos hay
+`))
+
+func toSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// contentWords returns the words of text that are not stopwords, lowercased and stripped of punctuation
+func contentWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '-' && r != '_' && r != '.'
+	}) {
+		w = strings.Trim(w, "-_.")
+		if w != "" && !stopwords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
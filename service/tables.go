@@ -0,0 +1,160 @@
+package service
+
+import (
+	"strings"
+)
+
+// segment is a run of the input that is either free text or a table
+type segment struct {
+	text  string
+	table *table
+}
+
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// MarkdownTable renders a table as GitHub-flavored markdown.
+// Extractors use it so tables reach the chunker in a shape it recognizes.
+func MarkdownTable(header []string, rows [][]string) string {
+	var b strings.Builder
+	writeRow := func(cells []string) {
+		b.WriteString("|")
+		for i := range header {
+			cell := ""
+			if i < len(cells) {
+				cell = strings.ReplaceAll(strings.TrimSpace(cells[i]), "|", `\|`)
+			}
+			b.WriteString(" " + cell + " |")
+		}
+		b.WriteString("\n")
+	}
+	writeRow(header)
+	b.WriteString("|")
+	for range header {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, r := range rows {
+		writeRow(r)
+	}
+	return b.String()
+}
+
+// splitTables separates markdown pipe tables and tab-separated blocks from the surrounding text
+func splitTables(text string) []segment {
+	lines := strings.Split(text, "\n")
+	var segs []segment
+	var pending []string
+	flushText := func() {
+		if t := strings.TrimSpace(strings.Join(pending, "\n")); t != "" {
+			segs = append(segs, segment{text: t})
+		}
+		pending = nil
+	}
+
+	for i := 0; i < len(lines); {
+		if t, n := parsePipeTable(lines[i:]); n > 0 {
+			flushText()
+			segs = append(segs, segment{table: t})
+			i += n
+			continue
+		}
+		if t, n := parseTSVTable(lines[i:]); n > 0 {
+			flushText()
+			segs = append(segs, segment{table: t})
+			i += n
+			continue
+		}
+		pending = append(pending, lines[i])
+		i++
+	}
+	flushText()
+	return segs
+}
+
+// parsePipeTable reads a markdown table (header, --- separator, rows) at the start of lines
+func parsePipeTable(lines []string) (*table, int) {
+	if len(lines) < 2 || !isPipeRow(lines[0]) || !isSeparatorRow(lines[1]) {
+		return nil, 0
+	}
+	t := &table{header: splitPipeRow(lines[0])}
+	n := 2
+	for n < len(lines) && isPipeRow(lines[n]) {
+		t.rows = append(t.rows, splitPipeRow(lines[n]))
+		n++
+	}
+	return t, n
+}
+
+func isPipeRow(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "|") && strings.Count(line, "|") >= 2
+}
+
+func isSeparatorRow(line string) bool {
+	if !isPipeRow(line) {
+		return false
+	}
+	for _, c := range splitPipeRow(line) {
+		if strings.Trim(c, ":-") != "" || !strings.Contains(c, "-") {
+			return false
+		}
+	}
+	return true
+}
+
+func splitPipeRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	line = strings.TrimSuffix(line, "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// parseTSVTable reads at least three consecutive lines with the same number (>=2) of tab-separated cells;
+// the first line is taken as the header
+func parseTSVTable(lines []string) (*table, int) {
+	cols := strings.Count(lines[0], "\t") + 1
+	if cols < 2 {
+		return nil, 0
+	}
+	n := 1
+	for n < len(lines) && strings.Count(lines[n], "\t")+1 == cols {
+		n++
+	}
+	if n < 3 {
+		return nil, 0
+	}
+	t := &table{header: strings.Split(lines[0], "\t")}
+	for _, l := range lines[1:n] {
+		t.rows = append(t.rows, strings.Split(l, "\t"))
+	}
+	return t, n
+}
+
+// tableChunks splits a table into markdown chunks of at most maxSize, as reported by measure,
+// repeating the header in every chunk so each one is self-describing
+func tableChunks(t *table, maxSize int, measure func(string) int) []string {
+	headerSize := measure(strings.Join(t.header, " "))
+	var chunks []string
+	var rows [][]string
+	size := headerSize
+	for _, r := range t.rows {
+		rs := measure(strings.Join(r, " "))
+		if len(rows) > 0 && maxSize > 0 && size+rs > maxSize {
+			chunks = append(chunks, MarkdownTable(t.header, rows))
+			rows, size = nil, headerSize
+		}
+		rows = append(rows, r)
+		size += rs
+	}
+	if len(rows) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, MarkdownTable(t.header, rows))
+	}
+	return chunks
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitTables(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []segment
+	}{
+		{"text only", "just text\n\nmore\n", []segment{{text: "just text\n\nmore"}}},
+		{"pipe table", "intro\n| a | b |\n| --- | :-: |\n| 1 | 2 |\noutro", []segment{
+			{text: "intro"},
+			{table: &table{header: []string{"a", "b"}, rows: [][]string{{"1", "2"}}}},
+			{text: "outro"},
+		}},
+		{"header only", "| a | b |\n|---|---|", []segment{{table: &table{header: []string{"a", "b"}}}}},
+		{"pipe rows without separator", "| a | b |\n| 1 | 2 |", []segment{{text: "| a | b |\n| 1 | 2 |"}}},
+		{"tab-separated", "x\ty\n1\t2\n3\t4\nafter", []segment{
+			{table: &table{header: []string{"x", "y"}, rows: [][]string{{"1", "2"}, {"3", "4"}}}},
+			{text: "after"},
+		}},
+		{"two tab-separated lines are text", "x\ty\n1\t2", []segment{{text: "x\ty\n1\t2"}}},
+		{"empty", " \n ", nil},
+	}
+	for _, tt := range tests {
+		if got := splitTables(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultPromptTemplate names the template answer prompts are built with unless another is asked
+// for; a file of that name replaces the built-in one
+const DefaultPromptTemplate = "default"
+
+// promptTemplateExt is the extension of prompt template files, the rest of their name naming them
+const promptTemplateExt = ".tmpl"
+
+// builtinPromptTemplate is the default answer prompt: the numbered passages, the question and
+// the instructions
+const builtinPromptTemplate = `Relevant context:
+
+{{range .Passages}}[{{.N}}] {{.Content}}
+
+{{end}}{{if .ImageDesc}}Imagen adjunta por el usuario: {{.ImageDesc}}
+
+{{end}}
+Pregunta: {{.Question}}
+Instrucciones: {{.Instructions}}
+Respuesta:`
+
+var builtinTemplate = template.Must(newPromptTemplate(DefaultPromptTemplate, builtinPromptTemplate))
+
+// PromptData is what a prompt template is executed with
+type PromptData struct {
+	Question string
+	// Passages are the retrieved passages numbered from 1, as citations refer to them
+	Passages []PromptPassage
+	// ImageDesc describes an image attached to the question, empty if none
+	ImageDesc string
+	// Style is the answer style asked for, empty for the default one
+	Style string
+	// DocType is the document type shared by most passages, empty if none is
+	DocType string
+	// Instructions are the built-in answer instructions for the passages and the style
+	Instructions string
+}
+
+// PromptPassage is a retrieved passage and its number in the prompt
+type PromptPassage struct {
+	N int
+	Passage
+}
+
+// UnknownPromptTemplateError reports a prompt template that is not loaded
+type UnknownPromptTemplateError struct {
+	Name string
+}
+
+func (e *UnknownPromptTemplateError) Error() string {
+	return fmt.Sprintf("unknown prompt template %q", e.Name)
+}
+
+// PromptTemplates are the answer prompt templates of a directory, Go text/template files named
+// <name>.tmpl executed with PromptData, next to the built-in DefaultPromptTemplate
+type PromptTemplates struct {
+	dir string
+	mu  sync.RWMutex
+	set map[string]*template.Template
+}
+
+// LoadPromptTemplates reads the prompt templates of dir
+func LoadPromptTemplates(dir string) (*PromptTemplates, error) {
+	t := &PromptTemplates{dir: dir}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func newPromptTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// reload parses every template of the directory, keeping the templates loaded before if one
+// does not parse
+func (t *PromptTemplates) reload() error {
+	paths, err := filepath.Glob(filepath.Join(t.dir, "*"+promptTemplateExt))
+	if err != nil {
+		return fmt.Errorf("error listing prompt templates: %w", err)
+	}
+	set := map[string]*template.Template{}
+	for _, path := range paths {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading prompt template: %w", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), promptTemplateExt)
+		if set[name], err = newPromptTemplate(name, string(text)); err != nil {
+			return fmt.Errorf("error parsing prompt template %s: %w", path, err)
+		}
+	}
+	t.mu.Lock()
+	t.set = set
+	t.mu.Unlock()
+	return nil
+}
+
+// Names lists the templates that can be asked for, in order
+func (t *PromptTemplates) Names() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	names := slices.Collect(maps.Keys(t.set))
+	if t.set[DefaultPromptTemplate] == nil {
+		names = append(names, DefaultPromptTemplate)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// lookup returns the template called name, nil if there is none; t may be nil
+func (t *PromptTemplates) lookup(name string) *template.Template {
+	if t != nil {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+		if tmpl := t.set[name]; tmpl != nil {
+			return tmpl
+		}
+	}
+	if name == DefaultPromptTemplate {
+		return builtinTemplate
+	}
+	return nil
+}
+
+// promptReloadDelay lets the writes of an edited template settle before it is parsed again
+const promptReloadDelay = 250 * time.Millisecond
+
+// Watch reloads the templates whenever a file of the directory changes, until ctx is done. A
+// template that does not parse is logged and the templates loaded before stay in use.
+func (t *PromptTemplates) Watch(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %w", err)
+	}
+	defer fw.Close()
+	if err := fw.Add(t.dir); err != nil {
+		return fmt.Errorf("error watching prompt templates: %w", err)
+	}
+	reload := time.NewTimer(0)
+	<-reload.C
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-fw.Events:
+			if strings.HasSuffix(ev.Name, promptTemplateExt) {
+				reload.Reset(promptReloadDelay)
+			}
+		case err := <-fw.Errors:
+			log.Printf("warning: watching prompt templates: %v", err)
+		case <-reload.C:
+			if err := t.reload(); err != nil {
+				log.Printf("warning: keeping the previous prompt templates: %v", err)
+				continue
+			}
+			log.Printf("Prompt templates reloaded: %s", strings.Join(t.Names(), ", "))
+		}
+	}
+}
+
+// WatchPromptTemplates reloads Config.PromptTemplates as their files change, until ctx is
+// done. It returns immediately without templates.
+func (s *RAGService) WatchPromptTemplates(ctx context.Context) {
+	if s.cfg.PromptTemplates == nil {
+		return
+	}
+	if err := s.cfg.PromptTemplates.Watch(ctx); err != nil {
+		log.Printf("warning: prompt templates will not be reloaded: %v", err)
+	}
+}
+
+// Prompt builds the prompt answering question from the retrieved passages with the template
+// called name (DefaultPromptTemplate when empty) of Config.PromptTemplates. imageDesc describes
+// an image attached to the question, empty if none. An unknown template is an
+// *UnknownPromptTemplateError.
+func (s *RAGService) Prompt(name, question string, passages []Passage, imageDesc, style string) (string, error) {
+	if name == "" {
+		name = DefaultPromptTemplate
+	}
+	tmpl := s.cfg.PromptTemplates.lookup(name)
+	if tmpl == nil {
+		return "", &UnknownPromptTemplateError{Name: name}
+	}
+	return executePrompt(tmpl, question, passages, imageDesc, style)
+}
+
+// executePrompt runs a prompt template
+func executePrompt(tmpl *template.Template, question string, passages []Passage, imageDesc, style string) (string, error) {
+	data := PromptData{
+		Question:     question,
+		Passages:     make([]PromptPassage, len(passages)),
+		ImageDesc:    imageDesc,
+		Style:        style,
+		DocType:      DominantType(passages),
+		Instructions: instructionsFor(passages, style),
+	}
+	for i, p := range passages {
+		data.Passages[i] = PromptPassage{N: i + 1, Passage: p}
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("error executing prompt template %q: %w", tmpl.Name(), err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Units of Config.ChunkSize and Config.ChunkOverlap
+const (
+	ChunkUnitTokens = "tokens"
+	ChunkUnitWords  = "words"
+)
+
+// CountTokens estimates the number of BPE tokens of text. It pre-tokenizes like GPT-style
+// tokenizers (letter runs, digit runs, single symbols) and charges about four ASCII letters,
+// two non-ASCII letters or three digits per token. The estimate errs on the high side, so
+// chunks sized with it stay within the model's real limit.
+func CountTokens(text string) int {
+	n := 0
+	for _, w := range strings.Fields(text) {
+		n += wordTokens(w)
+	}
+	return n
+}
+
+// truncateTokens cuts text after its leading words adding up to at most n tokens (see
+// CountTokens), marking the cut with an ellipsis
+func truncateTokens(text string, n int) string {
+	used := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		end := i + strings.IndexFunc(text[i:], unicode.IsSpace)
+		if end < i {
+			end = len(text)
+		}
+		if used += wordTokens(text[i:end]); used > n {
+			return strings.TrimSpace(text[:i] + " …")
+		}
+		i = end
+	}
+	return text
+}
+
+// wordTokens estimates the tokens of a single whitespace-free word
+func wordTokens(w string) int {
+	n := 0
+	var ascii, other, digits int
+	flush := func() {
+		n += ceilDiv(ascii, 4) + ceilDiv(other, 2) + ceilDiv(digits, 3)
+		ascii, other, digits = 0, 0, 0
+	}
+	for _, r := range w {
+		switch {
+		case unicode.IsLetter(r) && r < utf8.RuneSelf:
+			if digits > 0 {
+				flush()
+			}
+			ascii++
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			if digits > 0 {
+				flush()
+			}
+			other++
+		case unicode.IsDigit(r):
+			if ascii+other > 0 {
+				flush()
+			}
+			digits++
+		default:
+			flush()
+			n++
+		}
+	}
+	flush()
+	return n
+}
+
+func ceilDiv(a, b int) int { return (a + b - 1) / b }
+
+// measure returns the size of text in the sizing's unit
+func (z Sizing) measure(text string) int {
+	if z.Unit == ChunkUnitWords {
+		return len(strings.Fields(text))
+	}
+	return CountTokens(text)
+}
+
+// units splits text into words with their size in the sizing's unit. In token mode, words
+// longer than Size tokens (base64, minified code...) are cut into pieces that fit.
+func (z Sizing) units(text string) (words []string, sizes []int) {
+	for _, w := range strings.Fields(text) {
+		if z.Unit == ChunkUnitWords {
+			words, sizes = append(words, w), append(sizes, 1)
+			continue
+		}
+		for len(w) > 0 {
+			n := wordTokens(w)
+			if n <= z.Size {
+				words, sizes = append(words, w), append(sizes, n)
+				break
+			}
+			// a rune is at most one token, so Size runes always fit
+			cut := 0
+			for i := 0; i < z.Size && cut < len(w); i++ {
+				_, sz := utf8.DecodeRuneInString(w[cut:])
+				cut += sz
+			}
+			words, sizes = append(words, w[:cut]), append(sizes, wordTokens(w[:cut]))
+			w = w[cut:]
+		}
+	}
+	return words, sizes
+}
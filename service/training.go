@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"slices"
+)
+
+// TrainingExample is a question with chunks that answer it and chunks retrieved for it that do
+// not, for fine-tuning an embedding model on the corpus
+type TrainingExample struct {
+	Query     string
+	Positives []string
+	Negatives []string
+}
+
+// TrainingExamples derives training examples from the feedback on the answers of the history:
+//   - the passages a user opened (see ClickPassages) are positives, and the ones ranked above
+//     them they skipped are hard negatives (the next one below when they opened the first)
+//   - without clicks, a thumbs-up makes the first passage a positive and the last one a negative
+//
+// Entries with no positive or no negative left, chunks deleted since included, give none.
+func (s *RAGService) TrainingExamples(ctx context.Context) ([]TrainingExample, error) {
+	entries, err := s.repo.FeedbackHistory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	type labels struct{ pos, neg []int }
+	byEntry := make([]labels, len(entries))
+	var ids []int
+	for i, e := range entries {
+		var l labels
+		switch n := len(e.ChunkIDs); {
+		case len(e.Clicked) > 0:
+			last := slices.Max(e.Clicked)
+			for p := 1; p <= n; p++ {
+				switch {
+				case slices.Contains(e.Clicked, p):
+					l.pos = append(l.pos, e.ChunkIDs[p-1])
+				case p < last:
+					l.neg = append(l.neg, e.ChunkIDs[p-1])
+				case len(l.neg) == 0:
+					l.neg = append(l.neg, e.ChunkIDs[p-1])
+				}
+			}
+		case e.Rating > 0 && n > 1:
+			l.pos, l.neg = e.ChunkIDs[:1], e.ChunkIDs[n-1:]
+		}
+		byEntry[i] = l
+		ids = append(ids, l.pos...)
+		ids = append(ids, l.neg...)
+	}
+	chunks, err := s.repo.ChunksByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	content := make(map[int]string, len(chunks))
+	for _, c := range chunks {
+		content[c.ID] = c.Content
+	}
+	texts := func(ids []int) []string {
+		var out []string
+		for _, id := range ids {
+			if c, ok := content[id]; ok {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	var out []TrainingExample
+	for i, e := range entries {
+		ex := TrainingExample{Query: e.Question, Positives: texts(byEntry[i].pos), Negatives: texts(byEntry[i].neg)}
+		if len(ex.Positives) > 0 && len(ex.Negatives) > 0 {
+			out = append(out, ex)
+		}
+	}
+	return out, nil
+}
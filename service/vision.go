@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+const captionPrompt = "Describe this figure for a search index. Mention its type (diagram, chart, photo, screenshot...), " +
+	"every label or text visible in it, and what it shows. Answer in one short paragraph."
+
+const queryImagePrompt = "Describe this image so it can be matched against a document collection. " +
+	"Transcribe any visible text, name the application, product or diagram shown, and summarize what it depicts."
+
+// Figure is an image embedded in a document
+type Figure struct {
+	// Name identifies the figure inside the document (file name, alt text...)
+	Name string
+	Data []byte
+}
+
+// DescribeImage asks the vision model about an image
+func (s *RAGService) DescribeImage(ctx context.Context, img []byte, prompt string) (string, error) {
+	if s.cfg.VisionModel == "" {
+		return "", fmt.Errorf("no vision model configured")
+	}
+	out, err := s.generate(ctx, map[string]any{
+		"model":  s.cfg.VisionModel,
+		"prompt": prompt,
+		"images": []string{base64.StdEncoding.EncodeToString(img)},
+		"stream": false,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// DescribeQueryImage describes an image attached to a question
+func (s *RAGService) DescribeQueryImage(ctx context.Context, img []byte) (string, error) {
+	return s.DescribeImage(ctx, img, queryImagePrompt)
+}
+
+// VisionEnabled reports whether a vision model is configured
+func (s *RAGService) VisionEnabled() bool { return s.cfg.VisionModel != "" }
+
+// indexFigures captions every figure of doc and stores each caption as its own chunk,
+// recording them in report
+func (s *RAGService) indexFigures(ctx context.Context, doc Document, docDate time.Time, col repo.Collection, report *IndexReport) error {
+	for i, fig := range doc.Figures {
+		name := fig.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		caption, err := s.DescribeImage(ctx, fig.Data, captionPrompt)
+		if err != nil {
+			return fmt.Errorf("captioning figure %d: %w", i, err)
+		}
+		if caption == "" {
+			report.Skipped = append(report.Skipped, fmt.Sprintf("figure %s: empty caption", name))
+			continue
+		}
+		content := fmt.Sprintf("Figure %s: %s", name, caption)
+		chunk := repo.Chunk{Content: content, Source: doc.Source, DocDate: docDate, Collection: col.Name, DocType: doc.Type, Session: doc.Session, Metadata: doc.Metadata, ContentHash: report.ContentHash, DocumentID: report.DocumentID}
+		stored, err := s.storeOrQuarantine(ctx, col, chunk, report)
+		if err != nil {
+			return fmt.Errorf("storing figure %d: %w", i, err)
+		}
+		if stored {
+			report.Figures++
+		}
+	}
+	return nil
+}
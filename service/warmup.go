@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Thaizir/go-local-RAG/repo"
+)
+
+// prewarmer is implemented by repositories that can load their indexes into memory
+type prewarmer interface {
+	Prewarm(ctx context.Context) error
+}
+
+// Warmup loads the embedding and generation models and the vector index ahead of the first
+// real query: each query is embedded and searched, then a one-token generation is run.
+// Failures are logged rather than returned; warm-up is best effort.
+func (s *RAGService) Warmup(ctx context.Context, queries []string) {
+	start := time.Now()
+	if pw, ok := s.repo.(prewarmer); ok {
+		if err := pw.Prewarm(ctx); err != nil {
+			log.Printf("warm-up: index prewarm skipped: %v", err)
+		}
+	}
+	def, _ := s.Collection(ctx, repo.DefaultCollection)
+	for _, q := range queries {
+		emb, err := s.GenerateEmbedding(q)
+		if err != nil {
+			log.Printf("warm-up: embedding %q: %v", q, err)
+			continue
+		}
+		if _, err := s.repo.SearchSimilar(ctx, emb, 10, repo.SearchOptions{}); err != nil {
+			log.Printf("warm-up: searching %q: %v", q, annotateDimensionErr(err, def))
+		}
+	}
+	if err := s.warmupGeneration(ctx); err != nil {
+		log.Printf("warm-up: generation: %v", err)
+	}
+	log.Printf("✓ warm-up finished in %s", time.Since(start).Round(time.Millisecond))
+}
+
+func (s *RAGService) warmupGeneration(ctx context.Context) error {
+	_, err := s.generate(ctx, map[string]any{
+		"model":   s.cfg.LLMModel,
+		"prompt":  "ok",
+		"stream":  false,
+		"options": map[string]any{"num_predict": 1},
+	})
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", s.cfg.LLMModel, err)
+	}
+	return nil
+}
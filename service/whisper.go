@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// Segment is a timed stretch of a transcript, in seconds from the start of the audio
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcribe sends audio to the Whisper-compatible transcription endpoint
+// (OpenAI's /v1/audio/transcriptions, as served by whisper.cpp, faster-whisper-server, etc.)
+func (s *RAGService) Transcribe(ctx context.Context, audio []byte, filename string) (string, error) {
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := s.transcribe(ctx, audio, filename, "json", &result); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// TranscribeSegments transcribes audio like Transcribe, keeping the timestamps of each segment.
+// Services that return no segments yield the whole text as one segment.
+func (s *RAGService) TranscribeSegments(ctx context.Context, audio []byte, filename string) ([]Segment, error) {
+	var result struct {
+		Text     string    `json:"text"`
+		Duration float64   `json:"duration"`
+		Segments []Segment `json:"segments"`
+	}
+	if err := s.transcribe(ctx, audio, filename, "verbose_json", &result); err != nil {
+		return nil, err
+	}
+	if len(result.Segments) == 0 {
+		if t := strings.TrimSpace(result.Text); t != "" {
+			return []Segment{{End: result.Duration, Text: t}}, nil
+		}
+		return nil, nil
+	}
+	segments := result.Segments[:0]
+	for _, sg := range result.Segments {
+		if sg.Text = strings.TrimSpace(sg.Text); sg.Text != "" {
+			segments = append(segments, sg)
+		}
+	}
+	return segments, nil
+}
+
+// transcribe posts audio to the transcription endpoint and decodes the response into out
+func (s *RAGService) transcribe(ctx context.Context, audio []byte, filename, format string, out any) error {
+	if s.cfg.WhisperURL == "" {
+		return fmt.Errorf("no transcription service configured")
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(audio); err != nil {
+		return err
+	}
+	_ = mw.WriteField("model", "whisper-1")
+	_ = mw.WriteField("response_format", format)
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.WhisperURL+"/v1/audio/transcriptions", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling transcription service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("transcription status %d: %s", resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error parsing transcription JSON: %w", err)
+	}
+	return nil
+}
+
+// TranscriptionEnabled reports whether a transcription service is configured
+func (s *RAGService) TranscriptionEnabled() bool { return s.cfg.WhisperURL != "" }
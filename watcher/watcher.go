@@ -0,0 +1,185 @@
+// Package watcher keeps a collection in sync with a directory tree: new and changed files are
+// indexed, deleted files have their chunks removed.
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/Thaizir/go-local-RAG/loaders"
+	"github.com/Thaizir/go-local-RAG/service"
+)
+
+// Watcher mirrors the files under Root into Collection. Each file is indexed with its path as
+// source and its content hash as version, so restarts and touched-but-unchanged files cost nothing.
+type Watcher struct {
+	Root       string
+	Collection string
+	Registry   *loaders.Registry
+	// Sync indexes a document in place of its source unless version is already indexed
+	// (service.RAGService.SyncSource)
+	Sync func(ctx context.Context, doc service.Document, version string) (service.IndexReport, bool, error)
+	// Remove deletes the chunks of a source (service.RAGService.RemoveSource)
+	Remove func(ctx context.Context, collection, source string) (int64, error)
+	// Debounce waits for a file to stay quiet this long before indexing it, since editors and
+	// copies write files in several steps
+	Debounce time.Duration
+}
+
+// Run indexes the files already under Root, then follows changes until ctx is done
+func (w *Watcher) Run(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %w", err)
+	}
+	defer fw.Close()
+
+	if err := w.addTree(ctx, fw, w.Root); err != nil {
+		return err
+	}
+	log.Printf("Watching %s for documents (collection %q)", w.Root, w.Collection)
+
+	// paths with pending changes, processed once quiet for Debounce
+	pending := make(map[string]time.Time)
+	tick := time.NewTicker(max(w.Debounce/2, 100*time.Millisecond))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+			if !hidden(ev.Name) {
+				pending[ev.Name] = time.Now()
+			}
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("watcher: %v", err)
+		case now := <-tick.C:
+			for path, at := range pending {
+				if now.Sub(at) < w.Debounce {
+					continue
+				}
+				delete(pending, path)
+				w.handle(ctx, fw, path)
+			}
+		}
+	}
+}
+
+// handle brings the index up to date with path after it changed
+func (w *Watcher) handle(ctx context.Context, fw *fsnotify.Watcher, path string) {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// a deleted file, or a deleted directory whose files were indexed
+		w.remove(ctx, path)
+	case err != nil:
+		log.Printf("watcher: %v", err)
+	case info.IsDir():
+		if err := w.addTree(ctx, fw, path); err != nil {
+			log.Printf("watcher: %v", err)
+		}
+	default:
+		w.index(ctx, path, info)
+	}
+}
+
+// addTree watches dir and its subdirectories and indexes the files they hold
+func (w *Watcher) addTree(ctx context.Context, fw *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path != dir && hidden(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := fw.Add(path); err != nil {
+				return fmt.Errorf("error watching %s: %w", path, err)
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		w.index(ctx, path, info)
+		return nil
+	})
+}
+
+// index syncs one file, skipping files no loader handles
+func (w *Watcher) index(ctx context.Context, path string, info fs.FileInfo) {
+	loader, ok := w.Registry.Lookup(path, "")
+	if !ok || !info.Mode().IsRegular() {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("watcher: %v", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	sections, err := loader.Load(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("watcher: error reading %s: %v", path, err)
+		return
+	}
+	doc := loaders.ToDocument(sections)
+	doc.Source = path
+	doc.Collection = w.Collection
+	doc.Date = info.ModTime()
+	report, indexed, err := w.Sync(ctx, doc, hex.EncodeToString(sum[:]))
+	if err != nil {
+		log.Printf("watcher: %v", err)
+		return
+	}
+	if indexed {
+		log.Printf("watcher: indexed %s (%d chunks)", path, report.Chunks)
+	}
+}
+
+// remove deletes the chunks of a deleted file. Sources are file paths, so a deleted directory
+// is handled as the files it contained, which arrive as their own events on most platforms.
+func (w *Watcher) remove(ctx context.Context, path string) {
+	if _, ok := w.Registry.Lookup(path, ""); !ok {
+		return
+	}
+	n, err := w.Remove(ctx, w.Collection, path)
+	if err != nil {
+		log.Printf("watcher: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("watcher: removed %s (%d chunks)", path, n)
+	}
+}
+
+// hidden reports dotfiles and editor temporaries (".#x", "x~", "x.swp"), which are never indexed
+func hidden(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp")
+}
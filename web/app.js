@@ -1,11 +1,35 @@
 const uploadForm = document.getElementById('upload-form');
 const textEl = document.getElementById('text');
 const fileEl = document.getElementById('file');
+const figuresEl = document.getElementById('figures');
 const uploadStatus = document.getElementById('upload-status');
 
 const askBtn = document.getElementById('askBtn');
 const questionEl = document.getElementById('question');
 const answerEl = document.getElementById('answer');
+const queryImageEl = document.getElementById('queryImage');
+const micBtn = document.getElementById('micBtn');
+const docTypeEl = document.getElementById('docType');
+const sessionOnlyEl = document.getElementById('sessionOnly');
+const answerStyleEl = document.getElementById('answerStyle');
+const feedbackEl = document.getElementById('feedback');
+const sourcesEl = document.getElementById('sources');
+const uploadProgress = document.getElementById('upload-progress');
+const feedbackStatus = document.getElementById('feedback-status');
+
+// Documents uploaded "only for this conversation" are bound to this page's session
+// and deleted by the server when the page closes
+const sessionId = crypto.randomUUID();
+window.addEventListener('pagehide', () => {
+  fetch('/api/session?id=' + sessionId, { method: 'DELETE', keepalive: true });
+});
+
+// Questions and answers are kept in the server-side history of this browser's user id
+let userId = localStorage.getItem('userId');
+if (!userId) {
+  userId = crypto.randomUUID();
+  localStorage.setItem('userId', userId);
+}
 
 uploadForm.addEventListener('submit', async (e) => {
   e.preventDefault();
@@ -13,32 +37,140 @@ uploadForm.addEventListener('submit', async (e) => {
 
   const form = new FormData();
   const text = textEl.value.trim();
-  const file = fileEl.files && fileEl.files[0];
+  const files = fileEl.files ? [...fileEl.files] : [];
 
-  if (!text && !file) {
-    uploadStatus.textContent = 'You must enter text or select a .txt file';
+  if (!text && files.length === 0) {
+    uploadStatus.textContent = 'You must enter text or select a file';
     return;
   }
 
   if (text) form.append('text', text);
-  if (file) form.append('file', file);
+  for (const file of files) form.append('file', file);
+  if (files.length === 1) form.append('date', new Date(files[0].lastModified).toISOString());
+  if (docTypeEl.value) form.append('doc_type', docTypeEl.value);
+  if (sessionOnlyEl.checked) form.append('session', sessionId);
+  for (const fig of figuresEl.files) form.append('figure', fig);
 
   try {
     uploadForm.querySelector('button').disabled = true;
     uploadStatus.textContent = 'Uploading...';
     const resp = await fetch('/api/upload', { method: 'POST', body: form });
-    if (!resp.ok) {
-      const errText = await resp.text();
-      throw new Error(errText || 'Upload error');
-    }
-    uploadStatus.textContent = 'Saved!';
+    if (!resp.ok) throw new Error(await errorMessage(resp, 'Upload error'));
+    let data = await resp.json();
+    if (resp.status === 202) data = await waitForJob(data.job.id);
+    uploadStatus.textContent = data.files ? describeFiles(data.files) : describeReport(data.type, data.report);
     textEl.value = '';
     fileEl.value = '';
+    figuresEl.value = '';
   } catch (err) {
     uploadStatus.textContent = 'Error: ' + err.message;
   } finally {
     uploadForm.querySelector('button').disabled = false;
-    setTimeout(() => (uploadStatus.textContent = ''), 2500);
+    setTimeout(() => (uploadStatus.textContent = ''), 8000);
+  }
+});
+
+// errorMessage reads the message of an API error response ({"error": {"code", "message", ...}})
+async function errorMessage(resp, fallback) {
+  const text = await resp.text();
+  try {
+    const err = JSON.parse(text).error;
+    if (err && err.message) return err.message;
+  } catch (_) {}
+  return text || fallback;
+}
+
+// sseErrorMessage reads the message of the data of an SSE "error" event
+function sseErrorMessage(data) {
+  try {
+    const err = JSON.parse(data);
+    if (err && err.message) return err.message;
+  } catch (_) {}
+  return data;
+}
+
+// waitForJob follows a background indexing job until it finishes, showing its progress,
+// and returns its result
+function waitForJob(id) {
+  return new Promise((resolve, reject) => {
+    const es = new EventSource('/api/jobs/' + id + '/events');
+    es.addEventListener('progress', (ev) => {
+      const job = JSON.parse(ev.data);
+      if (job.total) {
+        uploadProgress.hidden = false;
+        uploadProgress.max = job.total;
+        uploadProgress.value = job.done;
+        uploadStatus.textContent = `Embedded ${job.done}/${job.total} chunks`;
+      } else {
+        uploadStatus.textContent = job.status === 'queued' ? 'Waiting to be indexed...' : 'Indexing...';
+      }
+    });
+    es.addEventListener('done', (ev) => {
+      es.close();
+      uploadProgress.hidden = true;
+      const job = JSON.parse(ev.data);
+      if (job.status === 'failed') reject(new Error(job.error));
+      else resolve(job.result);
+    });
+    es.addEventListener('error', (ev) => {
+      es.close();
+      uploadProgress.hidden = true;
+      reject(new Error(ev.data ? sseErrorMessage(ev.data) : 'lost track of the indexing job'));
+    });
+  });
+}
+
+// describeReport summarizes the upload report: what was indexed and anything to check
+function describeReport(type, r) {
+  if (r.already_indexed) return `Already indexed as ${r.already_indexed}: nothing was saved`;
+  let text = `Saved ${r.chunks} chunks from ${type} (${r.format}, language: ${r.language})`;
+  if (r.pages) text += `, ${r.pages} pages`;
+  if (r.records) text += `, ${r.records} records`;
+  if (r.figures) text += `, ${r.figures} figures`;
+  for (const s of r.skipped || []) text += `\nSkipped ${s}`;
+  for (const w of r.warnings || []) text += `\nWarning: ${w}`;
+  return text;
+}
+
+// describeFiles summarizes a multi-file upload, one line per file
+function describeFiles(files) {
+  const indexed = files.filter((f) => f.report).length;
+  let text = `Indexed ${indexed} of ${files.length} files`;
+  for (const f of files) {
+    if (f.error) text += `\n${f.source}: error: ${f.error}`;
+    else if (f.skipped) text += `\n${f.source}: skipped (${f.skipped})`;
+    else if (f.report.already_indexed) text += `\n${f.source}: already indexed as ${f.report.already_indexed}`;
+    else text += `\n${f.source}: ${f.report.chunks} chunks`;
+  }
+  return text;
+}
+
+const urlForm = document.getElementById('url-form');
+const pageUrlEl = document.getElementById('pageUrl');
+const urlStatus = document.getElementById('url-status');
+
+urlForm.addEventListener('submit', async (e) => {
+  e.preventDefault();
+  const url = pageUrlEl.value.trim();
+  if (!url) return;
+  const button = urlForm.querySelector('button');
+  try {
+    button.disabled = true;
+    urlStatus.textContent = 'Fetching...';
+    const resp = await fetch('/api/ingest/url', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ url }),
+    });
+    if (!resp.ok) throw new Error(await errorMessage(resp, 'Ingest error'));
+    const data = await resp.json();
+    urlStatus.textContent = 'Saved: ' + (data.title || data.source);
+    pageUrlEl.value = '';
+  } catch (err) {
+    urlStatus.textContent = 'Error: ' + err.message;
+  } finally {
+    button.disabled = false;
+    setTimeout(() => (urlStatus.textContent = ''), 4000);
   }
 });
 
@@ -50,28 +182,160 @@ questionEl.addEventListener('keydown', (e) => {
   }
 });
 
+// Answers saved to the history can be rated; thumbs-up ones may be reused for later questions
+let answerId = null;
+function showFeedback(id) {
+  answerId = Number(id);
+  feedbackStatus.textContent = '';
+  feedbackEl.hidden = false;
+}
+
+async function rateAnswer(rating) {
+  if (!answerId) return;
+  try {
+    const resp = await fetch('/api/history/feedback', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ user: userId, id: answerId, rating }),
+    });
+    if (!resp.ok) throw new Error(await errorMessage(resp, 'Feedback error'));
+    feedbackStatus.textContent = 'Thanks!';
+  } catch (err) {
+    feedbackStatus.textContent = 'Error: ' + err.message;
+  }
+}
+document.getElementById('thumbsUp').addEventListener('click', () => rateAnswer(1));
+document.getElementById('thumbsDown').addEventListener('click', () => rateAnswer(-1));
+
+// showSources lists the documents the answer is drawn from, once per source and page
+function showSources(data) {
+  let sources;
+  try {
+    sources = JSON.parse(data);
+  } catch (_) {
+    return;
+  }
+  const seen = new Set();
+  for (const s of sources) seen.add(s.page ? `${s.source} p.${s.page}` : s.source);
+  if (seen.size === 0) return;
+  sourcesEl.textContent = 'Answered from: ' + [...seen].join(', ');
+  sourcesEl.hidden = false;
+}
+
+function appendToken(data) {
+  // server escapes newlines as \n in data
+  answerEl.textContent += data.replace(/\\n/g, '\n');
+}
+
+// streamPost posts a form to an SSE endpoint and parses the stream by hand,
+// since EventSource only supports GET
+async function streamPost(url, form) {
+  try {
+    const resp = await fetch(url, { method: 'POST', body: form });
+    if (!resp.ok) throw new Error(await errorMessage(resp, 'Query error'));
+    const transcript = resp.headers.get('X-Transcript');
+    if (transcript) questionEl.value = decodeURIComponent(transcript.replace(/\+/g, ' '));
+    const reader = resp.body.getReader();
+    const decoder = new TextDecoder();
+    let buf = '';
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += decoder.decode(value, { stream: true });
+      let idx;
+      while ((idx = buf.indexOf('\n\n')) >= 0) {
+        const raw = buf.slice(0, idx);
+        buf = buf.slice(idx + 2);
+        let event = 'message';
+        let data = '';
+        for (const line of raw.split('\n')) {
+          if (line.startsWith('event: ')) event = line.slice(7);
+          else if (line.startsWith('data: ')) data += line.slice(6);
+        }
+        if (event === 'message') appendToken(data);
+        else if (event === 'sources') showSources(data);
+        else if (event === 'history') showFeedback(data);
+        else if (event === 'error') answerEl.textContent += '\n[error] ' + sseErrorMessage(data);
+      }
+    }
+  } catch (err) {
+    answerEl.textContent = 'Error: ' + err.message;
+  }
+}
+
 function ask() {
   const q = questionEl.value.trim();
   if (!q) return;
   answerEl.textContent = '';
+  feedbackEl.hidden = true;
+  sourcesEl.hidden = true;
 
-  const es = new EventSource('/api/query?q=' + encodeURIComponent(q));
+  const image = queryImageEl.files && queryImageEl.files[0];
+  if (image) {
+    const form = new FormData();
+    form.append('q', q);
+    form.append('image', image);
+    form.append('session', sessionId);
+    form.append('user', userId);
+    if (answerStyleEl.value) form.append('style', answerStyleEl.value);
+    streamPost('/api/query', form);
+    return;
+  }
+
+  let url = '/api/query?q=' + encodeURIComponent(q) + '&session=' + sessionId + '&user=' + userId;
+  if (answerStyleEl.value) url += '&style=' + answerStyleEl.value;
+  const es = new EventSource(url);
 
   es.onmessage = (ev) => {
     // Append tokens
     try {
-      // server escapes newlines as \n in data
-      const text = ev.data.replace(/\\n/g, '\n');
-      answerEl.textContent += text;
+      appendToken(ev.data);
     } catch (_) {}
   };
 
+  es.addEventListener('sources', (ev) => showSources(ev.data));
+  es.addEventListener('history', (ev) => showFeedback(ev.data));
+
   es.addEventListener('done', () => {
     es.close();
   });
 
   es.addEventListener('error', (ev) => {
-    console.error('SSE error', ev);
+    // server errors carry data; connection errors do not
+    if (ev.data) answerEl.textContent += '\n[error] ' + sseErrorMessage(ev.data);
+    else console.error('SSE error', ev);
     es.close();
   });
 }
+
+// Voice queries: first click starts recording, second click sends the clip
+let recorder = null;
+micBtn.addEventListener('click', async () => {
+  if (recorder) {
+    recorder.stop();
+    return;
+  }
+  try {
+    const stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+    const parts = [];
+    recorder = new MediaRecorder(stream);
+    recorder.ondataavailable = (e) => parts.push(e.data);
+    recorder.onstop = () => {
+      stream.getTracks().forEach((t) => t.stop());
+      recorder = null;
+      micBtn.textContent = '🎤';
+      const form = new FormData();
+      form.append('audio', new Blob(parts, { type: 'audio/webm' }), 'question.webm');
+      form.append('session', sessionId);
+      form.append('user', userId);
+      if (answerStyleEl.value) form.append('style', answerStyleEl.value);
+      answerEl.textContent = '';
+      sourcesEl.hidden = true;
+      streamPost('/api/query/voice', form);
+    };
+    recorder.start();
+    micBtn.textContent = '⏹';
+  } catch (err) {
+    answerEl.textContent = 'Error: ' + err.message;
+  }
+});